@@ -1,11 +1,183 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type VaultsmithConfig struct {
-	DocumentPath   string
-	Dry            bool
-	VaultRole      string
-	TemplateFile   string
-	TemplateParams []string
-	HttpAuthToken  string
-	TarDir         string
+	DocumentPath    string
+	OverlayPaths    []string // overlaid on DocumentPath in order; see document.Overlay
+	Dry             bool
+	VaultRole       string
+	TemplateFile    string
+	TemplateParams  []string
+	HttpAuthToken   string
+	TarDir          string
+	MaxFileSize     int64
+	AllowRecreate   bool
+	ResourcePath    string // resource to apply when reading a single document from stdin
+	NoCleanUp       bool
+	Watch           bool     // re-apply changed documents until interrupted
+	ContinueOnError bool     // apply the remaining resources when one fails, and report all failures
+	Targets         []string // only apply these handlers, e.g. "policy"; all if empty
+	Retries         int      // times to retry reading the live configuration when it fails
+	// accept documents with fields that aren't part of the resource, rather than failing
+	AllowUnknownFields bool
+	// KV v2 path of a lock preventing concurrent runs, held for at most LockTTL; a second run waits
+	// up to LockWait for it
+	LockPath string
+	LockTTL  time.Duration
+	LockWait time.Duration
+	// if set, keep running and apply this often (see runner.Reconcile)
+	ReconcileInterval time.Duration
+	// the result is POSTed to PostApplyURL and given to PostApplyCommand on stdin after a successful
+	// apply, or after every apply if PostApplyAlways is set
+	PostApplyURL     string
+	PostApplyCommand string
+	PostApplyAlways  bool
+	// document subtrees (e.g. "secret/payments") applied to a different Vault than the rest
+	Subtrees map[string]SubtreeTarget
+	// if set, write what is in Vault to this directory instead of applying anything
+	ExportPath string
+	// if set, only apply the directories with files changed since this git ref
+	Since string
+	// if set, the live configuration is recorded here after each run, and compared with Vault at the
+	// start of the next to report what was changed outside vaultsmith
+	StatePath string
+	// connection settings for the Vault clients; see vault.ClientOptions
+	VaultTimeout      time.Duration
+	VaultMaxIdleConns int
+	VaultKeepAlive    time.Duration
+	// sent with every request to the Vault at VAULT_ADDR, e.g. for a gateway in front of it
+	VaultHeaders http.Header
+	// if set, anything a run would disable or delete is listed first, and it only goes ahead once
+	// confirmed by a "y" read from here
+	ConfirmInput io.Reader
+	// if set, log in with this AppRole role_id and the secret_id unwrapped from AppRoleWrappingToken,
+	// rather than with AWS
+	AppRoleID            string
+	AppRoleWrappingToken string
+	// the mount path of the auth method to log in with, if not the default; see vault.ClientOptions
+	AuthPath string
+	// if set, only undeclared auth mounts of these types are disabled; others are left with a warning
+	DisableAuthTypes []string
+	// the preflight checks fail if the token expires sooner than this; see runner.Preflight
+	MinTokenTTL time.Duration
+	// apply a document path with no documents, removing everything vaultsmith manages
+	AllowEmpty bool
+	// log each field which differs from Vault, to show why a resource is changed
+	Explain bool
+	// sent with every request to Vault, so its audit log can be filtered by run; see vault.RunIDHeader
+	RunID string
+	// a dry run writes its plan here, to be applied later with PlanPath
+	PlanOutPath string
+	// apply only if the changes are exactly those in this plan, with nothing changed since
+	PlanPath string
+	// extract an http(s) tarball as it is downloaded, rather than saving the archive first
+	StreamTarball bool
+	// fail a change when Vault returns warnings for it; see vault.ClientOptions
+	WarningsAsErrors bool
+	// if a change fails, roll back those already made in the run
+	Atomic bool
+	// how an http(s) document-path is verified, separately from Vault
+	HttpCACert             string
+	HttpInsecureSkipVerify bool
+	// if set, only undeclared auth mounts under these path prefixes are disabled; see
+	// path_handlers.PathHandlerConfig
+	OwnedAuthPaths []string
+	// the Vault Agent sink file the token is read from before each request; see vault.ClientOptions
+	VaultTokenSink string
+	// add a unified diff of each changed resource to the summary; see path_handlers.Summary
+	Diff bool
+	// the order each dedicated handler runs in, by its --target name, replacing the default
+	HandlerOrder map[string]int
+	// read auth methods and mounts back after changing them, failing if Vault didn't keep the change
+	VerifyWrites bool
+	// delete undeclared transit keys, which destroys everything encrypted with them
+	AllowDeleteTransitKeys bool
+	// stop a single run which hasn't finished within this long; 0 for no limit
+	RunTimeout time.Duration
+	// limit downloading a remote document-path to this many bytes a second; 0 for no limit
+	MaxDownloadRate int64
+	// write the report of each run to this file as JSON; see runner.Report
+	ReportPath string
+	// wait between half this and this before applying each of Subtrees to its Vault
+	SubtreeStagger time.Duration
+	// tune auth methods and mounts with their live config, changing only the fields documents set
+	MergeTuning bool
+	// refuse to apply a run which would make more than this many changes; 0 for no limit
+	MaxChanges int
+	// append a readable entry listing the changes of each run to this file
+	ChangelogPath string
+	// create policies referenced by documents empty if they don't exist yet, rather than failing
+	StubMissingPolicies bool
+	// record the documents applied in this file as the run goes, for an unfinished run to resume from
+	CheckpointPath string
+	// reload the mounts using a plugin once it is registered again with a new binary
+	ReloadPlugins bool
+	// leave the resources matching these globs alone, e.g. "sys/auth/legacy-*", and don't report
+	// drift in them
+	IgnoreResources []string
+}
+
+// The Vault a document subtree is applied to
+type SubtreeTarget struct {
+	Address  string `json:"address"`
+	TokenEnv string `json:"token_env"` // environment variable holding the token; VAULT_TOKEN if empty
+}
+
+// Read subtree targets from a JSON file mapping each subtree to its SubtreeTarget
+func LoadSubtrees(path string) (map[string]SubtreeTarget, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read subtree config %s: %s", path, err)
+	}
+	var subtrees map[string]SubtreeTarget
+	err = json.Unmarshal(content, &subtrees)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse subtree config %s: %s", path, err)
+	}
+	for subtree, target := range subtrees {
+		if target.Address == "" {
+			return nil, fmt.Errorf("subtree %s in %s has no address", subtree, path)
+		}
+	}
+	return subtrees, nil
+}
+
+// Parse headers given as "Name=value", as for --vault-header
+func ParseHeaders(headers []string) (http.Header, error) {
+	parsed := http.Header{}
+	for _, h := range headers {
+		i := strings.Index(h, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q; expected Name=value", h)
+		}
+		parsed.Add(strings.TrimSpace(h[:i]), h[i+1:])
+	}
+	return parsed, nil
+}
+
+// Parse handler orders given as "target=order", as for --handler-order
+func ParseHandlerOrder(orders []string) (map[string]int, error) {
+	parsed := map[string]int{}
+	for _, o := range orders {
+		i := strings.Index(o, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid handler order %q; expected target=order", o)
+		}
+		order, err := strconv.Atoi(strings.TrimSpace(o[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid handler order %q: %s", o, err)
+		}
+		parsed[strings.TrimSpace(o[:i])] = order
+	}
+	return parsed, nil
 }
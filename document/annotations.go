@@ -0,0 +1,135 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Documents may carry instructions for vaultsmith under this key, which is removed before the
+// document is applied
+const AnnotationKey = "_vaultsmith"
+
+// Instructions for vaultsmith carried in a document, e.g.
+//   "_vaultsmith": {"enabled_when": "secret/data/flags#ldap", "retries": 5}
+type Annotations struct {
+	// a Condition (see ParseCondition) which must hold for the document to be applied
+	EnabledWhen string `json:"enabled_when"`
+	// when the condition doesn't hold, remove the resource as if it wasn't declared, rather than
+	// leaving it as it is
+	PruneWhenDisabled bool `json:"prune_when_disabled"`
+	// times to retry each failed Vault call made to apply the document, e.g. enabling a plugin
+	// which is slow to start
+	Retries int `json:"retries"`
+	// resource paths, e.g. "sys/policy/reader", of documents applied by the same handler which
+	// must be applied before this one
+	DependsOn []string `json:"depends_on"`
+}
+
+// Remove the annotations from the json document content, returning them and the rest of the
+// document. Content without annotations is returned unchanged.
+func SplitAnnotations(content []byte) (Annotations, []byte, error) {
+	var a Annotations
+	if !bytes.Contains(content, []byte(AnnotationKey)) {
+		return a, content, nil
+	}
+
+	var fields map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		// not an object; leave it to the caller to report
+		return a, content, nil
+	}
+	raw, ok := fields[AnnotationKey]
+	if !ok {
+		return a, content, nil
+	}
+	delete(fields, AnnotationKey)
+
+	decoder = json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&a); err != nil {
+		return a, content, fmt.Errorf("invalid %s annotations: %s", AnnotationKey, err)
+	}
+	if a.EnabledWhen != "" {
+		if _, err := ParseCondition(a.EnabledWhen); err != nil {
+			return a, content, err
+		}
+	}
+	if a.Retries < 0 {
+		return a, content, fmt.Errorf("invalid %s annotations: retries must not be negative", AnnotationKey)
+	}
+
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return a, content, fmt.Errorf("could not encode document without annotations: %s", err)
+	}
+	return a, rest, nil
+}
+
+/*
+	A Condition on a value in Vault, written as "<path>#<key>", optionally followed by "== <value>"
+	or "!= <value>", e.g. `secret/data/flags#stage == "beta"`. For a KV version 2 path (including
+	"/data/"), key is looked up in the secret's data. Without a comparison, the value must be true
+	(or "true").
+*/
+type Condition struct {
+	Path     string
+	Key      string
+	Operator string // "==", "!=", or "" to test the value is true
+	Value    string
+}
+
+func ParseCondition(expr string) (Condition, error) {
+	var c Condition
+	ref := strings.TrimSpace(expr)
+	for _, op := range []string{"==", "!="} {
+		if i := strings.Index(ref, op); i >= 0 {
+			c.Operator = op
+			c.Value = strings.TrimSpace(ref[i+len(op):])
+			if unquoted, err := strconv.Unquote(c.Value); err == nil {
+				c.Value = unquoted
+			}
+			ref = strings.TrimSpace(ref[:i])
+			break
+		}
+	}
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return c, fmt.Errorf("invalid condition %q; expected <path>#<key> [== value]", expr)
+	}
+	c.Path = strings.Trim(ref[:i], "/")
+	c.Key = ref[i+1:]
+	return c, nil
+}
+
+// Whether the condition holds for the secret data read from c.Path, which is nil if there is no
+// secret there
+func (c Condition) Holds(data map[string]interface{}) bool {
+	if nested, ok := data["data"].(map[string]interface{}); ok && strings.Contains(c.Path, "/data/") {
+		data = nested // KV version 2
+	}
+	value, ok := data[c.Key]
+	if !ok {
+		return false
+	}
+	actual := fmt.Sprint(value)
+	switch c.Operator {
+	case "==":
+		return actual == c.Value
+	case "!=":
+		return actual != c.Value
+	default:
+		return actual == "true"
+	}
+}
+
+func (c Condition) String() string {
+	if c.Operator == "" {
+		return c.Path + "#" + c.Key
+	}
+	return fmt.Sprintf("%s#%s %s %q", c.Path, c.Key, c.Operator, c.Value)
+}
@@ -0,0 +1,72 @@
+package document
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSplitAnnotations(t *testing.T) {
+	content := []byte(`{"type": "ldap", "ttl": 12345678901, "_vaultsmith": {"enabled_when": "flags#ldap"}}`)
+	a, rest, err := SplitAnnotations(content)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if a.EnabledWhen != "flags#ldap" {
+		t.Errorf("Expected enabled_when to be parsed, got %+v", a)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rest, &doc); err != nil {
+		t.Fatalf("Could not parse remaining document %s: %s", rest, err)
+	}
+	if _, ok := doc[AnnotationKey]; ok || doc["type"] != "ldap" || doc["ttl"] != float64(12345678901) {
+		t.Errorf("Unexpected remaining document %s", rest)
+	}
+
+	// left alone without annotations
+	plain := []byte(`{"type": "ldap"}`)
+	if _, rest, _ := SplitAnnotations(plain); string(rest) != string(plain) {
+		t.Errorf("Expected content without annotations to be unchanged, got %s", rest)
+	}
+}
+
+func TestSplitAnnotations_Invalid(t *testing.T) {
+	for _, content := range []string{
+		`{"_vaultsmith": {"enabled_whne": "flags#ldap"}}`,
+		`{"_vaultsmith": {"enabled_when": "flags"}}`,
+		`{"_vaultsmith": {"retries": -1}}`,
+	} {
+		if _, _, err := SplitAnnotations([]byte(content)); err == nil {
+			t.Errorf("Expected an error for %s", content)
+		}
+	}
+}
+
+func TestCondition_Holds(t *testing.T) {
+	data := map[string]interface{}{"on": true, "stage": "beta", "count": float64(2)}
+	kv2 := map[string]interface{}{"data": data, "metadata": map[string]interface{}{}}
+	cases := []struct {
+		expr  string
+		data  map[string]interface{}
+		holds bool
+	}{
+		{"flags#on", data, true},
+		{"flags#stage", data, false},
+		{`flags#stage == "beta"`, data, true},
+		{"flags#stage == beta", data, true},
+		{"flags#stage != beta", data, false},
+		{"flags#count == 2", data, true},
+		{"flags#missing", data, false},
+		{"flags#on", nil, false},
+		{"secret/data/flags#stage == beta", kv2, true},
+	}
+	for _, c := range cases {
+		cond, err := ParseCondition(c.expr)
+		if err != nil {
+			t.Errorf("Could not parse %q: %s", c.expr, err)
+			continue
+		}
+		if holds := cond.Holds(c.data); holds != c.holds {
+			t.Errorf("Expected %q to be %t, got %t", c.expr, c.holds, holds)
+		}
+	}
+}
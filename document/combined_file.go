@@ -0,0 +1,98 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+	CombinedFile implements document.Set for a single JSON file declaring every document, keyed by the directory it would be
+	in and then its name, e.g.
+		{
+			"sys/auth": {"approle": {"type": "approle"}},
+			"sys/policy": {"read_secrets": {"policy": "path \"secret/*\" {capabilities = [\"read\"]}"}},
+			"auth/approle/role": {"app": {"token_policies": ["read_secrets"]}}
+		}
+	Get writes the documents out as the directory tree they describe, so they are applied by the
+	same handlers, and Path returns its root.
+*/
+type CombinedFile struct {
+	WorkDir  string
+	FilePath string
+}
+
+func (c *CombinedFile) Get() error {
+	if err := prepareWorkDir(c.WorkDir); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(c.FilePath)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %s", c.FilePath, err)
+	}
+	var dirs map[string]map[string]json.RawMessage
+	err = json.Unmarshal(content, &dirs)
+	if err != nil {
+		return fmt.Errorf("could not parse %s, expected an object of directories, each an object "+
+			"of documents: %s", c.FilePath, err)
+	}
+
+	destDir := c.expandPath()
+	err = os.MkdirAll(destDir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory %q: %s", destDir, err)
+	}
+	for _, dir := range sortedDirs(dirs) {
+		for name, doc := range dirs[dir] {
+			if name == "" || strings.ContainsAny(name, `/\`) {
+				return fmt.Errorf("invalid document name %q under %s in %s", name, dir, c.FilePath)
+			}
+			target, err := extractTarget(destDir, filepath.Join(filepath.FromSlash(dir), name+".json"))
+			if err != nil {
+				return fmt.Errorf("%s in %s", err, c.FilePath)
+			}
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err != nil {
+				return fmt.Errorf("error creating directory %q: %s", filepath.Dir(target), err)
+			}
+			log.Debugf("Writing %q", target)
+			err = ioutil.WriteFile(target, doc, 0644)
+			if err != nil {
+				return fmt.Errorf("error writing to file %q: %s", target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Return the path to the documents written by Get
+func (c *CombinedFile) Path() (string, error) {
+	return c.expandPath(), nil
+}
+
+func (c *CombinedFile) CleanUp() {
+	log.Infof("Removing %s", c.expandPath())
+	err := os.RemoveAll(c.WorkDir)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (c *CombinedFile) expandPath() string {
+	return filepath.Join(c.WorkDir, fmt.Sprintf("%s-expand", filepath.Base(c.FilePath)))
+}
+
+// The directories in a stable order, so errors are the same on every run
+func sortedDirs(dirs map[string]map[string]json.RawMessage) []string {
+	var names []string
+	for d := range dirs {
+		names = append(names, d)
+	}
+	sort.Strings(names)
+	return names
+}
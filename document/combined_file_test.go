@@ -0,0 +1,89 @@
+package document
+
+import (
+	"github.com/starlingbank/vaultsmith/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// write content to a file called name in a new directory
+func writeCombinedFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCombinedFile_Get(t *testing.T) {
+	p := writeCombinedFile(t, "all.json", `{
+		"sys/auth": {"approle": {"type": "approle"}},
+		"auth/approle/role": {"app": {"token_policies": ["read_secrets"]}}
+	}`)
+	defer os.RemoveAll(filepath.Dir(p))
+	workDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+
+	c := CombinedFile{WorkDir: workDir, FilePath: p}
+	if err := c.Get(); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	defer c.CleanUp()
+	path, err := c.Path()
+	if err != nil {
+		t.Fatalf("Error calling Path: %s", err)
+	}
+
+	expected := map[string]string{
+		filepath.Join("sys", "auth", "approle.json"):         `{"type": "approle"}`,
+		filepath.Join("auth", "approle", "role", "app.json"): `{"token_policies": ["read_secrets"]}`,
+	}
+	if files := extractedFiles(t, path); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected files %+v, got %+v", expected, files)
+	}
+}
+
+// Names can't reach outside of the directory the documents are written to
+func TestCombinedFile_GetOutside(t *testing.T) {
+	for _, content := range []string{
+		`{"../../etc": {"passwd": {}}}`,
+		`{"sys/auth": {"../policy/root": {}}}`,
+		`{"sys/auth": {"": {}}}`,
+		`{"sys/auth": ["approle"]}`,
+	} {
+		p := writeCombinedFile(t, "all.json", content)
+		defer os.RemoveAll(filepath.Dir(p))
+		workDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+		if err != nil {
+			t.Fatalf("Could not create tempdir: %s", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		c := CombinedFile{WorkDir: workDir, FilePath: p}
+		if err := c.Get(); err == nil {
+			t.Errorf("Expected an error for %s", content)
+		}
+	}
+}
+
+func TestGetSet_CombinedFile(t *testing.T) {
+	p := writeCombinedFile(t, "all.json", `{}`)
+	defer os.RemoveAll(filepath.Dir(p))
+
+	set, err := GetSet(os.TempDir(), config.VaultsmithConfig{DocumentPath: p})
+	if err != nil {
+		t.Fatalf("Error calling GetSet: %s", err)
+	}
+	if _, ok := set.(*CombinedFile); !ok {
+		t.Errorf("Expected a CombinedFile for a .json document-path, got %T", set)
+	}
+}
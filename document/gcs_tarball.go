@@ -0,0 +1,132 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2/google"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Implements document.Set, for a tarball in Google Cloud Storage given as gs://bucket/object.tgz
+type GCSTarball struct {
+	LocalTarball
+	Bucket string
+	Object string
+	// extract the object as it is read, without saving the archive
+	Stream bool
+	// where the object is read from; the JSON API with the default credentials if nil
+	Storage GCSStorage
+	// limit the download to this many bytes a second, if set
+	MaxBytesPerSecond int64
+}
+
+// Reads objects from Cloud Storage
+type GCSStorage interface {
+	Open(bucket string, object string) (io.ReadCloser, error)
+}
+
+func (g *GCSTarball) Get() error {
+	if err := prepareWorkDir(g.WorkDir); err != nil {
+		return err
+	}
+	storage, err := g.storage()
+	if err != nil {
+		return err
+	}
+	body, err := storage.Open(g.Bucket, g.Object)
+	if err != nil {
+		return fmt.Errorf("error downloading gs://%s/%s: %s", g.Bucket, g.Object, err)
+	}
+	defer body.Close()
+	r := throttle(body, g.MaxBytesPerSecond)
+
+	g.LocalTarball.ArchivePath = filepath.Join(g.WorkDir, path.Base(g.Object))
+	if g.Stream {
+		log.Infof("Downloading gs://%s/%s and extracting as it is read", g.Bucket, g.Object)
+		err = g.LocalTarball.extractReader(r)
+	} else {
+		err = g.download(r)
+		if err == nil {
+			err = g.LocalTarball.extract()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error extracting tarball: %s", err)
+	}
+	return nil
+}
+
+func (g *GCSTarball) download(body io.Reader) error {
+	log.Infof("Downloading gs://%s/%s to %s", g.Bucket, g.Object, g.ArchivePath)
+	out, err := os.Create(g.ArchivePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	n, err := io.Copy(out, body)
+	if err != nil {
+		return fmt.Errorf("error downloading gs://%s/%s: %s", g.Bucket, g.Object, err)
+	}
+	log.Infof("%v bytes written to %s", n, g.ArchivePath)
+	return nil
+}
+
+func (g *GCSTarball) storage() (GCSStorage, error) {
+	if g.Storage != nil {
+		return g.Storage, nil
+	}
+	return newGCSClient()
+}
+
+// How long reading an object from Cloud Storage or S3 may take, so a stalled download fails the
+// run rather than hanging it
+var objectTimeout = 10 * time.Minute
+
+// Reads objects with the Cloud Storage JSON API, authenticated with the application default
+// credentials: GOOGLE_APPLICATION_CREDENTIALS, those of "gcloud auth application-default login",
+// or the metadata server on Google Cloud. With STORAGE_EMULATOR_HOST set, objects are read from
+// the emulator there without credentials.
+type gcsClient struct {
+	client   *http.Client
+	endpoint string
+}
+
+func newGCSClient() (*gcsClient, error) {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return &gcsClient{client: &http.Client{Timeout: objectTimeout}, endpoint: strings.TrimRight(host, "/")}, nil
+	}
+	client, err := google.DefaultClient(context.Background(), gcsReadScope)
+	if err != nil {
+		return nil, fmt.Errorf("could not find Google Cloud credentials: %s", err)
+	}
+	client.Timeout = objectTimeout
+	return &gcsClient{client: client, endpoint: "https://storage.googleapis.com"}, nil
+}
+
+// The OAuth scope needed to read objects
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+func (c *gcsClient) Open(bucket string, object string) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", c.endpoint, url.PathEscape(bucket),
+		url.PathEscape(object))
+	res, err := c.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("status code %v", res.StatusCode)
+	}
+	return res.Body, nil
+}
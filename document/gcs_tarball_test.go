@@ -0,0 +1,153 @@
+package document
+
+import (
+	"context"
+	"golang.org/x/oauth2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Serves objects from local files, keyed by "<bucket>/<object>"
+type fakeGCSStorage map[string]string
+
+func (s fakeGCSStorage) Open(bucket string, object string) (io.ReadCloser, error) {
+	p, ok := s[bucket+"/"+object]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(p)
+}
+
+// The object is extracted the same whether it is saved first or streamed, and as a local tarball
+func TestGCSTarball_Get(t *testing.T) {
+	archive := filepath.Join(examplePath(), "example.tar.gz")
+	storage := fakeGCSStorage{"docs/releases/example.tar.gz": archive}
+
+	localDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	local := LocalTarball{ArchivePath: archive, WorkDir: localDir}
+	if err := local.Get(); err != nil {
+		t.Fatalf("Error extracting %s: %s", archive, err)
+	}
+	defer local.CleanUp()
+	localPath, err := local.Path()
+	if err != nil {
+		t.Fatalf("Error calling Path: %s", err)
+	}
+	expected := extractedFiles(t, localPath)
+
+	for _, stream := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+		if err != nil {
+			t.Fatalf("Could not create tempdir: %s", err)
+		}
+		g := GCSTarball{
+			LocalTarball: LocalTarball{WorkDir: tmpDir},
+			Bucket:       "docs",
+			Object:       "releases/example.tar.gz",
+			Stream:       stream,
+			Storage:      storage,
+		}
+		if err := g.Get(); err != nil {
+			t.Fatalf("Error calling Get with Stream %v: %s", stream, err)
+		}
+		defer g.CleanUp()
+
+		_, err = os.Stat(filepath.Join(tmpDir, "example.tar.gz"))
+		if stream != os.IsNotExist(err) {
+			t.Errorf("Expected the archive to be saved only when not streaming, Stream %v: %v", stream, err)
+		}
+		path, err := g.Path()
+		if err != nil {
+			t.Fatalf("Error calling Path with Stream %v: %s", stream, err)
+		}
+		if files := extractedFiles(t, path); !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected the same files as the local tarball with Stream %v, got %d files, not %d",
+				stream, len(files), len(expected))
+		}
+	}
+}
+
+func TestGCSTarball_GetMissing(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	g := GCSTarball{
+		LocalTarball: LocalTarball{WorkDir: tmpDir},
+		Bucket:       "docs",
+		Object:       "missing.tgz",
+		Storage:      fakeGCSStorage{},
+	}
+	if err := g.Get(); err == nil {
+		t.Errorf("Expected an error for a missing object")
+	}
+}
+
+// The JSON API is asked for the object's media, with the object name escaped
+func TestGCSClient_Open(t *testing.T) {
+	var gotPath, gotQuery, gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery, gotAuth = r.URL.EscapedPath(), r.URL.RawQuery, r.Header.Get("Authorization")
+		io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	c := &gcsClient{
+		client:   oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "ya29.token"})),
+		endpoint: ts.URL,
+	}
+	body, err := c.Open("docs", "releases/example.tar.gz")
+	if err != nil {
+		t.Fatalf("Error calling Open: %s", err)
+	}
+	defer body.Close()
+	content, _ := ioutil.ReadAll(body)
+
+	if expected := "/storage/v1/b/docs/o/releases%2Fexample.tar.gz"; gotPath != expected {
+		t.Errorf("Expected request to %q, got %q", expected, gotPath)
+	}
+	if gotQuery != "alt=media" {
+		t.Errorf("Expected query alt=media, got %q", gotQuery)
+	}
+	if gotAuth != "Bearer ya29.token" {
+		t.Errorf("Expected the access token as a bearer token, got %q", gotAuth)
+	}
+	if string(content) != "content" {
+		t.Errorf("Expected the object content, got %q", content)
+	}
+}
+
+func TestGCSClient_OpenNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	c := &gcsClient{client: ts.Client(), endpoint: ts.URL}
+	if _, err := c.Open("docs", "missing.tgz"); err == nil {
+		t.Errorf("Expected an error for a 404")
+	}
+}
+
+func TestNewGCSClient_Emulator(t *testing.T) {
+	os.Setenv("STORAGE_EMULATOR_HOST", "localhost:4443")
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+	c, err := newGCSClient()
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	if c.endpoint != "http://localhost:4443" {
+		t.Errorf("Expected the emulator endpoint, got %q", c.endpoint)
+	}
+	if c.client.Timeout != objectTimeout {
+		t.Errorf("Expected a timeout of %s, got %s", objectTimeout, c.client.Timeout)
+	}
+}
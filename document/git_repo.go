@@ -0,0 +1,53 @@
+package document
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Implements document.Set, for a git repository given as e.g. git+ssh://git@github.com/org/vault.git,
+// with the branch or tag to check out as its fragment (e.g. #v1.2), or the default branch if none.
+// It is cloned with the git command, so uses the same ssh keys and credential helpers as git does.
+type GitRepo struct {
+	WorkDir string
+	Url     string // as given to git clone, e.g. ssh://git@github.com/org/vault.git
+	Ref     string // branch or tag to check out
+	Dir     string // directory within the repository to use as the document path
+}
+
+func (g *GitRepo) Get() error {
+	if err := prepareWorkDir(g.WorkDir); err != nil {
+		return err
+	}
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if g.Ref != "" {
+		args = append(args, "--branch", g.Ref)
+	}
+	log.Infof("Cloning %s to %s", g.Url, g.clonePath())
+	out, err := exec.Command("git", append(args, "--", g.Url, g.clonePath())...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error cloning %s: %s: %s", g.Url, err, strings.TrimSpace(string(out)))
+	}
+	// not a document, and only the checked out files are needed
+	return os.RemoveAll(filepath.Join(g.clonePath(), ".git"))
+}
+
+// Return the path to the checked out files. It does not guarantee that they exist.
+func (g *GitRepo) Path() (string, error) {
+	return filepath.Join(g.clonePath(), g.Dir), nil
+}
+
+func (g *GitRepo) CleanUp() {
+	log.Infof("Removing %s", g.clonePath())
+	if err := os.RemoveAll(g.WorkDir); err != nil {
+		log.Error(err)
+	}
+}
+
+func (g *GitRepo) clonePath() string {
+	return filepath.Join(g.WorkDir, "repo")
+}
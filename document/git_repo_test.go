@@ -0,0 +1,84 @@
+package document
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// A repository with the auth method approle on its master branch, and userpass on tag v1
+func gitRepository(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v: %s: %s", args, err, out)
+		}
+	}
+	write := func(name string) {
+		os.MkdirAll(filepath.Join(dir, "sys", "auth"), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, "sys", "auth", name), []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "--quiet")
+	git("checkout", "--quiet", "-b", "master")
+	write("userpass.json")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "userpass")
+	git("tag", "v1")
+	os.Remove(filepath.Join(dir, "sys", "auth", "userpass.json"))
+	write("approle.json")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "approle")
+	return dir
+}
+
+func TestGitRepo_Get(t *testing.T) {
+	repo := gitRepository(t)
+	defer os.RemoveAll(repo)
+
+	for ref, expected := range map[string]string{"": "approle.json", "v1": "userpass.json"} {
+		workDir, err := ioutil.TempDir("", "fetcher-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := GitRepo{WorkDir: workDir, Url: "file://" + repo, Ref: ref, Dir: "sys"}
+		if err := g.Get(); err != nil {
+			t.Fatalf("Error calling Get for ref %q: %s", ref, err)
+		}
+		defer g.CleanUp()
+		path, err := g.Path()
+		if err != nil {
+			t.Fatalf("Error calling Path: %s", err)
+		}
+		files, err := ioutil.ReadDir(filepath.Join(path, "auth"))
+		if err != nil || len(files) != 1 || files[0].Name() != expected {
+			t.Errorf("Expected only %s checked out for ref %q, got %v (%v)", expected, ref, files, err)
+		}
+		if _, err := os.Stat(filepath.Join(workDir, "repo", ".git")); !os.IsNotExist(err) {
+			t.Errorf("Expected the .git directory to be removed, got %v", err)
+		}
+	}
+}
+
+func TestGitRepo_GetMissingRef(t *testing.T) {
+	repo := gitRepository(t)
+	defer os.RemoveAll(repo)
+	workDir, err := ioutil.TempDir("", "fetcher-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	g := GitRepo{WorkDir: workDir, Url: "file://" + repo, Ref: "v2"}
+	if err := g.Get(); err == nil {
+		t.Errorf("Expected an error for a missing ref")
+	}
+}
@@ -0,0 +1,30 @@
+package document
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+)
+
+// Hash the path and content of every file in fsys, so a later run can tell whether any of the
+// documents changed, e.g. since a plan or checkpoint was written
+func HashFS(fsys fs.FS) (string, error) {
+	h := sha256.New()
+	// WalkDir visits the files in lexical order, so the hash is the same on every run
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %x\n", path, sha256.Sum256(content))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -1,24 +1,42 @@
 package document
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 )
 
-// Implements document.Set
+// Implements document.Set. Url may also be a file:// url, e.g. for testing, which is copied to the
+// work directory (or streamed) in the same way.
 type HttpTarball struct {
 	LocalTarball
 	Url       *url.URL
 	AuthToken string
+	// extract the response as it is read, without saving the archive
+	Stream bool
+	// PEM file of the CA to verify the server with, in place of the system roots
+	CACert string
+	// don't verify the server's certificate; only for this download, not for Vault
+	InsecureSkipVerify bool
+	// limit the download to this many bytes a second, if set
+	MaxBytesPerSecond int64
 }
 
 // download tarball from Github
 func (h *HttpTarball) Get() (err error) {
+	if err := prepareWorkDir(h.WorkDir); err != nil {
+		return err
+	}
+	if h.Stream {
+		return h.stream()
+	}
 	downloadPath, err := h.download()
 	if err != nil {
 		return fmt.Errorf("error downloading tarball: %s", err)
@@ -55,31 +73,90 @@ func (h *HttpTarball) download() (path string, err error) {
 	}
 	defer out.Close()
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", h.Url.String(), nil)
+	body, err := h.request()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	n, err := io.Copy(out, throttle(body, h.MaxBytesPerSecond))
 	if err != nil {
 		return "", err
 	}
+	log.Infof("%v bytes written to %s", n, h.archivePath())
+
+	return out.Name(), nil
+}
+
+// Extract the tarball as it is downloaded, so the archive is never written to disk. It is extracted
+// where it would be had it been downloaded first.
+func (h *HttpTarball) stream() error {
+	log.Infof("Downloading from %s and extracting as it is read", h.Url.String())
+	body, err := h.request()
+	if err != nil {
+		return fmt.Errorf("error downloading tarball: %s", err)
+	}
+	defer body.Close()
+
+	h.LocalTarball.ArchivePath = h.archivePath()
+	err = h.LocalTarball.extractReader(throttle(body, h.MaxBytesPerSecond))
+	if err != nil {
+		return fmt.Errorf("error extracting tarball: %s", err)
+	}
+	return nil
+}
+
+// Request the tarball, returning the response body, or open it for a file:// url
+func (h *HttpTarball) request() (io.ReadCloser, error) {
+	if h.Url.Scheme == "file" {
+		return os.Open(h.Url.Path)
+	}
+	client, err := h.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", h.Url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
 	if h.AuthToken != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("token %s", h.AuthToken))
 	}
 	res, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return "", fmt.Errorf("status code %v", res.StatusCode)
+		res.Body.Close()
+		return nil, fmt.Errorf("status code %v", res.StatusCode)
 	}
+	return res.Body, nil
+}
 
-	n, err := io.Copy(out, res.Body)
-	if err != nil {
-		return "", err
+// The client to download with, verifying the server as given by CACert and InsecureSkipVerify
+func (h *HttpTarball) httpClient() (*http.Client, error) {
+	if h.CACert == "" && !h.InsecureSkipVerify {
+		return &http.Client{}, nil
 	}
-	log.Infof("%v bytes written to %s", n, h.archivePath())
-
-	return out.Name(), nil
+	tlsConfig := &tls.Config{InsecureSkipVerify: h.InsecureSkipVerify}
+	if h.CACert != "" {
+		pem, err := ioutil.ReadFile(h.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate %s: %s", h.CACert, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", h.CACert)
+		}
+	}
+	if h.InsecureSkipVerify {
+		log.Warnf("Not verifying the TLS certificate of %s", h.Url.Host)
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}, nil
 }
 
 func (h *HttpTarball) archivePath() (path string) {
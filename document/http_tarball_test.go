@@ -1,13 +1,19 @@
 package document
 
 import (
+	"encoding/pem"
 	"fmt"
+	"github.com/starlingbank/vaultsmith/config"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type TestHttpHandler struct {
@@ -69,5 +75,221 @@ func TestHttpTarball_Get(t *testing.T) {
 	}
 }
 
+func TestHttpTarball_MaxBytesPerSecond(t *testing.T) {
+	// with the newline, 1000 bytes
+	ts := httptest.NewServer(&TestHttpHandler{DummyData: strings.Repeat("x", 999)})
+	defer ts.Close()
+	url, _ := url.Parse(ts.URL + "/test-archive.tgz")
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	p := HttpTarball{
+		LocalTarball:      LocalTarball{WorkDir: tmpDir},
+		Url:               url,
+		MaxBytesPerSecond: 2000,
+	}
+	defer p.CleanUp()
+
+	start := time.Now()
+	if _, err := p.download(); err != nil {
+		t.Fatalf("Error downloading: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Expected 1000 bytes at 2000 bytes a second to take at least 500ms, took %s", elapsed)
+	}
+	if info, err := os.Stat(p.archivePath()); err != nil || info.Size() != 1000 {
+		t.Errorf("Expected all 1000 bytes to be downloaded, got %+v (%v)", info, err)
+	}
+}
+
 func TestHttpTarball_extract(t *testing.T) {
 }
+
+// Return the relative path and content of every file under dir
+func extractedFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not read %s: %s", dir, err)
+	}
+	return files
+}
+
+// Streaming extracts the same files as downloading first, without keeping the archive
+func TestHttpTarball_GetStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(examplePath(), "example.tar.gz"))
+	}))
+	defer ts.Close()
+	url, _ := url.Parse(ts.URL + "/example.tar.gz")
+
+	extracted := map[bool]map[string]string{}
+	for _, stream := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+		if err != nil {
+			t.Fatalf("Could not create tempdir: %s", err)
+		}
+		p := HttpTarball{
+			LocalTarball: LocalTarball{
+				WorkDir: tmpDir,
+			},
+			Url:    url,
+			Stream: stream,
+		}
+		if err := p.Get(); err != nil {
+			t.Fatalf("Error calling Get with Stream %v: %s", stream, err)
+		}
+		defer p.CleanUp()
+
+		_, err = os.Stat(p.archivePath())
+		if stream && !os.IsNotExist(err) {
+			t.Errorf("Expected no archive at %s when streaming", p.archivePath())
+		}
+		path, err := p.Path()
+		if err != nil {
+			t.Fatalf("Error calling Path with Stream %v: %s", stream, err)
+		}
+		extracted[stream] = extractedFiles(t, path)
+	}
+
+	if len(extracted[false]) == 0 {
+		t.Fatalf("Expected files to be extracted")
+	}
+	if !reflect.DeepEqual(extracted[false], extracted[true]) {
+		t.Errorf("Expected streaming to extract the same files, got %d files, not %d",
+			len(extracted[true]), len(extracted[false]))
+	}
+}
+
+// A file:// url is fetched as an http one would be, to the same archive path, extracting the same
+// files as the local tarball
+func TestHttpTarball_FileURL(t *testing.T) {
+	archive, err := filepath.Abs(filepath.Join(examplePath(), "example.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	localDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	local := LocalTarball{ArchivePath: archive, WorkDir: localDir}
+	if err := local.Get(); err != nil {
+		t.Fatalf("Error extracting %s: %s", archive, err)
+	}
+	defer local.CleanUp()
+	localPath, err := local.Path()
+	if err != nil {
+		t.Fatalf("Error calling Path: %s", err)
+	}
+	expected := extractedFiles(t, localPath)
+
+	for _, stream := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+		if err != nil {
+			t.Fatalf("Could not create tempdir: %s", err)
+		}
+		set, err := GetSet(tmpDir, config.VaultsmithConfig{DocumentPath: "file://" + archive, StreamTarball: stream})
+		if err != nil {
+			t.Fatalf("Error calling GetSet: %s", err)
+		}
+		p, ok := set.(*HttpTarball)
+		if !ok {
+			t.Fatalf("Expected a file:// url to be fetched as an HttpTarball, got %T", set)
+		}
+		if err := p.Get(); err != nil {
+			t.Fatalf("Error calling Get with Stream %v: %s", stream, err)
+		}
+		defer p.CleanUp()
+
+		if exp := filepath.Join(tmpDir, "example.tar.gz"); p.archivePath() != exp {
+			t.Errorf("Expected the archive path %s, got %s", exp, p.archivePath())
+		}
+		_, err = os.Stat(p.archivePath())
+		if stream != os.IsNotExist(err) {
+			t.Errorf("Expected the archive to be copied only when not streaming, Stream %v: %v", stream, err)
+		}
+		path, err := p.Path()
+		if err != nil {
+			t.Fatalf("Error calling Path with Stream %v: %s", stream, err)
+		}
+		if files := extractedFiles(t, path); !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected the same files as the local tarball with Stream %v, got %d files, not %d",
+				stream, len(files), len(expected))
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	missing, _ := url.Parse("file://" + filepath.Join(tmpDir, "missing.tar.gz"))
+	p := HttpTarball{LocalTarball: LocalTarball{WorkDir: tmpDir}, Url: missing}
+	if err := p.Get(); err == nil || !strings.Contains(err.Error(), "missing.tar.gz") {
+		t.Errorf("Expected an error naming the missing archive, got %v", err)
+	}
+}
+
+// A server with a self-signed certificate is only trusted with its CA or when not verifying
+func TestHttpTarball_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(examplePath(), "example.tar.gz"))
+	}))
+	defer ts.Close()
+	url, _ := url.Parse(ts.URL + "/example.tar.gz")
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	caCert := filepath.Join(tmpDir, "ca.pem")
+	err = ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		caCert   string
+		insecure bool
+		ok       bool
+	}{
+		"verified":    {},
+		"custom CA":   {caCert: caCert, ok: true},
+		"skip verify": {insecure: true, ok: true},
+		"missing CA":  {caCert: filepath.Join(tmpDir, "missing.pem")},
+	} {
+		workDir, err := ioutil.TempDir(tmpDir, "work-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := HttpTarball{
+			LocalTarball:       LocalTarball{WorkDir: workDir},
+			Url:                url,
+			CACert:             tc.caCert,
+			InsecureSkipVerify: tc.insecure,
+		}
+		err = p.Get()
+		if tc.ok && err != nil {
+			t.Errorf("%s: expected the download to succeed, got %s", name, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s: expected the download to fail", name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package document
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the file in the root of the document path listing files that are not vault documents
+const IgnoreFileName = ".vaultsmithignore"
+
+// Ignore matches paths against gitignore-style patterns. Patterns are relative to the document
+// root; those without a slash match at any depth, a trailing slash only matches directories, "**"
+// matches any number of directories and a leading "!" re-includes a path. A nil *Ignore matches
+// nothing.
+type Ignore struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Load the ignore file from the root of the document path, if there is one
+func LoadIgnore(root string) (*Ignore, error) {
+	return LoadIgnoreFS(os.DirFS(root))
+}
+
+// As LoadIgnore, for documents in fsys
+func LoadIgnoreFS(fsys fs.FS) (*Ignore, error) {
+	file, err := fsys.Open(IgnoreFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Ignore{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %s", IgnoreFileName, err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", IgnoreFileName, err)
+	}
+	return NewIgnore(lines)
+}
+
+// Build an Ignore from the lines of an ignore file
+func NewIgnore(lines []string) (*Ignore, error) {
+	ignore := &Ignore{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		re, err := regexp.Compile(globToRegexp(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %s", line, IgnoreFileName, err)
+		}
+		p.re = re
+		ignore.patterns = append(ignore.patterns, p)
+	}
+	return ignore, nil
+}
+
+// Return true if relPath (relative to the document root) or any directory containing it is ignored
+func (i *Ignore) Match(relPath string, isDir bool) bool {
+	if i == nil || len(i.patterns) == 0 {
+		return false
+	}
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	if relPath == "." {
+		return false
+	}
+	parts := strings.Split(relPath, "/")
+	for n := 1; n < len(parts); n++ {
+		if i.matches(strings.Join(parts[:n], "/"), true) {
+			return true
+		}
+	}
+	return i.matches(relPath, isDir)
+}
+
+// the last pattern matching a path decides whether it is ignored
+func (i *Ignore) matches(relPath string, isDir bool) (ignored bool) {
+	for _, p := range i.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(relPath) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// Convert a gitignore-style glob to an anchored regular expression
+func globToRegexp(glob string) string {
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
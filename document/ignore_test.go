@@ -0,0 +1,61 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestIgnore_Match(t *testing.T) {
+	ignore, err := NewIgnore([]string{
+		"# comments and blank lines are skipped",
+		"",
+		"*.md",
+		"!docs/keep.md",
+		"scripts/",
+		"auth/**/draft.json",
+		"/tmp.json",
+	})
+	if err != nil {
+		t.Fatalf("Error calling NewIgnore: %s", err)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"README.md", false, true},
+		{"auth/aws/README.md", false, true},
+		{"docs/keep.md", false, false},
+		{"scripts", true, true},
+		{"scripts/apply.sh", false, true},
+		{"scripts", false, false}, // only directories
+		{"auth/draft.json", false, true},
+		{"auth/aws/role/draft.json", false, true},
+		{"sys/draft.json", false, false},
+		{"tmp.json", false, true},
+		{"auth/tmp.json", false, false}, // anchored to the root
+		{"auth/aws/config/client.json", false, false},
+	}
+	for _, test := range tests {
+		if ignored := ignore.Match(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("Match(%q, %t) = %t, expected %t", test.path, test.isDir, ignored, test.ignored)
+		}
+	}
+}
+
+func TestIgnore_Nil(t *testing.T) {
+	var ignore *Ignore
+	if ignore.Match("README.md", false) {
+		t.Errorf("nil Ignore should not match anything")
+	}
+}
+
+func TestLoadIgnore_Missing(t *testing.T) {
+	ignore, err := LoadIgnore(examplePath())
+	if err != nil {
+		t.Fatalf("Error calling LoadIgnore: %s", err)
+	}
+	if ignore.Match("auth/aws/config/client.json", false) {
+		t.Errorf("Expected nothing to be ignored without an ignore file")
+	}
+}
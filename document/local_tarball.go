@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Implements document.Set
@@ -19,6 +20,9 @@ type LocalTarball struct {
 }
 
 func (l *LocalTarball) Get() (err error) {
+	if err := prepareWorkDir(l.WorkDir); err != nil {
+		return err
+	}
 	return l.extract()
 }
 
@@ -61,6 +65,12 @@ func (l *LocalTarball) extract() (err error) {
 	if err != nil {
 		return fmt.Errorf("could not open file %q: %s", l.ArchivePath, err)
 	}
+	defer f.Close()
+	return l.extractReader(f)
+}
+
+// Extract the gzipped tar archive read from f to extractPath
+func (l *LocalTarball) extractReader(f io.Reader) (err error) {
 	r, err := gzip.NewReader(f)
 	if err != nil {
 		return fmt.Errorf("could not create gzip reader for %q: %s", l.ArchivePath, err)
@@ -82,14 +92,20 @@ func (l *LocalTarball) extract() (err error) {
 		}
 		switch hdr.Typeflag {
 		case tar.TypeDir: // create dir
-			dd := filepath.Join(destDir, hdr.Name)
+			dd, err := extractTarget(destDir, hdr.Name)
+			if err != nil {
+				return err
+			}
 			log.Debugf("Creating %q", dd)
-			err := os.MkdirAll(dd, 0777)
+			err = os.MkdirAll(dd, 0777)
 			if err != nil {
 				return fmt.Errorf("error creating directory %q: %s", dd, err)
 			}
 		case tar.TypeReg, tar.TypeRegA:
-			df := filepath.Join(destDir, hdr.Name)
+			df, err := extractTarget(destDir, hdr.Name)
+			if err != nil {
+				return err
+			}
 			log.Infof("Extracting %q", df)
 			w, err := os.Create(df)
 			if err != nil {
@@ -108,6 +124,17 @@ func (l *LocalTarball) extract() (err error) {
 	return
 }
 
+// Return where to extract the archive entry called name, refusing any that would be written outside
+// of destDir (e.g. "../../etc/cron.d/evil"), as archives may be downloaded from anywhere
+func extractTarget(destDir string, name string) (string, error) {
+	target := filepath.Join(destDir, name)
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %q would be extracted outside of %s", name, destDir)
+	}
+	return target, nil
+}
+
 func (l *LocalTarball) extractPath() (path string) {
 	_, file := filepath.Split(l.ArchivePath)
 
@@ -1,6 +1,8 @@
 package document
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
@@ -76,3 +78,63 @@ func TestLocalTarball_extractPath(t *testing.T) {
 		t.Errorf("Expected %q, got %q", exp, r)
 	}
 }
+
+// write a gzipped tarball containing a single file called name
+func craftTarball(t *testing.T, dir string, name string) string {
+	archivePath := filepath.Join(dir, "evil.tar.gz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	content := []byte("evil")
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write(content)
+	tw.Close()
+	gw.Close()
+	return archivePath
+}
+
+func TestLocalTarball_extract_PathTraversal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	workDir := filepath.Join(tmpDir, "work")
+	os.MkdirAll(workDir, 0755)
+
+	l := LocalTarball{
+		WorkDir:     workDir,
+		ArchivePath: craftTarball(t, tmpDir, "../../evil"),
+	}
+	os.MkdirAll(l.extractPath(), 0755)
+
+	err = l.extract()
+	if err == nil {
+		t.Errorf("Expected an error extracting an entry outside of the extract directory")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("File was written outside of the extract directory")
+	}
+}
+
+func TestExtractTarget(t *testing.T) {
+	tests := map[string]bool{ // entry name -> allowed
+		"foo/bar.json":   true,
+		"./foo":          true,
+		"foo/../bar":     true,
+		"/etc/passwd":    true, // joined onto the destination, so stays within it
+		"../evil":        false,
+		"foo/../../evil": false,
+		"..":             false,
+	}
+	for name, allowed := range tests {
+		_, err := extractTarget("/tmp/extract", name)
+		if (err == nil) != allowed {
+			t.Errorf("extractTarget(%q) allowed = %t, expected %t (err: %v)", name, err == nil, allowed, err)
+		}
+	}
+}
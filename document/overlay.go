@@ -0,0 +1,117 @@
+package document
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+	Overlay merges several document sets into one tree, copying each over those before it. A file
+	in a later set replaces the file at the same path relative to the root of an earlier one, and
+	directories are merged, so e.g. an environment's documents can override a few files of a shared
+	base. A later set can't remove anything, and a file where an earlier set has a directory (or the
+	reverse) is an error.
+
+	Implements document.Set
+*/
+type Overlay struct {
+	WorkDir string
+	Layers  []Layer // in order, the last taking precedence
+}
+
+// One of the sets merged by an Overlay, and the directory it can use for any temporary files
+type Layer struct {
+	Set     Set
+	WorkDir string
+}
+
+func (o *Overlay) Get() error {
+	err := os.RemoveAll(o.mergedPath())
+	if err != nil {
+		return fmt.Errorf("could not clear %s: %s", o.mergedPath(), err)
+	}
+	err = os.MkdirAll(o.mergedPath(), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %s", o.mergedPath(), err)
+	}
+	for _, l := range o.Layers {
+		err := l.Set.Get()
+		if err != nil {
+			return err
+		}
+		root, err := l.Set.Path()
+		if err != nil {
+			return err
+		}
+		if root == "" {
+			continue // empty tarball, already warned about
+		}
+		err = overlayTree(root, o.mergedPath())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the path to the merged documents
+func (o *Overlay) Path() (string, error) {
+	return o.mergedPath(), nil
+}
+
+// Remove the merged documents along with anything temporary from each layer, but not the
+// documents of the layers themselves
+func (o *Overlay) CleanUp() {
+	for _, l := range o.Layers {
+		l.Set.CleanUp()
+		if err := os.RemoveAll(l.WorkDir); err != nil {
+			log.Error(err)
+		}
+	}
+	log.Infof("Removing %s", o.mergedPath())
+	if err := os.RemoveAll(o.mergedPath()); err != nil {
+		log.Error(err)
+	}
+}
+
+func (o *Overlay) mergedPath() string {
+	return filepath.Join(o.WorkDir, "overlay")
+}
+
+// Copy the files under src to the same relative paths under dst, replacing any already there
+func overlayTree(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		existing, statErr := os.Stat(target)
+		if statErr == nil && existing.IsDir() != info.IsDir() {
+			return fmt.Errorf("cannot overlay %s: %s is a file in one document path and a "+
+				"directory in another", path, rel)
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			log.Warnf("Not overlaying %s, which is not a regular file", path)
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if statErr == nil {
+			log.WithFields(log.Fields{"path": rel, "from": src}).Debug("Overriding document")
+		}
+		return ioutil.WriteFile(target, content, info.Mode().Perm())
+	})
+}
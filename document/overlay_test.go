@@ -0,0 +1,75 @@
+package document
+
+import (
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	base := testutil.WriteDocuments(t, map[string]string{
+		"sys/policy/read.json":  `{"policy": "base"}`,
+		"sys/policy/write.json": `{"policy": "base"}`,
+		"sys/auth/approle.json": `{"type": "approle"}`,
+	})
+	env := testutil.WriteDocuments(t, map[string]string{
+		"sys/policy/read.json":   `{"policy": "env"}`,
+		"sys/policy/deploy.json": `{"policy": "env"}`,
+	})
+	workDir := filepath.Join(t.TempDir(), "work")
+
+	set, err := GetSet(workDir, config.VaultsmithConfig{DocumentPath: base, OverlayPaths: []string{env}})
+	if err != nil {
+		t.Fatalf("GetSet failed: %s", err)
+	}
+	if err := set.Get(); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	merged, err := set.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"sys/policy/read.json":   `{"policy": "env"}`,
+		"sys/policy/write.json":  `{"policy": "base"}`,
+		"sys/policy/deploy.json": `{"policy": "env"}`,
+		"sys/auth/approle.json":  `{"type": "approle"}`,
+	}
+	for name, exp := range expected {
+		content, err := ioutil.ReadFile(filepath.Join(merged, name))
+		if err != nil {
+			t.Errorf("Expected %s in merged documents: %s", name, err)
+		} else if string(content) != exp {
+			t.Errorf("Expected %s to be %s, got %s", name, exp, content)
+		}
+	}
+
+	set.CleanUp()
+	if _, err := os.Stat(merged); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", merged, err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "sys/policy/read.json")); err != nil {
+		t.Errorf("Expected the base documents to be left alone: %s", err)
+	}
+}
+
+func TestOverlay_FileReplacingDirectory(t *testing.T) {
+	base := testutil.WriteDocuments(t, map[string]string{"sys/policy/read.json": `{"policy": "base"}`})
+	env := testutil.WriteDocuments(t, map[string]string{"sys/policy": `{}`})
+
+	set, err := GetSet(filepath.Join(t.TempDir(), "work"), config.VaultsmithConfig{
+		DocumentPath: base,
+		OverlayPaths: []string{env},
+	})
+	if err != nil {
+		t.Fatalf("GetSet failed: %s", err)
+	}
+	defer set.CleanUp()
+	if err := set.Get(); err == nil {
+		t.Error("Expected an error overlaying a file on a directory")
+	}
+}
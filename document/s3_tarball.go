@@ -0,0 +1,110 @@
+package document
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Implements document.Set, for a tarball in Amazon S3 given as s3://bucket/key.tgz
+type S3Tarball struct {
+	LocalTarball
+	Bucket string
+	Key    string
+	// extract the object as it is read, without saving the archive
+	Stream bool
+	// where the object is read from; S3 with the default credentials if nil
+	Storage S3Storage
+	// limit the download to this many bytes a second, if set
+	MaxBytesPerSecond int64
+}
+
+// Reads objects from S3
+type S3Storage interface {
+	Open(bucket string, key string) (io.ReadCloser, error)
+}
+
+func (s *S3Tarball) Get() error {
+	if err := prepareWorkDir(s.WorkDir); err != nil {
+		return err
+	}
+	storage, err := s.storage()
+	if err != nil {
+		return err
+	}
+	body, err := storage.Open(s.Bucket, s.Key)
+	if err != nil {
+		return fmt.Errorf("error downloading s3://%s/%s: %s", s.Bucket, s.Key, err)
+	}
+	defer body.Close()
+	r := throttle(body, s.MaxBytesPerSecond)
+
+	s.LocalTarball.ArchivePath = filepath.Join(s.WorkDir, path.Base(s.Key))
+	if s.Stream {
+		log.Infof("Downloading s3://%s/%s and extracting as it is read", s.Bucket, s.Key)
+		err = s.LocalTarball.extractReader(r)
+	} else {
+		err = s.download(r)
+		if err == nil {
+			err = s.LocalTarball.extract()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error extracting tarball: %s", err)
+	}
+	return nil
+}
+
+func (s *S3Tarball) download(body io.Reader) error {
+	log.Infof("Downloading s3://%s/%s to %s", s.Bucket, s.Key, s.ArchivePath)
+	out, err := os.Create(s.ArchivePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	n, err := io.Copy(out, body)
+	if err != nil {
+		return fmt.Errorf("error downloading s3://%s/%s: %s", s.Bucket, s.Key, err)
+	}
+	log.Infof("%v bytes written to %s", n, s.ArchivePath)
+	return nil
+}
+
+func (s *S3Tarball) storage() (S3Storage, error) {
+	if s.Storage != nil {
+		return s.Storage, nil
+	}
+	return newS3Client()
+}
+
+// Reads objects with the AWS SDK's default credentials and region: the AWS_* environment
+// variables, the shared config and credentials files (~/.aws), or the instance or task role
+type s3Client struct {
+	client *s3.S3
+}
+
+func newS3Client() (*s3Client, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{HTTPClient: &http.Client{Timeout: objectTimeout}},
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create an AWS session: %s", err)
+	}
+	return &s3Client{client: s3.New(sess)}, nil
+}
+
+func (c *s3Client) Open(bucket string, key string) (io.ReadCloser, error) {
+	out, err := c.client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
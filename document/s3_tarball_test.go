@@ -0,0 +1,88 @@
+package document
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The object is extracted as a local tarball would be
+func TestS3Tarball_Get(t *testing.T) {
+	archive := filepath.Join(examplePath(), "example.tar.gz")
+	localDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	local := LocalTarball{ArchivePath: archive, WorkDir: localDir}
+	if err := local.Get(); err != nil {
+		t.Fatalf("Error extracting %s: %s", archive, err)
+	}
+	defer local.CleanUp()
+	localPath, err := local.Path()
+	if err != nil {
+		t.Fatalf("Error calling Path: %s", err)
+	}
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	s := S3Tarball{
+		LocalTarball: LocalTarball{WorkDir: tmpDir},
+		Bucket:       "docs",
+		Key:          "releases/example.tar.gz",
+		// the same as Cloud Storage, keyed by "<bucket>/<key>"
+		Storage: fakeGCSStorage{"docs/releases/example.tar.gz": archive},
+	}
+	if err := s.Get(); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	defer s.CleanUp()
+	path, err := s.Path()
+	if err != nil {
+		t.Fatalf("Error calling Path: %s", err)
+	}
+	if files, expected := extractedFiles(t, path), extractedFiles(t, localPath); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected the same files as the local tarball, got %d files, not %d", len(files), len(expected))
+	}
+}
+
+// The object is read with a GET of the bucket and key
+func TestS3Client_Open(t *testing.T) {
+	var gotPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(ts.URL),
+		Region:           aws.String("eu-west-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	c := &s3Client{client: s3.New(sess)}
+	body, err := c.Open("docs", "releases/example.tar.gz")
+	if err != nil {
+		t.Fatalf("Error calling Open: %s", err)
+	}
+	defer body.Close()
+	content, _ := ioutil.ReadAll(body)
+
+	if expected := "/docs/releases/example.tar.gz"; gotPath != expected {
+		t.Errorf("Expected request to %q, got %q", expected, gotPath)
+	}
+	if string(content) != "content" {
+		t.Errorf("Expected the object content, got %q", content)
+	}
+}
@@ -0,0 +1,169 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+/*
+	Schema is the subset of JSON Schema needed to describe the documents vaultsmith applies: type,
+	properties, additionalProperties (as a boolean), items and required. It's enough to catch
+	misspelled keys and values of the wrong type, which would otherwise be silently dropped when
+	the document is unmarshalled.
+*/
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Required             []string           `json:"required"`
+}
+
+// A schema type may be given as a single string or a list of them
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings: %s", err)
+	}
+	*t = list
+	return nil
+}
+
+// Parse a JSON Schema document
+func ParseSchema(content string) (*Schema, error) {
+	var s Schema
+	err := json.Unmarshal([]byte(content), &s)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse schema: %s", err)
+	}
+	return &s, nil
+}
+
+// Return the schema for the document applied to resourcePath (e.g. "sys/auth/approle"), or nil if
+// there isn't one for that type of resource
+func SchemaFor(resourcePath string) *Schema {
+	resourcePath = strings.Trim(resourcePath, "/")
+	var longest string
+	for prefix := range resourceSchemas {
+		if strings.HasPrefix(resourcePath, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return nil
+	}
+	return resourceSchemas[longest]
+}
+
+// Validate the JSON document content, returning an error listing every problem found. If
+// allowUnknownFields, fields which aren't in the schema aren't a problem, though the type of every
+// field which is, and that the required ones are there, still is.
+func (s *Schema) Validate(content string, allowUnknownFields bool) error {
+	var doc interface{}
+	err := json.Unmarshal([]byte(content), &doc)
+	if err != nil {
+		return fmt.Errorf("could not parse json: %s", err)
+	}
+	problems := s.validate("", doc, allowUnknownFields)
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *Schema) validate(at string, value interface{}, allowUnknownFields bool) (problems []string) {
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		return []string{fmt.Sprintf("%s should be %s, not %s",
+			describe(at), strings.Join(s.Type, " or "), jsonType(value))}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, fmt.Sprintf("missing required field %q%s", name, within(at)))
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				problems = append(problems, prop.validate(join(at, k), v[k], allowUnknownFields)...)
+			} else if !allowUnknownFields && s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				problems = append(problems, fmt.Sprintf("unknown field %q%s", k, within(at)))
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", at, i), item, allowUnknownFields)...)
+			}
+		}
+	}
+	return problems
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := jsonType(value)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// The JSON Schema type of a value decoded by encoding/json
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func join(at string, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+func describe(at string) string {
+	if at == "" {
+		return "document"
+	}
+	return fmt.Sprintf("field %q", at)
+}
+
+func within(at string) string {
+	if at == "" {
+		return ""
+	}
+	return fmt.Sprintf(" in %q", at)
+}
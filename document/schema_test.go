@@ -0,0 +1,52 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchema_Validate_UnknownField(t *testing.T) {
+	schema := SchemaFor("sys/auth/approle")
+	if schema == nil {
+		t.Fatal("Expected a schema for sys/auth")
+	}
+	err := schema.Validate(`{"type": "approle", "config": {"default_lase_ttl": "1h"}}`, false)
+	if err == nil {
+		t.Fatal("Expected an error for an unknown field")
+	}
+	if !strings.Contains(err.Error(), `unknown field "default_lase_ttl" in "config"`) {
+		t.Errorf("Expected error to name the unknown field, got: %s", err)
+	}
+}
+
+func TestSchema_Validate_TypeMismatch(t *testing.T) {
+	err := SchemaFor("sys/quotas/lease-count/global").Validate(`{"max_leases": "100"}`, false)
+	if err == nil {
+		t.Fatal("Expected an error for a string where an integer is required")
+	}
+	if !strings.Contains(err.Error(), `field "max_leases" should be integer, not string`) {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestSchema_Validate_Valid(t *testing.T) {
+	docs := map[string]string{
+		"sys/auth/aws":                `{"type": "aws", "config": {"max_lease_ttl": "0"}, "local": false}`,
+		"sys/mounts/secret":           `{"type": "kv", "options": {"version": "2"}}`,
+		"sys/policy/read":             `{"policy": "path \"secret/*\" {}"}`,
+		"sys/quotas/rate-limit/api":   `{"rate": 10.5, "interval": "1s", "path": "auth/"}`,
+		"sys/config/cors":             `{"enabled": true, "allowed_origins": "*"}`,
+		"sys/quotas/lease-count/base": `{"max_leases": 100}`,
+	}
+	for path, doc := range docs {
+		if err := SchemaFor(path).Validate(doc, false); err != nil {
+			t.Errorf("Expected %s to be valid, got: %s", path, err)
+		}
+	}
+}
+
+func TestSchemaFor_Unknown(t *testing.T) {
+	if s := SchemaFor("secret/foo"); s != nil {
+		t.Errorf("Expected no schema for secret/foo, got %+v", s)
+	}
+}
@@ -0,0 +1,206 @@
+package document
+
+// Schemas for the documents of the resource types vaultsmith knows about, keyed by the resource path
+// prefix they apply to. Documents for any other path are not validated.
+var resourceSchemas = map[string]*Schema{
+	"sys/auth/":                            mustParseSchema(authSchema),
+	"sys/mounts/":                          mustParseSchema(mountSchema),
+	"sys/policy/":                          mustParseSchema(policySchema),
+	"sys/policies/acl/":                    mustParseSchema(policySchema),
+	"sys/policies/rgp/":                    mustParseSchema(rgpSchema),
+	"sys/policies/egp/":                    mustParseSchema(egpSchema),
+	"sys/plugins/catalog/":                 mustParseSchema(pluginSchema),
+	"sys/config/cors":                      mustParseSchema(corsSchema),
+	"sys/config/auditing/request-headers/": mustParseSchema(auditHeaderSchema),
+	"sys/quotas/config":                    mustParseSchema(quotaConfigSchema),
+	"sys/quotas/rate-limit/":               mustParseSchema(rateLimitQuotaSchema),
+	"sys/quotas/lease-count/":              mustParseSchema(leaseCountQuotaSchema),
+	"transit/keys/":                        mustParseSchema(transitKeySchema),
+}
+
+func mustParseSchema(content string) *Schema {
+	s, err := ParseSchema(content)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// sys/auth/<path>, as api.EnableAuthOptions
+const authSchema = `{
+  "type": "object",
+  "required": ["type"],
+  "additionalProperties": false,
+  "properties": {
+    "type": {"type": "string"},
+    "description": {"type": "string"},
+    "local": {"type": "boolean"},
+    "plugin_name": {"type": "string"},
+    "seal_wrap": {"type": "boolean"},
+    "options": {"type": "object"},
+    "config": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "default_lease_ttl": {"type": "string"},
+        "max_lease_ttl": {"type": "string"},
+        "plugin_name": {"type": "string"},
+        "audit_non_hmac_request_keys": {"type": "array", "items": {"type": "string"}},
+        "audit_non_hmac_response_keys": {"type": "array", "items": {"type": "string"}},
+        "listing_visibility": {"type": "string"},
+        "passthrough_request_headers": {"type": "array", "items": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+// sys/mounts/<path>, as api.MountInput
+const mountSchema = `{
+  "type": "object",
+  "required": ["type"],
+  "additionalProperties": false,
+  "properties": {
+    "type": {"type": "string"},
+    "description": {"type": "string"},
+    "local": {"type": "boolean"},
+    "plugin_name": {"type": "string"},
+    "seal_wrap": {"type": "boolean"},
+    "options": {"type": "object"},
+    "config": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "options": {"type": "object"},
+        "default_lease_ttl": {"type": "string"},
+        "description": {"type": "string"},
+        "max_lease_ttl": {"type": "string"},
+        "force_no_cache": {"type": "boolean"},
+        "plugin_name": {"type": "string"},
+        "audit_non_hmac_request_keys": {"type": "array", "items": {"type": "string"}},
+        "audit_non_hmac_response_keys": {"type": "array", "items": {"type": "string"}},
+        "listing_visibility": {"type": "string"},
+        "passthrough_request_headers": {"type": "array", "items": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+// sys/policy/<name>
+const policySchema = `{
+  "type": "object",
+  "required": ["policy"],
+  "additionalProperties": false,
+  "properties": {
+    "policy": {"type": "string"}
+  }
+}`
+
+// sys/policies/rgp/<name>
+const rgpSchema = `{
+  "type": "object",
+  "required": ["policy", "enforcement_level"],
+  "additionalProperties": false,
+  "properties": {
+    "policy": {"type": "string"},
+    "enforcement_level": {"type": "string"}
+  }
+}`
+
+// sys/policies/egp/<name>
+const egpSchema = `{
+  "type": "object",
+  "required": ["policy", "enforcement_level", "paths"],
+  "additionalProperties": false,
+  "properties": {
+    "policy": {"type": "string"},
+    "enforcement_level": {"type": "string"},
+    "paths": {"type": ["string", "array"], "items": {"type": "string"}}
+  }
+}`
+
+// sys/plugins/catalog/<type>/<name>
+const pluginSchema = `{
+  "type": "object",
+  "required": ["sha256", "command"],
+  "additionalProperties": false,
+  "properties": {
+    "sha256": {"type": "string"},
+    "command": {"type": "string"},
+    "args": {"type": "array", "items": {"type": "string"}}
+  }
+}`
+
+// sys/config/cors
+const corsSchema = `{
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "enabled": {"type": "boolean"},
+    "allowed_origins": {"type": ["string", "array"], "items": {"type": "string"}},
+    "allowed_headers": {"type": ["string", "array"], "items": {"type": "string"}}
+  }
+}`
+
+// sys/config/auditing/request-headers/<name>
+const auditHeaderSchema = `{
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "hmac": {"type": "boolean"}
+  }
+}`
+
+// sys/quotas/config
+const quotaConfigSchema = `{
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "rate_limit_exempt_paths": {"type": "array", "items": {"type": "string"}},
+    "enable_rate_limit_audit_logging": {"type": "boolean"},
+    "enable_rate_limit_response_headers": {"type": "boolean"}
+  }
+}`
+
+// sys/quotas/rate-limit/<name>
+const rateLimitQuotaSchema = `{
+  "type": "object",
+  "required": ["rate"],
+  "additionalProperties": false,
+  "properties": {
+    "path": {"type": "string"},
+    "role": {"type": "string"},
+    "rate": {"type": "number"},
+    "interval": {"type": ["string", "integer"]},
+    "block_interval": {"type": ["string", "integer"]},
+    "inheritable": {"type": "boolean"}
+  }
+}`
+
+// sys/quotas/lease-count/<name>
+const leaseCountQuotaSchema = `{
+  "type": "object",
+  "required": ["max_leases"],
+  "additionalProperties": false,
+  "properties": {
+    "path": {"type": "string"},
+    "role": {"type": "string"},
+    "max_leases": {"type": "integer"},
+    "inheritable": {"type": "boolean"}
+  }
+}`
+
+// transit/keys/<name>
+const transitKeySchema = `{
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "type": {"type": "string"},
+    "exportable": {"type": "boolean"},
+    "allow_plaintext_backup": {"type": "boolean"},
+    "derived": {"type": "boolean"},
+    "convergent_encryption": {"type": "boolean"},
+    "min_decryption_version": {"type": "integer"},
+    "min_encryption_version": {"type": "integer"},
+    "auto_rotate_period": {"type": ["string", "integer"]}
+  }
+}`
@@ -2,10 +2,11 @@ package document
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/config"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // Retrieve the configuration files that we want to apply to Vault
@@ -15,11 +16,15 @@ type Set interface {
 	CleanUp()              // remove all temporary files
 }
 
-// Return the appropriate document.Set for the given path
+// Return the appropriate document.Set for the given path, overlaid with any in config.OverlayPaths
 func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err error) {
+	if len(config.OverlayPaths) > 0 {
+		return getOverlay(workDir, config)
+	}
+
 	u, err := url.Parse(config.DocumentPath)
 	if err != nil {
-		log.Error(err)
+		return nil, fmt.Errorf("could not parse document path %q: %s", config.DocumentPath, err)
 	}
 
 	switch u.Scheme {
@@ -31,6 +36,43 @@ func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err err
 			},
 			Url:       u,
 			AuthToken: config.HttpAuthToken,
+			Stream:    config.StreamTarball,
+			CACert:    config.HttpCACert,
+			// only for the documents; the Vault client has its own TLS settings
+			InsecureSkipVerify: config.HttpInsecureSkipVerify,
+			MaxBytesPerSecond:  config.MaxDownloadRate,
+		}, nil
+	case "gs":
+		return &GCSTarball{
+			LocalTarball: LocalTarball{
+				TarDir:  config.TarDir,
+				WorkDir: workDir,
+			},
+			Bucket:            u.Host,
+			Object:            strings.TrimPrefix(u.Path, "/"),
+			Stream:            config.StreamTarball,
+			MaxBytesPerSecond: config.MaxDownloadRate,
+		}, nil
+	case "s3":
+		return &S3Tarball{
+			LocalTarball: LocalTarball{
+				TarDir:  config.TarDir,
+				WorkDir: workDir,
+			},
+			Bucket:            u.Host,
+			Key:               strings.TrimPrefix(u.Path, "/"),
+			Stream:            config.StreamTarball,
+			MaxBytesPerSecond: config.MaxDownloadRate,
+		}, nil
+	case "git", "git+ssh", "git+https", "git+file":
+		ref := u.Fragment
+		u.Fragment = ""
+		u.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+		return &GitRepo{
+			WorkDir: workDir,
+			Url:     u.String(),
+			Ref:     ref,
+			Dir:     config.TarDir,
 		}, nil
 	case "", "file":
 		// local filesystem, handled below
@@ -40,7 +82,11 @@ func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err err
 	}
 
 	// From here we are assuming path points to the local file system
-	p, err := os.Stat(config.DocumentPath)
+	docPath := config.DocumentPath
+	if u.Scheme == "file" {
+		docPath = u.Path
+	}
+	p, err := os.Stat(docPath)
 	if err != nil {
 		return nil, fmt.Errorf("error reading %q: %s", config.DocumentPath, err)
 	}
@@ -49,16 +95,55 @@ func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err err
 		// Should be an directory of files
 		return &LocalFiles{
 			WorkDir:   workDir,
-			Directory: config.DocumentPath,
+			Directory: docPath,
+		}, nil
+	case mode.IsRegular() && strings.HasSuffix(docPath, ".json"):
+		// every document in a single file
+		return &CombinedFile{
+			WorkDir:  workDir,
+			FilePath: docPath,
+		}, nil
+	case mode.IsRegular() && u.Scheme == "file":
+		// fetched as though it were downloaded, so behaves the same as an http url
+		return &HttpTarball{
+			LocalTarball: LocalTarball{
+				TarDir:  config.TarDir,
+				WorkDir: workDir,
+			},
+			Url:               u,
+			Stream:            config.StreamTarball,
+			MaxBytesPerSecond: config.MaxDownloadRate,
 		}, nil
 	case mode.IsRegular():
 		// Should be an archive
 		return &LocalTarball{
 			WorkDir:     workDir,
-			ArchivePath: config.DocumentPath,
+			ArchivePath: docPath,
 			TarDir:      config.TarDir,
 		}, nil
 	default:
 		return nil, fmt.Errorf("don't know what to do with mode %s", mode)
 	}
 }
+
+// Return an Overlay of config.DocumentPath and each of config.OverlayPaths, in that order
+func getOverlay(workDir string, config config.VaultsmithConfig) (Set, error) {
+	overlay := &Overlay{WorkDir: workDir}
+	for i, p := range append([]string{config.DocumentPath}, config.OverlayPaths...) {
+		// each layer gets its own directory, as a tarball removes its work directory on clean up
+		layerDir := filepath.Join(workDir, fmt.Sprintf("layer-%d", i))
+		err := os.MkdirAll(layerDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("could not create %s: %s", layerDir, err)
+		}
+		layerConfig := config
+		layerConfig.DocumentPath = p
+		layerConfig.OverlayPaths = nil
+		set, err := GetSet(layerDir, layerConfig)
+		if err != nil {
+			return nil, err
+		}
+		overlay.Layers = append(overlay.Layers, Layer{Set: set, WorkDir: layerDir})
+	}
+	return overlay, nil
+}
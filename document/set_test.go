@@ -0,0 +1,72 @@
+package document
+
+import (
+	"fmt"
+	"github.com/starlingbank/vaultsmith/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The scheme of the document path selects the Set, as for --source
+func TestGetSet(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	example, err := filepath.Abs(examplePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(example, "example.tar.gz")
+	for rawurl, expected := range map[string]string{
+		example:                                    "*document.LocalFiles",
+		"file://" + example:                        "*document.LocalFiles",
+		archive:                                    "*document.LocalTarball",
+		"file://" + archive:                        "*document.HttpTarball",
+		"http://example.com/documents.tar.gz":      "*document.HttpTarball",
+		"https://example.com/documents.tar.gz":     "*document.HttpTarball",
+		"gs://bucket/documents.tar.gz":             "*document.GCSTarball",
+		"s3://bucket/documents.tar.gz":             "*document.S3Tarball",
+		"git+ssh://git@example.com/vault.git":      "*document.GitRepo",
+		"git+https://example.com/vault.git#v1":     "*document.GitRepo",
+		"ftp://example.com/documents.tar.gz":       "",
+		"file://" + filepath.Join(workDir, "none"): "",
+	} {
+		set, err := GetSet(workDir, config.VaultsmithConfig{DocumentPath: rawurl})
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error for %s, got %T", rawurl, set)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error calling GetSet for %s: %s", rawurl, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", set); got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, rawurl, got)
+		}
+	}
+}
+
+// The fetch settings are given to the Set along with the url
+func TestGetSet_HttpSettings(t *testing.T) {
+	set, err := GetSet("/tmp/work", config.VaultsmithConfig{
+		DocumentPath:    "https://example.com/documents.tar.gz",
+		HttpAuthToken:   "token",
+		TarDir:          "vault",
+		StreamTarball:   true,
+		MaxDownloadRate: 1024,
+	})
+	if err != nil {
+		t.Fatalf("Error calling GetSet: %s", err)
+	}
+	h := set.(*HttpTarball)
+	if h.AuthToken != "token" || h.TarDir != "vault" || !h.Stream || h.MaxBytesPerSecond != 1024 {
+		t.Errorf("Expected the settings to be passed on, got %+v", h)
+	}
+}
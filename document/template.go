@@ -2,6 +2,7 @@ package document
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -14,6 +15,21 @@ import (
 // Regexp which defines how to find "placeholder" values
 var matcher = regexp.MustCompile(`{{\s*([^ }]*)?\s*}}`)
 
+// Placeholders with this prefix are replaced with the environment variable named by the rest
+const envPrefix = "env."
+
+// Return true if text consists only of an environment placeholder, e.g. "{{ env.PASSWORD }}"
+func IsEnvPlaceholder(text string) bool {
+	m := matcher.FindStringSubmatch(text)
+	return m != nil && m[0] == strings.TrimSpace(text) && strings.HasPrefix(m[1], envPrefix)
+}
+
+// Escape s for use within a json string, as env values are substituted into the raw document
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
 // This seems a little unnecessary
 type Renderer interface {
 	Render(map[string][]string, string) error
@@ -97,7 +113,17 @@ func (t *Template) createRenderedTemplate(name string, params TemplateParams) (r
 
 	content := t.Content
 	for pk, placeholderText := range placeHolders {
-		if value, ok := params.Variables[pk]; ok {
+		if strings.HasPrefix(pk, envPrefix) {
+			// Secrets such as passwords can't be kept in the documents, so are read from the
+			// environment. Missing ones are an error rather than a warning, so we never write an
+			// empty password.
+			value, ok := os.LookupEnv(strings.TrimPrefix(pk, envPrefix))
+			if !ok {
+				return rt, fmt.Errorf("environment variable %s, used in %s, is not set",
+					strings.TrimPrefix(pk, envPrefix), t.FileName)
+			}
+			content = strings.Replace(content, placeholderText, jsonEscape(value), -1)
+		} else if value, ok := params.Variables[pk]; ok {
 			content = strings.Replace(content, placeholderText, value, -1)
 		} else if _, ok := params.Instances[pk]; ok {
 			// If the placeholder key is in instances, then we can assume this placeholder should be
@@ -116,6 +142,36 @@ func (t *Template) createRenderedTemplate(name string, params TemplateParams) (r
 	return RenderedTemplate{Name: name, Content: content}, err
 }
 
+// Replace the variable and environment placeholders in a document path, so the path of a resource
+// (e.g. an auth mount at sys/auth/{{ team }}-approle.json) can differ between deployments. Instance
+// placeholders in a file name are left for Render.
+func RenderPath(path string, params TemplateParams) (string, error) {
+	for _, m := range matcher.FindAllStringSubmatch(path, -1) {
+		key := m[1]
+		var value string
+		if strings.HasPrefix(key, envPrefix) {
+			v, ok := os.LookupEnv(strings.TrimPrefix(key, envPrefix))
+			if !ok {
+				return "", fmt.Errorf("environment variable %s, used in the path %s, is not set",
+					strings.TrimPrefix(key, envPrefix), path)
+			}
+			value = v
+		} else if v, ok := params.Variables[key]; ok {
+			value = v
+		} else if _, ok := params.Instances[key]; ok {
+			continue
+		} else {
+			return "", fmt.Errorf("placeholder %s in the path %s has no value", key, path)
+		}
+		// each placeholder fills part of a single directory or file name
+		if value == "" || strings.ContainsAny(value, `/\`) || value == ".." {
+			return "", fmt.Errorf("value %q of placeholder %s can't be used in the path %s", value, key, path)
+		}
+		path = strings.Replace(path, m[0], value, -1)
+	}
+	return path, nil
+}
+
 func (t *Template) replaceText(initialText string, params TemplateParams) (output string, err error) {
 
 	return
@@ -107,3 +107,80 @@ func TestTemplatedDocument_Render_FileName(t *testing.T) {
 		t.Errorf("Expected %q, got %q", exp, renderedTemplates[0].Name)
 	}
 }
+
+func TestTemplatedDocument_Render_Env(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_PASSWORD", `pa"ss`)
+	defer os.Unsetenv("VAULTSMITH_TEST_PASSWORD")
+
+	tf := Template{
+		Content: `{"password": "{{ env.VAULTSMITH_TEST_PASSWORD }}"}`,
+	}
+	renderedTemplates, err := tf.Render()
+	if err != nil {
+		log.Fatal(err)
+	}
+	exp := `{"password": "pa\"ss"}`
+	if renderedTemplates[0].Content != exp {
+		t.Errorf("Expected %q, got %q", exp, renderedTemplates[0].Content)
+	}
+}
+
+func TestTemplatedDocument_Render_EnvMissing(t *testing.T) {
+	os.Unsetenv("VAULTSMITH_TEST_MISSING")
+	tf := Template{
+		FileName: "config.json",
+		Content:  `{"password": "{{ env.VAULTSMITH_TEST_MISSING }}"}`,
+	}
+	_, err := tf.Render()
+	if err == nil {
+		t.Errorf("Expected an error rendering a missing environment variable")
+	}
+}
+
+func TestIsEnvPlaceholder(t *testing.T) {
+	tests := map[string]bool{
+		"{{ env.PASSWORD }}":  true,
+		"{{env.PASSWORD}}":    true,
+		"{{ password }}":      false,
+		"hunter2":             false,
+		"x{{ env.PASSWORD }}": false,
+	}
+	for text, expected := range tests {
+		if IsEnvPlaceholder(text) != expected {
+			t.Errorf("IsEnvPlaceholder(%q) should be %t", text, expected)
+		}
+	}
+}
+
+func TestRenderPath(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_REGION", "eu")
+	defer os.Unsetenv("VAULTSMITH_TEST_REGION")
+	params := TemplateParams{
+		Instances: map[string][]string{"role": {"read", "write"}},
+		Variables: map[string]string{"team": "payments", "nested": "a/b"},
+	}
+	for path, expected := range map[string]string{
+		"sys/auth/{{ team }}-approle.json":                            "sys/auth/payments-approle.json",
+		"auth/{{team}}-approle/{{ env.VAULTSMITH_TEST_REGION }}.json": "auth/payments-approle/eu.json",
+		// left for Render
+		"auth/{{ team }}/role/{{ role }}.json": "auth/payments/role/{{ role }}.json",
+		"sys/auth/approle.json":                "sys/auth/approle.json",
+	} {
+		rendered, err := RenderPath(path, params)
+		if err != nil {
+			t.Errorf("Error rendering %s: %s", path, err)
+		} else if rendered != expected {
+			t.Errorf("Expected %s to render as %s, got %s", path, expected, rendered)
+		}
+	}
+
+	for _, path := range []string{
+		"sys/auth/{{ unknown }}.json",
+		"sys/auth/{{ env.VAULTSMITH_TEST_UNSET }}.json",
+		"sys/auth/{{ nested }}.json",
+	} {
+		if rendered, err := RenderPath(path, params); err == nil {
+			t.Errorf("Expected an error rendering %s, got %s", path, rendered)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package document
+
+import (
+	"io"
+	"time"
+)
+
+// Reads from r no faster than bytesPerSecond, on average since the first read
+type throttledReader struct {
+	r              io.Reader
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+}
+
+// Limit reads from r to bytesPerSecond, or return r if it is 0
+func throttle(r io.Reader, bytesPerSecond int64) io.Reader {
+	if bytesPerSecond <= 0 {
+		return r
+	}
+	return &throttledReader{r: r, bytesPerSecond: bytesPerSecond}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// at most a second's worth at a time, so the rate doesn't burst
+	if int64(len(p)) > t.bytesPerSecond {
+		p = p[:t.bytesPerSecond]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	due := t.start.Add(time.Duration(t.read) * time.Second / time.Duration(t.bytesPerSecond))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
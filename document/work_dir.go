@@ -0,0 +1,50 @@
+package document
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// The environment variable naming the directory work directories are created in, e.g. to download
+// to a volume with enough space. The system temp directory if not set.
+const WorkDirEnv = "VAULTSMITH_WORK_DIR"
+
+// Create a new work directory for a Set under $VAULTSMITH_WORK_DIR, or the system temp directory
+func NewWorkDir() (string, error) {
+	base := os.Getenv(WorkDirEnv)
+	if base == "" {
+		base = os.TempDir()
+	}
+	err := prepareWorkDir(base)
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(base, "vaultsmith-")
+	if err != nil {
+		return "", fmt.Errorf("could not create temp directory: %s", err)
+	}
+	return dir, nil
+}
+
+// Create dir if it doesn't exist, and check files can be written to it, so a read-only or full
+// volume fails before anything is downloaded rather than part way through
+func prepareWorkDir(dir string) error {
+	hint := fmt.Sprintf("set %s to a writable directory to use another volume", WorkDirEnv)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create work directory %s: %s; %s", dir, err, hint)
+	}
+	probe, err := ioutil.TempFile(dir, ".vaultsmith-write-test-")
+	if err == nil {
+		_, err = probe.Write([]byte{0})
+		if closeErr := probe.Close(); err == nil {
+			err = closeErr
+		}
+		os.Remove(probe.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("work directory %s is not writable: %s; %s", dir, err, hint)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package document
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Serves the example tarball, counting the requests for it
+func exampleTarballServer(requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		http.ServeFile(w, r, filepath.Join(examplePath(), "example.tar.gz"))
+	}))
+}
+
+func TestHttpTarball_GetCreatesWorkDir(t *testing.T) {
+	var requests int
+	ts := exampleTarballServer(&requests)
+	defer ts.Close()
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	u, _ := url.Parse(ts.URL + "/example.tar.gz")
+	workDir := filepath.Join(tmpDir, "missing", "work")
+	h := HttpTarball{LocalTarball: LocalTarball{WorkDir: workDir}, Url: u}
+	if err := h.Get(); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	path, err := h.Path()
+	if err != nil {
+		t.Fatalf("Error calling Path: %s", err)
+	}
+	if !strings.HasPrefix(path, workDir) || len(extractedFiles(t, path)) == 0 {
+		t.Errorf("Expected the documents to be extracted under %s, got %s", workDir, path)
+	}
+}
+
+// Unwritable work directories fail with an error saying so, before anything is downloaded
+func TestHttpTarball_GetUnwritableWorkDir(t *testing.T) {
+	var requests int
+	ts := exampleTarballServer(&requests)
+	defer ts.Close()
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// a file in the way can't be worked around, even as root
+	file := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := filepath.Join(tmpDir, "read-only")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		filepath.Join(file, "work"): "could not create work directory " + filepath.Join(file, "work"),
+	}
+	if os.Geteuid() != 0 {
+		cases[readOnly] = "work directory " + readOnly + " is not writable"
+	}
+
+	u, _ := url.Parse(ts.URL + "/example.tar.gz")
+	for workDir, expected := range cases {
+		h := HttpTarball{LocalTarball: LocalTarball{WorkDir: workDir}, Url: u}
+		err := h.Get()
+		if err == nil || !strings.Contains(err.Error(), expected) || !strings.Contains(err.Error(), WorkDirEnv) {
+			t.Errorf("Expected an error containing %q and naming %s, got %v", expected, WorkDirEnv, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("Expected nothing to be downloaded, got %d requests", requests)
+	}
+}
+
+func TestNewWorkDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fetcher-")
+	if err != nil {
+		t.Fatalf("Could not create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer os.Setenv(WorkDirEnv, os.Getenv(WorkDirEnv))
+	base := filepath.Join(tmpDir, "downloads")
+	os.Setenv(WorkDirEnv, base)
+
+	dir, err := NewWorkDir()
+	if err != nil {
+		t.Fatalf("Error calling NewWorkDir: %s", err)
+	}
+	if filepath.Dir(dir) != base {
+		t.Errorf("Expected a work directory in %s, got %s", base, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("Expected %s to be created: %v", dir, err)
+	}
+}
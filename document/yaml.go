@@ -0,0 +1,30 @@
+package document
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	"path/filepath"
+	"strings"
+)
+
+// Whether the document at path is written in YAML rather than JSON, going by its extension
+func IsYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// The JSON equivalent of the document at path, which is content itself unless the document is
+// YAML. Everything after this works on JSON, so the handlers needn't care which a document is.
+func ToJSON(path string, content []byte) ([]byte, error) {
+	if !IsYAML(path) {
+		return content, nil
+	}
+	j, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse yaml: %s", err)
+	}
+	return j, nil
+}
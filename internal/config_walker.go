@@ -1,16 +1,21 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/path_handlers"
 	"github.com/starlingbank/vaultsmith/vault"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 // The ConfigWalker assumes it is in the root of the vault configuration to apply. As an example, it
@@ -20,27 +25,65 @@ type Walker interface {
 }
 
 type ConfigWalker struct {
-	HandlerMap map[string]path_handlers.PathHandler
-	Client     vault.Vault
-	ConfigDir  string
-	Visited    map[string]bool
+	HandlerMap  map[string]path_handlers.PathHandler
+	Client      vault.Vault
+	ConfigDir   string
+	Visited     map[string]bool
+	Ignore      *document.Ignore
+	FS          fs.FS
+	skipGeneric bool // only specific handlers were targeted
+	interrupt   *path_handlers.Interrupt
+	rollback    *path_handlers.Rollback   // nil unless the run is atomic
+	checkpoint  *path_handlers.Checkpoint // nil unless a checkpoint file is kept
+	// the subtrees applied to another Vault, and how long to wait before each; see stagger
+	subtrees       map[string]bool
+	subtreeStagger time.Duration
 }
 
-// Instantiates a configWalker and the required handlers
+// Instantiates a configWalker and the required handlers. Changes made by the handlers are recorded
+// in summary, which may be nil.
 // TODO this mixes configuration and code, could be declared in a better way
-func NewConfigWalker(client vault.Vault, config config.VaultsmithConfig, docPath string) (configWalker ConfigWalker, err error) {
+func NewConfigWalker(client vault.Vault, config config.VaultsmithConfig, docPath string, summary *path_handlers.Summary) (configWalker ConfigWalker, err error) {
+	return NewConfigWalkerFS(client, config, os.DirFS(docPath), docPath, summary)
+}
+
+// As NewConfigWalker, reading the documents from fsys rather than the directory at docPath, e.g.
+// an embed.FS or fstest.MapFS. docPath is only used to name them in the logs and errors.
+func NewConfigWalkerFS(client vault.Vault, config config.VaultsmithConfig, fsys fs.FS, docPath string, summary *path_handlers.Summary) (configWalker ConfigWalker, err error) {
 	// Map configuration directories to specific path handlers
 	var handlerMap = map[string]path_handlers.PathHandler{}
 
+	ignore, err := document.LoadIgnoreFS(fsys)
+	if err != nil {
+		return configWalker, err
+	}
+	interrupt := &path_handlers.Interrupt{}
+	hc := handlerConfig(config, docPath, summary)
+	hc.Ignore = ignore
+	hc.FS = fsys
+	hc.Interrupt = interrupt
+	var rollback *path_handlers.Rollback
+	if config.Atomic && !config.Dry {
+		rollback = &path_handlers.Rollback{}
+		hc.Rollback = rollback
+	}
+	var checkpoint *path_handlers.Checkpoint
+	if config.CheckpointPath != "" && !config.Dry {
+		checkpoint, err = path_handlers.OpenCheckpoint(config.CheckpointPath, fsys, config.TemplateParams)
+		if err != nil {
+			return configWalker, err
+		}
+		defer func() {
+			if err != nil {
+				checkpoint.Close(false)
+			}
+		}()
+		hc.Checkpoint = checkpoint
+	}
+
 	// Instantiate our path handlers
 	// We handle any unknown directories with this one
-	genericHandler, err := path_handlers.NewGeneric(
-		client,
-		path_handlers.PathHandlerConfig{
-			DocumentPath:      docPath,
-			TemplateFile:      config.TemplateFile,
-			TemplateOverrides: config.TemplateParams,
-		})
+	genericHandler, err := path_handlers.NewGeneric(client, withOrder(hc, 0, genericTarget))
 	if err != nil {
 		return configWalker, fmt.Errorf("could not create genericHandler: %s", err)
 	}
@@ -53,62 +96,357 @@ func NewConfigWalker(client vault.Vault, config config.VaultsmithConfig, docPath
 	}
 	handlerMap["sys"] = nullHandler
 
-	// The two sys path handlers
-	sysAuthDir := filepath.Join(docPath, "sys", "auth")
-	if f, err := os.Stat(sysAuthDir); !os.IsNotExist(err) {
-		if f.Mode().IsDir() {
-			sysAuthHandler, err := path_handlers.NewSysAuthHandler(
-				client,
-				path_handlers.PathHandlerConfig{
-					DocumentPath:      docPath,
-					Order:             10,
-					TemplateFile:      config.TemplateFile,
-					TemplateOverrides: config.TemplateParams,
-				})
-			if err != nil {
-				return configWalker, fmt.Errorf("could not create sysAuthHandler: %s", err)
-			}
-			handlerMap["sys/auth"] = sysAuthHandler
-		}
+	targets, err := targetSet(config.Targets)
+	if err != nil {
+		return configWalker, err
+	}
+	// Fail before anything is applied
+	err = checkDocuments(fsys, config, docPath, ignore)
+	if err != nil {
+		return configWalker, err
 	}
 
-	sysPolicyDir := filepath.Join(docPath, "sys", "policy")
-	if f, err := os.Stat(sysPolicyDir); !os.IsNotExist(err) {
-		if f.Mode().IsDir() {
-			sysPolicyHandler, err := path_handlers.NewSysPolicyHandler(
-				client,
-				path_handlers.PathHandlerConfig{
-					DocumentPath:      docPath,
-					Order:             20,
-					TemplateFile:      config.TemplateFile,
-					TemplateOverrides: config.TemplateParams,
-				})
-			if err != nil {
-				return configWalker, fmt.Errorf("could not create sysPolicyHandler: %s", err)
-			}
-			handlerMap["sys/policy"] = sysPolicyHandler
+	// Directories which have their own handler. Those which aren't targeted get a dummy, so nothing
+	// under them is touched (and the generic handler doesn't claim them either).
+	for _, r := range handlerRegistry {
+		if !isDir(fsys, r.path) {
+			continue
+		}
+		if targets != nil && !targets[r.target] {
+			handlerMap[r.path] = nullHandler
+			continue
+		}
+		order := r.order
+		if o, ok := config.HandlerOrder[r.target]; ok {
+			order = o
 		}
+		handler, err := r.new(client, withOrder(hc, order, r.path))
+		if err != nil {
+			return configWalker, fmt.Errorf("could not create %s handler: %s", r.target, err)
+		}
+		handlerMap[r.path] = handler
+	}
+
+	subtrees, err := addSubtreeHandlers(handlerMap, config, hc, nullHandler, targets != nil && !targets[genericTarget])
+	if err != nil {
+		return configWalker, err
 	}
 
 	return ConfigWalker{
-		HandlerMap: handlerMap,
-		Client:     client,
-		ConfigDir:  path.Clean(docPath),
-		Visited:    map[string]bool{},
+		HandlerMap:     handlerMap,
+		Client:         client,
+		ConfigDir:      path.Clean(docPath),
+		Visited:        map[string]bool{},
+		Ignore:         ignore,
+		FS:             fsys,
+		skipGeneric:    targets != nil && !targets[genericTarget],
+		interrupt:      interrupt,
+		rollback:       rollback,
+		checkpoint:     checkpoint,
+		subtrees:       subtrees,
+		subtreeStagger: config.SubtreeStagger,
 	}, nil
 }
 
-func (cw ConfigWalker) Run() error {
+// The directories with a dedicated handler, and the name used to select it with --target. Each
+// document under path is written to the same path under api, which may need sudo.
+var handlerRegistry = []struct {
+	target string
+	path   string
+	api    string
+	sudo   bool
+	order  int
+	new    func(vault.Vault, path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error)
+}{
+	// plugins are registered first, as auth methods and mounts may use them
+	{"plugins", "sys/plugins/catalog", "sys/plugins/catalog", true, 5, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewPluginCatalogHandler(c, hc)
+	}},
+	{"auth", "sys/auth", "sys/auth", true, 10, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewSysAuthHandler(c, hc)
+	}},
+	{"mounts", "sys/mounts", "sys/mounts", true, 15, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewSysMountsHandler(c, hc)
+	}},
+	{"policy", "sys/policy", "sys/policies/acl", false, 20, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewSysPolicyHandler(c, hc)
+	}},
+	{"policy", "sys/policies/acl", "sys/policies/acl", false, 20, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewACLPolicyHandler(c, hc)
+	}},
+	{"policy", "sys/policies/rgp", "sys/policies/rgp", false, 25, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewSentinelPolicyHandler(c, hc)
+	}},
+	{"policy", "sys/policies/egp", "sys/policies/egp", false, 25, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewSentinelPolicyHandler(c, hc)
+	}},
+	{"config", "sys/config", "sys/config", true, 30, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewSysConfigHandler(c, hc)
+	}},
+	{"config", "sys/config/auditing/request-headers", "sys/config/auditing/request-headers", true, 30, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewAuditHeadersHandler(c, hc)
+	}},
+	{"quotas", "sys/quotas", "sys/quotas", false, 40, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewQuotasHandler(c, hc)
+	}},
+	{"mfa", "sys/mfa", "sys/mfa", false, 45, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewMfaHandler(c, hc)
+	}},
+	// after the methods and auth mounts, whose IDs and accessors they are bound to
+	{"mfa", "identity/mfa/login-enforcement", "identity/mfa/login-enforcement", false, 50, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewLoginMfaHandler(c, hc)
+	}},
+	{"transit", "transit/keys", "transit/keys", false, 35, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewTransitHandler(c, hc)
+	}},
+	{"ldap", "auth/ldap", "auth/ldap", false, 0, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewLdapHandler(c, hc)
+	}},
+	{"jwt", "auth/jwt", "auth/jwt", false, 0, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewJwtHandler(c, hc)
+	}},
+	{"jwt", "auth/oidc", "auth/oidc", false, 0, func(c vault.Vault, hc path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+		return path_handlers.NewJwtHandler(c, hc)
+	}},
+}
+
+// The API path the handler of resource (a document path without its extension, e.g.
+// "sys/policy/admin") writes it to, and whether writing there needs sudo
+func ResourceAPIPath(resource string) (apiPath string, sudo bool) {
+	var match string
+	for _, r := range handlerRegistry {
+		if len(r.path) > len(match) && (resource == r.path || strings.HasPrefix(resource, r.path+"/")) {
+			match = r.path
+			apiPath, sudo = r.api+strings.TrimPrefix(resource, r.path), r.sudo
+		}
+	}
+	if match == "" {
+		return resource, false
+	}
+	return apiPath, sudo
+}
+
+// Check the documents at docPath can be applied with config, without reading anything from Vault,
+// as NewConfigWalker does before creating the handlers
+func CheckDocuments(config config.VaultsmithConfig, docPath string) error {
+	fsys := os.DirFS(docPath)
+	ignore, err := document.LoadIgnoreFS(fsys)
+	if err != nil {
+		return err
+	}
+	if _, err := targetSet(config.Targets); err != nil {
+		return err
+	}
+	return checkDocuments(fsys, config, docPath, ignore)
+}
+
+func checkDocuments(fsys fs.FS, config config.VaultsmithConfig, docPath string, ignore *document.Ignore) error {
+	if err := checkHandlerOrder(config.HandlerOrder); err != nil {
+		return err
+	}
+	if err := path_handlers.CheckIgnorePatterns(config.IgnoreResources); err != nil {
+		return err
+	}
+	if !config.AllowEmpty {
+		err := checkNotEmpty(fsys, docPath, ignore)
+		if err != nil {
+			return err
+		}
+	}
+	tp, err := document.GenerateTemplateParams(config.TemplateFile, config.TemplateParams)
+	if err != nil {
+		return fmt.Errorf("could not generate template parameters: %s", err)
+	}
+	err = findDuplicates(fsys, docPath, ignore, tp)
+	if err != nil {
+		return err
+	}
+	err = validateDocuments(fsys, docPath, ignore, tp, config.MaxFileSize, config.AllowUnknownFields)
+	if err != nil {
+		return err
+	}
+
+	// Each would remove the policies declared in the other
+	if isDir(fsys, "sys/policy") && isDir(fsys, "sys/policies/acl") {
+		return fmt.Errorf("ACL policies are in both sys/policy and sys/policies/acl; " +
+			"move them all to one of these")
+	}
+	return nil
+}
+
+func isDir(fsys fs.FS, name string) bool {
+	f, err := fs.Stat(fsys, name)
+	return err == nil && f.IsDir()
+}
+
+// Target name for every directory without a dedicated handler
+const genericTarget = "generic"
+
+// Return the targets as a set, or nil if everything should be applied
+func targetSet(targets []string) (map[string]bool, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	valid := map[string]bool{genericTarget: true}
+	names := []string{genericTarget}
+	for _, r := range handlerRegistry {
+		// several handlers share a target, e.g. policy for each kind of policy
+		if !valid[r.target] {
+			names = append(names, r.target)
+		}
+		valid[r.target] = true
+	}
+	set := map[string]bool{}
+	for _, t := range targets {
+		if !valid[t] {
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown target %q, valid targets are %s", t, strings.Join(names, ", "))
+		}
+		set[t] = true
+	}
+	return set, nil
+}
+
+// Only the dedicated handlers can be reordered; the generic one always runs after them
+func checkHandlerOrder(orders map[string]int) error {
+	valid := map[string]bool{}
+	var names []string
+	for _, r := range handlerRegistry {
+		if !valid[r.target] {
+			names = append(names, r.target)
+		}
+		valid[r.target] = true
+	}
+	for t := range orders {
+		if !valid[t] {
+			sort.Strings(names)
+			return fmt.Errorf("unknown handler %q in handler order, valid handlers are %s", t,
+				strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// Build the configuration common to all path handlers
+func handlerConfig(config config.VaultsmithConfig, docPath string, summary *path_handlers.Summary) path_handlers.PathHandlerConfig {
+	return path_handlers.PathHandlerConfig{
+		DocumentPath:       docPath,
+		TemplateFile:       config.TemplateFile,
+		TemplateOverrides:  config.TemplateParams,
+		MaxFileSize:        config.MaxFileSize,
+		AllowRecreate:      config.AllowRecreate,
+		Summary:            summary,
+		ContinueOnError:    config.ContinueOnError,
+		Retries:            config.Retries,
+		AllowUnknownFields: config.AllowUnknownFields,
+		DisableAuthTypes:   config.DisableAuthTypes,
+		Explain:            config.Explain,
+		OwnedAuthPaths:     config.OwnedAuthPaths,
+		Diff:               config.Diff,
+		// in a dry run nothing is written, so there is nothing to read back
+		VerifyWrites:           config.VerifyWrites && !config.Dry,
+		AllowDeleteTransitKeys: config.AllowDeleteTransitKeys,
+		MergeTuning:            config.MergeTuning,
+		StubMissingPolicies:    config.StubMissingPolicies,
+		ReloadPlugins:          config.ReloadPlugins,
+		IgnoreResources:        config.IgnoreResources,
+	}
+}
+
+// The handler config for a handler of component, e.g. "sys/auth", run in order
+func withOrder(hc path_handlers.PathHandlerConfig, order int, component string) path_handlers.PathHandlerConfig {
+	hc.Order = order
+	hc.Component = component
+	return hc
+}
+
+// Apply the configuration. Cancelling ctx stops the walk once the resource being applied is
+// finished, returning ctx.Err().
+func (cw ConfigWalker) Run(ctx context.Context) error {
+	cw.watch(ctx)
 	// file will be a dir here unless a trailing slash was added
 	log.Debugf("Starting in directory %s", cw.ConfigDir)
 
-	err := cw.walkConfigDir(cw.ConfigDir, cw.HandlerMap)
+	err := cw.walkConfigDir(ctx, cw.ConfigDir, cw.HandlerMap)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Apply only the directory relPath (relative to ConfigDir), using whichever handler would process
+// it during a full Run. Paths covered by a dedicated handler re-apply that handler's whole
+// directory, e.g. a change to "sys/auth/approle" re-applies "sys/auth".
+func (cw ConfigWalker) RunSubtree(ctx context.Context, relPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cw.watch(ctx)
+	relPath = filepath.Clean(relPath)
+	if relPath == "." {
+		return cw.Run(ctx)
+	}
+	logger := log.WithFields(log.Fields{
+		"path": relPath,
+	})
+
+	// The closest handler at or above relPath owns it
+	pathArr := strings.Split(relPath, string(os.PathSeparator))
+	for i := len(pathArr); i > 0; i-- {
+		s := strings.Join(pathArr[:i], string(os.PathSeparator))
+		handler, ok := cw.HandlerMap[s]
+		if !ok {
+			continue
+		}
+		if handler.Name() == "Dummy" {
+			logger.Debugf("Path is not processed, skipping")
+			return nil
+		}
+		logger.Infof("Processing with %s handler", handler.Name())
+		return handlerError(ctx, handler.PutPoliciesFromDir(filepath.Join(cw.ConfigDir, s)))
+	}
+
+	dir := filepath.Join(cw.ConfigDir, relPath)
+	if _, err := fs.Stat(cw.fsys(), filepath.ToSlash(relPath)); errors.Is(err, fs.ErrNotExist) {
+		// removed entirely; nothing left to declare
+		logger.Debugf("Path no longer exists, skipping")
+		return nil
+	}
+	if cw.skipGeneric {
+		return nil
+	}
+	logger.Infof("Processing with Generic handler")
+	return handlerError(ctx, cw.HandlerMap["*"].PutPoliciesFromDir(dir))
+}
+
+// Undo the changes made so far, if the run is atomic, recording each in summary
+func (cw ConfigWalker) RollBack(summary *path_handlers.Summary) error {
+	if cw.rollback == nil {
+		return nil
+	}
+	return cw.rollback.Run(summary)
+}
+
+// Close the checkpoint file, if one is kept, removing it if the run was complete
+func (cw ConfigWalker) CloseCheckpoint(complete bool) error {
+	return cw.checkpoint.Close(complete)
+}
+
+// Stop the handlers starting on new resources once ctx is cancelled
+func (cw ConfigWalker) watch(ctx context.Context) {
+	if cw.interrupt != nil {
+		cw.interrupt.Watch(ctx)
+	}
+}
+
+// The error to return for err from a handler; if the walk was interrupted, it is ctx.Err()
+func handlerError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 // Return a sorted slice of paths based on the Order() of its handler
 func (cw ConfigWalker) sortedPaths() (paths []string) {
 	for p := range cw.HandlerMap {
@@ -116,22 +454,26 @@ func (cw ConfigWalker) sortedPaths() (paths []string) {
 	}
 
 	sort.Slice(paths, func(i, j int) bool {
-		h1 := cw.HandlerMap[paths[i]]
-		h2 := cw.HandlerMap[paths[j]]
-		// zero (default) values always last
-		if h1.Order() == 0 {
-			return false
-		}
-		if h2.Order() == 0 {
-			return true
+		o1 := cw.HandlerMap[paths[i]].Order()
+		o2 := cw.HandlerMap[paths[j]].Order()
+		if o1 != o2 {
+			// zero (default) values always last
+			if o1 == 0 {
+				return false
+			}
+			if o2 == 0 {
+				return true
+			}
+			return o1 < o2
 		}
-		return h1.Order() < h2.Order()
+		// then by path, so the order is the same on every run
+		return paths[i] < paths[j]
 	})
 
 	return paths
 }
 
-func (cw ConfigWalker) walkConfigDir(path string, handlerMap map[string]path_handlers.PathHandler) error {
+func (cw ConfigWalker) walkConfigDir(ctx context.Context, path string, handlerMap map[string]path_handlers.PathHandler) error {
 	// Process according to <handler>.Order()
 	paths := cw.sortedPaths()
 	for _, v := range paths {
@@ -139,6 +481,9 @@ func (cw ConfigWalker) walkConfigDir(path string, handlerMap map[string]path_han
 			// not a real path, just used to store our generic handler
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		logger := log.WithFields(log.Fields{
 			"path": v,
 		})
@@ -147,8 +492,11 @@ func (cw ConfigWalker) walkConfigDir(path string, handlerMap map[string]path_han
 		p := filepath.Join(path, v)
 		if handler.Name() != "Dummy" {
 			// Dummy handler is a way of marking as "do not process"
+			if err := cw.stagger(ctx, v); err != nil {
+				return err
+			}
 			logger.Infof("Processing with %s handler", handler.Name())
-			err := handler.PutPoliciesFromDir(p)
+			err := handlerError(ctx, handler.PutPoliciesFromDir(p))
 			if err != nil {
 				return err
 			}
@@ -156,13 +504,30 @@ func (cw ConfigWalker) walkConfigDir(path string, handlerMap map[string]path_han
 		cw.Visited[p] = true
 	}
 
+	if cw.skipGeneric {
+		return nil
+	}
+
 	// Process other directories with the genericHandler
-	err := filepath.Walk(path, cw.walkFile)
+	err := fs.WalkDir(cw.fsys(), ".", func(p string, f fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return handlerError(ctx, cw.walkFile(filepath.Join(path, filepath.FromSlash(p)), f, err))
+	})
 	return err
 }
 
+// The filesystem the documents are read from
+func (cw ConfigWalker) fsys() fs.FS {
+	if cw.FS != nil {
+		return cw.FS
+	}
+	return os.DirFS(cw.ConfigDir)
+}
+
 // determine the handler and pass the root directory to it
-func (cw ConfigWalker) walkFile(path string, f os.FileInfo, err error) error {
+func (cw ConfigWalker) walkFile(path string, f fs.DirEntry, err error) error {
 	if f == nil {
 		return fmt.Errorf("path %q does not exist", path)
 	}
@@ -187,6 +552,10 @@ func (cw ConfigWalker) walkFile(path string, f os.FileInfo, err error) error {
 	if pathArray[0] == "." { // just to avoid a "no handler for path ." in log
 		return nil
 	}
+	if cw.Ignore.Match(relPath, true) {
+		log.WithFields(log.Fields{"path": relPath}).Debugf("Ignored by %s", document.IgnoreFileName)
+		return fs.SkipDir
+	}
 	logger := log.WithFields(log.Fields{
 		"path": relPath,
 	})
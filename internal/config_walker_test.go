@@ -1,12 +1,22 @@
 package internal
 
 import (
+	"context"
+	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
 	"github.com/starlingbank/vaultsmith/path_handlers"
 	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -138,6 +148,302 @@ func TestSortedPaths(t *testing.T) {
 
 }
 
+// Handlers with the same order are processed by path
+func TestSortedPaths_SameOrder(t *testing.T) {
+	handler := func(order int) path_handlers.PathHandler {
+		h, err := path_handlers.NewDummyHandler(&vault.MockClient{}, "", order)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return h
+	}
+	cw := ConfigWalker{
+		HandlerMap: map[string]path_handlers.PathHandler{
+			"sys/policies/rgp": handler(25),
+			"sys/policies/egp": handler(25),
+			"sys/auth":         handler(10),
+			"secret":           handler(0),
+			"auth":             handler(0),
+		},
+	}
+	expected := []string{"sys/auth", "sys/policies/egp", "sys/policies/rgp", "auth", "secret"}
+	for i := 0; i < 10; i++ {
+		if r := cw.sortedPaths(); !reflect.DeepEqual(r, expected) {
+			t.Fatalf("Expected %+v, got %+v", expected, r)
+		}
+	}
+}
+
+// Files excluded by .vaultsmithignore are never read, so invalid json in them is never noticed
+func TestConfigWalker_Ignore(t *testing.T) {
+	docPath, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(docPath)
+	files := map[string]string{
+		".vaultsmithignore":            "**/README.md\nscripts/\n",
+		"README.md":                    "# not json",
+		"secret/README.md":             "# not json",
+		"secret/foo.json":              `{"foo": "bar"}`,
+		"scripts/apply.sh":             "#!/bin/sh",
+		"sys/policy/README.md":         "# not json",
+		"sys/policy/read_secrets.json": `{"policy": "path \"secret/*\" {}"}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(docPath, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &vault.MockClient{}
+	cw, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	err = cw.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	var written []string
+	for _, c := range client.CallsTo("Write") {
+		written = append(written, c.Args[0].(string))
+	}
+	if expected := []string{"secret/foo"}; !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+	for _, c := range client.CallLog {
+		for _, arg := range c.Args {
+			if s, ok := arg.(string); ok && strings.Contains(s, "scripts") {
+				t.Errorf("Ignored directory was touched: %+v", c)
+			}
+		}
+	}
+	if calls := client.CallsTo("PutPolicy"); len(calls) != 1 {
+		t.Errorf("Expected 1 PutPolicy call, got %+v", calls)
+	}
+}
+
+// Only the targeted handler talks to Vault; other handlers aren't even created
+func TestConfigWalker_Target(t *testing.T) {
+	wd, _ := os.Getwd()
+	docPath := filepath.Join(filepath.Dir(wd), "example")
+
+	client := &vault.MockClient{}
+	conf := config.VaultsmithConfig{
+		TemplateFile: filepath.Join(docPath, "_vaultsmith.json"),
+		Targets:      []string{"policy"},
+	}
+	cw, err := NewConfigWalker(client, conf, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	err = cw.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	allowed := map[string]bool{
+		"ListPolicies": true, "GetPolicy": true, "PutPolicy": true, "DeletePolicy": true,
+	}
+	for _, c := range client.CallLog {
+		if !allowed[c.Method] {
+			t.Errorf("Unexpected call outside of the policy handler: %+v", c)
+		}
+	}
+	if calls := client.CallsTo("PutPolicy"); len(calls) == 0 {
+		t.Errorf("Expected the policy handler to apply policies")
+	}
+}
+
+// Applying the example from memory makes exactly the calls that applying it from disk does
+func TestConfigWalker_FS(t *testing.T) {
+	wd, _ := os.Getwd()
+	docPath := filepath.Join(filepath.Dir(wd), "example")
+	conf := config.VaultsmithConfig{TemplateFile: filepath.Join(docPath, "_vaultsmith.json")}
+
+	fsys := fstest.MapFS{}
+	err := filepath.Walk(docPath, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(docPath, path)
+		fsys[filepath.ToSlash(relPath)] = &fstest.MapFile{Data: content, Mode: 0644}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not read the example: %s", err)
+	}
+
+	apply := func(cw ConfigWalker, err error) []vault.MockCall {
+		if err != nil {
+			t.Fatalf("Error creating the ConfigWalker: %s", err)
+		}
+		if err := cw.Run(context.Background()); err != nil {
+			t.Fatalf("Error calling Run: %s", err)
+		}
+		return cw.Client.(*vault.MockClient).CallLog
+	}
+	expected := apply(NewConfigWalker(&vault.MockClient{}, conf, docPath, nil))
+	// nothing is read from the document path itself
+	got := apply(NewConfigWalkerFS(&vault.MockClient{}, conf, fsys, filepath.Join(wd, "missing"), nil))
+	if len(expected) == 0 {
+		t.Fatalf("Expected the example to be applied")
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the calls %+v, got %+v", expected, got)
+	}
+}
+
+func TestConfigWalker_UnknownTarget(t *testing.T) {
+	_, err := NewConfigWalker(&vault.MockClient{}, config.VaultsmithConfig{Targets: []string{"nope"}}, ".", nil)
+	if err == nil {
+		t.Fatalf("Expected an error for an unknown target")
+	}
+	// each target is listed once, in order, though several handlers share some
+	valid := strings.TrimPrefix(err.Error(), `unknown target "nope", valid targets are `)
+	names := strings.Split(valid, ", ")
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Expected the valid targets in order, got %s", valid)
+	}
+	if strings.Count(valid, "policy") != 1 {
+		t.Errorf("Expected the policy target to be listed once, got %s", valid)
+	}
+}
+
+// Overriding the order of a handler changes when it runs relative to the others
+func TestConfigWalker_HandlerOrder(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json": `{"type": "approle"}`,
+		"sys/mounts/kv.json":    `{"type": "kv"}`,
+	})
+
+	// the order auth methods are enabled and secrets engines mounted in
+	firstCalls := func(order map[string]int) []string {
+		client := &vault.MockClient{}
+		cw, err := NewConfigWalker(client, config.VaultsmithConfig{HandlerOrder: order}, docPath, nil)
+		if err != nil {
+			t.Fatalf("Error creating the ConfigWalker: %s", err)
+		}
+		if err := cw.Run(context.Background()); err != nil {
+			t.Fatalf("Error calling Run: %s", err)
+		}
+		var methods []string
+		for _, c := range client.CallLog {
+			if c.Method == "EnableAuth" || c.Method == "Mount" {
+				methods = append(methods, c.Method)
+			}
+		}
+		return methods
+	}
+
+	if got := firstCalls(nil); len(got) == 0 || got[0] != "EnableAuth" {
+		t.Errorf("Expected auth to be applied first by default, got %+v", got)
+	}
+	if got := firstCalls(map[string]int{"mounts": 8}); len(got) == 0 || got[0] != "Mount" {
+		t.Errorf("Expected mounts to be applied first with mounts=8, got %+v", got)
+	}
+}
+
+func TestConfigWalker_UnknownHandlerOrder(t *testing.T) {
+	conf := config.VaultsmithConfig{HandlerOrder: map[string]int{"generic": 1}}
+	if _, err := NewConfigWalker(&vault.MockClient{}, conf, ".", nil); err == nil {
+		t.Errorf("Expected an error for a handler order of the generic handler")
+	}
+}
+
+// Each resource in an all-in-one file is applied by the handler of its directory
+func TestConfigWalker_CombinedFile(t *testing.T) {
+	dir := testutil.WriteDocuments(t, map[string]string{"all.json": `{
+		"sys/auth": {"approle": {"type": "approle"}},
+		"sys/mounts": {"kv": {"type": "kv"}},
+		"sys/policy": {"read_secrets": {"policy": "path \"secret/*\" {}"}},
+		"auth/approle/role": {"app": {"token_policies": ["read_secrets"]}}
+	}`})
+	set := &document.CombinedFile{WorkDir: filepath.Join(dir, "work"), FilePath: filepath.Join(dir, "all.json")}
+	if err := set.Get(); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	docPath, _ := set.Path()
+
+	client := &vault.MockClient{}
+	cw, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error creating the ConfigWalker: %s", err)
+	}
+	if err := cw.Run(context.Background()); err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	for _, expected := range []struct {
+		method string
+		arg    string
+	}{
+		{"EnableAuth", "approle/"},
+		{"Mount", "kv/"},
+		{"PutPolicy", "read_secrets"},
+		{"Write", "auth/approle/role/app"},
+	} {
+		calls := client.CallsTo(expected.method)
+		if len(calls) != 1 || calls[0].Args[0] != expected.arg {
+			t.Errorf("Expected %s of %s, got %+v", expected.method, expected.arg, calls)
+		}
+	}
+}
+
+// Each directory under sys/policies is applied to its own endpoint
+func TestConfigWalker_Policies(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/policies/acl/read_secrets.json": `{"policy": "path \"secret/*\" { capabilities = [\"read\"] }"}`,
+		"sys/policies/rgp/business_hours.json": `{"policy": "main = rule { true }", ` +
+			`"enforcement_level": "soft-mandatory"}`,
+		"sys/policies/egp/no_delete.json": `{"policy": "main = rule { false }", ` +
+			`"enforcement_level": "hard-mandatory", "paths": ["secret/*"]}`,
+	})
+
+	client := &vault.MockClient{}
+	cw, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	err = cw.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	if calls := client.CallsTo("PutPolicy"); len(calls) != 1 || calls[0].Args[0] != "read_secrets" {
+		t.Errorf("Expected read_secrets to be written as an ACL policy, got %+v", calls)
+	}
+	written := map[string]bool{}
+	for _, c := range client.CallsTo("Write") {
+		written[c.Args[0].(string)] = true
+	}
+	expected := map[string]bool{"sys/policies/rgp/business_hours": true, "sys/policies/egp/no_delete": true}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+}
+
+// The two ACL policy layouts can't be mixed, as each handler would remove the other's policies
+func TestConfigWalker_PolicyLayoutsConflict(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/policy/a.json":       `{"policy": ""}`,
+		"sys/policies/acl/b.json": `{"policy": ""}`,
+	})
+
+	_, err := NewConfigWalker(&vault.MockClient{}, config.VaultsmithConfig{}, docPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "sys/policies/acl") {
+		t.Errorf("Expected an error for policies in both layouts, got %v", err)
+	}
+}
+
 type fakeFileInfo struct {
 	dir      bool
 	basename string
@@ -158,3 +464,165 @@ func (f *fakeFileInfo) Mode() os.FileMode {
 	}
 	return 0644
 }
+func (f *fakeFileInfo) Type() os.FileMode          { return f.Mode().Type() }
+func (f *fakeFileInfo) Info() (os.FileInfo, error) { return f, nil }
+
+// Plugins are registered before the auth methods and mounts which may use them
+func TestConfigWalker_PluginsFirst(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/custom.json":                      `{"type": "plugin", "plugin_name": "custom-auth"}`,
+		"sys/mounts/custom.json":                    `{"type": "plugin", "plugin_name": "custom-secrets"}`,
+		"sys/plugins/catalog/auth/custom-auth.json": `{"sha256": "aaaa", "command": "custom-auth"}`,
+		"sys/plugins/catalog/secret/custom-secrets.json": `{"sha256": "bbbb", "command": ` +
+			`"custom-secrets"}`,
+	})
+
+	client := &vault.MockClient{}
+	cw, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	err = cw.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	var applied []string
+	for _, c := range client.CallLog {
+		switch c.Method {
+		case "RegisterPlugin", "EnableAuth", "Mount":
+			applied = append(applied, c.Method)
+		}
+	}
+	expected := []string{"RegisterPlugin", "RegisterPlugin", "EnableAuth", "Mount"}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, applied)
+	}
+}
+
+// Changing the config of an auth method writes it to its config endpoint, leaving the mount alone
+func TestConfigWalker_AuthMethodConfig(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/ldap.json":    `{"type": "ldap"}`,
+		"auth/ldap/config.json": `{"url": "ldaps://ldap.example.com", "token_bound_cidrs": ["10.0.0.0/8"]}`,
+	})
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{"ldap/": {Type: "ldap"}},
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"auth/ldap/config": {Data: map[string]interface{}{
+				"url":               "ldaps://ldap.example.com",
+				"token_bound_cidrs": []interface{}{"192.168.0.0/16"},
+			}},
+		},
+	}
+	cw, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	if err := cw.Run(context.Background()); err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "auth/ldap/config" {
+		t.Errorf("Expected a single write to auth/ldap/config, got %+v", writes)
+	}
+	for _, method := range []string{"EnableAuth", "DisableAuth", "TuneMount"} {
+		if calls := client.CallsTo(method); len(calls) != 0 {
+			t.Errorf("Expected no %s calls, got %+v", method, calls)
+		}
+	}
+}
+
+// A client which cancels the run while the first write is being made, as a signal would
+type interruptingClient struct {
+	*vault.MockClient
+	cancel func()
+}
+
+func (c *interruptingClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	c.cancel()
+	return c.MockClient.Write(path, data)
+}
+
+// The write in flight when the run is cancelled is finished, but nothing more is started
+func TestConfigWalker_Interrupted(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"secret/a.json": `{"foo": "a"}`,
+		"secret/b.json": `{"foo": "b"}`,
+		"secret/c.json": `{"foo": "c"}`,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mock := &vault.MockClient{}
+	summary := &path_handlers.Summary{}
+	cw, err := NewConfigWalker(&interruptingClient{MockClient: mock, cancel: cancel}, config.VaultsmithConfig{},
+		docPath, summary)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	if err := cw.Run(ctx); err != context.Canceled {
+		t.Errorf("Expected %s, got %v", context.Canceled, err)
+	}
+
+	if writes := mock.CallsTo("Write"); len(writes) != 1 || writes[0].Args[0] != "secret/a" {
+		t.Fatalf("Expected only the write to secret/a, got %+v", writes)
+	}
+	if last := mock.CallLog[len(mock.CallLog)-1]; last.Method != "Write" {
+		t.Errorf("Expected nothing after the write in flight, got %+v", mock.CallLog)
+	}
+	if len(summary.Rows) != 1 || summary.Rows[0].Resource != "secret/a" || summary.Rows[0].Error != "" {
+		t.Errorf("Expected the summary to show only secret/a was written, got %+v", summary.Rows)
+	}
+}
+
+// The audited headers are applied by their own handler, not written as sys/config documents
+func TestConfigWalker_AuditHeaders(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/config/cors.json": `{"enabled": true}`,
+		"sys/config/auditing/request-headers/X-Request-Id.json": `{"hmac": true}`,
+	})
+
+	client := &vault.MockClient{}
+	cw, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling NewConfigWalker: %s", err)
+	}
+	if err := cw.Run(context.Background()); err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+
+	var written []string
+	for _, c := range client.CallsTo("Write") {
+		written = append(written, c.Args[0].(string))
+	}
+	expected := []string{"sys/config/cors", "sys/config/auditing/request-headers/x-request-id"}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+}
+
+func TestResourceAPIPath(t *testing.T) {
+	for _, c := range []struct {
+		resource string
+		apiPath  string
+		sudo     bool
+	}{
+		{"sys/policy/admin", "sys/policies/acl/admin", false},
+		{"sys/policies/acl/admin", "sys/policies/acl/admin", false},
+		{"sys/auth/approle", "sys/auth/approle", true},
+		{"sys/config/cors", "sys/config/cors", true},
+		{"sys/config/auditing/request-headers/X-Run", "sys/config/auditing/request-headers/X-Run", true},
+		{"sys/quotas/rate-limit/global", "sys/quotas/rate-limit/global", false},
+		{"sys/policyx/admin", "sys/policyx/admin", false},
+		{"auth/approle/role/app", "auth/approle/role/app", false},
+	} {
+		apiPath, sudo := ResourceAPIPath(c.resource)
+		if apiPath != c.apiPath || sudo != c.sudo {
+			t.Errorf("Expected %s to be written to %s (sudo %t), got %s (sudo %t)", c.resource,
+				c.apiPath, c.sudo, apiPath, sudo)
+		}
+	}
+}
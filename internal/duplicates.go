@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Return an error naming both files if two documents in fsys, which is docPath, would be written to the same
+// resource, e.g. sys/auth/userpass.json and sys/auth/userpass/.json, or a file and a templated file
+// rendering to the same name. Otherwise whichever was walked last would silently win.
+func findDuplicates(fsys fs.FS, docPath string, ignore *document.Ignore, tp document.TemplateParams) error {
+	sources := map[string]string{} // resource path -> file defining it
+	var duplicates []string
+
+	err := fs.WalkDir(fsys, ".", func(name string, f fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(docPath, filepath.FromSlash(name))
+		relPath := filepath.FromSlash(name)
+		if relPath == "." {
+			return nil
+		}
+		if ignore.Match(relPath, f.IsDir()) {
+			return skipDir(f)
+		}
+		if f.IsDir() {
+			if strings.HasPrefix(f.Name(), "_") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(relPath) == "." {
+			// files in the root, such as _vaultsmith.json, are never applied
+			return nil
+		}
+
+		resources := resourcePaths(relPath, tp)
+		if isAuthIndex(relPath) {
+			resources = indexResources(fsys, name)
+		}
+		for _, resource := range resources {
+			if other, ok := sources[resource]; ok {
+				duplicates = append(duplicates, fmt.Sprintf("%s is defined by both %s and %s",
+					resource, filepath.Join(docPath, other), path))
+				continue
+			}
+			sources[resource] = relPath
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not check %s for duplicate documents: %s", docPath, err)
+	}
+
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		return fmt.Errorf("duplicate resource definitions found: %s", strings.Join(duplicates, "; "))
+	}
+	return nil
+}
+
+func skipDir(f fs.DirEntry) error {
+	if f.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
+
+// The resource paths a document is written to; more than one if the file name is templated
+func resourcePaths(relPath string, tp document.TemplateParams) (paths []string) {
+	relPath, err := document.RenderPath(relPath, tp)
+	if err != nil {
+		// reported properly when the document is applied
+		return nil
+	}
+	dir := filepath.ToSlash(filepath.Dir(relPath))
+	td := &document.Template{
+		FileName: filepath.Base(relPath),
+		Params:   tp,
+	}
+	rendered, err := td.Render()
+	if err != nil {
+		// reported properly when the document is applied
+		return nil
+	}
+	for _, r := range rendered {
+		paths = append(paths, normalizeResourcePath(dir+"/"+r.Name))
+	}
+	return paths
+}
+
+// The resource paths of the mounts listed in the auth index file name
+func indexResources(fsys fs.FS, name string) (paths []string) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil
+	}
+	index, err := path_handlers.ParseAuthIndex(content)
+	if err != nil {
+		// reported properly when the document is applied
+		return nil
+	}
+	for _, mount := range sortedMounts(index) {
+		paths = append(paths, normalizeResourcePath("sys/auth/"+mount))
+	}
+	return paths
+}
+
+// collapse repeated slashes and trim them from the ends, as the handlers do
+func normalizeResourcePath(p string) string {
+	var parts []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "/")
+}
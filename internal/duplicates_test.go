@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigWalker_DuplicateMount(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/userpass.json":   `{"type": "userpass"}`,
+		"sys/auth/userpass.yaml":   `{"type": "userpass"}`,
+		"sys/policy/unique.json":   `{"policy": ""}`,
+		"secret/foo.json":          `{"foo": "bar"}`,
+		"_templates/userpass.json": `{}`,
+	})
+
+	client := &vault.MockClient{}
+	_, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err == nil {
+		t.Fatalf("Expected a duplicate resource error")
+	}
+	for _, f := range []string{"sys/auth/userpass.json", "sys/auth/userpass.yaml"} {
+		if !strings.Contains(err.Error(), filepath.Join(docPath, f)) {
+			t.Errorf("Expected error to name %s, got: %s", f, err)
+		}
+	}
+	if !strings.Contains(err.Error(), "sys/auth/userpass is defined by both") {
+		t.Errorf("Expected error to name the resource, got: %s", err)
+	}
+	// nothing is applied
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// A templated file name rendering to the same name as another file is also a duplicate
+func TestConfigWalker_DuplicateTemplated(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"_vaultsmith.json":            `{"instances": {"service": ["foo", "bar"]}}`,
+		"sys/policy/foo.json":         `{"policy": ""}`,
+		"sys/policy/{{service}}.json": `{"policy": ""}`,
+	})
+
+	conf := config.VaultsmithConfig{TemplateFile: filepath.Join(docPath, "_vaultsmith.json")}
+	_, err := NewConfigWalker(&vault.MockClient{}, conf, docPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "sys/policy/foo is defined by both") {
+		t.Errorf("Expected a duplicate error for sys/policy/foo, got: %v", err)
+	}
+}
+
+// A mount listed in the auth index file and in its own file is a duplicate
+func TestConfigWalker_DuplicateAuthIndex(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/_index.json":   `{"approle": {"type": "approle"}, "userpass": {"type": "userpass"}}`,
+		"sys/auth/userpass.json": `{"type": "userpass"}`,
+	})
+
+	_, err := NewConfigWalker(&vault.MockClient{}, config.VaultsmithConfig{}, docPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "sys/auth/userpass is defined by both") {
+		t.Errorf("Expected a duplicate error for sys/auth/userpass, got: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/starlingbank/vaultsmith/document"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// stops the walk at the first document
+var errFound = errors.New("found")
+
+// Fail if docPath, read from fsys, is missing or has no documents, as applying it would remove
+// everything vaultsmith manages, e.g. when a broken CI checkout never fetched them
+func checkNotEmpty(fsys fs.FS, docPath string, ignore *document.Ignore) error {
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		return fmt.Errorf("document path %s can't be read: %s", docPath, err)
+	}
+
+	found := false
+	err := fs.WalkDir(fsys, ".", func(name string, f fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath := filepath.FromSlash(name)
+		if relPath == "." {
+			return nil
+		}
+		if ignore.Match(relPath, f.IsDir()) {
+			return skipDir(f)
+		}
+		if f.IsDir() {
+			if strings.HasPrefix(f.Name(), "_") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// files at the top level, such as _vaultsmith.json, aren't resources
+		if filepath.Dir(relPath) != "." {
+			found = true
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no documents found in %s, so everything would be removed from Vault; "+
+			"pass --allow-empty if that is what you want", docPath)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigWalker_MissingDocumentPath(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, nil)
+
+	client := &vault.MockClient{}
+	_, err := NewConfigWalker(client, config.VaultsmithConfig{}, filepath.Join(docPath, "missing"), nil)
+	if err == nil || !strings.Contains(err.Error(), "can't be read") {
+		t.Errorf("Expected an error for a missing document path, got %v", err)
+	}
+}
+
+// Only empty directories and top level files, as a broken checkout might leave
+func TestConfigWalker_EmptyDocumentPath(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"_vaultsmith.json": `{"variables": {}}`,
+		"README.md":        "Vault configuration",
+	})
+	os.MkdirAll(filepath.Join(docPath, "sys", "auth"), 0755)
+
+	client := &vault.MockClient{}
+	_, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "no documents found") {
+		t.Errorf("Expected an error for a document path without documents, got %v", err)
+	}
+}
+
+func TestConfigWalker_AllowEmpty(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, nil)
+	os.MkdirAll(filepath.Join(docPath, "sys", "auth"), 0755)
+
+	client := &vault.MockClient{}
+	_, err := NewConfigWalker(client, config.VaultsmithConfig{AllowEmpty: true}, docPath, nil)
+	if err != nil {
+		t.Errorf("Expected an empty document path to be allowed, got %s", err)
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/document"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The documents found for one target, e.g. "auth"
+type InventoryEntry struct {
+	Target string `json:"target"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Count the documents under docPath, and their size, by the target which applies them. This is
+// done before anything is applied, so an unexpected fetch or overlay shows up at the start of the
+// run. Templated documents count once, however many resources they render to, and documents under
+// sys without a handler aren't counted, as nothing applies them.
+func Inventory(docPath string, ignore *document.Ignore) ([]InventoryEntry, error) {
+	counts := map[string]*InventoryEntry{}
+	err := fs.WalkDir(os.DirFS(docPath), ".", func(name string, f fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath := filepath.FromSlash(name)
+		if relPath == "." {
+			return nil
+		}
+		if ignore.Match(relPath, f.IsDir()) {
+			return skipDir(f)
+		}
+		if f.IsDir() {
+			if strings.HasPrefix(f.Name(), "_") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(relPath) == "." {
+			return nil
+		}
+
+		target := inventoryTarget(name)
+		if target == "" {
+			return nil
+		}
+		info, err := f.Info()
+		if err != nil {
+			return err
+		}
+		if counts[target] == nil {
+			counts[target] = &InventoryEntry{Target: target}
+		}
+		counts[target].Files++
+		counts[target].Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]InventoryEntry, 0, len(counts))
+	for _, e := range counts {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Target < entries[j].Target
+	})
+	return entries, nil
+}
+
+// The target applying the document at relPath, or "" if there is none
+func inventoryTarget(relPath string) string {
+	target, longest := "", 0
+	for _, r := range handlerRegistry {
+		if strings.HasPrefix(relPath, r.path+"/") && len(r.path) > longest {
+			target, longest = r.target, len(r.path)
+		}
+	}
+	if target == "" && !strings.HasPrefix(relPath, "sys/") {
+		return genericTarget
+	}
+	return target
+}
+
+// Log the inventory, one line per target
+func LogInventory(entries []InventoryEntry) {
+	var files int
+	for _, e := range entries {
+		log.WithFields(log.Fields{
+			"target": e.Target,
+			"files":  e.Files,
+			"bytes":  e.Bytes,
+		}).Info("Found documents")
+		files += e.Files
+	}
+	log.WithFields(log.Fields{"files": files}).Info("Found documents in total")
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInventory_Example(t *testing.T) {
+	wd, _ := os.Getwd()
+	docPath := filepath.Join(filepath.Dir(wd), "example")
+
+	entries, err := Inventory(docPath, nil)
+	if err != nil {
+		t.Fatalf("Error calling Inventory: %s", err)
+	}
+	files := map[string]int{}
+	for _, e := range entries {
+		files[e.Target] = e.Files
+		if e.Bytes == 0 {
+			t.Errorf("Expected the size of the %s documents, got 0", e.Target)
+		}
+	}
+	// templated documents count once, and top level files such as README.md not at all
+	expected := map[string]int{"auth": 2, "policy": 4, "generic": 5}
+	if len(files) != len(expected) {
+		t.Errorf("Expected counts %+v, got %+v", expected, files)
+	}
+	for target, n := range expected {
+		if files[target] != n {
+			t.Errorf("Expected %d %s documents, got %d", n, target, files[target])
+		}
+	}
+}
+
+func TestInventoryTarget(t *testing.T) {
+	for relPath, expected := range map[string]string{
+		"sys/auth/approle.json":                                 "auth",
+		"sys/config/ui/headers/x.json":                          "config",
+		"sys/config/auditing/request-headers/x-request-id.json": "config",
+		"auth/jwt/role/admin.json":                              "jwt",
+		"secret/foo.json":                                       "generic",
+		"sys/unhandled/foo.json":                                "",
+	} {
+		if target := inventoryTarget(relPath); target != expected {
+			t.Errorf("Expected %s to be counted for %q, got %q", relPath, expected, target)
+		}
+	}
+}
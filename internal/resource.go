@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Apply a single resource read from r to resourcePath (e.g. "sys/auth/approle"), bypassing the
+// directory walk. Nothing other than this resource is touched.
+func ApplyResource(client vault.Vault, config config.VaultsmithConfig, resourcePath string, r io.Reader) error {
+	resourcePath = strings.Trim(resourcePath, "/")
+	if resourcePath == "" {
+		return fmt.Errorf("a resource path is required when reading a single resource")
+	}
+
+	handler, err := resourceHandler(client, config, resourcePath)
+	if err != nil {
+		return err
+	}
+
+	r, err = fromYAML(r, config.MaxFileSize)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", resourcePath, err)
+	}
+
+	log.WithFields(log.Fields{"path": resourcePath}).Infof("Applying single resource")
+	return handler.PutResource(resourcePath, r)
+}
+
+// A resource read from stdin has no extension to tell its format by, so it is converted from
+// YAML, of which JSON is a subset. Content which isn't valid YAML, e.g. JSON which is only valid
+// once rendered, or which is too big, is left for the handler to report.
+func fromYAML(r io.Reader, maxFileSize int64) (io.Reader, error) {
+	if maxFileSize > 0 {
+		r = io.LimitReader(r, maxFileSize+1)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxFileSize > 0 && int64(len(content)) > maxFileSize {
+		return bytes.NewReader(content), nil
+	}
+	j, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return bytes.NewReader(content), nil
+	}
+	return bytes.NewReader(j), nil
+}
+
+// Return the handler responsible for the given resource path
+func resourceHandler(client vault.Vault, config config.VaultsmithConfig, resourcePath string) (path_handlers.ResourceHandler, error) {
+	hc := handlerConfig(config, "", nil)
+	switch {
+	case strings.HasPrefix(resourcePath, "sys/auth/"):
+		return path_handlers.NewSysAuthHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/mounts/"):
+		return path_handlers.NewSysMountsHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/policy/"):
+		return path_handlers.NewSysPolicyHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/policies/acl/"):
+		return path_handlers.NewACLPolicyHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/policies/rgp/"), strings.HasPrefix(resourcePath, "sys/policies/egp/"):
+		return path_handlers.NewSentinelPolicyHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/plugins/catalog/"):
+		return path_handlers.NewPluginCatalogHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/config/auditing/request-headers/"):
+		return path_handlers.NewAuditHeadersHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/config/"):
+		return path_handlers.NewSysConfigHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/quotas/"):
+		return path_handlers.NewQuotasHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "sys/mfa/"):
+		return path_handlers.NewMfaHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "identity/mfa/login-enforcement/"):
+		return path_handlers.NewLoginMfaHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "auth/ldap/"):
+		return path_handlers.NewLdapHandler(client, hc)
+	case strings.HasPrefix(resourcePath, "auth/jwt/"), strings.HasPrefix(resourcePath, "auth/oidc/"):
+		return path_handlers.NewJwtHandler(client, hc)
+	case resourcePath == "sys" || strings.HasPrefix(resourcePath, "sys/"):
+		return nil, fmt.Errorf("no handler for resource path %s", resourcePath)
+	default:
+		return path_handlers.NewGeneric(client, hc)
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"bytes"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestApplyResource_SysAuth(t *testing.T) {
+	client := &vault.MockClient{}
+	r := bytes.NewBufferString(`{"type": "approle", "description": "from stdin"}`)
+
+	err := ApplyResource(client, config.VaultsmithConfig{}, "sys/auth/ci-approle", r)
+	if err != nil {
+		t.Fatalf("Error calling ApplyResource: %s", err)
+	}
+
+	calls := client.CallsTo("EnableAuth")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 EnableAuth call, got %d", len(calls))
+	}
+	if calls[0].Args[0] != "ci-approle/" {
+		t.Errorf("Expected EnableAuth on %q, got %q", "ci-approle/", calls[0].Args[0])
+	}
+	opts := calls[0].Args[1].(*vaultApi.EnableAuthOptions)
+	if opts.Type != "approle" || opts.Description != "from stdin" {
+		t.Errorf("Unexpected EnableAuth options %+v", opts)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no DisableAuth calls for a single resource, got %+v", calls)
+	}
+}
+
+func TestApplyResource_Generic(t *testing.T) {
+	client := &vault.MockClient{}
+	r := bytes.NewBufferString(`{"policies": ["foo"]}`)
+
+	err := ApplyResource(client, config.VaultsmithConfig{}, "auth/approle/role/foo", r)
+	if err != nil {
+		t.Fatalf("Error calling ApplyResource: %s", err)
+	}
+	calls := client.CallsTo("Write")
+	if len(calls) != 1 || calls[0].Args[0] != "auth/approle/role/foo" {
+		t.Errorf("Expected a single write to auth/approle/role/foo, got %+v", calls)
+	}
+}
+
+// A resource read from stdin may be written in YAML, having no extension to tell it by
+func TestApplyResource_Yaml(t *testing.T) {
+	client := &vault.MockClient{}
+	r := bytes.NewBufferString("# from stdin\ntype: approle\nconfig:\n  max_lease_ttl: 1h\n")
+
+	err := ApplyResource(client, config.VaultsmithConfig{}, "sys/auth/ci-approle", r)
+	if err != nil {
+		t.Fatalf("Error calling ApplyResource: %s", err)
+	}
+	calls := client.CallsTo("EnableAuth")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 EnableAuth call, got %d", len(calls))
+	}
+	opts := calls[0].Args[1].(*vaultApi.EnableAuthOptions)
+	if opts.Type != "approle" || opts.Config.MaxLeaseTTL != "1h" {
+		t.Errorf("Unexpected EnableAuth options %+v", opts)
+	}
+}
+
+func TestApplyResource_UnhandledSysPath(t *testing.T) {
+	err := ApplyResource(&vault.MockClient{}, config.VaultsmithConfig{}, "sys/foo", &bytes.Buffer{})
+	if err == nil {
+		t.Error("Expected error for unhandled sys path, got nil")
+	}
+}
+
+// An ldap config read from stdin has its bind password rendered from the environment
+func TestApplyResource_LdapBindPass(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_BINDPASS", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_BINDPASS")
+	client := &vault.MockClient{}
+	r := bytes.NewBufferString(`{"url": "ldaps://ldap.example.com", "bindpass": "{{ env.VAULTSMITH_TEST_BINDPASS }}"}`)
+
+	err := ApplyResource(client, config.VaultsmithConfig{}, "auth/ldap/config", r)
+	if err != nil {
+		t.Fatalf("Error calling ApplyResource: %s", err)
+	}
+	calls := client.CallsTo("Write")
+	if len(calls) != 1 || calls[0].Args[0] != "auth/ldap/config" {
+		t.Fatalf("Expected a single write to auth/ldap/config, got %+v", calls)
+	}
+	if data := calls[0].Args[1].(map[string]interface{}); data["bindpass"] != "hunter2" {
+		t.Errorf("Expected bindpass from the environment, got %q", data["bindpass"])
+	}
+}
+
+// A literal bind password read from stdin is refused, as it is in a file
+func TestApplyResource_LdapLiteralBindPass(t *testing.T) {
+	client := &vault.MockClient{}
+	r := bytes.NewBufferString(`{"url": "ldaps://ldap.example.com", "bindpass": "hunter2"}`)
+
+	err := ApplyResource(client, config.VaultsmithConfig{}, "auth/ldap/config", r)
+	if err == nil || !strings.Contains(err.Error(), "bindpass") {
+		t.Errorf("Expected the literal bindpass to be refused, got %v", err)
+	}
+	if calls := client.CallsTo("Write"); len(calls) != 0 {
+		t.Errorf("Expected no writes, got %+v", calls)
+	}
+}
+
+// An oidc config read from stdin has its client secret checked and rendered as in a file
+func TestApplyResource_OidcClientSecret(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_CLIENT_SECRET", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_CLIENT_SECRET")
+	client := &vault.MockClient{}
+	r := bytes.NewBufferString(`{"oidc_client_id": "vault", "oidc_client_secret": "{{ env.VAULTSMITH_TEST_CLIENT_SECRET }}"}`)
+	if err := ApplyResource(client, config.VaultsmithConfig{}, "auth/oidc/config", r); err != nil {
+		t.Fatalf("Error calling ApplyResource: %s", err)
+	}
+	calls := client.CallsTo("Write")
+	if len(calls) != 1 || calls[0].Args[1].(map[string]interface{})["oidc_client_secret"] != "hunter2" {
+		t.Errorf("Expected the client secret from the environment to be written, got %+v", calls)
+	}
+
+	client = &vault.MockClient{}
+	r = bytes.NewBufferString(`{"oidc_client_id": "vault", "oidc_client_secret": "hunter2"}`)
+	err := ApplyResource(client, config.VaultsmithConfig{}, "auth/oidc/config", r)
+	if err == nil || !strings.Contains(err.Error(), "oidc_client_secret") {
+		t.Errorf("Expected the literal client secret to be refused, got %v", err)
+	}
+	if calls := client.CallsTo("Write"); len(calls) != 0 {
+		t.Errorf("Expected no writes, got %+v", calls)
+	}
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Waits for the subtree stagger to pass, and picks its jitter. Replaced in tests.
+var staggerAfter = time.After
+var staggerRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Create the client for a subtree applied to another Vault, with the other settings in opts.
+// Replaced in tests.
+var newSubtreeClient = func(target config.SubtreeTarget, opts vault.ClientOptions) (vault.Vault, error) {
+	opts.Address = target.Address
+	if target.TokenEnv != "" {
+		opts.Token = os.Getenv(target.TokenEnv)
+		if opts.Token == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", target.TokenEnv)
+		}
+	}
+	return vault.NewVaultClientWithOptions(opts)
+}
+
+// Give each subtree applied to a different Vault its own generic handler, using a client for that
+// Vault. Only paths which would otherwise be generic can be moved, as the dedicated handlers
+// manage the whole of their directory. Returns the subtrees given a handler.
+func addSubtreeHandlers(handlerMap map[string]path_handlers.PathHandler, config config.VaultsmithConfig, hc path_handlers.PathHandlerConfig, nullHandler path_handlers.PathHandler, skipGeneric bool) (map[string]bool, error) {
+	var subtrees []string
+	applied := map[string]bool{}
+	for subtree := range config.Subtrees {
+		rel := path.Clean(strings.Trim(subtree, "/"))
+		if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("subtree %q is not within the document path", subtree)
+		}
+		if rel == "sys" || strings.HasPrefix(rel, "sys/") {
+			return nil, fmt.Errorf("subtree %s is under sys, which can't be applied to another Vault", rel)
+		}
+		for _, r := range handlerRegistry {
+			if overlaps(rel, r.path) {
+				return nil, fmt.Errorf("subtree %s overlaps %s, which has its own handler", rel, r.path)
+			}
+		}
+		for _, other := range subtrees {
+			if overlaps(rel, other) {
+				return nil, fmt.Errorf("subtrees %s and %s overlap", rel, other)
+			}
+		}
+		subtrees = append(subtrees, rel)
+
+		if skipGeneric {
+			handlerMap[rel] = nullHandler
+			continue
+		}
+
+		target := config.Subtrees[subtree]
+		log.WithFields(log.Fields{"subtree": rel, "address": target.Address}).Info(
+			"Applying subtree to a different Vault")
+		client, err := newSubtreeClient(target, vault.ClientOptions{
+			ReadOnly:         config.Dry,
+			Timeout:          config.VaultTimeout,
+			MaxIdleConns:     config.VaultMaxIdleConns,
+			KeepAlive:        config.VaultKeepAlive,
+			Headers:          config.VaultHeaders,
+			RunID:            config.RunID,
+			WarningsAsErrors: config.WarningsAsErrors,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create client for subtree %s: %s", rel, err)
+		}
+		err = client.Authenticate(config.VaultRole)
+		if err != nil {
+			return nil, fmt.Errorf("failed authenticating with Vault at %s for subtree %s: %s",
+				target.Address, rel, err)
+		}
+		handler, err := path_handlers.NewGeneric(client, withOrder(hc, 0, rel))
+		if err != nil {
+			return nil, fmt.Errorf("could not create handler for subtree %s: %s", rel, err)
+		}
+		handlerMap[rel] = handler
+		applied[rel] = true
+	}
+	return applied, nil
+}
+
+// Wait before applying the subtree at path to its Vault, if config.SubtreeStagger is set, for a
+// random time between half of it and all of it, so the Vaults (and anything they share) aren't all
+// hit at once. Returns ctx.Err() if the run is stopped while waiting.
+func (cw ConfigWalker) stagger(ctx context.Context, path string) error {
+	if !cw.subtrees[path] || cw.subtreeStagger <= 0 {
+		return nil
+	}
+	half := cw.subtreeStagger / 2
+	wait := half + time.Duration(staggerRand.Int63n(int64(cw.subtreeStagger-half)+1))
+	log.WithFields(log.Fields{"subtree": path, "wait": wait}).Info("Waiting before applying to another Vault")
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-staggerAfter(wait):
+		return nil
+	}
+}
+
+// true if either path is, or is within, the other
+func overlaps(a string, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"context"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Paths of the Write calls made to client
+func writtenPaths(client *vault.MockClient) (paths []string) {
+	for _, c := range client.CallsTo("Write") {
+		paths = append(paths, c.Args[0].(string))
+	}
+	return paths
+}
+
+func TestConfigWalker_Subtrees(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"secret/shared/foo.json":   `{"foo": "bar"}`,
+		"secret/payments/key.json": `{"value": "a"}`,
+		"dr/config/key.json":       `{"value": "b"}`,
+	})
+
+	clients := map[string]*vault.MockClient{
+		"https://payments:8200": {},
+		"https://dr:8200":       {},
+	}
+	defer func(f func(config.SubtreeTarget, vault.ClientOptions) (vault.Vault, error)) { newSubtreeClient = f }(newSubtreeClient)
+	newSubtreeClient = func(target config.SubtreeTarget, opts vault.ClientOptions) (vault.Vault, error) {
+		client := clients[target.Address]
+		client.On("Authenticate", "root")
+		return client, nil
+	}
+
+	primary := &vault.MockClient{}
+	conf := config.VaultsmithConfig{
+		VaultRole: "root",
+		Subtrees: map[string]config.SubtreeTarget{
+			"secret/payments": {Address: "https://payments:8200"},
+			"/dr/":            {Address: "https://dr:8200"},
+		},
+	}
+	cw, err := NewConfigWalker(primary, conf, docPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to create ConfigWalker: %s", err)
+	}
+	err = cw.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := map[*vault.MockClient]string{
+		primary:                          "secret/shared/foo",
+		clients["https://payments:8200"]: "secret/payments/key",
+		clients["https://dr:8200"]:       "dr/config/key",
+	}
+	for client, p := range expected {
+		written := writtenPaths(client)
+		if len(written) != 1 || written[0] != p {
+			t.Errorf("Expected only %s to be written to its client, got %v", p, written)
+		}
+	}
+}
+
+func TestConfigWalker_SubtreeUnderDedicatedHandler(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"auth/ldap/config.json": `{}`,
+	})
+
+	for _, subtree := range []string{"sys/auth", "auth", "auth/ldap/groups"} {
+		conf := config.VaultsmithConfig{
+			Subtrees: map[string]config.SubtreeTarget{subtree: {Address: "https://dr:8200"}},
+		}
+		_, err := NewConfigWalker(&vault.MockClient{}, conf, docPath, nil)
+		if err == nil {
+			t.Errorf("Expected an error for subtree %s", subtree)
+		}
+	}
+}
+
+// Requests to a subtree's Vault carry the --vault-header headers too, e.g. for a gateway in front
+func TestConfigWalker_SubtreeHeaders(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"secret/payments/key.json": `{"value": "a"}`,
+	})
+
+	var mu sync.Mutex
+	var gatewayTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gatewayTokens = append(gatewayTokens, r.Header.Get("X-Gateway-Token"))
+		mu.Unlock()
+		if r.Method == http.MethodGet {
+			// nothing there yet
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer func(token string) { os.Setenv("PAYMENTS_VAULT_TOKEN", token) }(os.Getenv("PAYMENTS_VAULT_TOKEN"))
+	os.Setenv("PAYMENTS_VAULT_TOKEN", "root")
+
+	conf := config.VaultsmithConfig{
+		VaultRole:    "root",
+		VaultHeaders: http.Header{"X-Gateway-Token": []string{"letmein"}},
+		Subtrees: map[string]config.SubtreeTarget{
+			"secret/payments": {Address: server.URL, TokenEnv: "PAYMENTS_VAULT_TOKEN"},
+		},
+	}
+	cw, err := NewConfigWalker(&vault.MockClient{}, conf, docPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to create ConfigWalker: %s", err)
+	}
+	if err := cw.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(gatewayTokens) == 0 {
+		t.Fatal("Expected requests to the subtree's Vault, got none")
+	}
+	for _, token := range gatewayTokens {
+		if token != "letmein" {
+			t.Errorf("Expected every request to carry the gateway header, got %q", gatewayTokens)
+			break
+		}
+	}
+}
+
+// A clock which moves on by however long is waited for, straight away
+type fakeStaggerClock struct {
+	now time.Time
+}
+
+func (c *fakeStaggerClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// A client recording when, by clock, it was first written to
+type timedClient struct {
+	*vault.MockClient
+	clock   *fakeStaggerClock
+	written time.Time
+}
+
+func (c *timedClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	if c.written.IsZero() {
+		c.written = c.clock.now
+	}
+	return c.MockClient.Write(path, data)
+}
+
+func TestConfigWalker_SubtreeStagger(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"secret/shared/foo.json":   `{"foo": "bar"}`,
+		"secret/payments/key.json": `{"value": "a"}`,
+		"dr/config/key.json":       `{"value": "b"}`,
+	})
+
+	start := time.Unix(0, 0)
+	clock := &fakeStaggerClock{now: start}
+	defer func(f func(time.Duration) <-chan time.Time) { staggerAfter = f }(staggerAfter)
+	staggerAfter = clock.After
+	defer func(r *rand.Rand) { staggerRand = r }(staggerRand)
+	staggerRand = rand.New(rand.NewSource(1))
+
+	clients := map[string]*timedClient{
+		"https://payments:8200": {MockClient: &vault.MockClient{}, clock: clock},
+		"https://dr:8200":       {MockClient: &vault.MockClient{}, clock: clock},
+	}
+	defer func(f func(config.SubtreeTarget, vault.ClientOptions) (vault.Vault, error)) { newSubtreeClient = f }(newSubtreeClient)
+	newSubtreeClient = func(target config.SubtreeTarget, opts vault.ClientOptions) (vault.Vault, error) {
+		client := clients[target.Address]
+		client.On("Authenticate", "root")
+		return client, nil
+	}
+
+	primary := &timedClient{MockClient: &vault.MockClient{}, clock: clock}
+	stagger := 10 * time.Second
+	conf := config.VaultsmithConfig{
+		VaultRole: "root",
+		Subtrees: map[string]config.SubtreeTarget{
+			"secret/payments": {Address: "https://payments:8200"},
+			"dr":              {Address: "https://dr:8200"},
+		},
+		SubtreeStagger: stagger,
+	}
+	cw, err := NewConfigWalker(primary, conf, docPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to create ConfigWalker: %s", err)
+	}
+	if err := cw.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// subtrees are applied in order of their path, each after waiting from the one before
+	previous := start
+	for _, address := range []string{"https://dr:8200", "https://payments:8200"} {
+		wait := clients[address].written.Sub(previous)
+		if wait < stagger/2 || wait > stagger {
+			t.Errorf("Expected %s to be applied between %s and %s after the Vault before, got %s",
+				address, stagger/2, stagger, wait)
+		}
+		previous = clients[address].written
+	}
+	if primary.written != previous {
+		t.Errorf("Expected the primary Vault's generic documents not to wait, got written at %s", primary.written)
+	}
+}
@@ -0,0 +1,24 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write documents (path relative to the document root -> content) to a new document directory,
+// which is removed once the test finishes. Shared by the tests of each package.
+func WriteDocuments(t *testing.T, docs map[string]string) string {
+	dir := t.TempDir()
+	for p, content := range docs {
+		file := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Validate each document in fsys, which is docPath, against the schema for its resource type, if
+// there is one, returning an error naming every file with unknown fields (unless
+// allowUnknownFields) or values of the wrong type. Files larger than maxFileSize (if not 0) are
+// left for the handler to reject.
+func validateDocuments(fsys fs.FS, docPath string, ignore *document.Ignore, tp document.TemplateParams, maxFileSize int64, allowUnknownFields bool) error {
+	var invalid []string
+
+	err := fs.WalkDir(fsys, ".", func(name string, f fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(docPath, filepath.FromSlash(name))
+		relPath := filepath.FromSlash(name)
+		if relPath == "." {
+			return nil
+		}
+		if ignore.Match(relPath, f.IsDir()) {
+			return skipDir(f)
+		}
+		if f.IsDir() {
+			if strings.HasPrefix(f.Name(), "_") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(relPath) == "." {
+			return nil
+		}
+		relPath, err = document.RenderPath(relPath, tp)
+		if err != nil {
+			// reported properly when the document is applied
+			return nil
+		}
+
+		resourcePath := normalizeResourcePath(filepath.ToSlash(strings.TrimSuffix(relPath, filepath.Ext(relPath))))
+		schema := document.SchemaFor(resourcePath)
+		if schema == nil {
+			return nil
+		}
+		info, err := f.Info()
+		if err != nil {
+			return err
+		}
+		if maxFileSize > 0 && info.Size() > maxFileSize {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("error opening file: %s", err)
+		}
+		td := &document.Template{
+			FileName: strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath)),
+			Content:  string(content),
+			Params:   tp,
+		}
+		rendered, err := td.Render()
+		if err != nil {
+			// reported properly when the document is applied
+			return nil
+		}
+		for _, r := range rendered {
+			content, err := document.ToJSON(relPath, []byte(r.Content))
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s: %s", path, err))
+				break
+			}
+			docs := []string{string(content)}
+			if isAuthIndex(relPath) {
+				// each entry is the document of a mount
+				index, err := path_handlers.ParseAuthIndex(content)
+				if err != nil {
+					invalid = append(invalid, fmt.Sprintf("%s: %s", path, err))
+					break
+				}
+				docs = nil
+				for _, mount := range sortedMounts(index) {
+					docs = append(docs, string(index[mount]))
+				}
+			}
+			if err := validateDocument(schema, docs, allowUnknownFields); err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s: %s", path, err))
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not validate documents in %s: %s", docPath, err)
+	}
+
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return fmt.Errorf("invalid documents found: %s", strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// Validate each of docs against schema, leaving out their annotations, which are for vaultsmith
+// rather than part of the resource
+func validateDocument(schema *document.Schema, docs []string, allowUnknownFields bool) error {
+	for _, d := range docs {
+		_, doc, err := document.SplitAnnotations([]byte(d))
+		if err == nil {
+			err = schema.Validate(string(doc), allowUnknownFields)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the file at relPath is the index file of sys/auth, listing a document for each mount
+func isAuthIndex(relPath string) bool {
+	return filepath.ToSlash(relPath) == "sys/auth/"+path_handlers.AuthIndexFile
+}
+
+func sortedMounts(index map[string]json.RawMessage) []string {
+	mounts := make([]string, 0, len(index))
+	for m := range index {
+		mounts = append(mounts, m)
+	}
+	sort.Strings(mounts)
+	return mounts
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A document with a misspelled field should fail before anything is applied
+func TestConfigWalker_InvalidDocument(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json": `{"type": "approle", "config": {"default_lase_ttl": "1h"}}`,
+		"sys/auth/aws.json":     `{"type": "aws"}`,
+	})
+
+	client := &vault.MockClient{}
+	_, err := NewConfigWalker(client, config.VaultsmithConfig{}, docPath, nil)
+	if err == nil {
+		t.Fatalf("Expected a validation error")
+	}
+	if !strings.Contains(err.Error(), filepath.Join(docPath, "sys/auth/approle.json")) {
+		t.Errorf("Expected error to name the file, got: %s", err)
+	}
+	if !strings.Contains(err.Error(), "default_lase_ttl") {
+		t.Errorf("Expected error to name the field, got: %s", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// Each entry of the auth index file is validated as a sys/auth document
+func TestConfigWalker_InvalidAuthIndex(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/_index.json": `{"approle": {"type": "approle"}, "aws": {"type": "aws", "config": {"default_lase_ttl": "1h"}}}`,
+	})
+
+	_, err := NewConfigWalker(&vault.MockClient{}, config.VaultsmithConfig{}, docPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "default_lase_ttl") {
+		t.Fatalf("Expected a validation error naming the field, got: %v", err)
+	}
+
+	docPath = testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/_index.json": `{"approle": {"type": "approle"}, "aws": {"type": "aws"}}`,
+	})
+	if _, err := NewConfigWalker(&vault.MockClient{}, config.VaultsmithConfig{}, docPath, nil); err != nil {
+		t.Errorf("Expected a valid index to pass, got: %s", err)
+	}
+}
+
+// Allowing unknown fields still checks the type of those which are known
+func TestConfigWalker_AllowUnknownFields(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json": `{"type": "approle", "config": {"default_lase_ttl": "1h"}}`,
+	})
+	conf := config.VaultsmithConfig{AllowUnknownFields: true}
+	if _, err := NewConfigWalker(&vault.MockClient{}, conf, docPath, nil); err != nil {
+		t.Errorf("Expected an unknown field to be allowed, got: %s", err)
+	}
+
+	docPath = testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json": `{"type": "approle", "local": "yes", "extra": true}`,
+	})
+	_, err := NewConfigWalker(&vault.MockClient{}, conf, docPath, nil)
+	if err == nil || !strings.Contains(err.Error(), `field "local" should be boolean, not string`) {
+		t.Errorf("Expected a validation error for the type of local, got: %v", err)
+	}
+}
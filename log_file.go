@@ -0,0 +1,130 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// An append-only log file which is rotated once writing to it would take it past maxSize bytes. The
+// rotated files are kept next to it as <path>.1 (the newest), <path>.2 and so on, gzipped if
+// compress is set, and only maxBackups of them are kept, unless it is 0.
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // no rotation if 0
+	maxBackups int
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openLogFile(path string, maxSize int64, maxBackups int, compress bool) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, compress: compress}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// a line longer than maxSize is written anyway, to a file of its own
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not read log file info: %s", err)
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// The name of the nth most recent backup
+func (r *rotatingFile) backup(n int) string {
+	if r.compress {
+		return fmt.Sprintf("%s.%d.gz", r.path, n)
+	}
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+// Move the current file to the first backup, shifting the older ones along, and start a new one
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("could not close log file: %s", err)
+	}
+
+	last := 1
+	for ; r.maxBackups == 0 || last < r.maxBackups; last++ {
+		if _, err := os.Stat(r.backup(last)); os.IsNotExist(err) {
+			break
+		}
+	}
+	// the oldest falls off the end if there are already maxBackups
+	for n := last; n > 1; n-- {
+		if err := os.Rename(r.backup(n-1), r.backup(n)); err != nil {
+			return fmt.Errorf("could not rotate log file: %s", err)
+		}
+	}
+
+	var err error
+	if r.compress {
+		err = compressFile(r.path, r.backup(1))
+	} else {
+		err = os.Rename(r.path, r.backup(1))
+	}
+	if err != nil {
+		return fmt.Errorf("could not rotate log file: %s", err)
+	}
+	return r.open()
+}
+
+// Gzip the file at src to dst, removing src
+func compressFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
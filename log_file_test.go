@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vaultsmith.log")
+
+	f, err := openLogFile(path, 20, 2, false)
+	if err != nil {
+		t.Fatalf("Error opening log file: %s", err)
+	}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+	f.Close()
+
+	// each line takes the file past 20 bytes, so is in a file of its own; the first is dropped
+	for name, expected := range map[string]string{
+		path:        "fourth line\n",
+		path + ".1": "third line\n",
+		path + ".2": "second line\n",
+	} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil || string(content) != expected {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", name, expected, content, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept")
+	}
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vaultsmith.log")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", 15)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the size of what is already there counts
+	f, err := openLogFile(path, 20, 0, true)
+	if err != nil {
+		t.Fatalf("Error opening log file: %s", err)
+	}
+	if _, err := f.Write([]byte("new line\n")); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	f.Close()
+
+	backup, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatalf("Expected a compressed backup: %s", err)
+	}
+	defer backup.Close()
+	gz, err := gzip.NewReader(backup)
+	if err != nil {
+		t.Fatalf("Backup is not gzipped: %s", err)
+	}
+	content, err := ioutil.ReadAll(gz)
+	if err != nil || string(content) != strings.Repeat("x", 15)+"\n" {
+		t.Errorf("Expected the old log in the backup, got %q (%v)", content, err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "new line\n" {
+		t.Errorf("Expected the new line in a new file, got %q", content)
+	}
+}
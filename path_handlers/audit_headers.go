@@ -0,0 +1,212 @@
+package path_handlers
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+/*
+	AuditHeaders handles the request headers included in audit logs, declared in
+	sys/config/auditing/request-headers/<name>.json, e.g.
+		{"hmac": true}
+	to HMAC the value of the header before it is logged. Header names are case insensitive, as in
+	Vault. Undeclared headers are removed from the audited set, if the directory exists.
+*/
+type AuditHeaders struct {
+	BaseHandler
+	live       map[string]bool // lower case header name -> hmac
+	configured map[string]bool
+}
+
+// As the request-headers API
+type auditHeader struct {
+	HMAC bool `json:"hmac"`
+}
+
+const auditHeadersPath = "sys/config/auditing/request-headers"
+
+func NewAuditHeadersHandler(client vault.Vault, config PathHandlerConfig) (*AuditHeaders, error) {
+	return &AuditHeaders{
+		BaseHandler: BaseHandler{
+			name:   "AuditHeaders",
+			client: client,
+			config: config,
+			order:  config.Order,
+			log:    handlerLogger("AuditHeaders", config),
+		},
+		configured: map[string]bool{},
+	}, nil
+}
+
+func (ah *AuditHeaders) walkFile(path string, f fs.DirEntry, err error) error {
+	if f == nil {
+		ah.log.WithFields(log.Fields{"path": path, "error": err}).Debug("Path does not exist, skipping")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	if ah.ignored(path, f) {
+		return skipIgnored(f)
+	}
+	if f.IsDir() {
+		return nil
+	}
+
+	resourcePath, err := ah.resourcePath(path)
+	if err != nil {
+		return err
+	}
+	name, err := auditHeaderName(resourcePath)
+	if err != nil {
+		return err
+	}
+
+	var header auditHeader
+	annotations, err := ah.decodeFile(path, &header)
+	if err != nil {
+		return err
+	}
+	enabled, err := ah.enabled(resourcePath, annotations)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if !annotations.PruneWhenDisabled {
+			// still declared, so not removed
+			ah.configured[name] = true
+		}
+		return nil
+	}
+	return ah.ensureHeader(name, header)
+}
+
+// Apply a single header, e.g. resourcePath "sys/config/auditing/request-headers/x-request-id"
+func (ah *AuditHeaders) PutResource(resourcePath string, r io.Reader) error {
+	resourcePath = normalizePath(resourcePath)
+	name, err := auditHeaderName(resourcePath)
+	if err != nil {
+		return err
+	}
+
+	var header auditHeader
+	annotations, err := ah.decodeReader(resourcePath, r, &header)
+	if err != nil {
+		return err
+	}
+	if enabled, err := ah.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+	if err := ah.readLive(); err != nil {
+		return err
+	}
+	return ah.ensureHeader(name, header)
+}
+
+func (ah *AuditHeaders) PutPoliciesFromDir(path string) error {
+	if !ah.exists(path) {
+		// nothing declared, so the audited headers are left alone
+		return nil
+	}
+	if err := ah.readLive(); err != nil {
+		return err
+	}
+	err := ah.walk(path, ah.walkFile)
+	if err != nil {
+		return err
+	}
+	return ah.removeUndeclared()
+}
+
+// The lower case header name of a resource path
+func auditHeaderName(resourcePath string) (string, error) {
+	name := strings.TrimPrefix(resourcePath, auditHeadersPath+"/")
+	if !strings.HasPrefix(resourcePath, auditHeadersPath+"/") || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("audited header %s must be at %s/<name>", resourcePath, auditHeadersPath)
+	}
+	return strings.ToLower(name), nil
+}
+
+// Read the audited headers from Vault
+func (ah *AuditHeaders) readLive() error {
+	var data map[string]interface{}
+	err := ah.timed(auditHeadersPath, "read", func() error {
+		return withRetries(ah.config.Retries, ah.log, func() error {
+			secret, err := ah.client.Read(auditHeadersPath)
+			if err == nil && secret != nil {
+				data = secret.Data
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("could not read the audited headers: %s", err)
+	}
+
+	ah.live = map[string]bool{}
+	headers, _ := data["headers"].(map[string]interface{})
+	for name, v := range headers {
+		settings, _ := v.(map[string]interface{})
+		hmac, _ := settings["hmac"].(bool)
+		ah.live[strings.ToLower(name)] = hmac
+	}
+	return nil
+}
+
+// Ensure the header is audited with the configured HMAC setting
+func (ah *AuditHeaders) ensureHeader(name string, header auditHeader) (err error) {
+	resource := auditHeadersPath + "/" + name
+	ah.configured[name] = true
+	if ah.ignoredResource(resource) || ah.appliedEarlier(resource) {
+		return nil
+	}
+	defer func() { ah.recordApplied(resource, err) }()
+
+	if hmac, ok := ah.live[name]; ok && hmac == header.HMAC {
+		ah.log.WithFields(log.Fields{"header": name}).Debug("Audited header already applied")
+		ah.unchanged(resource)
+		return nil
+	}
+
+	ah.log.WithFields(log.Fields{"header": name, "hmac": header.HMAC}).Info("Applying audited header")
+	err = ah.timed(resource, "write", func() error {
+		_, err := ah.client.Write(resource, map[string]interface{}{"hmac": header.HMAC})
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("could not write audited header %s: %s", name, err)
+	}
+	return ah.result(resource, "write", err)
+}
+
+// Remove the live headers which aren't declared
+func (ah *AuditHeaders) removeUndeclared() error {
+	var names []string
+	for name := range ah.live {
+		if !ah.configured[name] && !ah.ignoredResource(auditHeadersPath+"/"+name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resource := auditHeadersPath + "/" + name
+		ah.log.WithFields(log.Fields{"header": name}).Info("Removing audited header")
+		err := ah.timed(resource, "delete", func() error {
+			_, err := ah.client.Delete(resource)
+			return err
+		})
+		if err != nil {
+			err = fmt.Errorf("could not remove audited header %s: %s", name, err)
+		}
+		if err := ah.result(resource, "delete", err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func applyAuditHeaders(t *testing.T, client *vault.MockClient, docPath string) error {
+	ah, err := NewAuditHeadersHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create AuditHeaders handler: %s", err)
+	}
+	return ah.PutPoliciesFromDir(filepath.Join(docPath, filepath.FromSlash(auditHeadersPath)))
+}
+
+// A client with the given headers audited
+func auditHeadersClient(headers map[string]interface{}) *vault.MockClient {
+	return &vault.MockClient{
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			auditHeadersPath: {Data: map[string]interface{}{"headers": headers}},
+		},
+	}
+}
+
+func TestAuditHeaders_AddsHeader(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/config/auditing/request-headers/X-Request-Id.json": `{"hmac": true}`,
+	})
+
+	client := auditHeadersClient(map[string]interface{}{})
+	if err := applyAuditHeaders(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != auditHeadersPath+"/x-request-id" {
+		t.Fatalf("Expected 1 Write to %s/x-request-id, got %+v", auditHeadersPath, writes)
+	}
+	if data := writes[0].Args[1].(map[string]interface{}); data["hmac"] != true {
+		t.Errorf("Expected hmac to be true, got %+v", data)
+	}
+}
+
+func TestAuditHeaders_ChangesHeader(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/config/auditing/request-headers/x-request-id.json": `{"hmac": true}`,
+	})
+
+	client := auditHeadersClient(map[string]interface{}{
+		"x-request-id": map[string]interface{}{"hmac": false},
+	})
+	if err := applyAuditHeaders(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if expected := []string{auditHeadersPath + "/x-request-id"}; !reflect.DeepEqual(writtenPaths(client), expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, writtenPaths(client))
+	}
+	if deletes := client.CallsTo("Delete"); len(deletes) != 0 {
+		t.Errorf("Expected no Delete calls, got %+v", deletes)
+	}
+}
+
+func TestAuditHeaders_NoChange(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/config/auditing/request-headers/X-Request-Id.json": `{"hmac": true}`,
+	})
+
+	client := auditHeadersClient(map[string]interface{}{
+		"x-request-id": map[string]interface{}{"hmac": true},
+	})
+	if err := applyAuditHeaders(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if len(client.CallsTo("Write"))+len(client.CallsTo("Delete")) != 0 {
+		t.Errorf("Expected no changes, got %+v", client.CallLog)
+	}
+}
+
+func TestAuditHeaders_RemovesUndeclared(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/config/auditing/request-headers/x-request-id.json": `{"hmac": false}`,
+	})
+
+	client := auditHeadersClient(map[string]interface{}{
+		"x-request-id":    map[string]interface{}{"hmac": false},
+		"x-forwarded-for": map[string]interface{}{"hmac": true},
+	})
+	if err := applyAuditHeaders(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	var deleted []string
+	for _, c := range client.CallsTo("Delete") {
+		deleted = append(deleted, c.Args[0].(string))
+	}
+	if expected := []string{auditHeadersPath + "/x-forwarded-for"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes of %+v, got %+v", expected, deleted)
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
+
+// Without the directory nothing is declared, so the audited headers are left alone
+func TestAuditHeaders_NoDirectory(t *testing.T) {
+	client := auditHeadersClient(map[string]interface{}{
+		"x-forwarded-for": map[string]interface{}{"hmac": true},
+	})
+	if err := applyAuditHeaders(t, client, filepath.Join(os.TempDir(), "vaultsmith-missing")); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if len(client.CallLog) != 0 {
+		t.Errorf("Expected no calls to Vault, got %+v", client.CallLog)
+	}
+}
@@ -2,13 +2,19 @@ package path_handlers
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/vault"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type PathHandlerConfig struct {
@@ -16,6 +22,45 @@ type PathHandlerConfig struct {
 	Order             int    // order to process (lower int is earlier, except 0 is last)
 	TemplateFile      string
 	TemplateOverrides []string
+	MaxFileSize       int64 // maximum size of a single document in bytes; 0 means no limit
+	AllowRecreate     bool  // allow mounts to be disabled and enabled again when required
+	Summary           *Summary
+	ContinueOnError   bool             // carry on with other resources when a change fails
+	Ignore            *document.Ignore // files excluded by the .vaultsmithignore file
+	Retries           int              // times to retry reading the live configuration from Vault
+	// don't fail on fields in documents which are not part of the resource, e.g. annotations
+	AllowUnknownFields bool
+	// if set, only undeclared auth mounts of these types (e.g. "userpass") are disabled
+	DisableAuthTypes []string
+	Explain          bool // log every field which differs from Vault
+	// once interrupted, no further resource is started
+	Interrupt *Interrupt
+	// if set, every change is recorded so the run can be rolled back should one fail
+	Rollback *Rollback
+	// the documents are read from this rather than DocumentPath, which their paths are still
+	// given relative to; if nil, the directory at DocumentPath
+	FS fs.FS
+	// if set, only undeclared auth mounts with a path starting with one of these are disabled, so
+	// those owned by another configuration of the same Vault are left alone
+	OwnedAuthPaths []string
+	// add a unified diff of each resource which is changed to the summary
+	Diff bool
+	// read auth methods and mounts back after changing them, failing if Vault didn't keep the change
+	VerifyWrites bool
+	// what the handler applies, e.g. the directory "sys/auth", logged as the component field
+	Component string
+	// allow undeclared transit keys to be deleted, destroying everything encrypted with them
+	AllowDeleteTransitKeys bool
+	// tune mounts with their live config, changing only the fields their document sets
+	MergeTuning bool
+	// create an empty policy for each one a document references which doesn't exist yet
+	StubMissingPolicies bool
+	// if set, documents applied by an earlier, unfinished run are skipped, and those applied recorded
+	Checkpoint *Checkpoint
+	// reload the mounts of a plugin registered again, e.g. with a new sha256, so the change takes effect
+	ReloadPlugins bool
+	// resources matching these, as for IgnoredResource, are left alone, even if declared
+	IgnoreResources []string
 }
 
 // A PathHandler takes a path and applies the policies within
@@ -25,6 +70,12 @@ type PathHandler interface {
 	Name() string
 }
 
+// A ResourceHandler can apply a single resource read from a reader, rather than walking a
+// directory. Undeclared resources are not removed, as we only know about the one.
+type ResourceHandler interface {
+	PutResource(resourcePath string, r io.Reader) error
+}
+
 type ValueMap map[string][]string
 
 // Set of methods common to all PathHandlers
@@ -36,6 +87,15 @@ type BaseHandler struct {
 	// processed after any others with a positive integer
 	name string
 	log  *log.Entry
+	// the resource operations were last made on, which is finished even if interrupted
+	current     string
+	interrupted bool
+	// times to retry the Vault calls for a resource, from its retries annotation
+	retries map[string]int
+	// how to undo the change passed to result next, if it can be
+	undo func() error
+	// resources a change to failed, even if the run carried on
+	failed map[string]bool
 }
 
 func (h *BaseHandler) Name() string {
@@ -46,24 +106,449 @@ func (h *BaseHandler) Order() int {
 	return h.order
 }
 
+// Record the outcome of a change in the summary. When continuing on error, the error is dropped so
+// the handler goes on to the remaining resources; it is reported at the end of the run instead.
+func (h *BaseHandler) result(resource string, action string, err error) error {
+	if h.interrupted {
+		// nothing was done, so it isn't in the summary
+		return errInterrupted
+	}
+	h.config.Summary.Add(resource, action, err)
+	if err == nil {
+		h.config.Rollback.record(resource, action, h.undo)
+	} else {
+		if h.failed == nil {
+			h.failed = map[string]bool{}
+		}
+		h.failed[resource] = true
+	}
+	h.undo = nil
+	if err != nil && h.config.ContinueOnError {
+		h.log.WithFields(log.Fields{"resource": resource}).Errorf("Failed to %s: %s", action, err)
+		return nil
+	}
+	return err
+}
+
+// Set how to undo the change about to be passed to result, should the run be rolled back
+func (h *BaseHandler) onRollback(undo func() error) {
+	h.undo = undo
+}
+
+// Record that action, just made on resource, can't be undone, should the run be rolled back. This is
+// for a step of a change which destroys data, so that it is listed even if the rest of the change
+// then fails.
+func (h *BaseHandler) irreversible(resource string, action string) {
+	h.config.Rollback.record(resource, action, nil)
+}
+
+// With VerifyWrites, run check to confirm the change just made to resource was kept by Vault. If not,
+// the change is still recorded to be rolled back, as it was made.
+func (h *BaseHandler) verify(resource string, action string, check func() error) error {
+	if !h.config.VerifyWrites {
+		return nil
+	}
+	err := check()
+	if err != nil {
+		h.config.Rollback.record(resource, action, h.undo)
+		h.undo = nil
+	}
+	return err
+}
+
+// The logger of the handler called name, with the component field if config has one
+func handlerLogger(name string, config PathHandlerConfig) *log.Entry {
+	fields := log.Fields{"handler": name}
+	if config.Component != "" {
+		fields["component"] = config.Component
+	}
+	return log.WithFields(fields)
+}
+
+// Record that resource already matches its document
+func (h *BaseHandler) unchanged(resource string) {
+	h.config.Summary.Unchanged(resource)
+}
+
+// Run the operation on resource against Vault, recording how long it took. Once the run is
+// interrupted, operations are only made on the resource already being applied. Failures are retried
+// as many times as the resource's retries annotation says.
+func (h *BaseHandler) timed(resource string, operation string, fn func() error) error {
+	if resource != h.current {
+		if h.config.Interrupt.interrupted() {
+			h.interrupted = true
+			return errInterrupted
+		}
+		h.current = resource
+	}
+	if retries := h.retries[strings.Trim(resource, "/")]; retries > 0 {
+		return timed(h.config.Summary, h.log, resource, operation, func() error {
+			return withRetries(retries, h.log.WithFields(log.Fields{"resource": resource}), fn)
+		})
+	}
+	return timed(h.config.Summary, h.log, resource, operation, fn)
+}
+
+func timed(summary *Summary, logger *log.Entry, resource string, operation string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	d := time.Since(start)
+	summary.Time(resource, operation, d)
+	logger.WithFields(log.Fields{
+		"resource":  resource,
+		"operation": operation,
+		"duration":  d,
+	}).Debug("Vault operation finished")
+	return err
+}
+
+// The filesystem to read path from, the name of path within it, and the directory names in it are
+// relative to. Without an FS configured, the operating system's is used.
+func (h *BaseHandler) open(path string) (fsys fs.FS, name string, root string, err error) {
+	if h.config.FS == nil {
+		root, name = filepath.Dir(path), filepath.Base(path)
+		if path == "" {
+			// an invalid name, so as with filepath.Walk nothing is found rather than "."
+			name = ""
+		}
+		return os.DirFS(root), name, root, nil
+	}
+	relPath, err := filepath.Rel(h.config.DocumentPath, path)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("could not determine relative path of %s to %s: %s",
+			path, h.config.DocumentPath, err)
+	}
+	return h.config.FS, filepath.ToSlash(relPath), h.config.DocumentPath, nil
+}
+
+// Walk the directory at path as fs.WalkDir does, passing fn the file system paths, as returned by
+// filepath.Walk, so they can be related to the document path. A file with a depends_on annotation
+// is held back until the files of the resources it names have been passed to fn.
+func (h *BaseHandler) walk(path string, fn fs.WalkDirFunc) error {
+	fsys, name, root, err := h.open(path)
+	if err != nil {
+		return err
+	}
+	deps, err := h.dependencies(fsys, name, root)
+	if err != nil {
+		return err
+	}
+	order := &dependencyOrder{dependencies: deps, fn: fn, applied: map[string]bool{}}
+	err = fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		path := filepath.Join(root, filepath.FromSlash(p))
+		if err != nil || d == nil || d.IsDir() || len(deps.dependsOn) == 0 {
+			return fn(path, d, err)
+		}
+		return order.file(path, d)
+	})
+	if err != nil {
+		return err
+	}
+	return order.release(true)
+}
+
+// Whether there is anything at path
+func (h *BaseHandler) exists(path string) bool {
+	fsys, name, _, err := h.open(path)
+	if err != nil {
+		return false
+	}
+	_, err = fs.Stat(fsys, name)
+	return err == nil
+}
+
+// The entries of the directory at path
+func (h *BaseHandler) readDir(path string) ([]fs.DirEntry, error) {
+	fsys, name, _, err := h.open(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(fsys, name)
+}
+
+// Return true if path has been excluded by the ignore file
+func (h *BaseHandler) ignored(path string, f fs.DirEntry) bool {
+	if h.config.Ignore == nil {
+		return false
+	}
+	relPath, err := filepath.Rel(h.config.DocumentPath, path)
+	if err != nil {
+		return false
+	}
+	return h.config.Ignore.Match(relPath, f.IsDir())
+}
+
+// What an fs.WalkDirFunc should return for an ignored file, so ignored directories aren't entered
+func skipIgnored(f fs.DirEntry) error {
+	if f.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
+
 func (h *BaseHandler) readFile(path string) (string, error) {
-	file, err := os.Open(path)
+	file, err := h.openFile(path)
 	if err != nil {
-		err = fmt.Errorf("error opening file: %s", err)
 		return "", err
 	}
 	defer file.Close()
 
 	var buf bytes.Buffer
-	_, err = io.Copy(&buf, file)
+	_, err = io.Copy(&buf, h.limitReader(file))
+	if err != nil {
+		return "", fmt.Errorf("error reading from buffer: %s", err)
+	}
+	if h.exceedsLimit(int64(buf.Len())) {
+		return "", h.fileSizeError(path)
+	}
+
+	return buf.String(), nil
+}
+
+// Decode the json document at path into v, returning the annotations it carries
+func (h *BaseHandler) decodeFile(path string, v interface{}) (document.Annotations, error) {
+	file, err := h.openFile(path)
+	if err != nil {
+		return document.Annotations{}, err
+	}
+	defer file.Close()
+
+	return h.decodeReader(path, file, v)
+}
+
+// Decode json from r into v, enforcing the maximum file size. Annotations are removed from the
+// document before it is decoded, and returned. name is used in error messages.
+func (h *BaseHandler) decodeReader(name string, r io.Reader, v interface{}) (document.Annotations, error) {
+	content, err := h.readDocument(name, r)
+	if err != nil {
+		return document.Annotations{}, err
+	}
+	return h.decodeDocument(name, content, v)
+}
+
+// Read the single json value of the document from r, enforcing the maximum file size. A json
+// document is decoded as it is read rather than buffered first; YAML has to be read whole to be
+// converted.
+func (h *BaseHandler) readDocument(name string, r io.Reader) (json.RawMessage, error) {
+	lr := &countingReader{r: h.limitReader(r)}
+	var src io.Reader = lr
+	if document.IsYAML(name) {
+		content, err := ioutil.ReadAll(lr)
+		if h.exceedsLimit(lr.n) {
+			return nil, h.fileSizeError(name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", name, err)
+		}
+		content, err = document.ToJSON(name, content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		src = bytes.NewReader(content)
+	}
+
+	var content json.RawMessage
+	decoder := json.NewDecoder(src)
+	err := decoder.Decode(&content)
+	if err == nil {
+		// Decode stops at the end of the first value, so anything after it has to be checked for
+		if _, tokenErr := decoder.Token(); tokenErr != io.EOF {
+			err = errors.New("unexpected data after the document")
+		}
+	}
+	if h.exceedsLimit(lr.n) {
+		// the file grew after we checked its size, or lied about it
+		return nil, h.fileSizeError(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse json from file %s: %s", name, err)
+	}
+	return content, nil
+}
 
+// Decode the json document content into v, returning the annotations removed from it first
+func (h *BaseHandler) decodeDocument(name string, content json.RawMessage, v interface{}) (document.Annotations, error) {
+	a, content, err := document.SplitAnnotations(content)
 	if err != nil {
-		log.Fatal(fmt.Sprintf("error reading from buffer: %s", err))
+		return a, fmt.Errorf("%s: %s", name, err)
 	}
 
-	data := buf.String()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if !h.config.AllowUnknownFields {
+		// a misspelled key would otherwise be silently dropped
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		return a, fmt.Errorf("could not parse json from file %s: %s", name, err)
+	}
+	return a, nil
+}
 
-	return data, nil
+// As decodeFile, also returning the keys given in the document's "config" object
+func (h *BaseHandler) decodeMountFile(path string, v interface{}) (document.Annotations, map[string]bool, error) {
+	file, err := h.openFile(path)
+	if err != nil {
+		return document.Annotations{}, nil, err
+	}
+	defer file.Close()
+
+	return h.decodeMountReader(path, file, v)
+}
+
+// As decodeReader, also returning the keys given in the document's "config" object, so that the
+// fields it leaves out can be told apart from those set to their zero value
+func (h *BaseHandler) decodeMountReader(name string, r io.Reader, v interface{}) (document.Annotations, map[string]bool, error) {
+	content, err := h.readDocument(name, r)
+	if err != nil {
+		return document.Annotations{}, nil, err
+	}
+	a, err := h.decodeDocument(name, content, v)
+	if err != nil {
+		return a, nil, err
+	}
+	var doc struct {
+		Config map[string]json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return a, nil, fmt.Errorf("could not parse json from file %s: %s", name, err)
+	}
+	keys := make(map[string]bool, len(doc.Config))
+	for k := range doc.Config {
+		// keys are matched to fields regardless of case
+		keys[strings.ToLower(k)] = true
+	}
+	return a, keys, nil
+}
+
+// Whether the document for resource should be applied, according to its enabled_when annotation.
+// The value the condition refers to is read from Vault, so this is accurate in dry runs too.
+func (h *BaseHandler) enabled(resource string, a document.Annotations) (bool, error) {
+	// every handler checks this before applying a document, so it's where its retries are taken
+	if a.Retries > 0 {
+		if h.retries == nil {
+			h.retries = map[string]int{}
+		}
+		h.retries[strings.Trim(resource, "/")] = a.Retries
+	}
+	if a.EnabledWhen == "" {
+		return true, nil
+	}
+	c, err := document.ParseCondition(a.EnabledWhen)
+	if err != nil {
+		return false, err
+	}
+	secret, err := h.client.Read(c.Path)
+	if err != nil {
+		return false, fmt.Errorf("could not read %s for the condition on %s: %s", c.Path, resource, err)
+	}
+	var data map[string]interface{}
+	if secret != nil {
+		data = secret.Data
+	}
+	if c.Holds(data) {
+		return true, nil
+	}
+
+	h.log.WithFields(log.Fields{
+		"resource":  resource,
+		"condition": c.String(),
+	}).Info("Condition not met, not applying")
+	h.config.Summary.Skip(resource, "apply", fmt.Sprintf("enabled_when %s is not met", c))
+	return false, nil
+}
+
+// Open the file, refusing to do so if it is larger than the configured maximum
+func (h *BaseHandler) openFile(path string) (fs.File, error) {
+	fsys, name, _, err := h.open(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading file info: %s", err)
+	}
+	if h.exceedsLimit(info.Size()) {
+		file.Close()
+		return nil, h.fileSizeError(path)
+	}
+	return file, nil
+}
+
+// Allow reading one byte past the limit, so we can tell the difference between a file that is
+// exactly at the limit and one that is over it
+func (h *BaseHandler) limitReader(r io.Reader) io.Reader {
+	if h.config.MaxFileSize <= 0 {
+		return r
+	}
+	return io.LimitReader(r, h.config.MaxFileSize+1)
+}
+
+func (h *BaseHandler) exceedsLimit(size int64) bool {
+	return h.config.MaxFileSize > 0 && size > h.config.MaxFileSize
+}
+
+func (h *BaseHandler) fileSizeError(path string) error {
+	return fmt.Errorf("file %s exceeds the maximum file size of %d bytes", path, h.config.MaxFileSize)
+}
+
+// io.Reader which keeps track of how many bytes have been read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Collapse duplicate slashes and trim leading and trailing ones, so paths compare equal however
+// the user wrote them. E.G. "/foo//bar/" becomes "foo/bar"
+func normalizePath(p string) string {
+	parts := strings.FieldsFunc(p, func(r rune) bool { return r == '/' })
+	return strings.Join(parts, "/")
+}
+
+// Vault lists mount paths with a single trailing slash, so mounts are always keyed that way
+func mountPath(p string) string {
+	return normalizePath(p) + "/"
+}
+
+// Replace any template placeholders in the part of filePath under the document path, e.g.
+// sys/auth/{{ team }}-approle.json, with the values from the template file or overrides
+func (h *BaseHandler) renderPath(filePath string) (string, error) {
+	if !strings.Contains(filePath, "{{") {
+		return filePath, nil
+	}
+	relPath, err := filepath.Rel(h.config.DocumentPath, filePath)
+	if err != nil {
+		return "", fmt.Errorf("could not determine relative path of %s to %s: %s",
+			filePath, h.config.DocumentPath, err)
+	}
+	tp, err := document.GenerateTemplateParams(h.config.TemplateFile, h.config.TemplateOverrides)
+	if err != nil {
+		return "", fmt.Errorf("could not generate template parameters: %s", err)
+	}
+	rendered, err := document.RenderPath(relPath, tp)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(h.config.DocumentPath, rendered), nil
+}
+
+// The vault api path for filePath, with any template placeholders in it replaced
+func (h *BaseHandler) resourcePath(filePath string) (string, error) {
+	rendered, err := h.renderPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	return apiPath(h.config.DocumentPath, rendered)
 }
 
 // Return the vault api path for this rendered template, given the filesystem path
@@ -1,21 +1,40 @@
 package path_handlers
 
 import (
+	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
+// Create an empty file in a new temp directory, returning it and a func removing the directory
+func tempFile(t *testing.T) (*os.File, func()) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	file, err := os.Create(filepath.Join(dir, "document"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	file.Close()
+	return file, func() { os.RemoveAll(dir) }
+}
+
 func TestReadFile(t *testing.T) {
 	var expectStr = "foo"
 	ph := &BaseHandler{}
-	file, _ := ioutil.TempFile(".", "test-PathHandler-")
+	file, remove := tempFile(t)
+	defer remove()
 	err := ioutil.WriteFile(file.Name(), []byte(expectStr), os.FileMode(int(0664)))
 	if err != nil {
 		t.Errorf("Could not create file %s: %s", file.Name(), err)
 	}
-	defer os.Remove(file.Name())
 
 	data, err := ph.readFile(file.Name())
 	if err != nil {
@@ -25,3 +44,208 @@ func TestReadFile(t *testing.T) {
 		t.Errorf("Got %s, expected %s", data, expectStr)
 	}
 }
+
+func TestReadFile_ExceedsMaxFileSize(t *testing.T) {
+	ph := &BaseHandler{config: PathHandlerConfig{MaxFileSize: 2}}
+	file, remove := tempFile(t)
+	defer remove()
+	err := ioutil.WriteFile(file.Name(), []byte("foo"), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	_, err = ph.readFile(file.Name())
+	if err == nil {
+		t.Fatal("Expected error reading file larger than MaxFileSize, got nil")
+	}
+	if !strings.Contains(err.Error(), "exceeds the maximum file size of 2 bytes") {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+}
+
+func TestReadFile_AtMaxFileSize(t *testing.T) {
+	ph := &BaseHandler{config: PathHandlerConfig{MaxFileSize: 3}}
+	file, remove := tempFile(t)
+	defer remove()
+	err := ioutil.WriteFile(file.Name(), []byte("foo"), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	data, err := ph.readFile(file.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if data != "foo" {
+		t.Errorf("Got %s, expected %s", data, "foo")
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	ph := &BaseHandler{config: PathHandlerConfig{MaxFileSize: 1024}}
+	file, remove := tempFile(t)
+	defer remove()
+	content := `{"type": "approle", "config": {"max_lease_ttl": "1h"}}`
+	err := ioutil.WriteFile(file.Name(), []byte(content), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	var opts vaultApi.EnableAuthOptions
+	_, err = ph.decodeFile(file.Name(), &opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if opts.Type != "approle" || opts.Config.MaxLeaseTTL != "1h" {
+		t.Errorf("Unexpected decode result %+v", opts)
+	}
+}
+
+func TestDecodeFile_ExceedsMaxFileSize(t *testing.T) {
+	ph := &BaseHandler{config: PathHandlerConfig{MaxFileSize: 10}}
+	file, remove := tempFile(t)
+	defer remove()
+	content := `{"type": "approle"}`
+	err := ioutil.WriteFile(file.Name(), []byte(content), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	var opts vaultApi.EnableAuthOptions
+	_, err = ph.decodeFile(file.Name(), &opts)
+	if err == nil {
+		t.Fatal("Expected error decoding file larger than MaxFileSize, got nil")
+	}
+	if !strings.Contains(err.Error(), "exceeds the maximum file size") {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+}
+
+func TestDecodeFile_InvalidJson(t *testing.T) {
+	ph := &BaseHandler{}
+	file, remove := tempFile(t)
+	defer remove()
+	err := ioutil.WriteFile(file.Name(), []byte(`{"type": `), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	var opts vaultApi.EnableAuthOptions
+	_, err = ph.decodeFile(file.Name(), &opts)
+	if err == nil || !strings.Contains(err.Error(), "could not parse json from file") {
+		t.Errorf("Expected json parse error, got %v", err)
+	}
+}
+
+func TestDecodeFile_UnknownField(t *testing.T) {
+	ph := &BaseHandler{}
+	file, remove := tempFile(t)
+	defer remove()
+	content := `{"type": "approle", "config": {"default_lase_ttl": "1h"}}`
+	err := ioutil.WriteFile(file.Name(), []byte(content), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	var opts vaultApi.EnableAuthOptions
+	_, err = ph.decodeFile(file.Name(), &opts)
+	if err == nil {
+		t.Fatal("Expected error decoding a file with an unknown field, got nil")
+	}
+	for _, s := range []string{file.Name(), `unknown field "default_lase_ttl"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("Expected error to contain %s, got: %s", s, err)
+		}
+	}
+
+	// unless unknown fields are allowed
+	ph.config.AllowUnknownFields = true
+	_, err = ph.decodeFile(file.Name(), &opts)
+	if err != nil {
+		t.Errorf("Expected no error when allowing unknown fields, got %s", err)
+	}
+}
+
+func TestDecodeFile_TrailingData(t *testing.T) {
+	ph := &BaseHandler{}
+	file, remove := tempFile(t)
+	defer remove()
+	err := ioutil.WriteFile(file.Name(), []byte(`{"type": "approle"} {"type": "userpass"}`), os.FileMode(int(0664)))
+	if err != nil {
+		t.Errorf("Could not create file %s: %s", file.Name(), err)
+	}
+
+	var opts vaultApi.EnableAuthOptions
+	_, err = ph.decodeFile(file.Name(), &opts)
+	if err == nil || !strings.Contains(err.Error(), "unexpected data after the document") {
+		t.Errorf("Expected an error for the data after the document, got %v", err)
+	}
+}
+
+func TestDecodeMountReader_Yaml(t *testing.T) {
+	ph := &BaseHandler{}
+	content := "# comments are allowed\ntype: approle\nconfig:\n  max_lease_ttl: 1h\n"
+
+	var opts vaultApi.EnableAuthOptions
+	_, keys, err := ph.decodeMountReader("sys/auth/approle.yaml", strings.NewReader(content), &opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if opts.Type != "approle" || opts.Config.MaxLeaseTTL != "1h" {
+		t.Errorf("Unexpected decode result %+v", opts)
+	}
+	if !keys["max_lease_ttl"] {
+		t.Errorf("Expected max_lease_ttl in the config keys, got %+v", keys)
+	}
+
+	// a document with the json extension is still read as json
+	_, _, err = ph.decodeMountReader("sys/auth/approle.json", strings.NewReader(content), &opts)
+	if err == nil || !strings.Contains(err.Error(), "could not parse json from file") {
+		t.Errorf("Expected json parse error, got %v", err)
+	}
+}
+
+// A mount document is read once, so its annotations and config keys come from the same value
+func TestDecodeMountReader_Annotations(t *testing.T) {
+	ph := &BaseHandler{}
+	content := `{"_vaultsmith": {"retries": 2}, "type": "approle", "config": {"max_lease_ttl": "1h"}}`
+
+	var opts vaultApi.EnableAuthOptions
+	a, keys, err := ph.decodeMountReader("sys/auth/approle.json", iotest.OneByteReader(strings.NewReader(content)), &opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if a.Retries != 2 || opts.Type != "approle" || !keys["max_lease_ttl"] {
+		t.Errorf("Unexpected decode result %+v %+v %+v", a, opts, keys)
+	}
+
+	_, _, err = ph.decodeMountReader("sys/auth/approle.json", strings.NewReader(content+" {}"), &opts)
+	if err == nil || !strings.Contains(err.Error(), "unexpected data after the document") {
+		t.Errorf("Expected an error for the data after the document, got %v", err)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := map[string]string{
+		"foo":            "foo",
+		"foo/":           "foo",
+		"foo//":          "foo",
+		"/foo":           "foo",
+		"sys/auth//foo/": "sys/auth/foo",
+		"a///b//c":       "a/b/c",
+		"":               "",
+	}
+	for in, expected := range tests {
+		if r := normalizePath(in); r != expected {
+			t.Errorf("normalizePath(%q): expected %q, got %q", in, expected, r)
+		}
+	}
+}
+
+func TestMountPath(t *testing.T) {
+	for _, in := range []string{"foo", "foo/", "foo//", "/foo/"} {
+		if r := mountPath(in); r != "foo/" {
+			t.Errorf("mountPath(%q): expected %q, got %q", in, "foo/", r)
+		}
+	}
+}
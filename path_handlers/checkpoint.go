@@ -0,0 +1,141 @@
+package path_handlers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/document"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Checkpoint is shared by the handlers of a run. Each document they apply, or find already applied,
+// is appended to a file as they go, so that if the run is interrupted or fails, the next one skips
+// those documents rather than reading and comparing each of them again. It only holds for the same
+// documents and template parameters; if they change, it is started afresh.
+type Checkpoint struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	applied map[string]bool
+}
+
+// Open the checkpoint file at path for the documents in fsys rendered with templateParams, resuming
+// from the resources it records if it was written for the same ones
+func OpenCheckpoint(path string, fsys fs.FS, templateParams []string) (*Checkpoint, error) {
+	documents, err := hashFS(fsys, templateParams)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the documents for checkpoint %s: %s", path, err)
+	}
+	header := "documents " + documents
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read checkpoint %s: %s", path, err)
+	}
+
+	c := &Checkpoint{path: path, applied: map[string]bool{}}
+	logger := log.WithFields(log.Fields{"checkpoint": path})
+	lines := strings.Split(string(content), "\n")
+	if len(lines) > 1 && lines[0] == header {
+		// the last line is cut short if the run was killed while writing it, and could be mistaken
+		// for another resource, so is dropped when the file is written again below
+		lines = lines[1 : len(lines)-1]
+		for _, line := range lines {
+			c.applied[line] = true
+		}
+		logger.WithFields(log.Fields{"applied": len(c.applied)}).Info("Resuming from checkpoint")
+	} else {
+		if len(content) > 0 {
+			logger.Info("Documents have changed since the checkpoint was written, starting afresh")
+		}
+		lines = nil
+	}
+	c.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		_, err = fmt.Fprintln(c.file, strings.Join(append([]string{header}, lines...), "\n"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open checkpoint %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// true if resource was applied by an earlier run
+func (c *Checkpoint) done(resource string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.applied[resource]
+}
+
+// Record that resource is applied. Failing to is only logged, as it just means the next run reads
+// the resource again.
+func (c *Checkpoint) record(resource string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.applied[resource] {
+		return
+	}
+	c.applied[resource] = true
+	if _, err := fmt.Fprintln(c.file, resource); err != nil {
+		log.WithFields(log.Fields{"checkpoint": c.path}).Warnf("Could not record %s: %s", resource, err)
+	}
+}
+
+// Whether an earlier run applied resource, per the checkpoint, in which case it is skipped. The
+// handler should still count it as declared, so it isn't pruned.
+func (h *BaseHandler) appliedEarlier(resource string) bool {
+	if !h.config.Checkpoint.done(resource) {
+		return false
+	}
+	h.log.WithFields(log.Fields{"path": resource}).Debugf("Applied by an earlier run, skipping")
+	h.config.Summary.Skip(resource, "apply", "applied by an earlier run, per the checkpoint")
+	return true
+}
+
+// Record resource in the checkpoint if ensuring it returned err nil, and no change to it failed
+func (h *BaseHandler) recordApplied(resource string, err error) {
+	if err == nil && !h.interrupted && !h.failed[resource] {
+		h.config.Checkpoint.record(resource)
+	}
+}
+
+// Close the checkpoint file. If the run was complete, it is removed, so the next run checks every
+// resource again.
+func (c *Checkpoint) Close(complete bool) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.file.Close(); err != nil {
+		return fmt.Errorf("could not write checkpoint %s: %s", c.path, err)
+	}
+	if complete {
+		if err := os.Remove(c.path); err != nil {
+			return fmt.Errorf("could not remove checkpoint %s: %s", c.path, err)
+		}
+	}
+	return nil
+}
+
+// Hash the documents in fsys, and the template parameters they are rendered with
+func hashFS(fsys fs.FS, templateParams []string) (string, error) {
+	documents, err := document.HashFS(fsys)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, p := range templateParams {
+		fmt.Fprintf(h, "param %s\n", p)
+	}
+	return fmt.Sprintf("%s %x", documents, h.Sum(nil)), nil
+}
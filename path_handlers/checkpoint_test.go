@@ -0,0 +1,72 @@
+package path_handlers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOpenCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+	fsys := fstest.MapFS{"secret/a.json": {Data: []byte(`{"value": "a"}`)}}
+
+	c, err := OpenCheckpoint(path, fsys, nil)
+	if err != nil {
+		t.Fatalf("Error opening checkpoint: %s", err)
+	}
+	c.record("secret/a")
+	c.record("secret/b")
+	if err := c.Close(false); err != nil {
+		t.Fatalf("Error closing checkpoint: %s", err)
+	}
+	// as if the run was killed while recording another
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("secret/c")
+	f.Close()
+
+	c, err = OpenCheckpoint(path, fsys, nil)
+	if err != nil {
+		t.Fatalf("Error opening checkpoint: %s", err)
+	}
+	for resource, expected := range map[string]bool{"secret/a": true, "secret/b": true, "secret/c": false} {
+		if c.done(resource) != expected {
+			t.Errorf("Expected %s to be done %t after resuming", resource, expected)
+		}
+	}
+	c.record("secret/d")
+	c.Close(false)
+
+	c, err = OpenCheckpoint(path, fsys, nil)
+	if err != nil {
+		t.Fatalf("Error opening checkpoint: %s", err)
+	}
+	if !c.done("secret/d") || c.done("secret/c") {
+		t.Errorf("Expected the cut short line to be dropped, got %+v", c.applied)
+	}
+	c.Close(false)
+
+	// anything else rendered differently means starting afresh
+	c, err = OpenCheckpoint(path, fsys, []string{"env=prod"})
+	if err != nil {
+		t.Fatalf("Error opening checkpoint: %s", err)
+	}
+	if c.done("secret/a") {
+		t.Errorf("Expected a checkpoint for other template parameters to be discarded")
+	}
+	if err := c.Close(true); err != nil {
+		t.Fatalf("Error closing checkpoint: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected a complete checkpoint to be removed, got %v", err)
+	}
+}
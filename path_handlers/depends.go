@@ -0,0 +1,191 @@
+package path_handlers
+
+import (
+	"fmt"
+	"github.com/starlingbank/vaultsmith/document"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// The depends_on annotations of the documents under a walked directory
+type dependencies struct {
+	resources map[string]string   // file path -> resource path
+	dependsOn map[string][]string // file path -> the resource paths it depends on
+}
+
+// Read the depends_on annotations of the files under name in fsys, checking that each names a
+// resource declared alongside it and that none depend on themselves, directly or not. Files which
+// can't be read or parsed are left for the handler to report.
+func (h *BaseHandler) dependencies(fsys fs.FS, name string, root string) (dependencies, error) {
+	deps := dependencies{resources: map[string]string{}, dependsOn: map[string][]string{}}
+	err := fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d == nil {
+			return nil
+		}
+		path := filepath.Join(root, filepath.FromSlash(p))
+		if h.ignored(path, d) {
+			return skipIgnored(d)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		resource, err := h.resourcePath(path)
+		if err != nil {
+			return nil
+		}
+		deps.resources[path] = filepath.ToSlash(resource)
+		if info, err := d.Info(); err != nil || h.exceedsLimit(info.Size()) {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil
+		}
+		a, _, err := document.SplitAnnotations(content)
+		if err != nil {
+			return nil
+		}
+		for _, r := range a.DependsOn {
+			deps.dependsOn[path] = append(deps.dependsOn[path], strings.Trim(r, "/"))
+		}
+		return nil
+	})
+	if err != nil || len(deps.dependsOn) == 0 {
+		return deps, err
+	}
+
+	declared := map[string]bool{}
+	graph := map[string][]string{}
+	for path, resource := range deps.resources {
+		declared[resource] = true
+		graph[resource] = append(graph[resource], deps.dependsOn[path]...)
+	}
+	for path, resources := range deps.dependsOn {
+		for _, r := range resources {
+			if !declared[r] {
+				return deps, fmt.Errorf("%s depends on %s, which isn't declared alongside it; a "+
+					"resource applied by another handler is ordered by the handler order instead", path, r)
+			}
+		}
+	}
+	return deps, checkCycles(graph)
+}
+
+// Return an error naming a cycle in graph, resource -> the resources it depends on, if there is one
+func checkCycles(graph map[string][]string) error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(r string) error
+	visit = func(r string) error {
+		switch state[r] {
+		case done:
+			return nil
+		case visiting:
+			for i, s := range stack {
+				if s == r {
+					return fmt.Errorf("dependency cycle in depends_on: %s -> %s",
+						strings.Join(stack[i:], " -> "), r)
+				}
+			}
+		}
+		state[r] = visiting
+		stack = append(stack, r)
+		for _, d := range graph[r] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[r] = done
+		return nil
+	}
+	for _, r := range sortedKeys(graph) {
+		if err := visit(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return sortedStrings(keys)
+}
+
+// Passes the files of a walk on once the resources they depend on have been, holding back the rest
+type dependencyOrder struct {
+	dependencies
+	fn      fs.WalkDirFunc
+	applied map[string]bool // resource paths
+	waiting []waitingFile   // in the order they were walked
+}
+
+type waitingFile struct {
+	path  string
+	entry fs.DirEntry
+}
+
+func (o *dependencyOrder) ready(path string) bool {
+	for _, r := range o.dependsOn[path] {
+		if !o.applied[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// Pass the file to fn if it is ready, and then any waiting on it, or otherwise hold it back
+func (o *dependencyOrder) file(path string, d fs.DirEntry) error {
+	if !o.ready(path) {
+		o.waiting = append(o.waiting, waitingFile{path: path, entry: d})
+		return nil
+	}
+	if err := o.apply(path, d); err != nil {
+		return err
+	}
+	return o.release(false)
+}
+
+func (o *dependencyOrder) apply(path string, d fs.DirEntry) error {
+	err := o.fn(path, d, nil)
+	o.applied[o.resources[path]] = true
+	if err == fs.SkipDir {
+		// the rest of its directory may be waiting, so carry on
+		return nil
+	}
+	return err
+}
+
+// Pass fn each waiting file which is now ready. Once the walk is over, those still waiting depend
+// on resources it skipped, so all are passed.
+func (o *dependencyOrder) release(final bool) error {
+	for len(o.waiting) > 0 {
+		next := -1
+		for i, w := range o.waiting {
+			if o.ready(w.path) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			if !final {
+				return nil
+			}
+			next = 0
+		}
+		w := o.waiting[next]
+		o.waiting = append(o.waiting[:next], o.waiting[next+1:]...)
+		if err := o.apply(w.path, w.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
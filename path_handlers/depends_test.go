@@ -0,0 +1,85 @@
+package path_handlers
+
+import (
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Apply the documents, path -> content, under secret with the generic handler, returning the paths
+// written in order
+func applyWithDependencies(t *testing.T, docs map[string]string) ([]string, error) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for p, content := range docs {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &vault.MockClient{}
+	gh, err := NewGeneric(client, PathHandlerConfig{DocumentPath: dir})
+	if err != nil {
+		t.Fatalf("Failed to create generic handler: %s", err)
+	}
+	err = gh.PutPoliciesFromDir(filepath.Join(dir, "secret"))
+	var written []string
+	for _, c := range client.CallsTo("Write") {
+		written = append(written, c.Args[0].(string))
+	}
+	return written, err
+}
+
+// A document is applied after those it depends on, even in a later directory; the others keep the
+// order of the walk
+func TestWalk_DependsOn(t *testing.T) {
+	written, err := applyWithDependencies(t, map[string]string{
+		"secret/a/app.json":    `{"key": "app", "_vaultsmith": {"depends_on": ["secret/z/base"]}}`,
+		"secret/a/other.json":  `{"key": "other"}`,
+		"secret/z/base.json":   `{"key": "base", "_vaultsmith": {"depends_on": ["/secret/z/shared/"]}}`,
+		"secret/z/shared.json": `{"key": "shared"}`,
+	})
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	expected := []string{"secret/a/other", "secret/z/shared", "secret/z/base", "secret/a/app"}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected the writes %+v, got %+v", expected, written)
+	}
+}
+
+func TestWalk_DependsOnCycle(t *testing.T) {
+	written, err := applyWithDependencies(t, map[string]string{
+		"secret/a.json": `{"key": "a", "_vaultsmith": {"depends_on": ["secret/b"]}}`,
+		"secret/b.json": `{"key": "b", "_vaultsmith": {"depends_on": ["secret/c"]}}`,
+		"secret/c.json": `{"key": "c", "_vaultsmith": {"depends_on": ["secret/a"]}}`,
+	})
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle in depends_on: secret/a -> secret/b -> secret/c -> secret/a") {
+		t.Errorf("Expected an error naming the cycle, got %v", err)
+	}
+	if len(written) != 0 {
+		t.Errorf("Expected nothing to be written, got %+v", written)
+	}
+}
+
+// Resources of other handlers are ordered by the handler order, so can't be depended on
+func TestWalk_DependsOnUndeclared(t *testing.T) {
+	written, err := applyWithDependencies(t, map[string]string{
+		"secret/a.json": `{"key": "a", "_vaultsmith": {"depends_on": ["sys/policy/reader"]}}`,
+	})
+	if err == nil || !strings.Contains(err.Error(), "depends on sys/policy/reader, which isn't declared alongside it") {
+		t.Errorf("Expected an error naming the missing dependency, got %v", err)
+	}
+	if len(written) != 0 {
+		t.Errorf("Expected nothing to be written, got %+v", written)
+	}
+}
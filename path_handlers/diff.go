@@ -0,0 +1,138 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Lines of context around each change in a unified diff, as diff -u
+const diffContext = 3
+
+// The difference between the live and configured values of resource as a unified diff, as
+// "diff -u" would show it, or "" if there is none. Both are written as indented json with sorted
+// keys, so only real changes show up; a string, such as the text of a policy, is diffed as it is.
+func unifiedDiff(resource string, configured interface{}, live interface{}) string {
+	a, b := diffLines(live), diffLines(configured)
+	ops := editScript(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// find the next change, and the hunk of context around it and any close enough to join it
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops) && i <= last+2*diffContext; i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			}
+		}
+		from, to := first-diffContext, last+diffContext+1
+		if from < start {
+			from = start
+		}
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- live/%s\n+++ configured/%s\n", resource, resource)
+		}
+		aStart, bStart := ops[from].a, ops[from].b
+		var aCount, bCount int
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// v as the lines which are compared; nothing at all if it is nil, e.g. when not in Vault
+func diffLines(v interface{}) []string {
+	if v == nil {
+		return nil
+	}
+	text, ok := v.(string)
+	if !ok {
+		b, err := json.MarshalIndent(toJsonValue(v), "", "  ")
+		if err != nil {
+			text = fmt.Sprintf("%v", v)
+		} else {
+			text = string(b)
+		}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// The start and length of a hunk as diff -u writes them; lines are numbered from 1, and an empty
+// hunk starts at the line before it
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// One line of an edit script: kept (' '), removed from a ('-') or added from b ('+'). a and b
+// are the number of lines of each before it.
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// The shortest edit script turning a into b, from their longest common subsequence
+func editScript(a []string, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i], a: i, b: j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: a[i], a: i, b: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j], a: i, b: j})
+			j++
+		}
+	}
+	return ops
+}
@@ -0,0 +1,121 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/vault"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	live := map[string]interface{}{
+		"config":      map[string]interface{}{"default_lease_ttl": 3600, "max_lease_ttl": 86400},
+		"description": "old",
+	}
+	configured := map[string]interface{}{
+		"config":      map[string]interface{}{"default_lease_ttl": 3600, "max_lease_ttl": 7200},
+		"description": "old",
+	}
+	expected := `--- live/sys/auth/approle/
++++ configured/sys/auth/approle/
+@@ -1,7 +1,7 @@
+ {
+   "config": {
+     "default_lease_ttl": 3600,
+-    "max_lease_ttl": 86400
++    "max_lease_ttl": 7200
+   },
+   "description": "old"
+ }
+`
+	if d := unifiedDiff("sys/auth/approle/", configured, live); d != expected {
+		t.Errorf("Expected the diff\n%s\ngot\n%s", expected, d)
+	}
+	if d := unifiedDiff("sys/auth/approle/", live, live); d != "" {
+		t.Errorf("Expected no diff for equal values, got\n%s", d)
+	}
+}
+
+// Only the lines around each change are shown, in separate hunks when far apart
+func TestUnifiedDiff_Hunks(t *testing.T) {
+	live := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	configured := "A\nb\nc\nd\ne\nf\ng\nh\ni\nj\nK\nl\n"
+	expected := `--- live/sys/policy/foo
++++ configured/sys/policy/foo
+@@ -1,4 +1,4 @@
+-a
++A
+ b
+ c
+ d
+@@ -8,4 +8,5 @@
+ h
+ i
+ j
+-k
++K
++l
+`
+	if d := unifiedDiff("sys/policy/foo", configured, live); d != expected {
+		t.Errorf("Expected the diff\n%s\ngot\n%s", expected, d)
+	}
+
+	// a new resource is all additions
+	d := unifiedDiff("sys/policy/foo", "a\nb\n", nil)
+	if expected := "--- live/sys/policy/foo\n+++ configured/sys/policy/foo\n@@ -0,0 +1,2 @@\n+a\n+b\n"; d != expected {
+		t.Errorf("Expected the diff\n%s\ngot\n%s", expected, d)
+	}
+}
+
+// With Diff, a drifted generic document is added to the summary, showing only what changes
+func TestGeneric_Diff(t *testing.T) {
+	client := &vault.MockClient{ReturnSecrets: map[string]*vaultApi.Secret{
+		"auth/approle/role/deploy": {Data: map[string]interface{}{
+			"token_ttl": "3600", "policies": []interface{}{"old"}, "bind_secret_id": true,
+		}},
+	}}
+	summary := &Summary{Dry: true}
+	gh, err := NewGeneric(client, PathHandlerConfig{Diff: true, Summary: summary})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+	err = gh.PutResource("auth/approle/role/deploy", strings.NewReader(`{"token_ttl": "1h", "policies": ["deploy"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(summary.Diffs) != 1 || summary.Diffs[0].Resource != "auth/approle/role/deploy" {
+		t.Fatalf("Expected a diff of the role, got %+v", summary.Diffs)
+	}
+	d := summary.Diffs[0].Diff
+	for _, line := range []string{`-    "old"`, `+    "deploy"`, `   "token_ttl": "1h"`} {
+		if !strings.Contains(d, line+"\n") {
+			t.Errorf("Expected the line %q in the diff, got\n%s", line, d)
+		}
+	}
+	if strings.Contains(d, "bind_secret_id") {
+		t.Errorf("Expected keys which aren't configured to be left out, got\n%s", d)
+	}
+}
+
+// Secret fields are shown in the diff, but not their values
+func TestGeneric_DiffRedacted(t *testing.T) {
+	client := &vault.MockClient{ReturnSecrets: map[string]*vaultApi.Secret{
+		"auth/userpass/users/deploy": {Data: map[string]interface{}{"policies": []interface{}{"old"}}},
+	}}
+	summary := &Summary{Dry: true}
+	gh, err := NewGeneric(client, PathHandlerConfig{Diff: true, Summary: summary})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+	err = gh.PutResource("auth/userpass/users/deploy", strings.NewReader(`{"password": "hunter2", "policies": ["deploy"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(summary.Diffs) != 1 {
+		t.Fatalf("Expected a diff of the user, got %+v", summary.Diffs)
+	}
+	d := summary.Diffs[0].Diff
+	if strings.Contains(d, "hunter2") || !strings.Contains(d, `+  "password": "****",`+"\n") {
+		t.Errorf("Expected the password to be redacted, got\n%s", d)
+	}
+}
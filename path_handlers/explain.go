@@ -0,0 +1,100 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A field whose configured value differs from the live one
+type FieldDiff struct {
+	Field      string
+	Configured interface{}
+	Live       interface{} // nil if not set in Vault
+}
+
+// Compare two values as they are encoded in json, e.g. a converted auth config and the live one,
+// returning the fields which differ sorted by name. Nested objects are compared field by field,
+// named like "config.max_lease_ttl".
+func diffFields(configured interface{}, live interface{}) []FieldDiff {
+	return diffValues("", toJsonValue(configured), toJsonValue(live))
+}
+
+func diffValues(field string, configured interface{}, live interface{}) (diffs []FieldDiff) {
+	configuredMap, ok := configured.(map[string]interface{})
+	liveMap, liveOk := live.(map[string]interface{})
+	if !ok || !liveOk {
+		if !reflect.DeepEqual(configured, live) {
+			diffs = append(diffs, FieldDiff{Field: field, Configured: configured, Live: live})
+		}
+		return diffs
+	}
+
+	keys := make([]string, 0, len(configuredMap))
+	for k := range configuredMap {
+		keys = append(keys, k)
+	}
+	for k := range liveMap {
+		if _, ok := configuredMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if field != "" {
+			name = field + "." + k
+		}
+		diffs = append(diffs, diffValues(name, configuredMap[k], liveMap[k])...)
+	}
+	return diffs
+}
+
+// v as decoded from its json encoding, so structs become maps keyed by their json names
+func toJsonValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// With Diff set, add the difference between the live and configured resource to the summary as a
+// unified diff; live is nil if the resource isn't in Vault yet. Secret fields are redacted.
+func (h *BaseHandler) diff(resource string, configured interface{}, live interface{}) {
+	if !h.config.Diff {
+		return
+	}
+	configured, live = vault.Redact(toJsonValue(configured)), vault.Redact(toJsonValue(live))
+	if d := unifiedDiff(resource, configured, live); d != "" {
+		h.config.Summary.AddDiff(resource, d)
+	}
+}
+
+// With Explain set, log each field which makes resource differ from what is configured, e.g. to
+// find out why a mount is tuned on every run
+func (h *BaseHandler) explain(resource string, diffs []FieldDiff) {
+	if !h.config.Explain {
+		return
+	}
+	for _, d := range diffs {
+		configured, live := vault.Redact(d.Configured), vault.Redact(d.Live)
+		// the last part of a nested field, e.g. "config.bindpass", is what names it
+		if vault.IsRedacted(d.Field[strings.LastIndex(d.Field, ".")+1:]) {
+			configured, live = vault.RedactedValue, vault.RedactedValue
+		}
+		h.log.WithFields(log.Fields{
+			"resource":   resource,
+			"field":      d.Field,
+			"configured": configured,
+			"live":       live,
+		}).Info("Field differs from Vault")
+	}
+}
@@ -0,0 +1,118 @@
+package path_handlers
+
+import (
+	"bytes"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	configured := map[string]interface{}{
+		"config":      vaultApi.AuthConfigOutput{DefaultLeaseTTL: 3600, MaxLeaseTTL: 7200},
+		"description": "same",
+	}
+	live := map[string]interface{}{
+		"config":      vaultApi.AuthConfigOutput{DefaultLeaseTTL: 3600, MaxLeaseTTL: 86400, ListingVisibility: "hidden"},
+		"description": "same",
+	}
+	expected := []FieldDiff{
+		{Field: "config.listing_visibility", Configured: nil, Live: "hidden"},
+		{Field: "config.max_lease_ttl", Configured: float64(7200), Live: float64(86400)},
+	}
+	if diffs := diffFields(configured, live); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diffs)
+	}
+}
+
+// With Explain, a tuned auth mount is logged with the exact field and both values
+func TestSysAuth_Explain(t *testing.T) {
+	client := &vault.MockClient{ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+		"approle/": {Type: "approle", Config: vaultApi.AuthConfigOutput{DefaultLeaseTTL: 3600, MaxLeaseTTL: 86400}},
+	}}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{Explain: true})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	log.SetOutput(&logged)
+	err = sh.PutResource("sys/auth/approle", strings.NewReader(
+		`{"type": "approle", "config": {"default_lease_ttl": "1h", "max_lease_ttl": "2h"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var explained []string
+	for _, line := range strings.Split(logged.String(), "\n") {
+		if strings.Contains(line, "Field differs from Vault") {
+			explained = append(explained, line)
+		}
+	}
+	if len(explained) != 1 {
+		t.Fatalf("Expected 1 field to be explained, got:\n%s", logged.String())
+	}
+	for _, expected := range []string{"field=config.max_lease_ttl", "configured=7200", "live=86400", "resource=sys/auth/approle/"} {
+		if !strings.Contains(explained[0], expected) {
+			t.Errorf("Expected %q in the explanation, got %s", expected, explained[0])
+		}
+	}
+}
+
+// Generic documents explain only the keys which differ
+func TestGeneric_Explain(t *testing.T) {
+	client := &vault.MockClient{ReturnSecrets: map[string]*vaultApi.Secret{
+		"auth/approle/role/deploy": {Data: map[string]interface{}{"token_ttl": "3600", "policies": []interface{}{"old"}}},
+	}}
+	gh, err := NewGeneric(client, PathHandlerConfig{Explain: true})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	log.SetOutput(&logged)
+	err = gh.PutResource("auth/approle/role/deploy", strings.NewReader(`{"token_ttl": "1h", "policies": ["deploy"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !strings.Contains(logged.String(), "field=policies") || !strings.Contains(logged.String(), "configured=\"[deploy]\"") {
+		t.Errorf("Expected policies to be explained, got:\n%s", logged.String())
+	}
+	if strings.Contains(logged.String(), "field=token_ttl") {
+		t.Errorf("Expected the equivalent token_ttl not to be explained, got:\n%s", logged.String())
+	}
+}
+
+func TestGeneric_ExplainRedacted(t *testing.T) {
+	client := &vault.MockClient{ReturnSecrets: map[string]*vaultApi.Secret{
+		"auth/ldap/config": {Data: map[string]interface{}{"url": "ldap://old", "bindpass": "old-secret"}},
+	}}
+	gh, err := NewGeneric(client, PathHandlerConfig{Explain: true})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	defer func(level log.Level) { log.SetLevel(level) }(log.GetLevel())
+	log.SetOutput(&logged)
+	log.SetLevel(log.DebugLevel)
+	err = gh.PutResource("auth/ldap/config", strings.NewReader(`{"url": "ldap://new", "bindpass": "hunter2"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !strings.Contains(logged.String(), "field=bindpass") || !strings.Contains(logged.String(), "field=url") {
+		t.Errorf("Expected both fields to be explained, got:\n%s", logged.String())
+	}
+	for _, secret := range []string{"hunter2", "old-secret"} {
+		if strings.Contains(logged.String(), secret) {
+			t.Errorf("Expected the bindpass to be redacted, got:\n%s", logged.String())
+		}
+	}
+}
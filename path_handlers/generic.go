@@ -1,13 +1,18 @@
 package path_handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/vault"
-	"os"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
 	"path/filepath"
 	"reflect"
 	"sort"
@@ -28,6 +33,24 @@ type Generic struct {
 	BaseHandler
 	configuredDocMap map[string]vaultDocument
 	removedDocMap    map[string]interface{}
+	// For handlers built on Generic; list keys where Vault may add its own values to ours
+	supersetKeys map[string]bool
+	// and keys which Vault never returns, such as passwords, so can't be compared
+	writeOnlyKeys map[string]bool
+	// every redacted key is write-only too
+	redactedWriteOnly bool
+	// keys holding durations, without "ttl" in their name
+	durationKeys map[string]bool
+	// keys holding objects, such as claims, whose values are compared in the same way as keys;
+	// unlike a document, the object may not have any keys we didn't declare
+	objectKeys map[string]bool
+	// if set, only undeclared documents in these subdirectories are removed
+	pruneDirs []string
+	// the policies in Vault, once listed for a document referencing some
+	livePolicies map[string]bool
+	// for handlers built on Generic; run on each document before it is compared and written, e.g.
+	// to replace the names it refers to with the IDs Vault knows them by
+	prepare func(doc *vaultDocument) error
 }
 
 func NewGeneric(client vault.Vault, config PathHandlerConfig) (*Generic, error) {
@@ -35,16 +58,15 @@ func NewGeneric(client vault.Vault, config PathHandlerConfig) (*Generic, error)
 		BaseHandler: BaseHandler{
 			client: client,
 			config: config,
-			log: log.WithFields(log.Fields{
-				"handler": "Generic",
-			}),
+			order:  config.Order,
+			log:    handlerLogger("Generic", config),
 		},
 		configuredDocMap: map[string]vaultDocument{},
 		removedDocMap:    map[string]interface{}{},
 	}, nil
 }
 
-func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
+func (gh *Generic) walkFile(path string, f fs.DirEntry, err error) error {
 	logger := gh.log.WithFields(log.Fields{
 		"path":  path,
 		"error": err,
@@ -57,6 +79,9 @@ func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return fmt.Errorf("error finding %s: %s", path, err)
 	}
+	if gh.ignored(path, f) {
+		return skipIgnored(f)
+	}
 	// not doing anything with dirs
 	if f.IsDir() {
 		return nil
@@ -67,12 +92,16 @@ func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
 		return fmt.Errorf("could not generate template parameters: %s", err)
 	}
 
-	content, err := document.Read(path)
+	content, err := gh.readFile(path)
 	if err != nil {
 		return fmt.Errorf("error reading %q: %s", path, err)
 	}
+	renderedPath, err := gh.renderPath(path)
+	if err != nil {
+		return err
+	}
 	td := &document.Template{
-		FileName: f.Name(),
+		FileName: filepath.Base(renderedPath),
 		Content:  content,
 		Params:   tp,
 	}
@@ -83,7 +112,7 @@ func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
 	}
 
 	// figure out where to write to
-	apiDir, err := apiDir(gh.config.DocumentPath, path)
+	apiDir, err := apiDir(gh.config.DocumentPath, renderedPath)
 	if err != nil {
 		return err
 	}
@@ -91,18 +120,28 @@ func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
 	for _, td := range templatedDocs {
 		// parse our document data as json
 		var data map[string]interface{}
-		err = json.Unmarshal([]byte(td.Content), &data)
+		annotations, err := gh.decodeReader(path, strings.NewReader(td.Content), &data)
 		if err != nil {
-			log.Debugf("Content:\n%s", data)
-			return fmt.Errorf("failed to parse json from file %q: %s", path, err)
+			return err
 		}
 
 		doc := vaultDocument{
-			path:       filepath.Join(apiDir, td.Name),
+			path:       normalizePath(filepath.Join(apiDir, td.Name)),
 			data:       data,
 			sourceFile: f.Name(),
 		}
-		err := gh.ensureDoc(doc)
+		enabled, err := gh.enabled(doc.path, annotations)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			if !annotations.PruneWhenDisabled {
+				// still declared, so left as it is rather than removed
+				gh.configuredDocMap[doc.path] = doc
+			}
+			continue
+		}
+		err = gh.ensureDoc(doc)
 		if err != nil {
 			return err
 		}
@@ -113,107 +152,421 @@ func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
 
 func (gh *Generic) PutPoliciesFromDir(path string) error {
 	// path must be a real file system path here, not the relative path to the document root
-	err := filepath.Walk(path, gh.walkFile)
+	err := gh.walk(path, gh.walkFile)
 	if err != nil {
 		return err
 	}
 
-	return gh.removeUndeclaredDocuments(path)
+	return gh.prune(path)
+}
+
+// Remove undeclared documents under path, or only under pruneDirs within it if set
+func (gh *Generic) prune(path string) error {
+	if gh.pruneDirs == nil {
+		return gh.removeUndeclaredDocuments(path)
+	}
+	for _, dir := range gh.pruneDirs {
+		pruneDir := filepath.Join(path, dir)
+		if !gh.exists(pruneDir) {
+			continue
+		}
+		err := gh.removeUndeclaredDocuments(pruneDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// As walkFile, first refusing each document with key set to a literal value rather than read from
+// the environment (for which example is given)
+func (gh *Generic) walkFileFromEnv(key string, example string) fs.WalkDirFunc {
+	return func(path string, f fs.DirEntry, err error) error {
+		if f != nil && err == nil && !f.IsDir() && !gh.ignored(path, f) {
+			if err := gh.checkFromEnv(path, key, example); err != nil {
+				return err
+			}
+		}
+		return gh.walkFile(path, f, err)
+	}
+}
+
+// Refuse the document at path if key is set to a literal value rather than read from the
+// environment, so secrets never end up in source control
+func (gh *Generic) checkFromEnv(path string, key string, example string) error {
+	data, err := gh.unrenderedData(path)
+	if err != nil || data == nil {
+		return err
+	}
+	return checkEnvValue(path, key, data, example)
+}
+
+// Check every redacted field in the document at path is an environment placeholder, as
+// checkFromEnv does for a single one
+func (gh *Generic) checkRedactedFromEnv(path string) error {
+	data, err := gh.unrenderedData(path)
+	if err != nil || data == nil {
+		return err
+	}
+	var keys []string
+	for key := range data {
+		if vault.IsRedacted(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := checkEnvValue(path, key, data, fmt.Sprintf("{{ env.%s }}", strings.ToUpper(key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The document at path as written, before its placeholders are replaced; nil if it is only
+// valid json (or yaml) once rendered, which walkFile reports
+func (gh *Generic) unrenderedData(path string) (map[string]interface{}, error) {
+	content, err := gh.readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %s", path, err)
+	}
+	j, err := document.ToJSON(path, []byte(content))
+	if err != nil {
+		return nil, nil
+	}
+	var data map[string]interface{}
+	if err := json.NewDecoder(bytes.NewReader(j)).Decode(&data); err != nil {
+		return nil, nil
+	}
+	return data, nil
+}
+
+func checkEnvValue(path string, key string, data map[string]interface{}, example string) error {
+	value, ok := data[key]
+	if !ok {
+		return nil
+	}
+	if s, ok := value.(string); !ok || !document.IsEnvPlaceholder(s) {
+		return fmt.Errorf("%s in %s must be read from the environment, e.g. %q", key, path, example)
+	}
+	return nil
+}
+
+// Apply a single document to resourcePath
+func (gh *Generic) PutResource(resourcePath string, r io.Reader) error {
+	var data map[string]interface{}
+	annotations, err := gh.decodeReader(resourcePath, r, &data)
+	if err != nil {
+		return err
+	}
+	if enabled, err := gh.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+
+	return gh.ensureDoc(vaultDocument{
+		path:       resourcePath,
+		data:       data,
+		sourceFile: resourcePath,
+	})
+}
+
+// Apply a single document read from r to resourcePath as walkFile would a file of it: refusing it
+// if key is set to a literal value rather than read from the environment (for which example is
+// given), then rendering its placeholders
+func (gh *Generic) putResourceFromEnv(resourcePath string, r io.Reader, key string, example string) error {
+	lr := &countingReader{r: gh.limitReader(r)}
+	content, err := ioutil.ReadAll(lr)
+	if gh.exceedsLimit(lr.n) {
+		return gh.fileSizeError(resourcePath)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", resourcePath, err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err == nil {
+		// if it isn't valid json until it is rendered, PutResource reports it
+		if err := checkEnvValue(resourcePath, key, data, example); err != nil {
+			return err
+		}
+	}
+
+	tp, err := document.GenerateTemplateParams(gh.config.TemplateFile, gh.config.TemplateOverrides)
+	if err != nil {
+		return fmt.Errorf("could not generate template parameters: %s", err)
+	}
+	td := &document.Template{
+		FileName: path.Base(resourcePath) + ".json",
+		Content:  string(content),
+		Params:   tp,
+	}
+	rendered, err := td.Render()
+	if err != nil {
+		return fmt.Errorf("failed to render document %q: %s", resourcePath, err)
+	}
+	for _, d := range rendered {
+		err := gh.PutResource(path.Join(path.Dir(resourcePath), d.Name), strings.NewReader(d.Content))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Ensure the document is present and consistent
-func (gh *Generic) ensureDoc(doc vaultDocument) error {
+func (gh *Generic) ensureDoc(doc vaultDocument) (err error) {
+	doc.path = normalizePath(doc.path)
+	if gh.ignoredResource(doc.path) || gh.appliedEarlier(doc.path) {
+		// not pruned either
+		gh.configuredDocMap[doc.path] = doc
+		return nil
+	}
+	defer func() { gh.recordApplied(doc.path, err) }()
+	if gh.prepare != nil {
+		if err := gh.prepare(&doc); err != nil {
+			return err
+		}
+	}
 	logger := gh.log.WithFields(log.Fields{
 		"path":       doc.path,
 		"sourceFile": doc.sourceFile,
 	})
 	gh.configuredDocMap[doc.path] = doc
 
-	if applied, err := gh.isDocApplied(doc); err != nil {
+	applied, restore, err := gh.isDocApplied(doc)
+	if err != nil {
 		if strings.Contains(err.Error(), "permission denied") {
 			// Continue with a warning on 403. The user might not have permission to read all
 			// documents, and in this case we want to continue updating others, without attempting
 			// to write this particular one.
 			logger.Warnf("Skipping path: %s", err.Error())
+			gh.config.Summary.Skip(doc.path, "write", err.Error())
 			return nil
 		}
 		return fmt.Errorf("could not determine if %q is applied: %s", doc.path, err)
 	} else if applied {
 		logger.Debugf("Document already applied")
+		gh.unchanged(doc.path)
 		return nil
 	}
 
+	if gh.config.StubMissingPolicies {
+		if err := gh.stubMissingPolicies(doc); err != nil {
+			return err
+		}
+	}
 	logger.Infof("Applying document")
-	_, err := gh.client.Write(doc.path, doc.data)
-	return err
+	gh.onRollback(restore)
+	err = gh.timed(doc.path, "write", func() error {
+		_, err := gh.client.Write(doc.path, doc.data)
+		return err
+	})
+	if err != nil && err != errInterrupted {
+		err = gh.missingPolicyError(doc, err)
+	}
+	return gh.result(doc.path, "write", err)
 }
 
-// true if the document is on the server and matches the one configured
-func (gh *Generic) isDocApplied(doc vaultDocument) (bool, error) {
-	secret, err := gh.client.Read(doc.path)
+// true if the document is on the server and matches the one configured, and how to put back the
+// live document should the run be rolled back (nil if it couldn't be read)
+func (gh *Generic) isDocApplied(doc vaultDocument) (bool, func() error, error) {
+	var secret *vaultApi.Secret
+	err := gh.timed(doc.path, "read", func() (err error) {
+		secret, err = gh.client.Read(doc.path)
+		return err
+	})
+	if err == errInterrupted {
+		return false, nil, err
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "Code: 403") {
 			gh.log.Debug(err.Error())
-			return false, errors.New("permission denied (code 403)")
+			return false, nil, errors.New("permission denied (code 403)")
 		}
 		gh.log.Errorf("error on client.Read: %s: %v, please raise a "+
 			"bug as this should be handled cleanly!", doc.path, err)
-		return false, nil
+		return false, nil, nil
 	}
+	restore := gh.restoreDoc(doc.path, secret)
 
 	if secret == nil || secret.Data == nil {
-		return false, nil
+		gh.diff(doc.path, gh.comparedData(doc.data), nil)
+		return false, restore, nil
 	}
 
-	return gh.areKeysApplied(doc.data, secret.Data), nil
+	differing := gh.differingKeys(doc.data, secret.Data)
+	var diffs []FieldDiff
+	for _, key := range differing {
+		diffs = append(diffs, FieldDiff{Field: key, Configured: doc.data[key], Live: secret.Data[key]})
+	}
+	gh.explain(doc.path, diffs)
+	if len(differing) > 0 {
+		configured := gh.comparedData(doc.data)
+		// only the configured keys, as the others are left alone, and those which are equivalent
+		// (e.g. "1h" and 3600) as they are configured
+		live := map[string]interface{}{}
+		for key, v := range configured {
+			live[key] = v
+		}
+		for _, key := range differing {
+			if v, ok := secret.Data[key]; ok {
+				live[key] = v
+			} else {
+				delete(live, key)
+			}
+		}
+		gh.diff(doc.path, configured, live)
+	}
+	return len(differing) == 0, restore, nil
+}
+
+// How to put back the document at path as read from Vault, should the run be rolled back: written
+// again with its live data, or deleted if there was none
+func (gh *Generic) restoreDoc(path string, live *vaultApi.Secret) func() error {
+	if live == nil || live.Data == nil {
+		return func() error {
+			_, err := gh.client.Delete(path)
+			return err
+		}
+	}
+	return func() error {
+		_, err := gh.client.Write(path, live.Data)
+		return err
+	}
+}
+
+// Read the document at path about to be deleted, so it can be put back if the run is rolled back
+func (gh *Generic) keepForRollback(path string) func() error {
+	if gh.config.Rollback == nil {
+		return nil
+	}
+	var live *vaultApi.Secret
+	err := gh.timed(path, "read", func() (err error) {
+		live, err = gh.client.Read(path)
+		return err
+	})
+	if err != nil {
+		gh.log.WithFields(log.Fields{"path": path}).Warnf("Could not read document to roll back to: %s", err)
+		return nil
+	}
+	if live == nil || live.Data == nil {
+		// gone already, so there is nothing to put back
+		return nil
+	}
+	return gh.restoreDoc(path, live)
+}
+
+// Whether Vault never returns key
+func (gh *Generic) isWriteOnly(key string) bool {
+	return gh.writeOnlyKeys[key] || (gh.redactedWriteOnly && vault.IsRedacted(key))
+}
+
+// data without the write-only keys, which aren't compared and may be secret
+func (gh *Generic) comparedData(data map[string]interface{}) map[string]interface{} {
+	compared := make(map[string]interface{}, len(data))
+	for key, v := range data {
+		if !gh.isWriteOnly(key) {
+			compared[key] = v
+		}
+	}
+	return compared
 }
 
 // Ensure all key/value pairs in mapA are present and consistent in mapB
 // extra keys in remoteMap are ignored
 func (gh *Generic) areKeysApplied(mapA map[string]interface{}, mapB map[string]interface{}) bool {
+	return len(gh.differingKeys(mapA, mapB)) == 0
+}
+
+// The keys of mapA which are missing from mapB or have a different value there, sorted
+func (gh *Generic) differingKeys(mapA map[string]interface{}, mapB map[string]interface{}) (keys []string) {
 	for key := range mapA {
+		if gh.isWriteOnly(key) {
+			continue
+		}
 		if _, ok := mapB[key]; !ok {
-			return false // not present at all
+			keys = append(keys, key) // not present at all
+			continue
 		}
-		if reflect.DeepEqual(mapA[key], mapB[key]) {
-			continue // value the same, skip further checks for this key
+		if gh.isValueApplied(key, mapA[key], mapB[key]) {
+			continue
 		}
-
-		// this is a bit more complicated, thanks to ttls and bundling into arrays :(
-		if strings.Contains(key, "ttl") {
-			// check if the ttls are equivalent
-			if isTtlEquivalent(mapA[key], mapB[key]) {
-				continue
-			}
+		if vault.IsRedacted(key) {
+			gh.log.Debugf("Field %q not equal", key)
+		} else {
+			gh.log.Debugf("Field %q not equal; %+v (type %T) != %+v (type %T)", key, vault.Redact(mapA[key]), mapA[key], vault.Redact(mapB[key]), mapB[key])
 		}
-		// covers cases such as "policy" == ["policy]
-		// logic is a bit scary, see function documentation
-		if isSliceEquivalent(mapA[key], mapB[key]) {
-			continue
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Whether the value a of key is consistent with b
+func (gh *Generic) isValueApplied(key string, a interface{}, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true // value the same, skip further checks for this key
+	}
+
+	// numbers from our documents and from Vault are decoded as different types
+	if isNumberEquivalent(a, b) {
+		return true
+	}
+	// this is a bit more complicated, thanks to ttls and bundling into arrays :(
+	if strings.Contains(key, "ttl") || gh.durationKeys[key] {
+		// check if the ttls are equivalent
+		if isTtlEquivalent(a, b) {
+			return true
 		}
-		gh.log.Debugf("Field %q not equal; %+v (type %T) != %+v (type %T)", key, mapA[key], mapA[key], mapB[key], mapB[key])
+	}
+	if gh.supersetKeys[key] && isSliceSubset(a, b) {
+		return true
+	}
+	if gh.objectKeys[key] && gh.isObjectEquivalent(a, b) {
+		return true
+	}
+	// covers cases such as "policy" == ["policy]
+	// logic is a bit scary, see function documentation
+	return isSliceEquivalent(a, b)
+}
+
+// Determine whether a and b are objects with the same keys, and equivalent values for each
+func (gh *Generic) isObjectEquivalent(a interface{}, b interface{}) bool {
+	objA, ok := a.(map[string]interface{})
+	if !ok {
 		return false
 	}
-	return true
+	objB, ok := b.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return len(objA) == len(objB) && gh.areKeysApplied(objA, objB)
 }
 
 // Remove documents that are not declared
 // Note; only the configured path for this handler is affected
 func (gh *Generic) removeUndeclaredDocuments(path string) (err error) {
-	err = filepath.Walk(path, gh.removalWalk)
+	err = gh.walk(path, gh.removalWalk)
 	return
 }
 
-func (gh *Generic) removalWalk(path string, f os.FileInfo, err error) error {
+func (gh *Generic) removalWalk(path string, f fs.DirEntry, err error) error {
 	if !f.IsDir() {
 		return nil
 	}
-	apiPath, err := apiPath(gh.config.DocumentPath, path)
+	if gh.ignored(path, f) {
+		// not managed by us, so nothing under it can be undeclared
+		return fs.SkipDir
+	}
+	apiPath, err := gh.resourcePath(path)
 	if err != nil {
 		return err
 	}
 
-	secret, err := gh.client.List(apiPath)
+	var secret *vaultApi.Secret
+	err = gh.timed(apiPath, "list", func() (err error) {
+		secret, err = gh.client.List(apiPath)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -230,22 +583,31 @@ func (gh *Generic) removalWalk(path string, f os.FileInfo, err error) error {
 		// cast to array, as vault secret data can be arbitrary types
 		keys = k
 	} else {
-		return fmt.Errorf("could not cask keys value '%+v' as an array", v)
+		return fmt.Errorf("could not cast keys of type %T as an array", v)
 	}
 
 	for k := range keys {
-		docPath := strings.Join([]string{apiPath, keys[k].(string)}, "/")
-		if _, ok := gh.configuredDocMap[docPath]; ok {
+		docPath := normalizePath(strings.Join([]string{apiPath, keys[k].(string)}, "/"))
+		if _, ok := gh.configuredDocMap[docPath]; ok || gh.ignoredResource(docPath) {
 			// configured, leave it alone
 			continue
 		}
 		logger := gh.log.WithFields(log.Fields{"docPath": docPath})
 
 		logger.Info("Removing document")
-		_, err := gh.client.Delete(docPath)
-		if err != nil {
+		restore := gh.keepForRollback(docPath)
+		err := gh.timed(docPath, "delete", func() error {
+			_, err := gh.client.Delete(docPath)
 			return err
+		})
+		if err != nil {
+			if err := gh.result(docPath, "delete", err); err != nil {
+				return err
+			}
+			continue
 		}
+		gh.config.Summary.Add(docPath, "delete", nil)
+		gh.config.Rollback.record(docPath, "delete", restore)
 		gh.removedDocMap[docPath] = true
 	}
 
@@ -277,7 +639,7 @@ func isSliceEquivalent(a interface{}, b interface{}) (equivalent bool) {
 			// recast as []string
 			aString, err = interfaceSliceToStringSlice(xif)
 			if err != nil {
-				log.Debugf("Could not cast %+v as string slice", a)
+				log.Debugf("Could not cast %T as string slice", a)
 				return false
 			}
 		}
@@ -287,7 +649,7 @@ func isSliceEquivalent(a interface{}, b interface{}) (equivalent bool) {
 			// recast as []string
 			bString, err = interfaceSliceToStringSlice(yif)
 			if err != nil {
-				log.Debugf("Could not cast %+v as string slice", a)
+				log.Debugf("Could not cast %T as string slice", b)
 				return false
 			}
 		}
@@ -338,6 +700,40 @@ func isSliceEquivalent(a interface{}, b interface{}) (equivalent bool) {
 	return false
 }
 
+// Determine whether every element of slice a is present in slice b
+func isSliceSubset(a interface{}, b interface{}) bool {
+	aString, err := toStringSlice(a)
+	if err != nil {
+		return false
+	}
+	bString, err := toStringSlice(b)
+	if err != nil {
+		return false
+	}
+	present := map[string]bool{}
+	for _, s := range bString {
+		present[s] = true
+	}
+	for _, s := range aString {
+		if !present[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// cast a decoded json list as []string
+func toStringSlice(x interface{}) ([]string, error) {
+	switch t := x.(type) {
+	case []string:
+		return t, nil
+	case []interface{}:
+		return interfaceSliceToStringSlice(t)
+	default:
+		return nil, fmt.Errorf("%T is not a list of strings", x)
+	}
+}
+
 func intSliceToStringSlice(in []int) (out []string, err error) {
 	for _, v := range in {
 		s := strconv.Itoa(v)
@@ -350,7 +746,7 @@ func interfaceSliceToStringSlice(in []interface{}) (out []string, err error) {
 	for _, v := range in {
 		x, ok := v.(string)
 		if !ok {
-			return out, fmt.Errorf("could not case `%+v` as string", v)
+			return out, fmt.Errorf("could not cast %T as string", v)
 		}
 		out = append(out, x)
 	}
@@ -402,16 +798,45 @@ func firstElementEqual(slice interface{}, value interface{}) bool {
 	return false
 }
 
+// Determine whether a and b are both numbers with the same value
+func isNumberEquivalent(a interface{}, b interface{}) bool {
+	numA, ok := toFloat(a)
+	if !ok {
+		return false
+	}
+	numB, ok := toFloat(b)
+	if !ok {
+		return false
+	}
+	return numA == numB
+}
+
+func toFloat(x interface{}) (float64, bool) {
+	switch t := x.(type) {
+	case float64:
+		return t, true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
 // Determine whether a string ttl is equal to an int ttl
 func isTtlEquivalent(ttlA interface{}, ttlB interface{}) bool {
 	durA, err := convertToDuration(ttlA)
 	if err != nil {
-		log.Warnf("Could not parse %+v: %s", ttlA, err)
+		log.Warnf("Could not parse ttl: %s", err)
 		return false
 	}
 	durB, err := convertToDuration(ttlB)
 	if err != nil {
-		log.Warnf("Could not convert %+v to duration: %s", ttlA, err)
+		log.Warnf("Could not convert ttl to duration: %s", err)
 		return false
 	}
 
@@ -422,29 +847,32 @@ func isTtlEquivalent(ttlA interface{}, ttlB interface{}) bool {
 	return false
 }
 
-// convert x to time.Duration. if x is an integer, we assume it is in seconds
+// convert x to time.Duration. if x is an integer, we assume it is in seconds. The error doesn't
+// include x, so can be logged whatever field x is from.
 func convertToDuration(x interface{}) (time.Duration, error) {
 	var duration time.Duration
 	var err error
 
 	switch x.(type) {
 	case string:
-		duration, err = time.ParseDuration(x.(string))
+		duration, err = parseTTL(x.(string))
 		if err != nil {
-			return 0, fmt.Errorf("%q can't be parsed as duration", x)
+			return 0, fmt.Errorf("a string which can't be parsed as a duration")
 		}
 	case int64:
 		duration = time.Duration(x.(int64)) * time.Second
 	case int:
 		duration = time.Duration(int64(x.(int))) * time.Second
+	case float64:
+		duration = time.Duration(x.(float64) * float64(time.Second))
 	case json.Number:
 		i, err := x.(json.Number).Int64()
 		if err != nil {
-			return 0, fmt.Errorf("could not parse %+v as json number: %s", x, err.Error())
+			return 0, fmt.Errorf("a number which isn't a whole number of seconds")
 		}
 		duration = time.Duration(i) * time.Second
 	default:
-		return 0, fmt.Errorf("type of '%+v' not handled", reflect.TypeOf(x))
+		return 0, fmt.Errorf("type %T not handled", x)
 	}
 
 	return duration, nil
@@ -5,6 +5,11 @@ import (
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -25,7 +30,7 @@ func TestGeneric_isDocApplied_true(t *testing.T) {
 		log.Fatal("Failed to create generic handler")
 	}
 
-	result, err := gh.isDocApplied(testDoc)
+	result, _, err := gh.isDocApplied(testDoc)
 	if err != nil {
 		t.Errorf("Error calling isDocApplied: %s", err)
 	}
@@ -54,7 +59,7 @@ func TestGeneric_isDocApplied_falseValue(t *testing.T) {
 		log.Fatal("Failed to create generic handler")
 	}
 
-	result, err := gh.isDocApplied(testDoc)
+	result, _, err := gh.isDocApplied(testDoc)
 	if err != nil {
 		t.Errorf("Error calling isDocApplied: %s", err)
 	}
@@ -146,6 +151,17 @@ func TestConvertAuthConfigConvertsMaxLeaseTTL(t *testing.T) {
 	}
 }
 
+// Values which can't be compared aren't included in the errors, as they may be secret
+func TestGeneric_ConversionErrorsRedacted(t *testing.T) {
+	if _, err := convertToDuration("hunter2"); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected an error without the value, got %v", err)
+	}
+	if _, err := toStringSlice([]interface{}{"a", map[string]interface{}{"password": "hunter2"}}); err == nil ||
+		strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected an error without the value, got %v", err)
+	}
+}
+
 func TestIsTtlEquivalent(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -223,3 +239,85 @@ func TestIsSliceEquivalent(t *testing.T) {
 		})
 	}
 }
+
+// Flipping the flag a document's enabled_when refers to should toggle whether it is applied
+func TestGeneric_EnabledWhen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "secret", "app"), 0755)
+	doc := `{"key": "value", "_vaultsmith": {"enabled_when": "flags/data/rollout#app == \"on\""}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "secret", "app", "config.json"), []byte(doc), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for flag, expectWrite := range map[string]bool{"on": true, "off": false} {
+		client := &vault.MockClient{
+			ReturnSecrets: map[string]*vaultApi.Secret{
+				"flags/data/rollout": {Data: map[string]interface{}{
+					"data": map[string]interface{}{"app": flag},
+				}},
+			},
+		}
+		gh, err := NewGeneric(client, PathHandlerConfig{DocumentPath: dir})
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = gh.PutPoliciesFromDir(filepath.Join(dir, "secret"))
+		if err != nil {
+			t.Fatalf("Unexpected error with flag %s: %s", flag, err)
+		}
+
+		writes := client.CallsTo("Write")
+		if expectWrite != (len(writes) == 1) {
+			t.Errorf("With flag %s, expected write %t, got %+v", flag, expectWrite, writes)
+		}
+		if expectWrite {
+			data := writes[0].Args[1].(map[string]interface{})
+			if _, ok := data["_vaultsmith"]; ok {
+				t.Errorf("Expected annotations to be removed before writing, got %+v", data)
+			}
+		}
+		// not applied, but still declared, so not removed either
+		if deletes := client.CallsTo("Delete"); len(deletes) != 0 {
+			t.Errorf("With flag %s, expected no deletes, got %+v", flag, deletes)
+		}
+	}
+}
+
+// Template variables in directory names are replaced in the path written to
+func TestGeneric_TemplatedDirectory(t *testing.T) {
+	docPath, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(docPath)
+	roleDir := filepath.Join(docPath, "auth", "{{ team }}-approle", "role")
+	os.MkdirAll(roleDir, 0755)
+	if err := ioutil.WriteFile(filepath.Join(roleDir, "deploy.json"), []byte(`{"policies": ["deploy"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &vault.MockClient{}
+	gh, err := NewGeneric(client, PathHandlerConfig{
+		DocumentPath:      docPath,
+		TemplateOverrides: []string{"team=payments"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+	if err := gh.PutPoliciesFromDir(filepath.Join(docPath, "auth")); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if expected := []string{"auth/payments-approle/role/deploy"}; !reflect.DeepEqual(writtenPaths(client), expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, writtenPaths(client))
+	}
+	for _, c := range client.CallsTo("List") {
+		if strings.Contains(c.Args[0].(string), "{{") {
+			t.Errorf("Expected placeholders to be replaced when listing, got %s", c.Args[0])
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package path_handlers
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"strings"
+)
+
+// Whether resource, e.g. "sys/auth/legacy/", or a path it is under matches one of patterns, which
+// are as for path.Match. Leading and trailing slashes don't matter, so "sys/auth/legacy" ignores the
+// auth mount, and "auth/legacy" everything under it.
+func IgnoredResource(patterns []string, resource string) bool {
+	resource = strings.Trim(resource, "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		for p := resource; p != "." && p != ""; p = path.Dir(p) {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check each of patterns can be given to IgnoredResource
+func CheckIgnorePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid --ignore pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// true if resource matches IgnoreResources, so is neither changed nor removed, however it differs
+// from the documents
+func (h *BaseHandler) ignoredResource(resource string) bool {
+	if !IgnoredResource(h.config.IgnoreResources, resource) {
+		return false
+	}
+	h.log.WithFields(log.Fields{"resource": resource}).Debug("Ignoring resource, as it matches --ignore")
+	return true
+}
@@ -0,0 +1,36 @@
+package path_handlers
+
+import (
+	"testing"
+)
+
+func TestIgnoredResource(t *testing.T) {
+	patterns := []string{"sys/auth/legacy-*", "/auth/ops/", "sys/policy/team-?"}
+	for resource, expected := range map[string]bool{
+		"sys/auth/legacy-corp/":       true,
+		"sys/auth/legacy":             false,
+		"sys/auth/approle/":           false,
+		"auth/ops":                    true,
+		"auth/ops/role/admin":         true,
+		"auth/operations/role/admin":  false,
+		"sys/policy/team-a":           true,
+		"sys/policy/team-ab":          false,
+		"sys/auth/legacy-corp/config": true,
+	} {
+		if got := IgnoredResource(patterns, resource); got != expected {
+			t.Errorf("Expected %s to be ignored %t, got %t", resource, expected, got)
+		}
+	}
+	if IgnoredResource(nil, "sys/auth/approle/") {
+		t.Errorf("Expected nothing to be ignored without patterns")
+	}
+}
+
+func TestCheckIgnorePatterns(t *testing.T) {
+	if err := CheckIgnorePatterns([]string{"sys/auth/*", "auth/[a-c]*"}); err != nil {
+		t.Errorf("Expected valid patterns, got %s", err)
+	}
+	if err := CheckIgnorePatterns([]string{"sys/auth/[a-"}); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,33 @@
+package path_handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Returned by a handler which stopped before starting on another resource
+var errInterrupted = errors.New("interrupted before all resources were applied")
+
+// Interrupt is shared by the handlers of a run. Once the context it watches is cancelled they don't
+// start on another resource, but finish the one they are on so it isn't left half applied.
+type Interrupt struct {
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// Stop the handlers starting on new resources once ctx is cancelled
+func (i *Interrupt) Watch(ctx context.Context) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.ctx = ctx
+}
+
+func (i *Interrupt) interrupted() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.ctx != nil && i.ctx.Err() != nil
+}
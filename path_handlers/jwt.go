@@ -0,0 +1,56 @@
+package path_handlers
+
+import (
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+)
+
+/*
+	Jwt handles the JWT/OIDC auth method, mounted at auth/jwt or auth/oidc. The config document
+	(config.json) and the roles (role/<name>.json) are written in the same way as the Generic
+	handler. Undeclared roles are removed.
+
+	Lists such as bound_audiences and allowed_redirect_uris, and those within bound_claims, are
+	compared regardless of order. Vault never returns the OIDC client secret, so it is not
+	compared, and it must be set from the environment, e.g.
+		"oidc_client_secret": "{{ env.OIDC_CLIENT_SECRET }}"
+*/
+type Jwt struct {
+	*Generic
+}
+
+func NewJwtHandler(client vault.Vault, config PathHandlerConfig) (*Jwt, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &Jwt{}, err
+	}
+	gh.name = "Jwt"
+	gh.log = handlerLogger("Jwt", config)
+	gh.writeOnlyKeys = map[string]bool{
+		"oidc_client_secret": true,
+	}
+	gh.objectKeys = map[string]bool{
+		"bound_claims":   true,
+		"claim_mappings": true,
+	}
+	// the config can't be listed, so only the roles are pruned
+	gh.pruneDirs = []string{"role"}
+	return &Jwt{Generic: gh}, nil
+}
+
+// Documents with a literal client secret are refused, so it never ends up in source control
+const oidcClientSecretExample = "{{ env.OIDC_CLIENT_SECRET }}"
+
+// Apply a single document to resourcePath, checking and rendering the client secret as for a file
+func (jh *Jwt) PutResource(resourcePath string, r io.Reader) error {
+	return jh.putResourceFromEnv(resourcePath, r, "oidc_client_secret", oidcClientSecretExample)
+}
+
+func (jh *Jwt) PutPoliciesFromDir(path string) error {
+	err := jh.walk(path, jh.walkFileFromEnv("oidc_client_secret", oidcClientSecretExample))
+	if err != nil {
+		return err
+	}
+
+	return jh.prune(path)
+}
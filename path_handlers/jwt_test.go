@@ -0,0 +1,192 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func applyJwt(t *testing.T, client *vault.MockClient, docPath string) error {
+	jh, err := NewJwtHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create Jwt handler: %s", err)
+	}
+	return jh.PutPoliciesFromDir(filepath.Join(docPath, "auth", "jwt"))
+}
+
+func writtenPaths(client *vault.MockClient) []string {
+	var written []string
+	for _, c := range client.CallsTo("Write") {
+		written = append(written, c.Args[0].(string))
+	}
+	return written
+}
+
+const jwtConfig = `{
+	"oidc_discovery_url": "https://accounts.example.com",
+	"oidc_client_id": "vault",
+	"oidc_client_secret": "{{ env.VAULTSMITH_TEST_OIDC_SECRET }}",
+	"bound_issuer": "https://accounts.example.com"
+}`
+
+func TestJwt_AppliesConfig(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_OIDC_SECRET", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_OIDC_SECRET")
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/jwt/config.json": jwtConfig})
+
+	client := &vault.MockClient{}
+	if err := applyJwt(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "auth/jwt/config" {
+		t.Fatalf("Expected 1 Write to auth/jwt/config, got %+v", writes)
+	}
+	data := writes[0].Args[1].(map[string]interface{})
+	if data["oidc_client_secret"] != "hunter2" {
+		t.Errorf("Expected oidc_client_secret from the environment, got %q", data["oidc_client_secret"])
+	}
+}
+
+// The client secret is never returned by Vault, so should not be considered drift
+func TestJwt_ConfigNoChange(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_OIDC_SECRET", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_OIDC_SECRET")
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/jwt/config.json": jwtConfig})
+
+	client := &vault.MockClient{
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"auth/jwt/config": {Data: map[string]interface{}{
+				"oidc_discovery_url": "https://accounts.example.com",
+				"oidc_client_id":     "vault",
+				"bound_issuer":       "https://accounts.example.com",
+			}},
+		},
+	}
+	if err := applyJwt(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
+
+func TestJwt_LiteralClientSecret(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"auth/jwt/config.json": `{"oidc_client_id": "vault", "oidc_client_secret": "hunter2"}`,
+	})
+
+	client := &vault.MockClient{}
+	if err := applyJwt(t, client, docPath); err == nil {
+		t.Errorf("Expected an error for a literal oidc_client_secret")
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
+
+const jwtRole = `{
+	"role_type": "oidc",
+	"user_claim": "sub",
+	"allowed_redirect_uris": ["https://vault.example.com/callback", "http://localhost:8250/callback"],
+	"bound_claims": {"groups": ["admins", "ops"]},
+	"policies": ["admin"]
+}`
+
+func TestJwt_CreatesRole(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/jwt/role/admin.json": jwtRole})
+
+	client := &vault.MockClient{}
+	if err := applyJwt(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if expected := []string{"auth/jwt/role/admin"}; !reflect.DeepEqual(writtenPaths(client), expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, writtenPaths(client))
+	}
+}
+
+// Lists in a different order, including within bound_claims, are not drift
+func TestJwt_RoleReordered(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/jwt/role/admin.json": jwtRole})
+
+	client := &vault.MockClient{
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"auth/jwt/role/admin": {Data: map[string]interface{}{
+				"role_type":  "oidc",
+				"user_claim": "sub",
+				"allowed_redirect_uris": []interface{}{
+					"http://localhost:8250/callback", "https://vault.example.com/callback",
+				},
+				"bound_claims": map[string]interface{}{"groups": []interface{}{"ops", "admins"}},
+				"policies":     []interface{}{"admin"},
+			}},
+		},
+	}
+	if err := applyJwt(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
+
+func TestJwt_UpdatesRole(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/jwt/role/admin.json": jwtRole})
+
+	for name, boundClaims := range map[string]map[string]interface{}{
+		"changed value": {"groups": []interface{}{"admins"}},
+		"extra claim":   {"groups": []interface{}{"admins", "ops"}, "email": "ops@example.com"},
+	} {
+		client := &vault.MockClient{
+			ReturnSecrets: map[string]*vaultApi.Secret{
+				"auth/jwt/role/admin": {Data: map[string]interface{}{
+					"role_type":  "oidc",
+					"user_claim": "sub",
+					"allowed_redirect_uris": []interface{}{
+						"https://vault.example.com/callback", "http://localhost:8250/callback",
+					},
+					"bound_claims": boundClaims,
+					"policies":     []interface{}{"admin"},
+				}},
+			},
+		}
+		if err := applyJwt(t, client, docPath); err != nil {
+			t.Fatalf("%s: error calling PutPoliciesFromDir: %s", name, err)
+		}
+		if expected := []string{"auth/jwt/role/admin"}; !reflect.DeepEqual(writtenPaths(client), expected) {
+			t.Errorf("%s: expected writes to %+v, got %+v", name, expected, writtenPaths(client))
+		}
+	}
+}
+
+// Orphaned roles are removed, but the config is not listed
+func TestJwt_RemovesOrphanedRoles(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/jwt/role/admin.json": jwtRole})
+
+	client := &vault.MockClient{
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"keys": []interface{}{"admin", "orphan"}},
+		},
+	}
+	if err := applyJwt(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	var deleted, listed []string
+	for _, c := range client.CallsTo("Delete") {
+		deleted = append(deleted, c.Args[0].(string))
+	}
+	for _, c := range client.CallsTo("List") {
+		listed = append(listed, c.Args[0].(string))
+	}
+	if expected := []string{"auth/jwt/role/orphan"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes of %+v, got %+v", expected, deleted)
+	}
+	if expected := []string{"auth/jwt/role"}; !reflect.DeepEqual(listed, expected) {
+		t.Errorf("Expected only %+v to be listed, got %+v", expected, listed)
+	}
+}
@@ -0,0 +1,51 @@
+package path_handlers
+
+import (
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+)
+
+/*
+	Ldap handles the LDAP auth method, mounted at auth/ldap. The config document (config.json) and
+	the group and user mappings (groups/<name>.json, users/<name>.json) are written in the same way
+	as the Generic handler. Undeclared groups and users are removed.
+
+	Vault never returns the bind password, so it is not compared; changing only the password will
+	not cause the config to be written. It must be set from the environment, e.g.
+		"bindpass": "{{ env.LDAP_BINDPASS }}"
+*/
+type Ldap struct {
+	*Generic
+}
+
+func NewLdapHandler(client vault.Vault, config PathHandlerConfig) (*Ldap, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &Ldap{}, err
+	}
+	gh.name = "Ldap"
+	gh.log = handlerLogger("Ldap", config)
+	gh.writeOnlyKeys = map[string]bool{
+		"bindpass": true,
+	}
+	// the config can't be listed, so only the mappings are pruned
+	gh.pruneDirs = []string{"groups", "users"}
+	return &Ldap{Generic: gh}, nil
+}
+
+// Documents with a literal bind password are refused, so it never ends up in source control
+const ldapBindPassExample = "{{ env.LDAP_BINDPASS }}"
+
+// Apply a single document to resourcePath, checking and rendering the bind password as for a file
+func (lh *Ldap) PutResource(resourcePath string, r io.Reader) error {
+	return lh.putResourceFromEnv(resourcePath, r, "bindpass", ldapBindPassExample)
+}
+
+func (lh *Ldap) PutPoliciesFromDir(path string) error {
+	err := lh.walk(path, lh.walkFileFromEnv("bindpass", ldapBindPassExample))
+	if err != nil {
+		return err
+	}
+
+	return lh.prune(path)
+}
@@ -0,0 +1,132 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const ldapConfig = `{
+	"url": "ldaps://ldap.example.com",
+	"binddn": "cn=vault,dc=example,dc=com",
+	"bindpass": "{{ env.VAULTSMITH_TEST_BINDPASS }}"
+}`
+
+func TestLdap_AppliesConfig(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_BINDPASS", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_BINDPASS")
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/ldap/config.json": ldapConfig})
+
+	client := &vault.MockClient{}
+	lh, err := NewLdapHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create Ldap handler: %s", err)
+	}
+	err = lh.PutPoliciesFromDir(filepath.Join(docPath, "auth", "ldap"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "auth/ldap/config" {
+		t.Fatalf("Expected 1 Write to auth/ldap/config, got %+v", writes)
+	}
+	data := writes[0].Args[1].(map[string]interface{})
+	if data["bindpass"] != "hunter2" {
+		t.Errorf("Expected bindpass from the environment, got %q", data["bindpass"])
+	}
+}
+
+// The bind password is never returned by Vault, so should not be considered drift
+func TestLdap_ConfigNoChange(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_BINDPASS", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_BINDPASS")
+	docPath := testutil.WriteDocuments(t, map[string]string{"auth/ldap/config.json": ldapConfig})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"url":    "ldaps://ldap.example.com",
+				"binddn": "cn=vault,dc=example,dc=com",
+			},
+		},
+	}
+	lh, err := NewLdapHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create Ldap handler: %s", err)
+	}
+	err = lh.PutPoliciesFromDir(filepath.Join(docPath, "auth", "ldap"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
+
+func TestLdap_LiteralBindPass(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"auth/ldap/config.json": `{"url": "ldaps://ldap.example.com", "bindpass": "hunter2"}`,
+	})
+
+	client := &vault.MockClient{}
+	lh, err := NewLdapHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create Ldap handler: %s", err)
+	}
+	err = lh.PutPoliciesFromDir(filepath.Join(docPath, "auth", "ldap"))
+	if err == nil {
+		t.Errorf("Expected an error for a literal bindpass")
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
+
+// Declared groups are created and orphaned ones removed
+func TestLdap_GroupMappings(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"auth/ldap/groups/admins.json": `{"policies": ["admin"]}`,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"keys": []interface{}{"admins", "orphan"},
+			},
+		},
+	}
+	lh, err := NewLdapHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create Ldap handler: %s", err)
+	}
+	err = lh.PutPoliciesFromDir(filepath.Join(docPath, "auth", "ldap"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	var written, deleted, listed []string
+	for _, c := range client.CallsTo("Write") {
+		written = append(written, c.Args[0].(string))
+	}
+	for _, c := range client.CallsTo("Delete") {
+		deleted = append(deleted, c.Args[0].(string))
+	}
+	for _, c := range client.CallsTo("List") {
+		listed = append(listed, c.Args[0].(string))
+	}
+	if expected := []string{"auth/ldap/groups/admins"}; !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+	if expected := []string{"auth/ldap/groups/orphan"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes of %+v, got %+v", expected, deleted)
+	}
+	// only the mapping directories are pruned
+	if expected := []string{"auth/ldap/groups"}; !reflect.DeepEqual(listed, expected) {
+		t.Errorf("Expected only %+v to be listed, got %+v", expected, listed)
+	}
+}
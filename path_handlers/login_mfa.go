@@ -0,0 +1,133 @@
+package path_handlers
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"strings"
+)
+
+/*
+	LoginMfa handles the login MFA enforcements under identity/mfa/login-enforcement, defined in
+	identity/mfa/login-enforcement/<name>.json. These are written in the same way as the Generic
+	handler, and undeclared enforcements are removed.
+
+	Vault binds an enforcement to MFA methods and auth mounts by their IDs and accessors, which
+	aren't known until they are created, so they may be given by name instead:
+		"mfa_methods": ["totp/corp"]  for the method in sys/mfa/method/totp/corp.json
+		"auth_mounts": ["userpass"]   for the auth mount at auth/userpass
+	These are looked up and added to mfa_method_ids and auth_method_accessors before the
+	enforcement is compared and written. It runs after the Mfa and SysAuth handlers, so the methods
+	and mounts declared in the same documents exist by then.
+*/
+type LoginMfa struct {
+	*Generic
+	methodIDs map[string]string // method name, e.g. "totp/corp" -> id
+	accessors map[string]string // auth mount path -> accessor, once listed
+}
+
+func NewLoginMfaHandler(client vault.Vault, config PathHandlerConfig) (*LoginMfa, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &LoginMfa{}, err
+	}
+	gh.name = "LoginMfa"
+	gh.log = handlerLogger("LoginMfa", config)
+	lh := &LoginMfa{Generic: gh, methodIDs: map[string]string{}}
+	gh.prepare = lh.resolve
+	return lh, nil
+}
+
+// Replace the mfa_methods and auth_mounts of doc with the IDs and accessors they name
+func (lh *LoginMfa) resolve(doc *vaultDocument) error {
+	for _, ref := range []struct {
+		key, target string
+		lookup      func(string) (string, error)
+	}{
+		{"mfa_methods", "mfa_method_ids", lh.methodID},
+		{"auth_mounts", "auth_method_accessors", lh.accessor},
+	} {
+		value, ok := doc.data[ref.key]
+		if !ok {
+			continue
+		}
+		names, err := toStringSlice(value)
+		if err != nil {
+			return fmt.Errorf("%s of %s must be a list of names: %s", ref.key, doc.path, err)
+		}
+		resolved, err := toStringSlice(doc.data[ref.target])
+		if doc.data[ref.target] != nil && err != nil {
+			return fmt.Errorf("%s of %s must be a list: %s", ref.target, doc.path, err)
+		}
+		for _, name := range names {
+			id, err := ref.lookup(strings.Trim(name, "/"))
+			if err != nil {
+				return fmt.Errorf("could not resolve %s of %s: %s", ref.key, doc.path, err)
+			}
+			resolved = append(resolved, id)
+		}
+		delete(doc.data, ref.key)
+		doc.data[ref.target] = resolved
+	}
+	return nil
+}
+
+// The id of the MFA method name, e.g. "totp/corp" for sys/mfa/method/totp/corp
+func (lh *LoginMfa) methodID(name string) (string, error) {
+	if id, ok := lh.methodIDs[name]; ok {
+		return id, nil
+	}
+	path := "sys/mfa/method/" + name
+	var secret *vaultApi.Secret
+	err := lh.timed(path, "read", func() (err error) {
+		secret, err = lh.client.Read(path)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not read MFA method %s: %s", name, err)
+	}
+	var id string
+	if secret != nil {
+		id, _ = secret.Data["id"].(string)
+	}
+	if id == "" {
+		if lh.config.Summary != nil && lh.config.Summary.Dry {
+			// the plan may create it first, so it isn't an error yet
+			lh.log.WithFields(log.Fields{"method": name}).Warn("MFA method doesn't exist yet, so its id isn't known")
+			id = "<id of " + path + ">"
+		} else {
+			return "", fmt.Errorf("MFA method %s doesn't exist; declare it in %s.json", name, path)
+		}
+	}
+	lh.methodIDs[name] = id
+	return id, nil
+}
+
+// The accessor of the auth mount at path, e.g. "userpass"
+func (lh *LoginMfa) accessor(path string) (string, error) {
+	if lh.accessors == nil {
+		var listed map[string]*vaultApi.AuthMount
+		err := lh.timed("sys/auth", "list", func() (err error) {
+			listed, err = lh.client.ListAuth()
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("could not list auth mounts: %s", err)
+		}
+		lh.accessors = map[string]string{}
+		for p, mount := range listed {
+			lh.accessors[strings.Trim(p, "/")] = mount.Accessor
+		}
+	}
+	accessor, ok := lh.accessors[path]
+	if !ok || accessor == "" {
+		if lh.config.Summary != nil && lh.config.Summary.Dry {
+			lh.log.WithFields(log.Fields{"mount": path}).Warn("Auth mount doesn't exist yet, so its accessor isn't known")
+			return "<accessor of auth/" + path + ">", nil
+		}
+		return "", fmt.Errorf("there is no auth mount at auth/%s; declare it in sys/auth/%s.json",
+			path, path)
+	}
+	return accessor, nil
+}
@@ -0,0 +1,156 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const corpEnforcement = `{"mfa_methods": ["totp/corp"], "auth_mounts": ["userpass"]}`
+
+// A Vault with the totp/corp method and a userpass mount, and the live enforcements given
+func loginMfaClient(enforcements map[string]*vaultApi.Secret) *vault.MockClient {
+	secrets := map[string]*vaultApi.Secret{
+		"sys/mfa/method/totp/corp": {Data: map[string]interface{}{"id": "f4d6b9d2", "type": "totp"}},
+	}
+	var names []interface{}
+	for name, s := range enforcements {
+		secrets["identity/mfa/login-enforcement/"+name] = s
+		names = append(names, name)
+	}
+	return &vault.MockClient{
+		ReturnSecrets: secrets,
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"keys": names},
+		},
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"userpass/": {Type: "userpass", Accessor: "auth_userpass_9d3e7a21"},
+		},
+	}
+}
+
+func applyLoginMfa(client *vault.MockClient, docPath string) error {
+	lh, err := NewLoginMfaHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		return err
+	}
+	return lh.PutPoliciesFromDir(filepath.Join(docPath, "identity", "mfa", "login-enforcement"))
+}
+
+// The method and mount are written by their id and accessor
+func TestLoginMfa_Create(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"identity/mfa/login-enforcement/corp.json": corpEnforcement,
+	})
+
+	client := loginMfaClient(nil)
+	if err := applyLoginMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "identity/mfa/login-enforcement/corp" {
+		t.Fatalf("Expected a write of the enforcement, got %+v", writes)
+	}
+	expected := map[string]interface{}{
+		"mfa_method_ids":        []string{"f4d6b9d2"},
+		"auth_method_accessors": []string{"auth_userpass_9d3e7a21"},
+	}
+	if data := writes[0].Args[1]; !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %+v to be written, got %+v", expected, data)
+	}
+}
+
+func TestLoginMfa_NoChange(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"identity/mfa/login-enforcement/corp.json": corpEnforcement,
+	})
+
+	client := loginMfaClient(map[string]*vaultApi.Secret{
+		"corp": {Data: map[string]interface{}{
+			"name":                  "corp",
+			"id":                    "0b1c2d3e",
+			"mfa_method_ids":        []interface{}{"f4d6b9d2"},
+			"auth_method_accessors": []interface{}{"auth_userpass_9d3e7a21"},
+		}},
+	})
+	if err := applyLoginMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if written := client.CallsTo("Write"); len(written) != 0 {
+		t.Errorf("Expected no writes, got %+v", written)
+	}
+}
+
+// The live enforcement is bound to a method which was since recreated with a new id
+func TestLoginMfa_Update(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"identity/mfa/login-enforcement/corp.json": corpEnforcement,
+	})
+
+	client := loginMfaClient(map[string]*vaultApi.Secret{
+		"corp": {Data: map[string]interface{}{
+			"mfa_method_ids":        []interface{}{"77aa01bc"},
+			"auth_method_accessors": []interface{}{"auth_userpass_9d3e7a21"},
+		}},
+	})
+	if err := applyLoginMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 {
+		t.Fatalf("Expected 1 write, got %+v", writes)
+	}
+	data := writes[0].Args[1].(map[string]interface{})
+	if !reflect.DeepEqual(data["mfa_method_ids"], []string{"f4d6b9d2"}) {
+		t.Errorf("Expected the new method id to be written, got %+v", data)
+	}
+}
+
+func TestLoginMfa_DeleteOrphan(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"identity/mfa/login-enforcement/corp.json": corpEnforcement,
+	})
+
+	client := loginMfaClient(map[string]*vaultApi.Secret{
+		"corp":   {Data: map[string]interface{}{}},
+		"orphan": {Data: map[string]interface{}{}},
+	})
+	if err := applyLoginMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	expected := []string{"identity/mfa/login-enforcement/orphan"}
+	if deleted := paths(client.CallsTo("Delete")); !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes of %+v, got %+v", expected, deleted)
+	}
+}
+
+// Names which don't resolve fail, except in a dry run, where they may be created first
+func TestLoginMfa_Unresolved(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"identity/mfa/login-enforcement/corp.json": `{"mfa_methods": ["totp/missing"], "auth_mounts": ["ldap"]}`,
+	})
+
+	client := loginMfaClient(nil)
+	err := applyLoginMfa(client, docPath)
+	if err == nil || !strings.Contains(err.Error(), "MFA method totp/missing doesn't exist") {
+		t.Errorf("Expected an error naming the missing method, got %v", err)
+	}
+	if written := client.CallsTo("Write"); len(written) != 0 {
+		t.Errorf("Expected no writes, got %+v", written)
+	}
+
+	lh, err := NewLoginMfaHandler(client, PathHandlerConfig{DocumentPath: docPath, Summary: &Summary{Dry: true}})
+	if err != nil {
+		t.Fatalf("Failed to create LoginMfa handler: %s", err)
+	}
+	if err := lh.PutPoliciesFromDir(filepath.Join(docPath, "identity", "mfa", "login-enforcement")); err != nil {
+		t.Errorf("Expected the dry run to plan the enforcement, got %s", err)
+	}
+}
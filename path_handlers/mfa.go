@@ -0,0 +1,94 @@
+package path_handlers
+
+import (
+	"fmt"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	Mfa handles the MFA method definitions under sys/mfa, defined in
+	sys/mfa/method/<type>/<name>.json for the totp, okta, duo and pingid types. These are written in
+	the same way as the Generic handler, and undeclared methods of each type are removed.
+
+	Vault never returns the credentials of a method (the Okta API token, the Duo keys and the
+	PingID settings file), so they are not compared, and never logged or shown in a diff. These are
+	the fields which are redacted, and must be set from the environment, e.g.
+		"api_token": "{{ env.API_TOKEN }}"
+	Only the method definitions are written: generating a TOTP key returns the shared key, so
+	documents under a method, such as sys/mfa/method/totp/<name>/admin-generate, are refused.
+*/
+type Mfa struct {
+	*Generic
+}
+
+// The method types, each a directory under sys/mfa/method
+var mfaMethodTypes = []string{"duo", "okta", "pingid", "totp"}
+
+func NewMfaHandler(client vault.Vault, config PathHandlerConfig) (*Mfa, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &Mfa{}, err
+	}
+	gh.name = "Mfa"
+	gh.log = handlerLogger("Mfa", config)
+	// the credentials (api_token, integration_key, secret_key and settings_file_base64) are those
+	// which are redacted
+	gh.redactedWriteOnly = true
+	for _, t := range mfaMethodTypes {
+		gh.pruneDirs = append(gh.pruneDirs, filepath.Join("method", t))
+	}
+	return &Mfa{Generic: gh}, nil
+}
+
+func (mh *Mfa) walkFile(path string, f fs.DirEntry, err error) error {
+	if f != nil && err == nil && !f.IsDir() && !mh.ignored(path, f) {
+		resourcePath, err := mh.resourcePath(path)
+		if err != nil {
+			return err
+		}
+		if err := checkMfaMethodPath(filepath.ToSlash(resourcePath)); err != nil {
+			return err
+		}
+		if err := mh.checkRedactedFromEnv(path); err != nil {
+			return err
+		}
+	}
+	return mh.Generic.walkFile(path, f, err)
+}
+
+// Refuse anything but a method definition, sys/mfa/method/<type>/<name>
+func checkMfaMethodPath(resourcePath string) error {
+	parts := strings.Split(strings.Trim(resourcePath, "/"), "/")
+	if len(parts) == 5 && parts[0] == "sys" && parts[1] == "mfa" && parts[2] == "method" {
+		for _, t := range mfaMethodTypes {
+			if parts[3] == t {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown MFA method type %q in %s; expected one of %s", parts[3],
+			resourcePath, strings.Join(mfaMethodTypes, ", "))
+	}
+	return fmt.Errorf("%s is not an MFA method; only sys/mfa/method/<type>/<name> is applied",
+		resourcePath)
+}
+
+func (mh *Mfa) PutPoliciesFromDir(path string) error {
+	err := mh.walk(path, mh.walkFile)
+	if err != nil {
+		return err
+	}
+
+	return mh.prune(path)
+}
+
+// Apply a single method definition to resourcePath
+func (mh *Mfa) PutResource(resourcePath string, r io.Reader) error {
+	if err := checkMfaMethodPath(resourcePath); err != nil {
+		return err
+	}
+	return mh.Generic.PutResource(resourcePath, r)
+}
@@ -0,0 +1,176 @@
+package path_handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const totpMethod = `{"issuer": "Example", "period": 30, "algorithm": "SHA256", "digits": 6}`
+
+func applyMfa(client *vault.MockClient, docPath string) error {
+	mh, err := NewMfaHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		return err
+	}
+	return mh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "mfa"))
+}
+
+func TestMfa_Create(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/mfa/method/totp/corp.json": totpMethod})
+
+	client := &vault.MockClient{}
+	if err := applyMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	expected := []string{"sys/mfa/method/totp/corp"}
+	if written := paths(client.CallsTo("Write")); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+	// only the method types are listed for pruning
+	expected = []string{"sys/mfa/method/totp"}
+	if listed := paths(client.CallsTo("List")); !reflect.DeepEqual(listed, expected) {
+		t.Errorf("Expected %+v to be listed, got %+v", expected, listed)
+	}
+}
+
+// Vault returns numbers as json.Number, and fields of its own
+func TestMfa_NoChange(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/mfa/method/totp/corp.json": totpMethod})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"id":        "f4d6b9d2",
+				"name":      "corp",
+				"type":      "totp",
+				"issuer":    "Example",
+				"period":    json.Number("30"),
+				"algorithm": "SHA256",
+				"digits":    json.Number("6"),
+			},
+		},
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"keys": []interface{}{"corp"}},
+		},
+	}
+	if err := applyMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	if written := client.CallsTo("Write"); len(written) != 0 {
+		t.Errorf("Expected no writes, got %+v", written)
+	}
+}
+
+func TestMfa_Update(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/mfa/method/totp/corp.json": totpMethod})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"issuer":    "Example",
+				"period":    json.Number("60"),
+				"algorithm": "SHA1",
+				"digits":    json.Number("6"),
+			},
+		},
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"keys": []interface{}{"corp"}},
+		},
+	}
+	if err := applyMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	expected := []string{"sys/mfa/method/totp/corp"}
+	if written := paths(client.CallsTo("Write")); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+}
+
+func TestMfa_DeleteOrphan(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/mfa/method/totp/corp.json": totpMethod})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"keys": []interface{}{"corp", "orphan"},
+			},
+		},
+	}
+	if err := applyMfa(client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	expected := []string{"sys/mfa/method/totp/orphan"}
+	if deleted := paths(client.CallsTo("Delete")); !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes of %+v, got %+v", expected, deleted)
+	}
+}
+
+// Credentials are written, but never compared or logged
+func TestMfa_SecretsNotLogged(t *testing.T) {
+	os.Setenv("VAULTSMITH_TEST_DUO_SECRET", "hunter2")
+	defer os.Unsetenv("VAULTSMITH_TEST_DUO_SECRET")
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/mfa/method/duo/corp.json": `{"api_hostname": "api.duo.example.com", "integration_key": "{{ env.VAULTSMITH_TEST_DUO_SECRET }}", ` +
+			`"secret_key": "{{ env.VAULTSMITH_TEST_DUO_SECRET }}"}`,
+	})
+
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	defer func(level log.Level) { log.SetLevel(level) }(log.GetLevel())
+	log.SetOutput(&logged)
+	log.SetLevel(log.DebugLevel)
+	client := &vault.MockClient{}
+	mh, err := NewMfaHandler(client, PathHandlerConfig{DocumentPath: docPath, Explain: true, Diff: true,
+		Summary: &Summary{}})
+	if err != nil {
+		t.Fatalf("Failed to create Mfa handler: %s", err)
+	}
+	if err := mh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "mfa")); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[1].(map[string]interface{})["secret_key"] != "hunter2" {
+		t.Fatalf("Expected the secret key to be written from the environment, got %+v", writes)
+	}
+	if strings.Contains(logged.String(), "hunter2") {
+		t.Errorf("Expected the credentials not to be logged, got:\n%s", logged.String())
+	}
+	for _, d := range mh.config.Summary.Diffs {
+		if strings.Contains(d.Diff, "hunter2") {
+			t.Errorf("Expected the credentials not to be in the diff, got:\n%s", d.Diff)
+		}
+	}
+}
+
+func TestMfa_Refused(t *testing.T) {
+	for name, doc := range map[string]string{
+		"duo/corp":                 `{"api_hostname": "api.duo.example.com", "secret_key": "hunter2"}`,
+		"totp/corp/admin-generate": `{"entity_id": "f4d6b9d2"}`,
+		"webauthn/corp":            `{}`,
+	} {
+		docPath := testutil.WriteDocuments(t, map[string]string{"sys/mfa/method/" + name + ".json": doc})
+		client := &vault.MockClient{}
+		if err := applyMfa(client, docPath); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+		if written := client.CallsTo("Write"); len(written) != 0 {
+			t.Errorf("Expected no writes for %s, got %+v", name, written)
+		}
+		os.RemoveAll(docPath)
+	}
+}
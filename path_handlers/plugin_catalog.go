@@ -0,0 +1,313 @@
+package path_handlers
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+/*
+	PluginCatalog registers plugins in Vault's plugin catalog, described in
+	sys/plugins/catalog/<type>/<name>.json where type is auth, secret or database, e.g.
+		{"sha256": "d130b9a0...", "command": "vault-plugin-secrets-foo", "args": ["-tls-skip-verify"]}
+
+	A plugin is registered again if its sha256, command or args differ from the catalog. Undeclared
+	plugins of each type with a directory are deregistered, but builtin plugins are left alone. It
+	runs before the auth and mounts handlers, so the plugins they enable are already registered.
+
+	Mounts keep running the binary they started with until they are reloaded. With ReloadPlugins,
+	an auth or secret plugin registered again has the mounts using it reloaded straight after.
+*/
+type PluginCatalog struct {
+	BaseHandler
+	configured map[string]bool // "<type>/<name>"
+}
+
+// As the fields of the catalog API
+type plugin struct {
+	SHA256  string   `json:"sha256"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+var pluginTypes = map[string]bool{"auth": true, "secret": true, "database": true}
+
+const pluginCatalogPrefix = "sys/plugins/catalog/"
+
+func NewPluginCatalogHandler(client vault.Vault, config PathHandlerConfig) (*PluginCatalog, error) {
+	return &PluginCatalog{
+		BaseHandler: BaseHandler{
+			name:   "PluginCatalog",
+			client: client,
+			config: config,
+			order:  config.Order,
+			log:    handlerLogger("PluginCatalog", config),
+		},
+		configured: map[string]bool{},
+	}, nil
+}
+
+func (ph *PluginCatalog) walkFile(path string, f fs.DirEntry, err error) error {
+	if f == nil {
+		ph.log.WithFields(log.Fields{"path": path, "error": err}).Debug("Path does not exist, skipping")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	if ph.ignored(path, f) {
+		return skipIgnored(f)
+	}
+	if f.IsDir() {
+		return nil
+	}
+
+	resourcePath, err := ph.resourcePath(path)
+	if err != nil {
+		return err
+	}
+	pluginType, name, err := pluginName(resourcePath)
+	if err != nil {
+		return err
+	}
+
+	var p plugin
+	annotations, err := ph.decodeFile(path, &p)
+	if err != nil {
+		return err
+	}
+	enabled, err := ph.enabled(resourcePath, annotations)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if !annotations.PruneWhenDisabled {
+			// still declared, so not deregistered
+			ph.configured[pluginType+"/"+name] = true
+		}
+		return nil
+	}
+	return ph.ensurePlugin(pluginType, name, p)
+}
+
+// Register a single plugin, e.g. resourcePath "sys/plugins/catalog/secret/foo"
+func (ph *PluginCatalog) PutResource(resourcePath string, r io.Reader) error {
+	resourcePath = normalizePath(resourcePath)
+	pluginType, name, err := pluginName(resourcePath)
+	if err != nil {
+		return err
+	}
+
+	var p plugin
+	annotations, err := ph.decodeReader(resourcePath, r, &p)
+	if err != nil {
+		return err
+	}
+	if enabled, err := ph.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+	return ph.ensurePlugin(pluginType, name, p)
+}
+
+func (ph *PluginCatalog) PutPoliciesFromDir(path string) error {
+	err := ph.walk(path, ph.walkFile)
+	if err != nil {
+		return err
+	}
+	return ph.deregisterUndeclared(path)
+}
+
+// Split a resource path into the plugin type and name
+func pluginName(resourcePath string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(resourcePath, pluginCatalogPrefix), "/")
+	if !strings.HasPrefix(resourcePath, pluginCatalogPrefix) || len(parts) != 2 || !pluginTypes[parts[0]] {
+		return "", "", fmt.Errorf("plugin %s must be at %s<type>/<name>, where type is auth, "+
+			"secret or database", resourcePath, pluginCatalogPrefix)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Ensure the plugin is registered as configured
+func (ph *PluginCatalog) ensurePlugin(pluginType string, name string, p plugin) (err error) {
+	resource := pluginCatalogPrefix + pluginType + "/" + name
+	logger := ph.log.WithFields(log.Fields{
+		"type":    pluginType,
+		"name":    name,
+		"command": p.Command,
+	})
+	ph.configured[pluginType+"/"+name] = true
+	if ph.ignoredResource(resource) || ph.appliedEarlier(resource) {
+		return nil
+	}
+	defer func() { ph.recordApplied(resource, err) }()
+
+	var live *vaultApi.GetPluginResponse
+	err = ph.timed(resource, "read", func() (err error) {
+		live, err = ph.client.GetPlugin(pluginType, name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not read plugin %s: %s", resource, err)
+	}
+	if live != nil && isPluginApplied(p, live) {
+		logger.Debug("Plugin already registered")
+		ph.unchanged(resource)
+		return nil
+	}
+
+	logger.Info("Registering plugin")
+	err = ph.timed(resource, "register", func() error {
+		return ph.client.RegisterPlugin(pluginType, &vaultApi.RegisterPluginInput{
+			Name:    name,
+			SHA256:  p.SHA256,
+			Command: p.Command,
+			Args:    p.Args,
+		})
+	})
+	if err != nil {
+		err = fmt.Errorf("could not register plugin %s: %s", resource, err)
+	}
+	registered := err == nil
+	if err := ph.result(resource, "register", err); err != nil || !registered {
+		return err
+	}
+	if ph.config.ReloadPlugins && live != nil && !live.Builtin {
+		// only mounts already using the plugin run an old binary
+		return ph.reloadMounts(pluginType, name)
+	}
+	return nil
+}
+
+// Reload the mounts using the plugin name of pluginType, so they run the binary just registered
+func (ph *PluginCatalog) reloadMounts(pluginType string, name string) error {
+	resource := pluginCatalogPrefix + pluginType + "/" + name
+	logger := ph.log.WithFields(log.Fields{"type": pluginType, "name": name})
+	mounts, err := ph.pluginMounts(pluginType, name)
+	if err != nil {
+		return ph.result(resource, "reload", err)
+	}
+	if len(mounts) == 0 {
+		logger.Debug("Plugin isn't mounted, so there is nothing to reload")
+		return nil
+	}
+
+	logger.WithFields(log.Fields{"mounts": mounts}).Info("Reloading plugin")
+	err = ph.timed(resource, "reload", func() error {
+		return ph.client.ReloadPlugin(mounts)
+	})
+	if err != nil {
+		err = fmt.Errorf("could not reload plugin %s at %s: %s", resource, strings.Join(mounts, ", "), err)
+	}
+	return ph.result(resource, "reload", err)
+}
+
+// The mounts running the plugin name of pluginType, sorted, as their paths are given to ReloadPlugin
+func (ph *PluginCatalog) pluginMounts(pluginType string, name string) ([]string, error) {
+	// before Vault 1.0, mounts of external plugins had the type "plugin", and named it in their config
+	uses := func(mountType string, pluginName string) bool {
+		return mountType == name || (mountType == "plugin" && pluginName == name)
+	}
+	var mounts []string
+	switch pluginType {
+	case "auth":
+		var listed map[string]*vaultApi.AuthMount
+		err := ph.timed("sys/auth", "list", func() (err error) {
+			listed, err = ph.client.ListAuth()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list auth mounts: %s", err)
+		}
+		for path, m := range listed {
+			if uses(m.Type, m.Config.PluginName) && !ph.ignoredResource("sys/auth/"+path) {
+				mounts = append(mounts, "auth/"+path)
+			}
+		}
+	case "secret":
+		var listed map[string]*vaultApi.MountOutput
+		err := ph.timed("sys/mounts", "list", func() (err error) {
+			listed, err = ph.client.ListMounts()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list mounts: %s", err)
+		}
+		for path, m := range listed {
+			if uses(m.Type, m.Config.PluginName) && !ph.ignoredResource("sys/mounts/"+path) {
+				mounts = append(mounts, path)
+			}
+		}
+	default:
+		// run by the connections of a database mount rather than mounted themselves
+		ph.log.WithFields(log.Fields{"name": name}).Warn("Database plugins aren't reloaded; " +
+			"reset the connections using it for the new binary to take effect")
+	}
+	return sortedStrings(mounts), nil
+}
+
+func isPluginApplied(p plugin, live *vaultApi.GetPluginResponse) bool {
+	if live.Builtin || p.SHA256 != live.SHA256 || p.Command != live.Command {
+		return false
+	}
+	// the order of args matters, but no args is the same however it's written
+	return (len(p.Args) == 0 && len(live.Args) == 0) || reflect.DeepEqual(p.Args, live.Args)
+}
+
+// Deregister the plugins which aren't declared, for each type with a directory under path
+func (ph *PluginCatalog) deregisterUndeclared(path string) error {
+	entries, err := ph.readDir(path)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %s", path, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !pluginTypes[e.Name()] || ph.ignored(filepath.Join(path, e.Name()), e) {
+			continue
+		}
+		pluginType := e.Name()
+		var names []string
+		err := ph.timed(pluginCatalogPrefix+pluginType, "list", func() (err error) {
+			names, err = ph.client.ListPlugins(pluginType)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error listing %s plugins: %s", pluginType, err)
+		}
+
+		for _, name := range names {
+			resource := pluginCatalogPrefix + pluginType + "/" + name
+			if ph.configured[pluginType+"/"+name] || ph.ignoredResource(resource) {
+				continue
+			}
+			var live *vaultApi.GetPluginResponse
+			err := ph.timed(resource, "read", func() (err error) {
+				live, err = ph.client.GetPlugin(pluginType, name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("could not read plugin %s: %s", resource, err)
+			}
+			if live == nil || live.Builtin {
+				// part of Vault, so can't be deregistered
+				continue
+			}
+
+			ph.log.WithFields(log.Fields{"type": pluginType, "name": name}).Info("Deregistering plugin")
+			err = ph.timed(resource, "deregister", func() error {
+				return ph.client.DeregisterPlugin(pluginType, name)
+			})
+			if err != nil {
+				err = fmt.Errorf("could not deregister plugin %s: %s", resource, err)
+			}
+			if err := ph.result(resource, "deregister", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func applyPlugins(t *testing.T, client *vault.MockClient, docPath string) error {
+	ph, err := NewPluginCatalogHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create PluginCatalog handler: %s", err)
+	}
+	return ph.PutPoliciesFromDir(filepath.Join(docPath, "sys", "plugins", "catalog"))
+}
+
+const customPlugin = `{"sha256": "d130b9a0", "command": "vault-plugin-secrets-custom", "args": ["-debug"]}`
+
+func TestPluginCatalog_Register(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/plugins/catalog/secret/custom.json": customPlugin,
+	})
+
+	client := &vault.MockClient{}
+	if err := applyPlugins(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	calls := client.CallsTo("RegisterPlugin")
+	if len(calls) != 1 || calls[0].Args[0] != "secret" {
+		t.Fatalf("Expected a single secret plugin to be registered, got %+v", calls)
+	}
+	expected := &vaultApi.RegisterPluginInput{
+		Name:    "custom",
+		SHA256:  "d130b9a0",
+		Command: "vault-plugin-secrets-custom",
+		Args:    []string{"-debug"},
+	}
+	if input := calls[0].Args[1].(*vaultApi.RegisterPluginInput); !reflect.DeepEqual(input, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, input)
+	}
+}
+
+func TestPluginCatalog_Update(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/plugins/catalog/secret/custom.json": customPlugin,
+	})
+
+	for name, live := range map[string]*vaultApi.GetPluginResponse{
+		"unchanged": {Name: "custom", SHA256: "d130b9a0", Command: "vault-plugin-secrets-custom", Args: []string{"-debug"}},
+		"new build": {Name: "custom", SHA256: "00000000", Command: "vault-plugin-secrets-custom", Args: []string{"-debug"}},
+		"new args":  {Name: "custom", SHA256: "d130b9a0", Command: "vault-plugin-secrets-custom"},
+	} {
+		client := &vault.MockClient{
+			ReturnPlugins: map[string]*vaultApi.GetPluginResponse{"secret/custom": live},
+		}
+		if err := applyPlugins(t, client, docPath); err != nil {
+			t.Fatalf("%s: error calling PutPoliciesFromDir: %s", name, err)
+		}
+		calls := client.CallsTo("RegisterPlugin")
+		if name == "unchanged" && len(calls) != 0 {
+			t.Errorf("%s: expected no RegisterPlugin calls, got %+v", name, calls)
+		} else if name != "unchanged" && len(calls) != 1 {
+			t.Errorf("%s: expected the plugin to be registered again, got %+v", name, calls)
+		}
+	}
+}
+
+// Undeclared plugins are deregistered, except builtin ones and those of types without a directory
+func TestPluginCatalog_Deregister(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/plugins/catalog/secret/custom.json": customPlugin,
+	})
+
+	client := &vault.MockClient{
+		ReturnPlugins: map[string]*vaultApi.GetPluginResponse{
+			"secret/custom": {Name: "custom", SHA256: "d130b9a0", Command: "vault-plugin-secrets-custom", Args: []string{"-debug"}},
+			"secret/orphan": {Name: "orphan", SHA256: "ffff", Command: "orphan"},
+			"secret/kv":     {Name: "kv", Builtin: true},
+			"auth/other":    {Name: "other", SHA256: "eeee", Command: "other"},
+		},
+	}
+	if err := applyPlugins(t, client, docPath); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	var deregistered []string
+	for _, c := range client.CallsTo("DeregisterPlugin") {
+		deregistered = append(deregistered, c.Args[0].(string)+"/"+c.Args[1].(string))
+	}
+	if expected := []string{"secret/orphan"}; !reflect.DeepEqual(deregistered, expected) {
+		t.Errorf("Expected %+v to be deregistered, got %+v", expected, deregistered)
+	}
+}
+
+// A new build of a plugin reloads only the mounts using it, and only once it was registered before
+func TestPluginCatalog_Reload(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/plugins/catalog/secret/custom.json": customPlugin,
+	})
+
+	for name, test := range map[string]struct {
+		live     *vaultApi.GetPluginResponse
+		reload   bool
+		expected [][]string
+	}{
+		"new build":  {&vaultApi.GetPluginResponse{Name: "custom", SHA256: "00000000", Command: "vault-plugin-secrets-custom"}, true, [][]string{{"custom/"}}},
+		"not opted":  {&vaultApi.GetPluginResponse{Name: "custom", SHA256: "00000000", Command: "vault-plugin-secrets-custom"}, false, nil},
+		"unchanged":  {&vaultApi.GetPluginResponse{Name: "custom", SHA256: "d130b9a0", Command: "vault-plugin-secrets-custom", Args: []string{"-debug"}}, true, nil},
+		"registered": {nil, true, nil},
+	} {
+		client := &vault.MockClient{
+			ReturnPlugins: map[string]*vaultApi.GetPluginResponse{"secret/custom": test.live},
+			ReturnMounts: map[string]*vaultApi.MountOutput{
+				"custom/": {Type: "custom"},
+				"other/":  {Type: "plugin", Config: vaultApi.MountConfigOutput{PluginName: "other"}},
+				"secret/": {Type: "kv"},
+			},
+		}
+		ph, err := NewPluginCatalogHandler(client, PathHandlerConfig{DocumentPath: docPath, ReloadPlugins: test.reload})
+		if err != nil {
+			t.Fatalf("Failed to create PluginCatalog handler: %s", err)
+		}
+		if err := ph.PutPoliciesFromDir(filepath.Join(docPath, "sys", "plugins", "catalog")); err != nil {
+			t.Fatalf("%s: error calling PutPoliciesFromDir: %s", name, err)
+		}
+		var reloaded [][]string
+		for _, c := range client.CallsTo("ReloadPlugin") {
+			reloaded = append(reloaded, c.Args[0].([]string))
+		}
+		if !reflect.DeepEqual(reloaded, test.expected) {
+			t.Errorf("%s: expected reloads of %+v, got %+v", name, test.expected, reloaded)
+		}
+	}
+}
+
+// The plugin name can come from a template variable, as document paths elsewhere can
+func TestPluginCatalog_TemplatedName(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"_vaultsmith.json": `{"variables": {"plugin": "custom"}}`,
+		"sys/plugins/catalog/secret/{{ plugin }}.json": customPlugin,
+	})
+	templateFile := filepath.Join(docPath, "_vaultsmith.json")
+
+	client := &vault.MockClient{}
+	ph, err := NewPluginCatalogHandler(client, PathHandlerConfig{DocumentPath: docPath, TemplateFile: templateFile})
+	if err != nil {
+		t.Fatalf("Failed to create PluginCatalog handler: %s", err)
+	}
+	if err := ph.PutPoliciesFromDir(filepath.Join(docPath, "sys", "plugins", "catalog")); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	calls := client.CallsTo("RegisterPlugin")
+	if len(calls) != 1 || calls[0].Args[1].(*vaultApi.RegisterPluginInput).Name != "custom" {
+		t.Errorf("Expected the custom plugin to be registered, got %+v", calls)
+	}
+}
+
+func TestPluginCatalog_InvalidType(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/plugins/catalog/custom.json": customPlugin})
+
+	client := &vault.MockClient{}
+	if err := applyPlugins(t, client, docPath); err == nil {
+		t.Error("Expected an error for a plugin without a type")
+	}
+	if calls := client.CallsTo("RegisterPlugin"); len(calls) != 0 {
+		t.Errorf("Expected no RegisterPlugin calls, got %+v", calls)
+	}
+}
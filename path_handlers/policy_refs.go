@@ -0,0 +1,108 @@
+package path_handlers
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Keys of a document holding the names of the policies it grants, e.g. of an auth role
+var policyRefKeys = []string{"policies", "token_policies"}
+
+// The rules of an empty policy created for a document which references a missing one
+const stubPolicy = "# created empty by vaultsmith, as a document references it before it is declared\n"
+
+// The policies data references, as a list or a comma separated string
+func referencedPolicies(data map[string]interface{}) []string {
+	var names []string
+	for _, key := range policyRefKeys {
+		var values []string
+		switch v := data[key].(type) {
+		case string:
+			values = strings.Split(v, ",")
+		default:
+			values, _ = toStringSlice(v)
+		}
+		for _, name := range values {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// The policies doc references which aren't in Vault, sorted. The live policies are listed once per
+// handler, and the stubs it creates added to them.
+func (gh *Generic) missingPolicies(doc vaultDocument) ([]string, error) {
+	referenced := referencedPolicies(doc.data)
+	if len(referenced) == 0 {
+		return nil, nil
+	}
+	if gh.livePolicies == nil {
+		var listed []string
+		err := gh.timed("sys/policy", "list", func() (err error) {
+			listed, err = gh.client.ListPolicies()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list policies referenced by %s: %s", doc.path, err)
+		}
+		gh.livePolicies = map[string]bool{}
+		for _, name := range listed {
+			gh.livePolicies[name] = true
+		}
+	}
+	seen := map[string]bool{}
+	var missing []string
+	for _, name := range referenced {
+		// root is never listed, but always exists
+		if !gh.livePolicies[name] && name != "root" && !seen[name] {
+			seen[name] = true
+			missing = append(missing, name)
+		}
+	}
+	return sortedStrings(missing), nil
+}
+
+// Create an empty policy for each one doc references which doesn't exist yet, so it can be written
+// before the policies are applied. A policy declared under sys/policy replaces its stub when it is.
+func (gh *Generic) stubMissingPolicies(doc vaultDocument) error {
+	missing, err := gh.missingPolicies(doc)
+	if err != nil {
+		return err
+	}
+	for _, name := range missing {
+		gh.log.WithFields(log.Fields{
+			"policy":     name,
+			"referenced": doc.path,
+		}).Warn("Creating empty policy referenced before it exists")
+		err := gh.timed("sys/policy/"+name, "stub", func() error {
+			return gh.client.PutPolicy(name, stubPolicy)
+		})
+		if err == nil {
+			gh.livePolicies[name] = true
+		}
+		if err := gh.result("sys/policy/"+name, "stub", err); err != nil {
+			return fmt.Errorf("could not create policy %s referenced by %s: %s", name, doc.path, err)
+		}
+	}
+	return nil
+}
+
+// The error of writing doc, naming the policies it references which are missing, if Vault refused it
+// for one that doesn't exist
+func (gh *Generic) missingPolicyError(doc vaultDocument, err error) error {
+	if !strings.Contains(strings.ToLower(err.Error()), "does not exist") {
+		return err
+	}
+	// listed afresh, as another handler may have changed them
+	gh.livePolicies = nil
+	missing, listErr := gh.missingPolicies(doc)
+	if listErr != nil || len(missing) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s references policies which don't exist in Vault: %s; declare them under "+
+		"sys/policy, which is applied first, or create them empty with --stub-missing-policies: %s",
+		doc.path, strings.Join(missing, ", "), err)
+}
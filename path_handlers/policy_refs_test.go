@@ -0,0 +1,74 @@
+package path_handlers
+
+import (
+	"errors"
+	"github.com/starlingbank/vaultsmith/vault"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const appRole = `{"token_policies": ["default", "app-read"], "policies": "app-write"}`
+
+func TestReferencedPolicies(t *testing.T) {
+	data := map[string]interface{}{
+		"token_policies": []interface{}{"default", "app-read"},
+		"policies":       "app-write, app-list",
+		"bind_secret_id": true,
+	}
+	expected := []string{"app-write", "app-list", "default", "app-read"}
+	if names := referencedPolicies(data); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, names)
+	}
+}
+
+// Vault's error for a missing policy is replaced with one naming it
+func TestGeneric_MissingPolicyError(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnPolicies: map[string]string{"default": "", "app-write": ""},
+		WriteErrors: map[string]error{
+			"auth/approle/role/app": errors.New(`Code: 400. Errors: * policy "app-read" does not exist`),
+		},
+	}
+	gh, err := NewGeneric(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+
+	err = gh.PutResource("auth/approle/role/app", strings.NewReader(appRole))
+	expected := "auth/approle/role/app references policies which don't exist in Vault: app-read;"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected an error containing %q, got %v", expected, err)
+	}
+}
+
+func TestGeneric_StubMissingPolicies(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnPolicies: map[string]string{"default": ""},
+	}
+	gh, err := NewGeneric(client, PathHandlerConfig{StubMissingPolicies: true, Summary: &Summary{}})
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+
+	if err := gh.PutResource("auth/approle/role/app", strings.NewReader(appRole)); err != nil {
+		t.Fatalf("Error calling PutResource: %s", err)
+	}
+	var stubbed []string
+	for _, c := range client.CallsTo("PutPolicy") {
+		stubbed = append(stubbed, c.Args[0].(string))
+	}
+	if expected := []string{"app-read", "app-write"}; !reflect.DeepEqual(stubbed, expected) {
+		t.Errorf("Expected %+v to be created, got %+v", expected, stubbed)
+	}
+	var methods []string
+	for _, c := range client.CallLog {
+		methods = append(methods, c.Method)
+	}
+	if expected := []string{"Read", "ListPolicies", "PutPolicy", "PutPolicy", "Write"}; !reflect.DeepEqual(methods, expected) {
+		t.Errorf("Expected the policies to be created before the write, got %+v", methods)
+	}
+	if rows := gh.config.Summary.Rows; len(rows) != 3 || rows[0].Action != "stub" {
+		t.Errorf("Expected the stubs and the write in the summary, got %+v", rows)
+	}
+}
@@ -0,0 +1,33 @@
+package path_handlers
+
+import (
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+/*
+	Quotas handles the rate limit and lease count quotas under sys/quotas, defined in
+	sys/quotas/rate-limit/<name>.json and sys/quotas/lease-count/<name>.json. These are written in the
+	same way as the Generic handler, and undeclared quotas of either type are removed.
+
+	Vault returns intervals in seconds, so these are compared as durations, allowing e.g. "1m" in
+	the documents.
+*/
+type Quotas struct {
+	*Generic
+}
+
+func NewQuotasHandler(client vault.Vault, config PathHandlerConfig) (*Quotas, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &Quotas{}, err
+	}
+	gh.name = "Quotas"
+	gh.log = handlerLogger("Quotas", config)
+	gh.durationKeys = map[string]bool{
+		"interval":       true,
+		"block_interval": true,
+	}
+	// sys/quotas/config is a singleton, so only the quota types are pruned
+	gh.pruneDirs = []string{"rate-limit", "lease-count"}
+	return &Quotas{Generic: gh}, nil
+}
@@ -0,0 +1,122 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const rateLimitQuota = `{"path": "secret/", "rate": 100, "interval": "1m"}`
+
+func applyQuotas(t *testing.T, client *vault.MockClient, docPath string) {
+	qh, err := NewQuotasHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create Quotas handler: %s", err)
+	}
+	err = qh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "quotas"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+}
+
+func paths(calls []vault.MockCall) (p []string) {
+	for _, c := range calls {
+		p = append(p, c.Args[0].(string))
+	}
+	return p
+}
+
+func TestQuotas_Create(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/quotas/rate-limit/global.json": rateLimitQuota,
+	})
+
+	client := &vault.MockClient{}
+	applyQuotas(t, client, docPath)
+
+	expected := []string{"sys/quotas/rate-limit/global"}
+	if written := paths(client.CallsTo("Write")); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+	// only the quota types are listed for pruning
+	expected = []string{"sys/quotas/rate-limit"}
+	if listed := paths(client.CallsTo("List")); !reflect.DeepEqual(listed, expected) {
+		t.Errorf("Expected %+v to be listed, got %+v", expected, listed)
+	}
+}
+
+// Vault returns numbers as json.Number and intervals in seconds
+func TestQuotas_NoChange(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/quotas/rate-limit/global.json": rateLimitQuota,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"name":     "global",
+				"type":     "rate-limit",
+				"path":     "secret/",
+				"rate":     json.Number("100"),
+				"interval": json.Number("60"),
+			},
+		},
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"keys": []interface{}{"global"}},
+		},
+	}
+	applyQuotas(t, client, docPath)
+
+	if written := client.CallsTo("Write"); len(written) != 0 {
+		t.Errorf("Expected no writes, got %+v", written)
+	}
+}
+
+func TestQuotas_Update(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/quotas/rate-limit/global.json": rateLimitQuota,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"path":     "secret/",
+				"rate":     json.Number("50"),
+				"interval": json.Number("60"),
+			},
+		},
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{"keys": []interface{}{"global"}},
+		},
+	}
+	applyQuotas(t, client, docPath)
+
+	expected := []string{"sys/quotas/rate-limit/global"}
+	if written := paths(client.CallsTo("Write")); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected writes to %+v, got %+v", expected, written)
+	}
+}
+
+func TestQuotas_DeleteOrphan(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/quotas/rate-limit/global.json": rateLimitQuota,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"keys": []interface{}{"global", "orphan"},
+			},
+		},
+	}
+	applyQuotas(t, client, docPath)
+
+	expected := []string{"sys/quotas/rate-limit/orphan"}
+	if deleted := paths(client.CallsTo("Delete")); !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes of %+v, got %+v", expected, deleted)
+	}
+}
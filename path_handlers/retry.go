@@ -0,0 +1,40 @@
+package path_handlers
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"time"
+)
+
+// Delay before the first retry of a failed Vault call, doubled for each one after
+var retryDelay = time.Second
+
+// Call fn, retrying up to retries times if it fails, so that a momentary blip talking to Vault
+// doesn't abort the run. The last error is returned if every attempt fails, or straight away if
+// it isn't one a retry could fix.
+func withRetries(retries int, logger *log.Entry, fn func() error) error {
+	delay := retryDelay
+	err := fn()
+	for attempt := 1; err != nil && attempt <= retries && retryable(err); attempt++ {
+		logger.WithFields(log.Fields{
+			"attempt": attempt,
+			"error":   err,
+		}).Warnf("Vault call failed, retrying in %s", delay)
+		time.Sleep(delay)
+		delay *= 2
+		err = fn()
+	}
+	return err
+}
+
+// Whether the call may succeed if tried again: Vault couldn't be reached, or answered 5xx (e.g.
+// sealed, or a standby) or 429 (rate limited). Any other refusal, such as permission denied or a
+// bad request, would only fail the same way again.
+func retryable(err error) bool {
+	var re *vault.ResponseError
+	if !errors.As(err, &re) {
+		return true
+	}
+	return re.StatusCode >= 500 || re.StatusCode == 429
+}
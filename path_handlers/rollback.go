@@ -0,0 +1,71 @@
+package path_handlers
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// Rollback is shared by the handlers of an atomic run. Each change they make is recorded with how
+// to undo it, so that if a later one fails, the run can be rolled back. It is best effort: Vault
+// isn't transactional, and some changes, such as disabling an auth mount, can't be undone.
+type Rollback struct {
+	mu    sync.Mutex
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	resource string
+	action   string
+	undo     func() error // nil if the change can't be undone
+}
+
+// Record that action was made on resource, and how to undo it (nil if it can't be). Only the first
+// change to a resource is kept, as undoing it restores the resource to how it was before the run,
+// and it is only listed once as not undone.
+func (r *Rollback) record(resource string, action string, undo func() error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.steps {
+		if s.resource == resource && (s.undo != nil || undo == nil) {
+			return
+		}
+	}
+	r.steps = append(r.steps, rollbackStep{resource: resource, action: action, undo: undo})
+}
+
+// Undo the recorded changes, the most recent first, adding the outcome of each to summary. Every
+// step is attempted; the resources which couldn't be restored are returned in the error.
+func (r *Rollback) Run(summary *Summary) error {
+	r.mu.Lock()
+	steps := r.steps
+	r.steps = nil
+	r.mu.Unlock()
+
+	var failed []string
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		logger := log.WithFields(log.Fields{"resource": s.resource, "action": s.action})
+		if s.undo == nil {
+			logger.Warn("Change can't be rolled back")
+			summary.Skip(s.resource, "rollback", fmt.Sprintf("a %s can't be undone", s.action))
+			failed = append(failed, s.resource)
+			continue
+		}
+		logger.Info("Rolling back change")
+		err := s.undo()
+		if err != nil {
+			logger.Errorf("Could not roll back change: %s", err)
+			failed = append(failed, s.resource)
+		}
+		summary.Add(s.resource, "rollback", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not roll back %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package path_handlers
+
+import (
+	"errors"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A mount tuned after it is made is only unmounted, and a changed policy is put back
+func TestRollback_MountAndPolicy(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnPolicies: map[string]string{"read": `path "secret/*" { capabilities = ["read"] }`},
+	}
+	summary := &Summary{}
+	hc := PathHandlerConfig{Summary: summary, Rollback: &Rollback{}}
+
+	mh, err := NewSysMountsHandler(client, hc)
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+	err = mh.PutResource("sys/mounts/secret", strings.NewReader(
+		`{"type": "kv", "config": {"default_lease_ttl": "1h"}}`))
+	if err != nil {
+		t.Fatalf("Error applying mount: %s", err)
+	}
+	ph, err := NewACLPolicyHandler(client, hc)
+	if err != nil {
+		t.Fatalf("Failed to create SysPolicy: %s", err)
+	}
+	err = ph.PutResource("sys/policies/acl/read", strings.NewReader(
+		`{"policy": "path \"secret/*\" { capabilities = [\"list\"] }"}`))
+	if err != nil {
+		t.Fatalf("Error applying policy: %s", err)
+	}
+
+	client.CallLog = nil
+	if err := hc.Rollback.Run(summary); err != nil {
+		t.Fatalf("Error rolling back: %s", err)
+	}
+	var calls []string
+	for _, c := range client.CallLog {
+		calls = append(calls, c.Method+" "+c.Args[0].(string))
+	}
+	if expected := []string{"PutPolicy read", "Unmount secret/"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %+v, got %+v", expected, calls)
+	}
+	if restored := client.CallLog[0].Args[1]; restored != client.ReturnPolicies["read"] {
+		t.Errorf("Expected the live policy to be put back, got %q", restored)
+	}
+}
+
+// Changes which can't be undone are skipped and reported
+func TestRollback_Irreversible(t *testing.T) {
+	summary := &Summary{}
+	r := &Rollback{}
+	undone := false
+	r.record("sys/auth/approle/", "enable", func() error {
+		undone = true
+		return nil
+	})
+	r.record("secret/foo", "write", nil)
+
+	err := r.Run(summary)
+	if err == nil || !strings.Contains(err.Error(), "secret/foo") {
+		t.Errorf("Expected secret/foo not to be rolled back, got %v", err)
+	}
+	if !undone {
+		t.Errorf("Expected the enable to be undone")
+	}
+	expected := []SummaryRow{
+		{Resource: "secret/foo", Action: "rollback", Status: StatusSkipped, Error: "a write can't be undone"},
+		{Resource: "sys/auth/approle/", Action: "rollback", Status: StatusOK},
+	}
+	if !reflect.DeepEqual(summary.Rows, expected) {
+		t.Errorf("Expected rows %+v, got %+v", expected, summary.Rows)
+	}
+}
+
+// An auth mount disabled to recreate it is listed as not undone, even if enabling it again fails
+func TestRollback_RecreateDisable(t *testing.T) {
+	client := &flakyEnableAuthClient{
+		MockClient: &vault.MockClient{
+			ReturnAuthMounts: map[string]*vaultApi.AuthMount{"foo/": {Type: "userpass"}},
+		},
+		failures: 1,
+		err:      errors.New("permission denied"),
+	}
+	summary := &Summary{}
+	hc := PathHandlerConfig{Summary: summary, Rollback: &Rollback{}, AllowRecreate: true}
+	sh, err := NewSysAuthHandler(client, hc)
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.ensureAuth("foo/", vaultApi.EnableAuthOptions{Type: "ldap"}, nil); err == nil {
+		t.Fatal("Expected enabling the mount again to fail")
+	}
+
+	err = hc.Rollback.Run(summary)
+	if err == nil || !strings.Contains(err.Error(), "sys/auth/foo/") {
+		t.Errorf("Expected sys/auth/foo/ not to be rolled back, got %v", err)
+	}
+	expected := SummaryRow{Resource: "sys/auth/foo/", Action: "rollback", Status: StatusSkipped,
+		Error: "a disable can't be undone"}
+	if last := summary.Rows[len(summary.Rows)-1]; last != expected {
+		t.Errorf("Expected row %+v, got %+v", expected, last)
+	}
+}
+
+// A kv upgrade is listed as not undone, though the config tuned with it is put back
+func TestRollback_KvUpgrade(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"secret/": {Type: "kv", Options: map[string]string{"version": "1"}},
+		},
+	}
+	summary := &Summary{}
+	hc := PathHandlerConfig{Summary: summary, Rollback: &Rollback{}}
+	sh, err := NewSysMountsHandler(client, hc)
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+	err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv", "options": {"version": "2"}}`))
+	if err != nil {
+		t.Fatalf("Error applying mount: %s", err)
+	}
+
+	if err := hc.Rollback.Run(summary); err == nil {
+		t.Errorf("Expected the kv upgrade not to be rolled back")
+	}
+	expected := []SummaryRow{
+		{Resource: "sys/mounts/secret", Action: "tune", Status: StatusOK},
+		{Resource: "sys/mounts/secret", Action: "rollback", Status: StatusOK},
+		{Resource: "sys/mounts/secret", Action: "rollback", Status: StatusSkipped, Error: "a kv upgrade can't be undone"},
+	}
+	if !reflect.DeepEqual(summary.Rows, expected) {
+		t.Errorf("Expected rows %+v, got %+v", expected, summary.Rows)
+	}
+}
+
+// A pruned document is written back with the data it had
+func TestRollback_GenericDelete(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"secret/a.json": `{"value": "a"}`})
+	client := &vault.MockClient{
+		ReturnListSecret: &vaultApi.Secret{Data: map[string]interface{}{"keys": []interface{}{"a", "old"}}},
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"secret/a":   {Data: map[string]interface{}{"value": "a"}},
+			"secret/old": {Data: map[string]interface{}{"value": "old"}},
+		},
+	}
+	summary := &Summary{}
+	hc := PathHandlerConfig{DocumentPath: docPath, Summary: summary, Rollback: &Rollback{}}
+	gh, err := NewGeneric(client, hc)
+	if err != nil {
+		t.Fatalf("Failed to create Generic: %s", err)
+	}
+	if err := gh.PutPoliciesFromDir(filepath.Join(docPath, "secret")); err != nil {
+		t.Fatalf("Error applying documents: %s", err)
+	}
+	if calls := client.CallsTo("Delete"); len(calls) != 1 || calls[0].Args[0] != "secret/old" {
+		t.Fatalf("Expected secret/old to be deleted, got %+v", calls)
+	}
+
+	client.CallLog = nil
+	if err := hc.Rollback.Run(summary); err != nil {
+		t.Fatalf("Error rolling back: %s", err)
+	}
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "secret/old" {
+		t.Fatalf("Expected secret/old to be written back, got %+v", client.CallLog)
+	}
+	if data := writes[0].Args[1].(map[string]interface{}); data["value"] != "old" {
+		t.Errorf("Expected the live data to be written back, got %+v", data)
+	}
+}
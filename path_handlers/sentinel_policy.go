@@ -0,0 +1,26 @@
+package path_handlers
+
+import (
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+/*
+	SentinelPolicy handles the role and endpoint governing policies of Vault Enterprise, defined in
+	sys/policies/rgp/<name>.json and sys/policies/egp/<name>.json, e.g.
+		{"policy": "main = rule { true }", "enforcement_level": "soft-mandatory"}
+	These are written in the same way as the Generic handler, and undeclared policies in the handled
+	directory are removed. Vault without Enterprise has no such endpoints, so writes fail there.
+*/
+type SentinelPolicy struct {
+	*Generic
+}
+
+func NewSentinelPolicyHandler(client vault.Vault, config PathHandlerConfig) (*SentinelPolicy, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &SentinelPolicy{}, err
+	}
+	gh.name = "SentinelPolicy"
+	gh.log = handlerLogger("SentinelPolicy", config)
+	return &SentinelPolicy{Generic: gh}, nil
+}
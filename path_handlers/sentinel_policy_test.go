@@ -0,0 +1,68 @@
+package path_handlers
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const businessHoursPolicy = `{"policy": "main = rule { true }", "enforcement_level": "soft-mandatory"}`
+
+func TestSentinelPolicy_RGP(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/policies/rgp/business_hours.json": businessHoursPolicy,
+	})
+
+	client := &vault.MockClient{
+		ReturnListSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"keys": []interface{}{"business_hours", "orphan"},
+			},
+		},
+	}
+	sh, err := NewSentinelPolicyHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create SentinelPolicy handler: %s", err)
+	}
+	err = sh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "policies", "rgp"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "sys/policies/rgp/business_hours" {
+		t.Fatalf("Expected a write to sys/policies/rgp/business_hours, got %+v", writes)
+	}
+	expected := map[string]interface{}{"policy": "main = rule { true }", "enforcement_level": "soft-mandatory"}
+	if data := writes[0].Args[1]; !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %+v to be written, got %+v", expected, data)
+	}
+	if deleted := paths(client.CallsTo("Delete")); !reflect.DeepEqual(deleted, []string{"sys/policies/rgp/orphan"}) {
+		t.Errorf("Expected the undeclared policy to be deleted, got %+v", deleted)
+	}
+}
+
+// Without Enterprise, Vault has no Sentinel policy endpoints
+func TestSentinelPolicy_NotEnterprise(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/policies/rgp/business_hours.json": businessHoursPolicy,
+	})
+
+	client := &vault.MockClient{
+		WriteErrors: map[string]error{
+			"sys/policies/rgp/business_hours": fmt.Errorf("Code: 404. Errors:\n\n* 1 error occurred:\n\t* unsupported path"),
+		},
+	}
+	sh, err := NewSentinelPolicyHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create SentinelPolicy handler: %s", err)
+	}
+	err = sh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "policies", "rgp"))
+	if err == nil {
+		t.Error("Expected an error writing an RGP policy to Vault without Enterprise")
+	}
+}
@@ -0,0 +1,180 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+	StatusPlanned = "planned" // in a dry run, the change would be made
+)
+
+// The action of a resource which a dry run found would not change
+const ActionUnchanged = "unchanged"
+
+// A change made (or attempted) to a single resource
+type SummaryRow struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// The difference between Vault and the document of a changed resource, as a unified diff
+type ResourceDiff struct {
+	Resource string `json:"resource"`
+	Diff     string `json:"diff"`
+}
+
+// How long a single operation against Vault took, e.g. enabling an auth mount
+type Timing struct {
+	Resource  string        `json:"resource"`
+	Operation string        `json:"operation"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// The duration is given in seconds, e.g. 0.25, rather than as a count of nanoseconds
+func (t Timing) MarshalJSON() ([]byte, error) {
+	type timing Timing
+	return json.Marshal(struct {
+		timing
+		Duration float64 `json:"duration"`
+	}{timing(t), t.Duration.Seconds()})
+}
+
+// Summary collects the changes made by all handlers in a run, so they can be reported at the end,
+// and how long each operation they made against Vault took. A nil *Summary discards everything
+// added to it.
+//
+// In a dry run, the rows are the plan: nothing is written, but everything is compared with what is
+// live in Vault, so each change is listed as planned, and each resource which wouldn't change is
+// listed as unchanged.
+type Summary struct {
+	mu      sync.Mutex
+	Dry     bool           `json:"dry,omitempty"`
+	Rows    []SummaryRow   `json:"rows"`
+	Timings []Timing       `json:"timings,omitempty"`
+	Diffs   []ResourceDiff `json:"diffs,omitempty"`
+}
+
+// Record the outcome of action on resource; err is nil if it succeeded
+func (s *Summary) Add(resource string, action string, err error) {
+	row := SummaryRow{Resource: resource, Action: action, Status: StatusOK}
+	if err != nil {
+		row.Status = StatusFailed
+		row.Error = err.Error()
+	} else if s != nil && s.Dry {
+		row.Status = StatusPlanned
+	}
+	s.add(row)
+}
+
+// Record that resource matches its document. Only dry runs list these, to complete the plan.
+func (s *Summary) Unchanged(resource string) {
+	if s == nil || !s.Dry {
+		return
+	}
+	s.add(SummaryRow{Resource: resource, Action: ActionUnchanged, Status: StatusOK})
+}
+
+// Record that action on resource was not attempted, and why
+func (s *Summary) Skip(resource string, action string, reason string) {
+	s.add(SummaryRow{Resource: resource, Action: action, Status: StatusSkipped, Error: reason})
+}
+
+func (s *Summary) add(row SummaryRow) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Rows = append(s.Rows, row)
+}
+
+// Record the difference between Vault and the document of resource, which is changed
+func (s *Summary) AddDiff(resource string, diff string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Diffs = append(s.Diffs, ResourceDiff{Resource: resource, Diff: diff})
+}
+
+// Record that operation on resource took d
+func (s *Summary) Time(resource string, operation string, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Timings = append(s.Timings, Timing{Resource: resource, Operation: operation, Duration: d})
+}
+
+// Return the n slowest operations, slowest first
+func (s *Summary) Slowest(n int) []Timing {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sorted := append([]Timing(nil), s.Timings...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// Return the rows which failed
+func (s *Summary) Failed() (failed []SummaryRow) {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.Rows {
+		if r.Status == StatusFailed {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Write the summary to w as a table
+func (s *Summary) Render(w io.Writer) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tACTION\tSTATUS\tERROR")
+	for _, r := range s.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Resource, r.Action, r.Status, r.Error)
+	}
+	return tw.Flush()
+}
+
+// Write the diffs to w, one after the other, as diff -u would for several files
+func (s *Summary) RenderDiffs(w io.Writer) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.Diffs {
+		if _, err := io.WriteString(w, d.Diff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package path_handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A failed write is recorded and the remaining documents still applied
+func TestSummary_MixedRunContinueOnError(t *testing.T) {
+	docPath, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(docPath)
+	dir := filepath.Join(docPath, "secret")
+	os.MkdirAll(dir, 0755)
+	for _, name := range []string{"a", "b", "c"} {
+		ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(`{"foo": "bar"}`), 0644)
+	}
+
+	client := &vault.MockClient{
+		WriteErrors: map[string]error{"secret/b": errors.New("permission denied")},
+	}
+	summary := &Summary{}
+	gh, err := NewGeneric(client, PathHandlerConfig{
+		DocumentPath:    docPath,
+		Summary:         summary,
+		ContinueOnError: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create generic handler: %s", err)
+	}
+
+	err = gh.PutPoliciesFromDir(dir)
+	if err != nil {
+		t.Fatalf("Expected errors to be collected, got %s", err)
+	}
+
+	expected := []SummaryRow{
+		{Resource: "secret/a", Action: "write", Status: StatusOK},
+		{Resource: "secret/b", Action: "write", Status: StatusFailed, Error: "permission denied"},
+		{Resource: "secret/c", Action: "write", Status: StatusOK},
+	}
+	if !reflect.DeepEqual(summary.Rows, expected) {
+		t.Errorf("Expected summary rows %+v, got %+v", expected, summary.Rows)
+	}
+	if failed := summary.Failed(); len(failed) != 1 {
+		t.Errorf("Expected 1 failed row, got %+v", failed)
+	}
+}
+
+// Without ContinueOnError the first failure stops the handler
+func TestSummary_StopsOnError(t *testing.T) {
+	client := &vault.MockClient{
+		WriteErrors: map[string]error{"foo": errors.New("boom")},
+	}
+	summary := &Summary{}
+	sph, err := NewSysPolicyHandler(client, PathHandlerConfig{Summary: summary})
+	if err != nil {
+		t.Fatalf("Failed to create policy handler: %s", err)
+	}
+
+	err = sph.EnsurePolicy(policy{Name: "foo", Policy: "path \"*\" {}"})
+	if err == nil {
+		t.Errorf("Expected an error from EnsurePolicy")
+	}
+	expected := []SummaryRow{
+		{Resource: "sys/policy/foo", Action: "write", Status: StatusFailed, Error: "boom"},
+	}
+	if !reflect.DeepEqual(summary.Rows, expected) {
+		t.Errorf("Expected summary rows %+v, got %+v", expected, summary.Rows)
+	}
+}
+
+func TestSummary_Render(t *testing.T) {
+	summary := &Summary{}
+	summary.Add("secret/a", "write", nil)
+	summary.Add("sys/auth/aws/", "enable", errors.New("boom"))
+
+	var buf bytes.Buffer
+	summary.Render(&buf)
+	expected := strings.Join([]string{
+		"RESOURCE       ACTION  STATUS  ERROR",
+		"secret/a       write   ok      ",
+		"sys/auth/aws/  enable  failed  boom",
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("Expected table:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// Adding to a nil summary is a no-op
+func TestSummary_Nil(t *testing.T) {
+	var summary *Summary
+	summary.Add("secret/a", "write", nil)
+	if failed := summary.Failed(); failed != nil {
+		t.Errorf("Expected no failures, got %+v", failed)
+	}
+}
+
+func TestSummary_Slowest(t *testing.T) {
+	s := &Summary{}
+	s.Time("secret/a", "write", 2*time.Millisecond)
+	s.Time("sys/auth/approle/", "enable", 5*time.Millisecond)
+	s.Time("secret/b", "read", time.Millisecond)
+
+	expected := []Timing{
+		{Resource: "sys/auth/approle/", Operation: "enable", Duration: 5 * time.Millisecond},
+		{Resource: "secret/a", Operation: "write", Duration: 2 * time.Millisecond},
+	}
+	if slowest := s.Slowest(2); !reflect.DeepEqual(slowest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, slowest)
+	}
+
+	var nilSummary *Summary
+	nilSummary.Time("secret/a", "write", time.Millisecond)
+	if slowest := nilSummary.Slowest(1); slowest != nil {
+		t.Errorf("Expected nothing from a nil summary, got %+v", slowest)
+	}
+}
+
+// Timings are given in seconds in JSON
+func TestTiming_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(Timing{Resource: "secret/a", Operation: "write", Duration: 250 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error marshalling timing: %s", err)
+	}
+	expected := `{"resource":"secret/a","operation":"write","duration":0.25}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+}
+
+// In a dry run, changes are planned and unchanged resources are listed too
+func TestSummary_Dry(t *testing.T) {
+	s := &Summary{Dry: true}
+	s.Add("sys/auth/github/", "enable", nil)
+	s.Add("sys/auth/ldap/", "disable", errors.New("permission denied"))
+	s.Unchanged("sys/auth/userpass/")
+
+	expected := []SummaryRow{
+		{Resource: "sys/auth/github/", Action: "enable", Status: StatusPlanned},
+		{Resource: "sys/auth/ldap/", Action: "disable", Status: StatusFailed, Error: "permission denied"},
+		{Resource: "sys/auth/userpass/", Action: ActionUnchanged, Status: StatusOK},
+	}
+	if !reflect.DeepEqual(s.Rows, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s.Rows)
+	}
+
+	s = &Summary{}
+	s.Unchanged("sys/auth/userpass/")
+	if len(s.Rows) != 0 {
+		t.Errorf("Expected unchanged resources to be left out of real runs, got %+v", s.Rows)
+	}
+}
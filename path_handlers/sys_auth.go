@@ -1,14 +1,16 @@
 package path_handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/vault"
-	"os"
-	"path/filepath"
+	"io"
+	"io/fs"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -17,6 +19,15 @@ import (
 	SysAuth handles the creation/enabling of auth methods and policies, described in the
 	configuration under sys.
 
+	The "config" of a sys/auth document is the mount's tuning, which is changed in place. The
+	settings of the auth method itself, such as an LDAP url or bound CIDRs, live at
+	auth/<path>/config instead, so go in auth/<path>/config.json. That is written (and diffed)
+	on its own by the handler for auth/<path>, after the method is enabled, so changing it never
+	re-enables or tunes the mount.
+
+	Mounts may also be listed together in sys/auth/_index.json (AuthIndexFile), as an object of
+	mount path -> document, which are applied as though each were in a file of its own.
+
 	Currently it does not support templating, as I didn't see a need for it, but there's no reason
 	it couldn't.
 */
@@ -27,11 +38,23 @@ type SysAuth struct {
 }
 
 func NewSysAuthHandler(client vault.Vault, config PathHandlerConfig) (*SysAuth, error) {
+	logger := handlerLogger("SysAuth", config)
+
 	// Build a map of currently active auth methods, so walkFile() can reference it
-	liveAuthMap, err := client.ListAuth()
+	var listedAuthMap map[string]*vaultApi.AuthMount
+	err := timed(config.Summary, logger, "sys/auth", "list", func() error {
+		return withRetries(config.Retries, logger, func() (err error) {
+			listedAuthMap, err = client.ListAuth()
+			return err
+		})
+	})
 	if err != nil {
 		return &SysAuth{}, err
 	}
+	liveAuthMap := make(map[string]*vaultApi.AuthMount)
+	for path, authMount := range listedAuthMap {
+		liveAuthMap[mountPath(path)] = authMount
+	}
 
 	// Create a mapping of configured auth methods, which we append to as we go,
 	// so we can disable those that are missing at the end
@@ -42,16 +65,15 @@ func NewSysAuthHandler(client vault.Vault, config PathHandlerConfig) (*SysAuth,
 			name:   "SysAuth",
 			client: client,
 			config: config,
-			log: log.WithFields(log.Fields{
-				"handler": "SysAuth",
-			}),
+			order:  config.Order,
+			log:    logger,
 		},
 		liveAuthMap:       liveAuthMap,
 		configuredAuthMap: configuredAuthMap,
 	}, nil
 }
 
-func (sh *SysAuth) walkFile(path string, f os.FileInfo, err error) error {
+func (sh *SysAuth) walkFile(path string, f fs.DirEntry, err error) error {
 	if f == nil {
 		logger := sh.log.WithFields(log.Fields{"path": path, "error": err})
 		logger.Debug("Path does not exist, skipping")
@@ -60,12 +82,19 @@ func (sh *SysAuth) walkFile(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return fmt.Errorf("error reading %s: %s", path, err)
 	}
+	if sh.ignored(path, f) {
+		return skipIgnored(f)
+	}
 	// not doing anything with dirs
 	if f.IsDir() {
 		return nil
 	}
 
-	policyPath, err := apiPath(sh.config.DocumentPath, path)
+	if f.Name() == AuthIndexFile {
+		return sh.walkIndex(path)
+	}
+
+	policyPath, err := sh.resourcePath(path)
 	if err != nil {
 		return err
 	}
@@ -73,39 +102,143 @@ func (sh *SysAuth) walkFile(path string, f os.FileInfo, err error) error {
 		return fmt.Errorf("found file without sys/auth prefix: %s", policyPath)
 	}
 
-	fileContents, err := sh.readFile(path)
+	file, err := sh.openFile(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+	return sh.applyDocument(path, policyPath, file)
+}
 
+// Ensure each auth mount listed in the index file at path, as though each were in its own file
+func (sh *SysAuth) walkIndex(path string) error {
+	content, err := sh.readFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %q: %s", path, err)
+	}
+	index, err := ParseAuthIndex([]byte(content))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for _, mount := range sortedKeysOf(index) {
+		name := fmt.Sprintf("%s entry %q", path, mount)
+		err := sh.applyDocument(name, "sys/auth/"+mount, bytes.NewReader(index[mount]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode the document named name of the auth mount at policyPath, e.g. "sys/auth/approle", from
+// r, and ensure it unless its annotations disable it
+func (sh *SysAuth) applyDocument(name string, policyPath string, r io.Reader) error {
+	sysAuthPath := mountPath(strings.TrimPrefix(policyPath, "sys/auth/"))
 	var enableOpts vaultApi.EnableAuthOptions
-	err = json.Unmarshal([]byte(fileContents), &enableOpts)
+	annotations, configKeys, err := sh.decodeMountReader(name, r, &enableOpts)
 	if err != nil {
-		return fmt.Errorf("could not parse json from file %s: %s", path, err)
+		return authConfigHint(sysAuthPath, err)
 	}
 
-	sysAuthPath := strings.TrimPrefix(policyPath, "sys/auth/") + "/"
-	err = sh.ensureAuth(sysAuthPath, enableOpts)
+	enabled, err := sh.enabled(policyPath, annotations)
 	if err != nil {
-		return fmt.Errorf("error while ensuring auth for path %s: %s", path, err)
+		return err
+	}
+	if !enabled {
+		if !annotations.PruneWhenDisabled {
+			// still declared, so not disabled
+			sh.configuredAuthMap[sysAuthPath] = &vaultApi.AuthMount{Type: enableOpts.Type}
+		}
+		return nil
+	}
+	err = sh.ensureAuth(sysAuthPath, enableOpts, configKeys)
+	if err != nil {
+		return fmt.Errorf("error while ensuring auth for path %s: %s", name, err)
 	}
 
 	return nil
 }
 
+// The file in sys/auth listing any number of auth mounts, as an object of mount path -> document
+const AuthIndexFile = "_index.json"
+
+// Parse the content of an AuthIndexFile, returning the document of each mount by its path
+func ParseAuthIndex(content []byte) (map[string]json.RawMessage, error) {
+	var index map[string]json.RawMessage
+	err := json.Unmarshal(content, &index)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the auth index, expected an object of mount "+
+			"path -> document: %s", err)
+	}
+	clean := make(map[string]json.RawMessage, len(index))
+	for mount, doc := range index {
+		path := strings.Trim(mount, "/")
+		if path == "" {
+			return nil, fmt.Errorf("invalid mount path %q in the auth index", mount)
+		}
+		if _, ok := clean[path]; ok {
+			return nil, fmt.Errorf("mount path %s is listed twice in the auth index", path)
+		}
+		clean[path] = doc
+	}
+	return clean, nil
+}
+
+func sortedKeysOf(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return sortedStrings(keys)
+}
+
+// Apply a single auth mount, e.g. resourcePath "sys/auth/approle"
+func (sh *SysAuth) PutResource(resourcePath string, r io.Reader) error {
+	resourcePath = normalizePath(resourcePath)
+	if !strings.HasPrefix(resourcePath, "sys/auth/") {
+		return fmt.Errorf("resource path %s does not have sys/auth prefix", resourcePath)
+	}
+
+	var enableOpts vaultApi.EnableAuthOptions
+	annotations, configKeys, err := sh.decodeMountReader(resourcePath, r, &enableOpts)
+	if err != nil {
+		return authConfigHint(strings.TrimPrefix(resourcePath, "sys/auth/"), err)
+	}
+	if enabled, err := sh.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+
+	return sh.ensureAuth(strings.TrimPrefix(resourcePath, "sys/auth/"), enableOpts, configKeys)
+}
+
+// An unknown field is most likely a setting of the auth method, put in the mount's tuning
+func authConfigHint(path string, err error) error {
+	if !strings.Contains(err.Error(), "unknown field") {
+		return err
+	}
+	return fmt.Errorf("%s; the settings of the auth method itself, rather than the tuning of its "+
+		"mount, go in auth/%s/config.json", err, strings.TrimSuffix(path, "/"))
+}
+
 func (sh *SysAuth) PutPoliciesFromDir(path string) error {
-	err := filepath.Walk(path, sh.walkFile)
+	err := sh.walk(path, sh.walkFile)
 	if err != nil {
 		return err
 	}
 	return sh.DisableUnconfiguredAuths()
 }
 
-// Ensure that this auth type is enabled and has the correct configuration
-func (sh *SysAuth) ensureAuth(path string, enableOpts vaultApi.EnableAuthOptions) error {
+// Ensure that this auth type is enabled and has the correct configuration. Only the config fields
+// in configKeys are compared with the live mount, or all of them if it is nil.
+func (sh *SysAuth) ensureAuth(path string, enableOpts vaultApi.EnableAuthOptions, configKeys map[string]bool) (err error) {
+	path = mountPath(path)
+	if sh.ignoredResource("sys/auth/" + path) {
+		return nil
+	}
+
 	// we need to convert to AuthConfigOutput in order to compare with existing config
 	var enableOptsAuthConfigOutput vaultApi.AuthConfigOutput
-	enableOptsAuthConfigOutput, err := ConvertAuthConfig(enableOpts.Config)
+	enableOptsAuthConfigOutput, err = ConvertAuthConfig(enableOpts.Config)
 	if err != nil {
 		return err
 	}
@@ -115,6 +248,10 @@ func (sh *SysAuth) ensureAuth(path string, enableOpts vaultApi.EnableAuthOptions
 		Config: enableOptsAuthConfigOutput,
 	}
 	sh.configuredAuthMap[path] = &authMount
+	if sh.appliedEarlier("sys/auth/" + path) {
+		return nil
+	}
+	defer func() { sh.recordApplied("sys/auth/"+path, err) }()
 
 	logger := sh.log.WithFields(log.Fields{
 		"mount path":     path,
@@ -122,55 +259,239 @@ func (sh *SysAuth) ensureAuth(path string, enableOpts vaultApi.EnableAuthOptions
 	})
 
 	if liveAuth, ok := sh.liveAuthMap[path]; ok {
+		if liveAuth.Type != enableOpts.Type {
+			// Vault cannot change the type of a mount in place
+			return sh.recreateAuth(path, liveAuth, enableOpts, configKeys, fmt.Sprintf(
+				"is of type %q but is configured as %q", liveAuth.Type, enableOpts.Type))
+		}
+		if liveAuth.Local != enableOpts.Local {
+			// nor whether it is replicated
+			return sh.recreateAuth(path, liveAuth, enableOpts, configKeys, fmt.Sprintf(
+				"has local set to %t but is configured with %t", liveAuth.Local, enableOpts.Local))
+		}
+		if liveAuth.SealWrap != enableOpts.SealWrap {
+			// or seal wrapped, in Vault Enterprise
+			return sh.recreateAuth(path, liveAuth, enableOpts, configKeys, fmt.Sprintf(
+				"has seal_wrap set to %t but is configured with %t", liveAuth.SealWrap,
+				enableOpts.SealWrap))
+		}
 		// If this path is present in our live config, we may not need to enable
-		err, applied := sh.isConfigApplied(enableOpts.Config, liveAuth.Config)
+		err, applied := sh.isConfigApplied(enableOpts.Config, liveAuth.Config, configKeys)
 		if err != nil {
 			return fmt.Errorf(
 				"could not determine whether configuration for auth mount %s was applied: %s",
 				enableOpts.Type, err)
 		}
-		if applied {
+		// the description isn't part of the config, but can be tuned along with it
+		descriptionApplied := liveAuth.Description == enableOpts.Description
+		if applied && descriptionApplied {
 			logger.Debugf("Auth mount configuration already applied")
+			sh.unchanged("sys/auth/" + path)
 			return nil
 		}
+		// any error converting was returned by isConfigApplied
+		converted, _ := ConvertAuthConfig(enableOpts.Config)
+		configuredFields := map[string]interface{}{"config": configFields(converted, configKeys), "description": enableOpts.Description}
+		liveFields := map[string]interface{}{"config": configFields(liveAuth.Config, configKeys), "description": liveAuth.Description}
+		sh.explain("sys/auth/"+path, diffFields(configuredFields, liveFields))
+		sh.diff("sys/auth/"+path, configuredFields, liveFields)
+		// Already enabled, so the configuration can be tuned in place
+		restore := liveTuneConfig(liveAuth.Config, liveAuth.Description)
+		tuneConfig := authTuneConfig(enableOpts.Config)
+		if sh.config.MergeTuning {
+			tuneConfig = mergeTuneConfig(tuneConfig, restore, configKeys)
+		}
+		tuneConfig = declaredHeaders(tuneConfig, configKeys)
+		if !descriptionApplied {
+			logger = logger.WithFields(log.Fields{
+				"live description":       liveAuth.Description,
+				"configured description": enableOpts.Description,
+			})
+			tuneConfig.Description = &enableOpts.Description
+		}
+		logger.Infof("Tuning auth mount")
+		err = sh.timed("sys/auth/"+path, "tune", func() error {
+			return sh.client.TuneMount("auth/"+path, tuneConfig)
+		})
+		if err != nil {
+			err = fmt.Errorf("could not tune auth %s: %s", path, err)
+		}
+		sh.onRollback(func() error {
+			return sh.client.TuneMount("auth/"+path, restore)
+		})
+		if err == nil {
+			err = sh.verify("sys/auth/"+path, "tune", func() error {
+				return sh.verifyAuth(path, enableOpts, configKeys)
+			})
+		}
+		return sh.result("sys/auth/"+path, "tune", err)
 	}
 	logger.Infof("Applying auth mount")
-	err = sh.client.EnableAuth(path, &enableOpts)
+	sh.diff("sys/auth/"+path, enableOpts, nil)
+	err = sh.timed("sys/auth/"+path, "enable", func() error {
+		return sh.client.EnableAuth(path, &enableOpts)
+	})
 	if err != nil {
-		return fmt.Errorf("could not enable auth %s: %s", path, err)
+		err = fmt.Errorf("could not enable auth %s: %s", path, err)
+	}
+	sh.onRollback(func() error {
+		return sh.client.DisableAuth(path)
+	})
+	if err == nil {
+		err = sh.verify("sys/auth/"+path, "enable", func() error {
+			return sh.verifyAuth(path, enableOpts, configKeys)
+		})
+	}
+	return sh.result("sys/auth/"+path, "enable", err)
+}
+
+// Disable the live auth mount at path and enable it again as configured, as the difference given
+// by reason can't be changed in place. This is destructive, so is only done if explicitly allowed
+func (sh *SysAuth) recreateAuth(path string, liveAuth *vaultApi.AuthMount, enableOpts vaultApi.EnableAuthOptions, configKeys map[string]bool, reason string) error {
+	logger := sh.log.WithFields(log.Fields{
+		"mount path":      path,
+		"live type":       liveAuth.Type,
+		"configured type": enableOpts.Type,
+		"reason":          reason,
+	})
+	if !sh.config.AllowRecreate {
+		return sh.result("sys/auth/"+path, "recreate", fmt.Errorf("auth mount %s %s; Vault cannot "+
+			"change this in place. It must be disabled and enabled again, which "+
+			"destroys all data and leases under it. Use --allow-recreate to permit this",
+			path, reason))
+	}
+
+	logger.Warn("Auth mount can't be changed in place, RECREATING mount. All data and leases under " +
+		"this mount will be lost!")
+	err := sh.timed("sys/auth/"+path, "disable", func() error {
+		return sh.client.DisableAuth(path)
+	})
+	if err != nil {
+		err = fmt.Errorf("could not disable auth %s for recreation: %s", path, err)
+	} else {
+		sh.irreversible("sys/auth/"+path, "disable")
+		err = sh.timed("sys/auth/"+path, "enable", func() error {
+			return sh.client.EnableAuth(path, &enableOpts)
+		})
+		if err != nil {
+			err = fmt.Errorf("could not enable auth %s after disabling it for recreation: %s", path, err)
+		} else {
+			err = sh.verify("sys/auth/"+path, "recreate", func() error {
+				return sh.verifyAuth(path, enableOpts, configKeys)
+			})
+		}
+	}
+	return sh.result("sys/auth/"+path, "recreate", err)
+}
+
+// Read the auth mount at path back from Vault, returning an error unless it is as configured
+func (sh *SysAuth) verifyAuth(path string, enableOpts vaultApi.EnableAuthOptions, configKeys map[string]bool) error {
+	var listed map[string]*vaultApi.AuthMount
+	err := sh.timed("sys/auth/"+path, "verify", func() (err error) {
+		listed, err = sh.client.ListAuth()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not list auth mounts to verify %s: %s", path, err)
+	}
+	var live *vaultApi.AuthMount
+	for p, authMount := range listed {
+		if mountPath(p) == path {
+			live = authMount
+		}
+	}
+	if live == nil {
+		return fmt.Errorf("auth %s is not enabled after writing it; Vault did not keep the change", path)
+	}
+	err, applied := sh.isConfigApplied(enableOpts.Config, live.Config, configKeys)
+	if err != nil {
+		return fmt.Errorf("could not verify auth %s: %s", path, err)
+	}
+	if live.Type != enableOpts.Type || live.Description != enableOpts.Description || !applied {
+		return fmt.Errorf("auth %s does not match its document after writing it; Vault did not "+
+			"keep the change", path)
 	}
 	return nil
 }
 
 func (sh *SysAuth) DisableUnconfiguredAuths() error {
-	// delete entries not in configured list
-	for path, authMount := range sh.liveAuthMap {
+	// delete entries not in configured list, in order of path so runs are reproducible
+	paths := make([]string, 0, len(sh.liveAuthMap))
+	for path := range sh.liveAuthMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		authMount := sh.liveAuthMap[path]
 		logger := log.WithFields(log.Fields{"authMount.Type": authMount.Type, "path": path})
 		if _, ok := sh.configuredAuthMap[path]; ok {
 			logger.Debugf("Not disabling auth mount, is configured")
 			continue // present, do nothing
 		} else if authMount.Type == "token" {
 			continue // cannot be disabled, would give http 400 if attempted
+		} else if sh.ignoredResource("sys/auth/" + path) {
+			continue
+		} else if !sh.owns(path) {
+			logger.Debugf("Not disabling undeclared auth mount, its path is not owned")
+			continue
+		} else if !sh.mayDisable(authMount.Type) {
+			logger.Warn("Not disabling undeclared auth mount, its type may not be disabled")
+			continue
 		} else {
 			logger.Infof("Disabling auth mount")
-			err := sh.client.DisableAuth(path)
+			err := sh.timed("sys/auth/"+path, "disable", func() error {
+				return sh.client.DisableAuth(path)
+			})
 			if err != nil {
-				return fmt.Errorf("failed to disable authMount at %s: %s", path, err)
+				err = fmt.Errorf("failed to disable authMount at %s: %s", path, err)
+			}
+			if err := sh.result("sys/auth/"+path, "disable", err); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-// return true if the localConfig is reflected in remoteConfig, else false
-func (sh *SysAuth) isConfigApplied(localConfig vaultApi.AuthConfigInput, remoteConfig vaultApi.AuthConfigOutput) (error, bool) {
+// Whether undeclared auth mounts of this type may be disabled, as given by DisableAuthTypes
+func (sh *SysAuth) mayDisable(authType string) bool {
+	if len(sh.config.DisableAuthTypes) == 0 {
+		return true
+	}
+	for _, t := range sh.config.DisableAuthTypes {
+		if t == authType {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the auth mount at path is managed by this configuration, as given by OwnedAuthPaths.
+// Whole segments are matched, so owning team-a includes team-a/approle but not team-ab.
+func (sh *SysAuth) owns(path string) bool {
+	if len(sh.config.OwnedAuthPaths) == 0 {
+		return true
+	}
+	p := strings.Trim(path, "/")
+	for _, prefix := range sh.config.OwnedAuthPaths {
+		prefix = strings.Trim(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// return true if the localConfig is reflected in remoteConfig, else false. Only the fields in keys
+// are compared, or all of them if it is nil
+func (sh *SysAuth) isConfigApplied(localConfig vaultApi.AuthConfigInput, remoteConfig vaultApi.AuthConfigOutput, keys map[string]bool) (error, bool) {
 	// AuthConfigInput uses different types for TTL, which need to be converted
 	converted, err := ConvertAuthConfig(localConfig)
 	if err != nil {
 		return err, false
 	}
 
-	if reflect.DeepEqual(converted, remoteConfig) {
+	if reflect.DeepEqual(configFields(converted, keys), configFields(remoteConfig, keys)) {
 		return nil, true
 	} else {
 		return nil, false
@@ -181,10 +502,113 @@ func (sh *SysAuth) Order() int {
 	return sh.order
 }
 
+// Vault does not care about the order of header and key lists, nor whether an empty one is nil, so
+// sort them to avoid reporting false drift
+func normalizeAuthConfig(config vaultApi.AuthConfigOutput) vaultApi.AuthConfigOutput {
+	config.AuditNonHMACRequestKeys = sortedStrings(config.AuditNonHMACRequestKeys)
+	config.AuditNonHMACResponseKeys = sortedStrings(config.AuditNonHMACResponseKeys)
+	config.PassthroughRequestHeaders = sortedStrings(config.PassthroughRequestHeaders)
+	return config
+}
+
+// The normalized config as encoded in json, with only the fields in keys, or all of them if keys is
+// nil. Vault fills in the fields a document leaves out with its defaults, so they aren't compared.
+func configFields(config vaultApi.AuthConfigOutput, keys map[string]bool) interface{} {
+	fields := toJsonValue(normalizeAuthConfig(config))
+	all, ok := fields.(map[string]interface{})
+	if keys == nil || !ok {
+		return fields
+	}
+	out := make(map[string]interface{})
+	for k, v := range all {
+		if keys[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Return a sorted copy of in, or nil if it is empty
+func sortedStrings(in []string) []string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]string, len(in))
+	copy(out, in)
+	sort.Strings(out)
+	return out
+}
+
+// Convert the configuration given when enabling an auth mount to that used to tune it
+func authTuneConfig(input vaultApi.AuthConfigInput) vaultApi.MountConfigInput {
+	return vaultApi.MountConfigInput{
+		DefaultLeaseTTL:           input.DefaultLeaseTTL,
+		MaxLeaseTTL:               input.MaxLeaseTTL,
+		PluginName:                input.PluginName,
+		AuditNonHMACRequestKeys:   input.AuditNonHMACRequestKeys,
+		AuditNonHMACResponseKeys:  input.AuditNonHMACResponseKeys,
+		ListingVisibility:         input.ListingVisibility,
+		PassthroughRequestHeaders: input.PassthroughRequestHeaders,
+	}
+}
+
+// Passthrough headers declared empty in the document (configKeys) are sent as an explicit empty
+// list, so the live ones are cleared rather than the field being left out of the tune request
+func declaredHeaders(config vaultApi.MountConfigInput, configKeys map[string]bool) vaultApi.MountConfigInput {
+	if configKeys["passthrough_request_headers"] && len(config.PassthroughRequestHeaders) == 0 {
+		config.PassthroughRequestHeaders = []string{}
+	}
+	return config
+}
+
+// The tune config which restores a mount to its live config and description, to roll back tuning it
+func liveTuneConfig(live vaultApi.AuthConfigOutput, description string) vaultApi.MountConfigInput {
+	return vaultApi.MountConfigInput{
+		DefaultLeaseTTL:           fmt.Sprintf("%ds", live.DefaultLeaseTTL),
+		MaxLeaseTTL:               fmt.Sprintf("%ds", live.MaxLeaseTTL),
+		Description:               &description,
+		AuditNonHMACRequestKeys:   live.AuditNonHMACRequestKeys,
+		AuditNonHMACResponseKeys:  live.AuditNonHMACResponseKeys,
+		ListingVisibility:         live.ListingVisibility,
+		// never nil, so that headers added by the tune are cleared again
+		PassthroughRequestHeaders: append([]string{}, live.PassthroughRequestHeaders...),
+	}
+}
+
+// The tune config for a mount whose tuning is shared with something else: the live config, with
+// only the fields the document sets (configKeys) taken from configured, so the rest are written
+// back as they are. The description and plugin are left as configured.
+func mergeTuneConfig(configured, live vaultApi.MountConfigInput, configKeys map[string]bool) vaultApi.MountConfigInput {
+	if configKeys == nil {
+		return configured
+	}
+	merged := live
+	merged.Description = configured.Description
+	merged.PluginName = configured.PluginName
+	if configKeys["default_lease_ttl"] {
+		merged.DefaultLeaseTTL = configured.DefaultLeaseTTL
+	}
+	if configKeys["max_lease_ttl"] {
+		merged.MaxLeaseTTL = configured.MaxLeaseTTL
+	}
+	if configKeys["audit_non_hmac_request_keys"] {
+		merged.AuditNonHMACRequestKeys = configured.AuditNonHMACRequestKeys
+	}
+	if configKeys["audit_non_hmac_response_keys"] {
+		merged.AuditNonHMACResponseKeys = configured.AuditNonHMACResponseKeys
+	}
+	if configKeys["listing_visibility"] {
+		merged.ListingVisibility = configured.ListingVisibility
+	}
+	if configKeys["passthrough_request_headers"] {
+		merged.PassthroughRequestHeaders = configured.PassthroughRequestHeaders
+	}
+	return merged
+}
+
 // convert AuthConfigInput type to AuthConfigOutput type
 // A potential problem with this is that the transformation doesn't use the same code that Vault
-// uses internally, so bugs are possible; but ParseDuration is pretty standard (and vault
-// does use this same method)
+// uses internally, so bugs are possible; parseTTL accepts the same forms as Vault does though
 func ConvertAuthConfig(input vaultApi.AuthConfigInput) (vaultApi.AuthConfigOutput, error) {
 	var output vaultApi.AuthConfigOutput
 	var dur time.Duration
@@ -193,7 +617,7 @@ func ConvertAuthConfig(input vaultApi.AuthConfigInput) (vaultApi.AuthConfigOutpu
 	var DefaultLeaseTTL int // was string
 
 	if input.DefaultLeaseTTL != "" {
-		dur, err = time.ParseDuration(input.DefaultLeaseTTL)
+		dur, err = parseTTL(input.DefaultLeaseTTL)
 		if err != nil {
 			return output, fmt.Errorf("could not parse DefaultLeaseTTL value %s as seconds: %s", input.DefaultLeaseTTL, err)
 		}
@@ -202,7 +626,7 @@ func ConvertAuthConfig(input vaultApi.AuthConfigInput) (vaultApi.AuthConfigOutpu
 
 	var MaxLeaseTTL int // was string
 	if input.MaxLeaseTTL != "" {
-		dur, err = time.ParseDuration(input.MaxLeaseTTL)
+		dur, err = parseTTL(input.MaxLeaseTTL)
 		if err != nil {
 			return output, fmt.Errorf("could not parse MaxLeaseTTL value %s as seconds: %s", input.MaxLeaseTTL, err)
 		}
@@ -1,12 +1,21 @@
 package path_handlers
 
 import (
+	"bytes"
+	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
 	"github.com/starlingbank/vaultsmith/vault"
+	"github.com/starlingbank/vaultsmith/vault/vaulttest"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // calculate path to test fixtures (example/)
@@ -27,7 +36,7 @@ func TestSysAuth_EnsureAuth(t *testing.T) {
 	}
 
 	enableOpts := vaultApi.EnableAuthOptions{}
-	err = sh.ensureAuth("foo", enableOpts)
+	err = sh.ensureAuth("foo", enableOpts, nil)
 	if err != nil {
 		t.Errorf("Error calling ensureAuth: %s", err)
 	}
@@ -71,3 +80,1045 @@ func TestSysAuth_WalkFile(t *testing.T) {
 	//}
 
 }
+
+// Changing the type of an existing mount should fail unless recreation is allowed
+func TestSysAuth_EnsureAuth_TypeChangeGuarded(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {Type: "userpass"},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	err = sh.ensureAuth("foo/", vaultApi.EnableAuthOptions{Type: "ldap"}, nil)
+	if err == nil {
+		t.Fatal("Expected error when changing mount type without AllowRecreate, got nil")
+	}
+	if !strings.Contains(err.Error(), "--allow-recreate") {
+		t.Errorf("Expected error to explain how to allow recreation, got %q", err)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no DisableAuth calls, got %+v", calls)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// Changing the type of an existing mount should disable and re-enable it when allowed
+func TestSysAuth_EnsureAuth_TypeChangeRecreate(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {Type: "userpass"},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{AllowRecreate: true})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	err = sh.ensureAuth("foo/", vaultApi.EnableAuthOptions{Type: "ldap"}, nil)
+	if err != nil {
+		t.Fatalf("Error calling ensureAuth: %s", err)
+	}
+
+	var methods []string
+	for _, c := range client.CallLog {
+		if c.Method == "DisableAuth" || c.Method == "EnableAuth" {
+			methods = append(methods, c.Method)
+		}
+	}
+	expected := []string{"DisableAuth", "EnableAuth"}
+	if !reflect.DeepEqual(methods, expected) {
+		t.Errorf("Expected calls %+v, got %+v", expected, methods)
+	}
+	enabled := client.CallsTo("EnableAuth")[0].Args[1].(*vaultApi.EnableAuthOptions)
+	if enabled.Type != "ldap" {
+		t.Errorf("Expected mount to be enabled as ldap, got %q", enabled.Type)
+	}
+}
+
+// Whether a mount is local can't be changed in place either, so is handled like a type change
+func TestSysAuth_EnsureAuth_LocalChange(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		client := &vault.MockClient{
+			ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+				"foo/": {Type: "userpass"},
+			},
+		}
+		sh, err := NewSysAuthHandler(client, PathHandlerConfig{AllowRecreate: allow})
+		if err != nil {
+			t.Fatalf("Failed to create SysAuth: %s", err)
+		}
+
+		err = sh.ensureAuth("foo/", vaultApi.EnableAuthOptions{Type: "userpass", Local: true}, nil)
+		if !allow {
+			if err == nil || !strings.Contains(err.Error(), "local") {
+				t.Errorf("Expected an error explaining local can't be changed, got %v", err)
+			}
+			if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+				t.Errorf("Expected no DisableAuth calls, got %+v", calls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error calling ensureAuth: %s", err)
+		}
+		if calls := client.CallsTo("DisableAuth"); len(calls) != 1 {
+			t.Errorf("Expected the mount to be disabled, got %+v", calls)
+		}
+		calls := client.CallsTo("EnableAuth")
+		if len(calls) != 1 || !calls[0].Args[1].(*vaultApi.EnableAuthOptions).Local {
+			t.Errorf("Expected the mount to be enabled as local, got %+v", calls)
+		}
+		if calls := client.CallsTo("TuneMount"); len(calls) != 0 {
+			t.Errorf("Expected no TuneMount calls, got %+v", calls)
+		}
+	}
+}
+
+// seal_wrap is passed when enabling, and can't be changed in place, so is handled like a type change
+func TestSysAuth_EnsureAuth_SealWrap(t *testing.T) {
+	client := &vault.MockClient{}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.PutResource("sys/auth/foo", strings.NewReader(`{"type": "userpass", "seal_wrap": true}`)); err != nil {
+		t.Fatalf("Error calling PutResource: %s", err)
+	}
+	calls := client.CallsTo("EnableAuth")
+	if len(calls) != 1 || !calls[0].Args[1].(*vaultApi.EnableAuthOptions).SealWrap {
+		t.Errorf("Expected the mount to be enabled with seal_wrap, got %+v", calls)
+	}
+
+	client = &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {Type: "userpass", SealWrap: true},
+		},
+	}
+	sh, err = NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	err = sh.ensureAuth("foo/", vaultApi.EnableAuthOptions{Type: "userpass"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "seal_wrap") || !strings.Contains(err.Error(), "--allow-recreate") {
+		t.Errorf("Expected an error explaining seal_wrap needs a recreate, got %v", err)
+	}
+	if n := len(client.CallsTo("DisableAuth")) + len(client.CallsTo("TuneMount")); n != 0 {
+		t.Errorf("Expected no DisableAuth or TuneMount calls, got %d", n)
+	}
+}
+
+// However the path is slashed, it should match the live mount and not be re-enabled or disabled
+func TestSysAuth_EnsureAuth_AwkwardSlashes(t *testing.T) {
+	for _, path := range []string{"foo", "foo/", "foo//", "/foo/"} {
+		client := &vault.MockClient{
+			ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+				"foo/": {Type: "userpass"},
+			},
+		}
+		sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+		if err != nil {
+			t.Fatalf("Failed to create SysAuth: %s", err)
+		}
+
+		err = sh.ensureAuth(path, vaultApi.EnableAuthOptions{Type: "userpass"}, nil)
+		if err != nil {
+			t.Fatalf("Error calling ensureAuth(%q): %s", path, err)
+		}
+		if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+			t.Errorf("ensureAuth(%q): expected no EnableAuth calls, got %+v", path, calls)
+		}
+		if _, ok := sh.configuredAuthMap["foo/"]; !ok {
+			t.Errorf("ensureAuth(%q): expected configuredAuthMap key %q, got %+v",
+				path, "foo/", sh.configuredAuthMap)
+		}
+		err = sh.DisableUnconfiguredAuths()
+		if err != nil {
+			t.Fatalf("Error calling DisableUnconfiguredAuths: %s", err)
+		}
+		if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+			t.Errorf("ensureAuth(%q): expected no DisableAuth calls, got %+v", path, calls)
+		}
+	}
+}
+
+// Live mounts listed without a trailing slash should still match configured mounts
+func TestSysAuth_LiveAuthMapNormalized(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo": {Type: "userpass"},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if _, ok := sh.liveAuthMap["foo/"]; !ok {
+		t.Errorf("Expected liveAuthMap key %q, got %+v", "foo/", sh.liveAuthMap)
+	}
+}
+
+// Header lists in a different order are not drift
+func TestSysAuth_EnsureAuth_HeadersUnordered(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {
+				Type: "userpass",
+				Config: vaultApi.AuthConfigOutput{
+					PassthroughRequestHeaders: []string{"X-B", "X-A"},
+					AuditNonHMACRequestKeys:   []string{"b", "a"},
+				},
+			},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	err = sh.ensureAuth("foo", vaultApi.EnableAuthOptions{
+		Type: "userpass",
+		Config: vaultApi.AuthConfigInput{
+			PassthroughRequestHeaders: []string{"X-A", "X-B"},
+			AuditNonHMACRequestKeys:   []string{"a", "b"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error calling ensureAuth: %s", err)
+	}
+	if calls := client.CallsTo("TuneMount"); len(calls) != 0 {
+		t.Errorf("Expected no TuneMount calls, got %+v", calls)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// Drifted header lists on an existing mount are corrected with a single tune, not a re-enable
+func TestSysAuth_EnsureAuth_HeadersDrift(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {
+				Type: "userpass",
+				Config: vaultApi.AuthConfigOutput{
+					PassthroughRequestHeaders: []string{"X-A"},
+				},
+			},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	headers := []string{"X-C", "X-A"}
+	err = sh.ensureAuth("foo", vaultApi.EnableAuthOptions{
+		Type:   "userpass",
+		Config: vaultApi.AuthConfigInput{PassthroughRequestHeaders: headers},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error calling ensureAuth: %s", err)
+	}
+
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected exactly 1 TuneMount call, got %+v", calls)
+	}
+	if calls[0].Args[0] != "auth/foo/" {
+		t.Errorf("Expected tune of %q, got %q", "auth/foo/", calls[0].Args[0])
+	}
+	tuned := calls[0].Args[1].(vaultApi.MountConfigInput)
+	if !reflect.DeepEqual(tuned.PassthroughRequestHeaders, headers) {
+		t.Errorf("Expected headers %+v to be tuned, got %+v", headers, tuned.PassthroughRequestHeaders)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// Only the description differs, which is tuned in place without touching the rest
+func TestSysAuth_EnsureAuth_DescriptionDrift(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {Type: "userpass", Description: "old"},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	err = sh.ensureAuth("foo", vaultApi.EnableAuthOptions{Type: "userpass", Description: "new"}, nil)
+	if err != nil {
+		t.Fatalf("Error calling ensureAuth: %s", err)
+	}
+
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected exactly 1 TuneMount call, got %+v", calls)
+	}
+	tuned := calls[0].Args[1].(vaultApi.MountConfigInput)
+	if tuned.Description == nil || *tuned.Description != "new" {
+		t.Errorf("Expected the description to be tuned, got %+v", tuned.Description)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// The description is left out of the tune if it already matches
+func TestSysAuth_EnsureAuth_DescriptionUnchanged(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"foo/": {Type: "userpass", Description: "users"},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	err = sh.ensureAuth("foo", vaultApi.EnableAuthOptions{
+		Type:        "userpass",
+		Description: "users",
+		Config:      vaultApi.AuthConfigInput{DefaultLeaseTTL: "1h"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error calling ensureAuth: %s", err)
+	}
+
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected exactly 1 TuneMount call, got %+v", calls)
+	}
+	if tuned := calls[0].Args[1].(vaultApi.MountConfigInput); tuned.Description != nil {
+		t.Errorf("Expected no description in the tune, got %q", *tuned.Description)
+	}
+}
+
+// Vault fills in the config fields a document leaves out with its defaults, which are not drift
+func TestSysAuth_PutResource_OmittedConfigNotDrift(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"userpass/": {Type: "userpass", Config: vaultApi.AuthConfigOutput{
+				DefaultLeaseTTL:   2764800,
+				MaxLeaseTTL:       2764800,
+				ListingVisibility: "unauth",
+			}},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	doc := `{"type": "userpass", "config": {"listing_visibility": "unauth"}}`
+	if err := sh.PutResource("sys/auth/userpass", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if calls := client.CallsTo("TuneMount"); len(calls) != 0 {
+		t.Errorf("Expected no TuneMount calls, got %+v", calls)
+	}
+}
+
+// With MergeTuning, the tune carries the live values of the fields the document leaves out
+func TestSysAuth_PutResource_MergeTuning(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"userpass/": {Type: "userpass", Config: vaultApi.AuthConfigOutput{
+				DefaultLeaseTTL:           60,
+				MaxLeaseTTL:               7200,
+				ListingVisibility:         "unauth",
+				PassthroughRequestHeaders: []string{"X-Request-Id"},
+			}},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{MergeTuning: true})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	doc := `{"type": "userpass", "config": {"default_lease_ttl": "1h"}}`
+	if err := sh.PutResource("sys/auth/userpass", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected exactly 1 TuneMount call, got %+v", calls)
+	}
+	tuned := calls[0].Args[1].(vaultApi.MountConfigInput)
+	if tuned.DefaultLeaseTTL != "1h" {
+		t.Errorf("Expected default_lease_ttl to be tuned to 1h, got %q", tuned.DefaultLeaseTTL)
+	}
+	if tuned.MaxLeaseTTL != "7200s" || tuned.ListingVisibility != "unauth" ||
+		!reflect.DeepEqual(tuned.PassthroughRequestHeaders, []string{"X-Request-Id"}) {
+		t.Errorf("Expected the unmanaged fields to keep their live values, got %+v", tuned)
+	}
+	if tuned.Description != nil {
+		t.Errorf("Expected no description in the tune, got %q", *tuned.Description)
+	}
+}
+
+// The settings of the method itself don't belong in the mount's config
+func TestSysAuth_PutResource_AuthMethodConfig(t *testing.T) {
+	client := &vault.MockClient{}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	doc := `{"type": "ldap", "config": {"url": "ldaps://ldap.example.com"}}`
+	err = sh.PutResource("sys/auth/ldap", strings.NewReader(doc))
+	if err == nil || !strings.Contains(err.Error(), "auth/ldap/config.json") {
+		t.Errorf("Expected an error pointing to auth/ldap/config.json, got %v", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// A field which is set is compared, even to its zero value
+func TestSysAuth_PutResource_ZeroConfigDrift(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"userpass/": {Type: "userpass", Config: vaultApi.AuthConfigOutput{
+				DefaultLeaseTTL: 2764800,
+				MaxLeaseTTL:     2764800,
+			}},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	doc := `{"type": "userpass", "config": {"default_lease_ttl": "0"}}`
+	if err := sh.PutResource("sys/auth/userpass", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 TuneMount call, got %+v", calls)
+	}
+	if config := calls[0].Args[1].(vaultApi.MountConfigInput); config.DefaultLeaseTTL != "0" {
+		t.Errorf("Expected default_lease_ttl to be tuned to 0, got %+v", config)
+	}
+}
+
+// A client whose ListAuth fails a number of times before succeeding
+type flakyListAuthClient struct {
+	*vault.MockClient
+	failures int
+}
+
+func (c *flakyListAuthClient) ListAuth() (map[string]*vaultApi.AuthMount, error) {
+	if c.failures > 0 {
+		c.failures--
+		return nil, fmt.Errorf("connection reset by peer")
+	}
+	return c.MockClient.ListAuth()
+}
+
+// A transient failure listing the auth mounts should be retried rather than failing construction
+func TestNewSysAuthHandler_RetriesListAuth(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = 0
+
+	client := &flakyListAuthClient{
+		MockClient: &vault.MockClient{
+			ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+				"approle/": {Type: "approle"},
+			},
+		},
+		failures: 1,
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{Retries: 2})
+	if err != nil {
+		t.Fatalf("Expected construction to succeed after retrying, got %s", err)
+	}
+	if _, ok := sh.liveAuthMap["approle/"]; !ok {
+		t.Errorf("Expected live auth map to contain approle/, got %+v", sh.liveAuthMap)
+	}
+
+	client.failures = 3
+	_, err = NewSysAuthHandler(client, PathHandlerConfig{Retries: 2})
+	if err == nil {
+		t.Error("Expected an error once the retries were used up")
+	}
+}
+
+// A client whose EnableAuth fails a number of times before succeeding, with err if it is given
+type flakyEnableAuthClient struct {
+	*vault.MockClient
+	failures int
+	err      error
+}
+
+func (c *flakyEnableAuthClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
+	c.MockClient.EnableAuth(path, options)
+	if c.failures > 0 {
+		c.failures--
+		if c.err != nil {
+			return c.err
+		}
+		return fmt.Errorf("plugin is still starting")
+	}
+	return nil
+}
+
+// The retries annotation overrides how many times applying that resource is retried
+func TestSysAuth_PutResource_RetriesAnnotation(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = 0
+
+	client := &flakyEnableAuthClient{MockClient: &vault.MockClient{}, failures: 3}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{Retries: 1})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	doc := `{"type": "approle", "_vaultsmith": {"retries": 3}}`
+	if err := sh.PutResource("sys/auth/approle", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Expected success after retrying, got %s", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 4 {
+		t.Errorf("Expected 4 EnableAuth calls, got %d", len(calls))
+	}
+
+	// without the annotation, applying isn't retried
+	client = &flakyEnableAuthClient{MockClient: &vault.MockClient{}, failures: 1}
+	sh, err = NewSysAuthHandler(client, PathHandlerConfig{Retries: 1})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.PutResource("sys/auth/approle", strings.NewReader(`{"type": "approle"}`)); err == nil {
+		t.Error("Expected the failure without a retries annotation")
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 1 {
+		t.Errorf("Expected 1 EnableAuth call, got %d", len(calls))
+	}
+}
+
+// Only failures which might not happen again are retried; Vault refusing the request isn't
+func TestSysAuth_PutResource_RetriesOnlyTransient(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = 0
+
+	doc := `{"type": "approle", "_vaultsmith": {"retries": 3}}`
+	for code, expectCalls := range map[int]int{400: 1, 403: 1, 404: 1, 429: 2, 500: 2, 503: 2} {
+		client := &flakyEnableAuthClient{
+			MockClient: &vault.MockClient{},
+			failures:   1,
+			err:        &vault.ResponseError{StatusCode: code, Errors: []string{"refused"}},
+		}
+		sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+		if err != nil {
+			t.Fatalf("Failed to create SysAuth: %s", err)
+		}
+		sh.PutResource("sys/auth/approle", strings.NewReader(doc))
+		if calls := client.CallsTo("EnableAuth"); len(calls) != expectCalls {
+			t.Errorf("Expected %d EnableAuth calls after a %d, got %d", expectCalls, code, len(calls))
+		}
+	}
+}
+
+// A disabled auth mount is left alone, unless it should be pruned when disabled
+func TestSysAuth_EnabledWhen_Prune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	authDir := filepath.Join(dir, "sys", "auth")
+	os.MkdirAll(authDir, 0755)
+
+	for prune, expectDisable := range map[bool]bool{false: false, true: true} {
+		doc := fmt.Sprintf(`{"type": "ldap", "_vaultsmith": {"enabled_when": "flags/ldap#enabled", `+
+			`"prune_when_disabled": %t}}`, prune)
+		err = ioutil.WriteFile(filepath.Join(authDir, "ldap.json"), []byte(doc), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &vault.MockClient{
+			ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+				"ldap/": {Type: "ldap"},
+			},
+			ReturnSecrets: map[string]*vaultApi.Secret{
+				"flags/ldap": {Data: map[string]interface{}{"enabled": false}},
+			},
+		}
+		sh, err := NewSysAuthHandler(client, PathHandlerConfig{DocumentPath: dir})
+		if err != nil {
+			t.Fatalf("Failed to create SysAuth: %s", err)
+		}
+		err = sh.PutPoliciesFromDir(authDir)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+			t.Errorf("Expected no EnableAuth calls while disabled, got %+v", calls)
+		}
+		disabled := len(client.CallsTo("DisableAuth")) == 1
+		if disabled != expectDisable {
+			t.Errorf("With prune_when_disabled %t, expected disable %t", prune, expectDisable)
+		}
+	}
+}
+
+// Run against the real Vault client and an emulated server, so the HTTP requests made are checked
+func realClientAuth(t *testing.T, server *vaulttest.Server) *SysAuth {
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{DocumentPath: examplePath()})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	return sh
+}
+
+func TestSysAuth_RealClient_Enable(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	sh := realClientAuth(t, server)
+
+	err := sh.PutPoliciesFromDir(filepath.Join(examplePath(), "sys", "auth"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %+v", requests)
+	}
+	for i, expected := range []struct{ path, authType string }{
+		{"sys/auth/approle", "approle"},
+		{"sys/auth/aws", "aws"},
+	} {
+		r := requests[i]
+		if r.Method != "POST" || r.Path != expected.path {
+			t.Errorf("Expected POST %s, got %s %s", expected.path, r.Method, r.Path)
+		}
+		if r.Body["type"] != expected.authType {
+			t.Errorf("Expected type %q in body, got %+v", expected.authType, r.Body)
+		}
+	}
+	if config, ok := requests[1].Body["config"].(map[string]interface{}); !ok || config["max_lease_ttl"] != "0" {
+		t.Errorf("Expected aws config to be sent, got %+v", requests[1].Body)
+	}
+}
+
+func TestSysAuth_RealClient_Tune(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AuthMounts["aws/"] = &vaultApi.AuthMount{Type: "aws"}
+	sh := realClientAuth(t, server)
+
+	enableOpts := vaultApi.EnableAuthOptions{
+		Type:   "aws",
+		Config: vaultApi.AuthConfigInput{DefaultLeaseTTL: "1h", MaxLeaseTTL: "2h"},
+	}
+	err := sh.ensureAuth("aws", enableOpts, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Method != "POST" || requests[0].Path != "sys/mounts/auth/aws/tune" {
+		t.Fatalf("Expected a single POST to sys/mounts/auth/aws/tune, got %+v", requests)
+	}
+	if requests[0].Body["default_lease_ttl"] != "1h" || requests[0].Body["max_lease_ttl"] != "2h" {
+		t.Errorf("Expected TTLs in tune request, got %+v", requests[0].Body)
+	}
+
+	// once tuned, a new run finds nothing to do
+	sh = realClientAuth(t, server)
+	err = sh.ensureAuth("aws", enableOpts, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if requests := server.Requests(); len(requests) != 1 {
+		t.Errorf("Expected no further requests, got %+v", requests[1:])
+	}
+}
+
+// Declaring no passthrough headers clears the live ones, which needs the empty list to be sent
+func TestSysAuth_RealClient_ClearHeaders(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AuthMounts["aws/"] = &vaultApi.AuthMount{
+		Type:   "aws",
+		Config: vaultApi.AuthConfigOutput{PassthroughRequestHeaders: []string{"X-Request-Id"}},
+	}
+	sh := realClientAuth(t, server)
+
+	doc := `{"type": "aws", "config": {"passthrough_request_headers": []}}`
+	err := sh.PutResource("sys/auth/aws", strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Path != "sys/mounts/auth/aws/tune" {
+		t.Fatalf("Expected a single tune request, got %+v", requests)
+	}
+	if headers, ok := requests[0].Body["passthrough_request_headers"].([]interface{}); !ok || len(headers) != 0 {
+		t.Errorf("Expected an empty list of headers in the tune request, got %+v", requests[0].Body)
+	}
+
+	// once cleared, a new run finds nothing to do
+	sh = realClientAuth(t, server)
+	err = sh.PutResource("sys/auth/aws", strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if requests := server.Requests(); len(requests) != 1 {
+		t.Errorf("Expected no further requests, got %+v", requests[1:])
+	}
+}
+
+func TestSysAuth_RealClient_TuneDescription(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AuthMounts["aws/"] = &vaultApi.AuthMount{Type: "aws", Description: "old"}
+	sh := realClientAuth(t, server)
+
+	err := sh.ensureAuth("aws", vaultApi.EnableAuthOptions{Type: "aws", Description: "new"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Path != "sys/mounts/auth/aws/tune" {
+		t.Fatalf("Expected a single POST to sys/mounts/auth/aws/tune, got %+v", requests)
+	}
+	if requests[0].Body["description"] != "new" {
+		t.Errorf("Expected the description in the tune request, got %+v", requests[0].Body)
+	}
+	if server.AuthMounts["aws/"].Description != "new" {
+		t.Errorf("Expected the description to be updated, got %q", server.AuthMounts["aws/"].Description)
+	}
+}
+
+func TestSysAuth_RealClient_Disable(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AuthMounts["github/"] = &vaultApi.AuthMount{Type: "github"}
+	sh := realClientAuth(t, server)
+
+	err := sh.DisableUnconfiguredAuths()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// the token mount can't be disabled, so is left alone
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Method != "DELETE" || requests[0].Path != "sys/auth/github" {
+		t.Errorf("Expected a single DELETE of sys/auth/github, got %+v", requests)
+	}
+	if _, ok := server.AuthMounts["github/"]; ok {
+		t.Error("Expected github/ to be disabled")
+	}
+}
+
+// Undeclared auth mounts are disabled in order of path, whatever order the live map is iterated in
+func TestSysAuth_DisableUnconfiguredAuths_Order(t *testing.T) {
+	expected := []string{"approle/", "github/", "ldap/", "team/aws/", "userpass/"}
+	for i := 0; i < 10; i++ {
+		client := &vault.MockClient{ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"userpass/": {Type: "userpass"},
+			"token/":    {Type: "token"},
+			"github/":   {Type: "github"},
+			"team/aws/": {Type: "aws"},
+			"approle/":  {Type: "approle"},
+			"ldap/":     {Type: "ldap"},
+		}}
+		sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+		if err != nil {
+			t.Fatalf("Failed to create SysAuth: %s", err)
+		}
+		err = sh.DisableUnconfiguredAuths()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		var disabled []string
+		for _, c := range client.CallsTo("DisableAuth") {
+			disabled = append(disabled, c.Args[0].(string))
+		}
+		if !reflect.DeepEqual(disabled, expected) {
+			t.Fatalf("Expected auth mounts to be disabled in the order %+v, got %+v", expected, disabled)
+		}
+	}
+}
+
+// Every operation made against Vault for each applied auth mount is timed
+func TestSysAuth_Timings(t *testing.T) {
+	client := &vault.MockClient{ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+		"github/": {Type: "github"},
+	}}
+	summary := &Summary{}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{DocumentPath: examplePath(), Summary: summary})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	err = sh.PutPoliciesFromDir(filepath.Join(examplePath(), "sys", "auth"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var timed []string
+	for _, timing := range summary.Timings {
+		timed = append(timed, timing.Operation+" "+timing.Resource)
+	}
+	expected := []string{
+		"list sys/auth",
+		"enable sys/auth/approle/",
+		"enable sys/auth/aws/",
+		"disable sys/auth/github/",
+	}
+	if !reflect.DeepEqual(timed, expected) {
+		t.Errorf("Expected timings for %+v, got %+v", expected, timed)
+	}
+}
+
+// Undeclared auth mounts whose type isn't in DisableAuthTypes are warned about, not disabled
+func TestSysAuth_DisableUnconfiguredAuths_AllowedTypes(t *testing.T) {
+	client := &vault.MockClient{ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+		"userpass/": {Type: "userpass"},
+		"oidc/":     {Type: "oidc"},
+		"approle/":  {Type: "approle"},
+	}}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{
+		DisableAuthTypes: []string{"userpass", "approle"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	log.SetOutput(&logged)
+	if err := sh.DisableUnconfiguredAuths(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var disabled []string
+	for _, c := range client.CallsTo("DisableAuth") {
+		disabled = append(disabled, c.Args[0].(string))
+	}
+	if expected := []string{"approle/", "userpass/"}; !reflect.DeepEqual(disabled, expected) {
+		t.Errorf("Expected %+v to be disabled, got %+v", expected, disabled)
+	}
+	if !strings.Contains(logged.String(), "level=warning") || !strings.Contains(logged.String(), "path=oidc/") {
+		t.Errorf("Expected a warning about oidc/, got:\n%s", logged.String())
+	}
+}
+
+// Undeclared auth mounts outside OwnedAuthPaths belong to someone else, so aren't disabled
+func TestSysAuth_DisableUnconfiguredAuths_OwnedPaths(t *testing.T) {
+	client := &vault.MockClient{ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+		"userpass/":       {Type: "userpass"},
+		"userpass2/":      {Type: "userpass"},
+		"team-a/approle/": {Type: "approle"},
+		"team-a-approle/": {Type: "approle"},
+		"oidc/":           {Type: "oidc"},
+	}}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{
+		OwnedAuthPaths: []string{"userpass", "/team-a/"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.DisableUnconfiguredAuths(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var disabled []string
+	for _, c := range client.CallsTo("DisableAuth") {
+		disabled = append(disabled, c.Args[0].(string))
+	}
+	// only whole segments match, so neither userpass2/ nor team-a-approle/ is owned
+	if expected := []string{"team-a/approle/", "userpass/"}; !reflect.DeepEqual(disabled, expected) {
+		t.Errorf("Expected %+v to be disabled, got %+v", expected, disabled)
+	}
+}
+
+// The mount path can come from a template variable, e.g. one deployment per team
+func TestSysAuth_TemplatedMountPath(t *testing.T) {
+	docPath, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(docPath)
+	templateFile := filepath.Join(docPath, "_vaultsmith.json")
+	if err := ioutil.WriteFile(templateFile, []byte(`{"variables": {"team": "payments"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	authDir := filepath.Join(docPath, "sys", "auth")
+	os.MkdirAll(authDir, 0755)
+	doc := filepath.Join(authDir, "{{ team }}-approle.json")
+	if err := ioutil.WriteFile(doc, []byte(`{"type": "approle"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &vault.MockClient{}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{DocumentPath: docPath, TemplateFile: templateFile})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.PutPoliciesFromDir(authDir); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	enables := client.CallsTo("EnableAuth")
+	if len(enables) != 1 || enables[0].Args[0] != "payments-approle/" {
+		t.Errorf("Expected payments-approle/ to be enabled, got %+v", enables)
+	}
+}
+
+// A client which keeps the auth mounts enabled, so they are found when read back
+type keepingAuthClient struct {
+	*vault.MockClient
+}
+
+func (c *keepingAuthClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
+	c.MockClient.EnableAuth(path, options)
+	c.ReturnAuthMounts = map[string]*vaultApi.AuthMount{
+		path + "/": {Type: options.Type, Description: options.Description},
+	}
+	return nil
+}
+
+// With VerifyWrites, an auth mount which Vault doesn't keep fails, and one it does passes
+func TestSysAuth_VerifyWrites(t *testing.T) {
+	forgetting := &vault.MockClient{}
+	sh, err := NewSysAuthHandler(forgetting, PathHandlerConfig{VerifyWrites: true})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	err = sh.PutResource("sys/auth/approle", strings.NewReader(`{"type": "approle"}`))
+	if err == nil || !strings.Contains(err.Error(), "did not keep") {
+		t.Errorf("Expected an error as the mount was not kept, got %v", err)
+	}
+	if n := len(forgetting.CallsTo("ListAuth")); n != 2 {
+		t.Errorf("Expected the auth mounts to be listed again to verify, got %d lists", n)
+	}
+
+	keeping := &keepingAuthClient{MockClient: &vault.MockClient{}}
+	sh, err = NewSysAuthHandler(keeping, PathHandlerConfig{VerifyWrites: true})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.PutResource("sys/auth/approle", strings.NewReader(`{"type": "approle"}`)); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+// A tune which Vault doesn't keep fails verification, and is still rolled back
+func TestSysAuth_VerifyWrites_Tune(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"approle/": {Type: "approle", Description: "old"},
+		},
+	}
+	rollback := &Rollback{}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{VerifyWrites: true, Rollback: rollback})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	err = sh.PutResource("sys/auth/approle", strings.NewReader(`{"type": "approle", "description": "new"}`))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Expected an error as the tune was not kept, got %v", err)
+	}
+	if len(rollback.steps) != 1 || rollback.steps[0].undo == nil {
+		t.Errorf("Expected the tune to be recorded for rollback, got %+v", rollback.steps)
+	}
+}
+
+// Without VerifyWrites, nothing is read back
+func TestSysAuth_NoVerifyWrites(t *testing.T) {
+	client := &vault.MockClient{}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.PutResource("sys/auth/approle", strings.NewReader(`{"type": "approle"}`)); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if n := len(client.CallsTo("ListAuth")); n != 1 {
+		t.Errorf("Expected the auth mounts to be listed once, got %d", n)
+	}
+}
+
+// The mounts listed in the index file are ensured and pruned as though each had its own file
+func TestSysAuth_IndexFile(t *testing.T) {
+	dir := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/" + AuthIndexFile: `{
+			"approle": {"type": "approle", "config": {"default_lease_ttl": "1h"}},
+			"ldap": {"type": "ldap"},
+			"/ci/userpass/": {"type": "userpass", "description": "CI users"}
+		}`,
+		"sys/auth/github.json": `{"type": "github"}`,
+	})
+	authDir := filepath.Join(dir, "sys", "auth")
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"ldap/":     {Type: "ldap"},
+			"old-ldap/": {Type: "ldap"},
+		},
+	}
+	sh, err := NewSysAuthHandler(client, PathHandlerConfig{DocumentPath: dir})
+	if err != nil {
+		t.Fatalf("Failed to create SysAuth: %s", err)
+	}
+	if err := sh.PutPoliciesFromDir(authDir); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	var enabled []string
+	for _, c := range client.CallsTo("EnableAuth") {
+		enabled = append(enabled, c.Args[0].(string))
+	}
+	// ldap is already enabled as declared
+	expected := []string{"approle/", "ci/userpass/", "github/"}
+	if !reflect.DeepEqual(enabled, expected) {
+		t.Errorf("Expected %+v to be enabled, got %+v", expected, enabled)
+	}
+	for _, c := range client.CallsTo("EnableAuth") {
+		if c.Args[0] == "ci/userpass/" && c.Args[1].(*vaultApi.EnableAuthOptions).Description != "CI users" {
+			t.Errorf("Expected the description of ci/userpass from the index, got %+v", c.Args[1])
+		}
+	}
+	disabled := client.CallsTo("DisableAuth")
+	if len(disabled) != 1 || disabled[0].Args[0] != "old-ldap/" {
+		t.Errorf("Expected only old-ldap/ to be disabled, got %+v", disabled)
+	}
+}
+
+func TestSysAuth_IndexFileInvalid(t *testing.T) {
+	for index, expected := range map[string]string{
+		`["approle"]`: "expected an object of mount path -> document",
+		`{"approle": {"type": "approle", "tpye": "x"}}`:          `entry "approle"`,
+		`{"ldap": {"type": "ldap"}, "/ldap/": {"type": "ldap"}}`: "ldap is listed twice",
+	} {
+		dir := testutil.WriteDocuments(t, map[string]string{"sys/auth/" + AuthIndexFile: index})
+		authDir := filepath.Join(dir, "sys", "auth")
+		client := &vault.MockClient{}
+		sh, err := NewSysAuthHandler(client, PathHandlerConfig{DocumentPath: dir})
+		if err != nil {
+			t.Fatalf("Failed to create SysAuth: %s", err)
+		}
+		err = sh.PutPoliciesFromDir(authDir)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q for %s, got %v", expected, index, err)
+		}
+		if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+			t.Errorf("Expected no EnableAuth calls for %s, got %+v", index, calls)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package path_handlers
+
+import (
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/fs"
+	"path/filepath"
+)
+
+/*
+	SysConfig handles the singleton configuration endpoints under sys/config, such as
+	sys/config/cors and sys/config/ui/headers/<header>. Documents are written in the same way as the
+	Generic handler, however these endpoints can't be listed, so undeclared configuration is never
+	removed. The audited request headers have their own handler, AuditHeaders.
+*/
+type SysConfig struct {
+	*Generic
+}
+
+func NewSysConfigHandler(client vault.Vault, config PathHandlerConfig) (*SysConfig, error) {
+	gh, err := NewGeneric(client, config)
+	if err != nil {
+		return &SysConfig{}, err
+	}
+	gh.name = "SysConfig"
+	gh.log = handlerLogger("SysConfig", config)
+	// Vault adds the headers it always allows to the ones configured for CORS
+	gh.supersetKeys = map[string]bool{
+		"allowed_headers": true,
+	}
+	return &SysConfig{Generic: gh}, nil
+}
+
+func (sh *SysConfig) walkFile(path string, f fs.DirEntry, err error) error {
+	if f != nil && f.IsDir() {
+		if rel, relErr := filepath.Rel(sh.config.DocumentPath, path); relErr == nil &&
+			filepath.ToSlash(rel) == auditHeadersPath {
+			return fs.SkipDir
+		}
+	}
+	return sh.Generic.walkFile(path, f, err)
+}
+
+func (sh *SysConfig) PutPoliciesFromDir(path string) error {
+	return sh.walk(path, sh.walkFile)
+}
@@ -0,0 +1,73 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const corsConfig = `{"allowed_origins": ["https://example.com"], "allowed_headers": ["X-Custom"]}`
+
+func TestSysConfig_AppliesCors(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/config/cors.json": corsConfig})
+
+	client := &vault.MockClient{}
+	sh, err := NewSysConfigHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create SysConfig: %s", err)
+	}
+
+	err = sh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "config"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 {
+		t.Fatalf("Expected 1 Write call, got %+v", writes)
+	}
+	if writes[0].Args[0] != "sys/config/cors" {
+		t.Errorf("Expected write to sys/config/cors, got %q", writes[0].Args[0])
+	}
+	expected := []interface{}{"https://example.com"}
+	data := writes[0].Args[1].(map[string]interface{})
+	if !reflect.DeepEqual(data["allowed_origins"], expected) {
+		t.Errorf("Expected allowed_origins %+v, got %+v", expected, data["allowed_origins"])
+	}
+	// singletons are never listed or removed
+	if calls := client.CallsTo("List"); len(calls) != 0 {
+		t.Errorf("Expected no List calls, got %+v", calls)
+	}
+}
+
+func TestSysConfig_CorsNoChange(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/config/cors.json": corsConfig})
+
+	// Vault reports the standard headers it always allows alongside ours
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"enabled":         true,
+				"allowed_origins": []interface{}{"https://example.com"},
+				"allowed_headers": []interface{}{
+					"Content-Type", "X-Requested-With", "X-Vault-Token", "X-Custom",
+				},
+			},
+		},
+	}
+	sh, err := NewSysConfigHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err != nil {
+		t.Fatalf("Failed to create SysConfig: %s", err)
+	}
+
+	err = sh.PutPoliciesFromDir(filepath.Join(docPath, "sys", "config"))
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if writes := client.CallsTo("Write"); len(writes) != 0 {
+		t.Errorf("Expected no Write calls, got %+v", writes)
+	}
+}
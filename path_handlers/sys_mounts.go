@@ -0,0 +1,366 @@
+package path_handlers
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+)
+
+/*
+	SysMounts handles the mounting of secrets engines, described in sys/mounts/<path>.json using the
+	same fields as the sys/mounts API, e.g. {"type": "kv", "options": {"version": "2"}}.
+
+	Mount options are compared with the live mount, so a kv mount moving from version 1 to 2 is
+	upgraded in place. The tunable config (lease TTLs, audit keys, listing visibility and headers)
+	is tuned after mounting, and again whenever a configured field drifts. Undeclared mounts are
+	left alone, as unmounting destroys their data. A mount which turns out to exist already when it
+	is mounted, e.g. created by another tool, is taken over and tuned to the configuration.
+*/
+type SysMounts struct {
+	BaseHandler
+	liveMountMap map[string]*vaultApi.MountOutput
+}
+
+func NewSysMountsHandler(client vault.Vault, config PathHandlerConfig) (*SysMounts, error) {
+	logger := handlerLogger("SysMounts", config)
+
+	var listedMountMap map[string]*vaultApi.MountOutput
+	err := timed(config.Summary, logger, "sys/mounts", "list", func() error {
+		return withRetries(config.Retries, logger, func() (err error) {
+			listedMountMap, err = client.ListMounts()
+			return err
+		})
+	})
+	if err != nil {
+		return &SysMounts{}, fmt.Errorf("error listing mounts: %s", err)
+	}
+	liveMountMap := make(map[string]*vaultApi.MountOutput)
+	for path, mount := range listedMountMap {
+		liveMountMap[mountPath(path)] = mount
+	}
+
+	return &SysMounts{
+		BaseHandler: BaseHandler{
+			name:   "SysMounts",
+			client: client,
+			config: config,
+			order:  config.Order,
+			log:    logger,
+		},
+		liveMountMap: liveMountMap,
+	}, nil
+}
+
+func (sh *SysMounts) walkFile(path string, f fs.DirEntry, err error) error {
+	if f == nil {
+		logger := sh.log.WithFields(log.Fields{"path": path, "error": err})
+		logger.Debug("Path does not exist, skipping")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	if sh.ignored(path, f) {
+		return skipIgnored(f)
+	}
+	// not doing anything with dirs
+	if f.IsDir() {
+		return nil
+	}
+
+	mountApiPath, err := sh.resourcePath(path)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(mountApiPath, "sys/mounts/") {
+		return fmt.Errorf("found file without sys/mounts prefix: %s", mountApiPath)
+	}
+
+	var input vaultApi.MountInput
+	annotations, configKeys, err := sh.decodeMountFile(path, &input)
+	if err != nil {
+		return err
+	}
+	// mounts are never removed, so there is nothing to prune when disabled
+	if enabled, err := sh.enabled(mountApiPath, annotations); err != nil || !enabled {
+		return err
+	}
+
+	err = sh.ensureMount(strings.TrimPrefix(mountApiPath, "sys/mounts/"), input, configKeys)
+	if err != nil {
+		return fmt.Errorf("error while ensuring mount for path %s: %s", path, err)
+	}
+	return nil
+}
+
+// Apply a single mount, e.g. resourcePath "sys/mounts/secret"
+func (sh *SysMounts) PutResource(resourcePath string, r io.Reader) error {
+	resourcePath = normalizePath(resourcePath)
+	if !strings.HasPrefix(resourcePath, "sys/mounts/") {
+		return fmt.Errorf("resource path %s does not have sys/mounts prefix", resourcePath)
+	}
+
+	var input vaultApi.MountInput
+	annotations, configKeys, err := sh.decodeMountReader(resourcePath, r, &input)
+	if err != nil {
+		return err
+	}
+	if enabled, err := sh.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+	return sh.ensureMount(strings.TrimPrefix(resourcePath, "sys/mounts/"), input, configKeys)
+}
+
+func (sh *SysMounts) PutPoliciesFromDir(path string) error {
+	return sh.walk(path, sh.walkFile)
+}
+
+// Ensure the secrets engine is mounted at path with the configured options. Only the config fields
+// in configKeys are compared with the live mount.
+func (sh *SysMounts) ensureMount(path string, input vaultApi.MountInput, configKeys map[string]bool) (err error) {
+	path = mountPath(path)
+	resource := "sys/mounts/" + normalizePath(path)
+	if sh.ignoredResource(resource) || sh.appliedEarlier(resource) {
+		return nil
+	}
+	defer func() { sh.recordApplied(resource, err) }()
+	logger := sh.log.WithFields(log.Fields{
+		"mount path": path,
+		"type":       input.Type,
+	})
+
+	configured, err := ConvertAuthConfig(mountAuthConfig(input.Config))
+	if err != nil {
+		return fmt.Errorf("could not parse the config of mount %s: %s", path, err)
+	}
+
+	liveMount, ok := sh.liveMountMap[path]
+	if !ok {
+		logger.Info("Mounting secrets engine")
+		sh.diff(resource, input, nil)
+		err := sh.timed(resource, "mount", func() error {
+			return sh.client.Mount(path, &input)
+		})
+		if err != nil && isPathInUse(err) {
+			// mounted since we listed them, e.g. by another tool, so it's taken over as it is
+			adopted, listErr := sh.adoptMount(path)
+			if listErr != nil {
+				return sh.result(resource, "mount", fmt.Errorf("could not mount %s: %s; and %s",
+					path, err, listErr))
+			}
+			if adopted {
+				logger.Warn("Mount already exists, reconciling it instead")
+				return sh.ensureMount(path, input, configKeys)
+			}
+		}
+		if err != nil {
+			return sh.result(resource, "mount", fmt.Errorf("could not mount %s: %s", path, err))
+		}
+		unmount := func() error {
+			return sh.client.Unmount(path)
+		}
+		if !isMountConfigSet(configured) {
+			sh.onRollback(unmount)
+			return sh.result(resource, "mount", sh.verify(resource, "mount", func() error {
+				return sh.verifyMount(path, input, configKeys)
+			}))
+		}
+		sh.config.Summary.Add(resource, "mount", nil)
+		sh.config.Rollback.record(resource, "mount", unmount)
+		// not everything is taken from the config when mounting, so it is applied again by tuning
+		tuneConfig := declaredHeaders(authTuneConfig(mountAuthConfig(input.Config)), configKeys)
+		return sh.tuneMount(path, resource, tuneConfig, input, configKeys)
+	}
+
+	if mountType(liveMount.Type) != mountType(input.Type) {
+		return sh.result(resource, "mount", fmt.Errorf("mount %s is of type %q but is configured "+
+			"as %q; Vault cannot change the type of a mount in place", path, liveMount.Type, input.Type))
+	}
+	if liveMount.SealWrap != input.SealWrap {
+		return sh.result(resource, "mount", fmt.Errorf("mount %s has seal_wrap set to %t but is "+
+			"configured with %t; Vault cannot change this in place, so it must be unmounted and "+
+			"mounted again, which destroys its data", path, liveMount.SealWrap, input.SealWrap))
+	}
+
+	liveOptions := mountOptions(liveMount.Type, liveMount.Options)
+	configuredOptions := mountOptions(input.Type, input.Options)
+	optionsApplied := reflect.DeepEqual(liveOptions, configuredOptions)
+	configuredConfig := configFields(configured, configKeys)
+	liveConfig := configFields(liveMountConfig(liveMount.Config), configKeys)
+	configApplied := reflect.DeepEqual(configuredConfig, liveConfig)
+	if optionsApplied && configApplied {
+		logger.Debug("Mount already configured")
+		sh.unchanged(resource)
+		return nil
+	}
+	if mountType(input.Type) == "kv" && liveOptions["version"] == "2" && configuredOptions["version"] == "1" {
+		return sh.result(resource, "tune", fmt.Errorf("mount %s is kv version 2 and cannot be "+
+			"downgraded to version 1", path))
+	}
+
+	configuredFields := map[string]interface{}{"options": configuredOptions, "config": configuredConfig}
+	liveFields := map[string]interface{}{"options": liveOptions, "config": liveConfig}
+	sh.explain(resource, diffFields(configuredFields, liveFields))
+	sh.diff(resource, configuredFields, liveFields)
+
+	// An upgrade from kv version 1 to 2 is done by tuning the options, keeping the data
+	restore := liveTuneConfig(liveMountConfig(liveMount.Config), liveMount.Description)
+	tuneConfig := authTuneConfig(mountAuthConfig(input.Config))
+	if sh.config.MergeTuning {
+		tuneConfig = mergeTuneConfig(tuneConfig, restore, configKeys)
+	}
+	tuneConfig = declaredHeaders(tuneConfig, configKeys)
+	if !optionsApplied {
+		tuneConfig.Options = configuredOptions
+	}
+	logger.WithFields(log.Fields{
+		"live options":       liveOptions,
+		"configured options": configuredOptions,
+		"live config":        liveMount.Config,
+		"configured config":  configured,
+	}).Info("Tuning mount")
+	// a kv upgrade can't be undone, but the config can be put back
+	sh.onRollback(func() error {
+		return sh.client.TuneMount(path, restore)
+	})
+	return sh.tuneMount(path, resource, tuneConfig, input, configKeys)
+}
+
+// Vault's error for a mount at a path which is already mounted
+func isPathInUse(err error) bool {
+	return strings.Contains(err.Error(), "path is already in use")
+}
+
+// List the mounts again, adding the one at path to the live mounts if it is there now
+func (sh *SysMounts) adoptMount(path string) (bool, error) {
+	var listed map[string]*vaultApi.MountOutput
+	err := sh.timed("sys/mounts", "list", func() (err error) {
+		listed, err = sh.client.ListMounts()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error listing mounts: %s", err)
+	}
+	for p, mount := range listed {
+		if mountPath(p) == path {
+			sh.liveMountMap[path] = mount
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Tune the mount at path, which is then verified against input
+func (sh *SysMounts) tuneMount(path string, resource string, config vaultApi.MountConfigInput, input vaultApi.MountInput, configKeys map[string]bool) error {
+	// the plugin can't be changed by tuning
+	config.PluginName = ""
+	err := sh.timed(resource, "tune", func() error {
+		return sh.client.TuneMount(path, config)
+	})
+	if err != nil {
+		err = fmt.Errorf("could not tune mount %s: %s", path, err)
+	} else {
+		if mountType(input.Type) == "kv" && config.Options["version"] == "2" {
+			sh.irreversible(resource, "kv upgrade")
+		}
+		err = sh.verify(resource, "tune", func() error {
+			return sh.verifyMount(path, input, configKeys)
+		})
+	}
+	return sh.result(resource, "tune", err)
+}
+
+// Read the mount at path back from Vault, returning an error unless it is as configured. Only the
+// config fields in configKeys are compared.
+func (sh *SysMounts) verifyMount(path string, input vaultApi.MountInput, configKeys map[string]bool) error {
+	var listed map[string]*vaultApi.MountOutput
+	err := sh.timed("sys/mounts/"+normalizePath(path), "verify", func() (err error) {
+		listed, err = sh.client.ListMounts()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not list mounts to verify %s: %s", path, err)
+	}
+	var live *vaultApi.MountOutput
+	for p, mount := range listed {
+		if mountPath(p) == path {
+			live = mount
+		}
+	}
+	if live == nil {
+		return fmt.Errorf("mount %s is not mounted after writing it; Vault did not keep the change", path)
+	}
+	configured, err := ConvertAuthConfig(mountAuthConfig(input.Config))
+	if err != nil {
+		return fmt.Errorf("could not verify mount %s: %s", path, err)
+	}
+	optionsApplied := reflect.DeepEqual(mountOptions(live.Type, live.Options), mountOptions(input.Type, input.Options))
+	configApplied := reflect.DeepEqual(configFields(configured, configKeys),
+		configFields(liveMountConfig(live.Config), configKeys))
+	if mountType(live.Type) != mountType(input.Type) || !optionsApplied || !configApplied {
+		return fmt.Errorf("mount %s does not match its document after writing it; Vault did not "+
+			"keep the change", path)
+	}
+	return nil
+}
+
+func (sh *SysMounts) Order() int {
+	return sh.order
+}
+
+// The type Vault reports for a mount of type t. "kv-v2" is mounted as kv, with version 2.
+func mountType(t string) string {
+	if t == "kv-v2" {
+		return "kv"
+	}
+	return t
+}
+
+// Return the options as Vault would report them for a mount of type t, so that a kv mount
+// without a version is seen as version 1, and a kv-v2 one as version 2
+func mountOptions(t string, options map[string]string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range options {
+		out[k] = v
+	}
+	switch {
+	case t == "kv-v2":
+		out["version"] = "2"
+	case t == "kv" && out["version"] == "":
+		out["version"] = "1"
+	}
+	return out
+}
+
+// The tunable parts of a mount's config, which are the same as an auth mount's
+func mountAuthConfig(config vaultApi.MountConfigInput) vaultApi.AuthConfigInput {
+	return vaultApi.AuthConfigInput{
+		DefaultLeaseTTL:           config.DefaultLeaseTTL,
+		MaxLeaseTTL:               config.MaxLeaseTTL,
+		AuditNonHMACRequestKeys:   config.AuditNonHMACRequestKeys,
+		AuditNonHMACResponseKeys:  config.AuditNonHMACResponseKeys,
+		ListingVisibility:         config.ListingVisibility,
+		PassthroughRequestHeaders: config.PassthroughRequestHeaders,
+	}
+}
+
+func isMountConfigSet(configured vaultApi.AuthConfigOutput) bool {
+	return !reflect.DeepEqual(normalizeAuthConfig(configured), vaultApi.AuthConfigOutput{})
+}
+
+// The live config in the form of the configured one, with TTLs in seconds, so they can be compared
+func liveMountConfig(live vaultApi.MountConfigOutput) vaultApi.AuthConfigOutput {
+	return vaultApi.AuthConfigOutput{
+		DefaultLeaseTTL:           live.DefaultLeaseTTL,
+		MaxLeaseTTL:               live.MaxLeaseTTL,
+		AuditNonHMACRequestKeys:   live.AuditNonHMACRequestKeys,
+		AuditNonHMACResponseKeys:  live.AuditNonHMACResponseKeys,
+		ListingVisibility:         live.ListingVisibility,
+		PassthroughRequestHeaders: live.PassthroughRequestHeaders,
+	}
+}
@@ -0,0 +1,373 @@
+package path_handlers
+
+import (
+	"errors"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/vault"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Mounting a kv v2 engine should pass its options through to Vault
+func TestSysMounts_PutResource_KvV2(t *testing.T) {
+	client := &vault.MockClient{}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv", "options": {"version": "2"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	calls := client.CallsTo("Mount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 Mount call, got %d", len(calls))
+	}
+	if calls[0].Args[0] != "secret/" {
+		t.Errorf("Expected mount at secret/, got %v", calls[0].Args[0])
+	}
+	input := calls[0].Args[1].(*vaultApi.MountInput)
+	if !reflect.DeepEqual(input.Options, map[string]string{"version": "2"}) {
+		t.Errorf("Expected options to be passed through, got %+v", input.Options)
+	}
+}
+
+// Nothing should be sent when the live mount already matches
+func TestSysMounts_PutResource_NoOp(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"secret/": {Type: "kv", Options: map[string]string{"version": "2"}},
+			"kv1/":    {Type: "kv"},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	docs := map[string]string{
+		"sys/mounts/secret": `{"type": "kv", "options": {"version": "2"}}`,
+		"sys/mounts/kv1":    `{"type": "kv", "options": {"version": "1"}}`,
+	}
+	for path, doc := range docs {
+		err = sh.PutResource(path, strings.NewReader(doc))
+		if err != nil {
+			t.Errorf("Unexpected error applying %s: %s", path, err)
+		}
+	}
+
+	if n := len(client.CallsTo("Mount")) + len(client.CallsTo("TuneMount")); n != 0 {
+		t.Errorf("Expected no Mount or TuneMount calls, got %d", n)
+	}
+}
+
+// Vault lists a mount made with type kv-v2 as a kv mount of version 2, which matches it on the
+// next run
+func TestSysMounts_PutResource_KvV2Type(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"secret/": {Type: "kv", Options: map[string]string{"version": "2"}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv-v2"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if n := len(client.CallsTo("Mount")) + len(client.CallsTo("TuneMount")); n != 0 {
+		t.Errorf("Expected no Mount or TuneMount calls, got %d", n)
+	}
+}
+
+// A kv mount moving from version 1 to 2 should be upgraded in place, not mounted again
+func TestSysMounts_PutResource_KvUpgrade(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"secret/": {Type: "kv", Options: map[string]string{"version": "1"}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv", "options": {"version": "2"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if calls := client.CallsTo("Mount"); len(calls) != 0 {
+		t.Errorf("Expected no Mount calls, got %+v", calls)
+	}
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 TuneMount call, got %d", len(calls))
+	}
+	config := calls[0].Args[1].(vaultApi.MountConfigInput)
+	if config.Options["version"] != "2" {
+		t.Errorf("Expected version 2 to be tuned, got %+v", config.Options)
+	}
+}
+
+// Downgrading a kv mount is not possible, so should fail without touching Vault
+func TestSysMounts_PutResource_KvDowngrade(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"secret/": {Type: "kv", Options: map[string]string{"version": "2"}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv"}`))
+	if err == nil {
+		t.Error("Expected an error downgrading kv version 2 to 1")
+	}
+	if n := len(client.CallsTo("TuneMount")); n != 0 {
+		t.Errorf("Expected no TuneMount calls, got %d", n)
+	}
+}
+
+// A drifted max_lease_ttl is tuned back, comparing TTLs in seconds, without mounting again
+func TestSysMounts_PutResource_MaxLeaseTTLDrift(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"pki/": {Type: "pki", Config: vaultApi.MountConfigOutput{DefaultLeaseTTL: 3600, MaxLeaseTTL: 86400}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	doc := `{"type": "pki", "config": {"default_lease_ttl": "1h", "max_lease_ttl": "87600h"}}`
+	if err := sh.PutResource("sys/mounts/pki", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if calls := client.CallsTo("Mount"); len(calls) != 0 {
+		t.Errorf("Expected no Mount calls, got %+v", calls)
+	}
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 TuneMount call, got %+v", calls)
+	}
+	config := calls[0].Args[1].(vaultApi.MountConfigInput)
+	if calls[0].Args[0] != "pki/" || config.MaxLeaseTTL != "87600h" || config.DefaultLeaseTTL != "1h" {
+		t.Errorf("Expected pki/ to be tuned with the configured TTLs, got %+v", calls[0].Args)
+	}
+	if config.Options != nil {
+		t.Errorf("Expected the unchanged options not to be tuned, got %+v", config.Options)
+	}
+}
+
+// With MergeTuning, a field set on the mount by something else survives tuning another
+func TestSysMounts_PutResource_MergeTuning(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"pki/": {Type: "pki", Config: vaultApi.MountConfigOutput{DefaultLeaseTTL: 3600, MaxLeaseTTL: 86400,
+				AuditNonHMACRequestKeys: []string{"common_name"}}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{MergeTuning: true})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	doc := `{"type": "pki", "config": {"max_lease_ttl": "87600h"}}`
+	if err := sh.PutResource("sys/mounts/pki", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	calls := client.CallsTo("TuneMount")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 TuneMount call, got %+v", calls)
+	}
+	config := calls[0].Args[1].(vaultApi.MountConfigInput)
+	if config.MaxLeaseTTL != "87600h" || config.DefaultLeaseTTL != "3600s" ||
+		!reflect.DeepEqual(config.AuditNonHMACRequestKeys, []string{"common_name"}) {
+		t.Errorf("Expected only max_lease_ttl to change, got %+v", config)
+	}
+}
+
+// The same TTLs written differently, and fields which aren't configured, are not drift
+func TestSysMounts_PutResource_ConfigApplied(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"pki/": {Type: "pki", Config: vaultApi.MountConfigOutput{
+				DefaultLeaseTTL:   3600,
+				MaxLeaseTTL:       315360000,
+				ListingVisibility: "hidden",
+			}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	doc := `{"type": "pki", "config": {"default_lease_ttl": "3600", "max_lease_ttl": "87600h"}}`
+	if err := sh.PutResource("sys/mounts/pki", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if calls := client.CallsTo("TuneMount"); len(calls) != 0 {
+		t.Errorf("Expected no TuneMount calls, got %+v", calls)
+	}
+}
+
+// A field which is set is compared, even to its zero value
+func TestSysMounts_PutResource_ZeroConfigDrift(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"pki/": {Type: "pki", Config: vaultApi.MountConfigOutput{DefaultLeaseTTL: 3600}},
+		},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	doc := `{"type": "pki", "config": {"default_lease_ttl": "0s"}}`
+	if err := sh.PutResource("sys/mounts/pki", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if calls := client.CallsTo("TuneMount"); len(calls) != 1 {
+		t.Errorf("Expected 1 TuneMount call, got %+v", calls)
+	}
+}
+
+// A new mount is tuned after mounting, as not all of the config is applied when mounting
+func TestSysMounts_PutResource_TuneAfterMount(t *testing.T) {
+	client := &vault.MockClient{}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{Summary: &Summary{}})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	doc := `{"type": "pki", "config": {"max_lease_ttl": "87600h"}}`
+	if err := sh.PutResource("sys/mounts/pki", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var methods []string
+	for _, c := range client.CallLog {
+		methods = append(methods, c.Method)
+	}
+	if expected := []string{"ListMounts", "Mount", "TuneMount"}; !reflect.DeepEqual(methods, expected) {
+		t.Errorf("Expected calls %+v, got %+v", expected, methods)
+	}
+}
+
+// A client which finds the mount already in Vault when it tries to create it, as happens if
+// another tool mounted it since vaultsmith listed the mounts
+type existingMountClient struct {
+	*vault.MockClient
+	existing *vaultApi.MountOutput
+}
+
+func (c *existingMountClient) Mount(path string, input *vaultApi.MountInput) error {
+	c.MockClient.Mount(path, input)
+	c.ReturnMounts = map[string]*vaultApi.MountOutput{path: c.existing}
+	return errors.New("Error making API request.\n\nCode: 400. Errors:\n\n* path is already in use at " + path)
+}
+
+// The mount is adopted and tuned to the configuration, rather than failing the run
+func TestSysMounts_PutResource_AlreadyMounted(t *testing.T) {
+	client := &existingMountClient{
+		MockClient: &vault.MockClient{},
+		existing:   &vaultApi.MountOutput{Type: "pki", Config: vaultApi.MountConfigOutput{MaxLeaseTTL: 3600}},
+	}
+	summary := &Summary{}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{Summary: summary})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+
+	doc := `{"type": "pki", "config": {"max_lease_ttl": "87600h"}}`
+	if err := sh.PutResource("sys/mounts/pki", strings.NewReader(doc)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var methods []string
+	for _, c := range client.CallLog {
+		methods = append(methods, c.Method)
+	}
+	expected := []string{"ListMounts", "Mount", "ListMounts", "TuneMount"}
+	if !reflect.DeepEqual(methods, expected) {
+		t.Errorf("Expected calls %+v, got %+v", expected, methods)
+	}
+	if len(summary.Rows) != 1 || summary.Rows[0].Action != "tune" || summary.Rows[0].Status != StatusOK {
+		t.Errorf("Expected only the tune in the summary, got %+v", summary.Rows)
+	}
+}
+
+// A mount of another type can't be adopted, so still fails
+func TestSysMounts_PutResource_AlreadyMountedOtherType(t *testing.T) {
+	client := &existingMountClient{
+		MockClient: &vault.MockClient{},
+		existing:   &vaultApi.MountOutput{Type: "kv"},
+	}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+	err = sh.PutResource("sys/mounts/pki", strings.NewReader(`{"type": "pki"}`))
+	if err == nil || !strings.Contains(err.Error(), "cannot change the type") {
+		t.Errorf("Expected an error for the type, got %v", err)
+	}
+}
+
+// seal_wrap is passed when mounting, and a mount can't be changed to or from it
+func TestSysMounts_PutResource_SealWrap(t *testing.T) {
+	client := &vault.MockClient{}
+	sh, err := NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+	if err := sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv", "seal_wrap": true}`)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	calls := client.CallsTo("Mount")
+	if len(calls) != 1 || !calls[0].Args[1].(*vaultApi.MountInput).SealWrap {
+		t.Errorf("Expected the mount to be made with seal_wrap, got %+v", calls)
+	}
+
+	client = &vault.MockClient{
+		ReturnMounts: map[string]*vaultApi.MountOutput{"secret/": {Type: "kv"}},
+	}
+	sh, err = NewSysMountsHandler(client, PathHandlerConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create SysMounts: %s", err)
+	}
+	err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv", "seal_wrap": true}`))
+	if err == nil || !strings.Contains(err.Error(), "seal_wrap") {
+		t.Errorf("Expected an error explaining seal_wrap can't be changed, got %v", err)
+	}
+	if n := len(client.CallsTo("Mount")) + len(client.CallsTo("TuneMount")); n != 0 {
+		t.Errorf("Expected no Mount or TuneMount calls, got %d", n)
+	}
+}
+
+// With VerifyWrites, a mount or tune which Vault doesn't keep fails
+func TestSysMounts_VerifyWrites(t *testing.T) {
+	for name, client := range map[string]*vault.MockClient{
+		"mount": {},
+		"tune": {ReturnMounts: map[string]*vaultApi.MountOutput{
+			"secret/": {Type: "kv", Config: vaultApi.MountConfigOutput{MaxLeaseTTL: 3600}},
+		}},
+	} {
+		sh, err := NewSysMountsHandler(client, PathHandlerConfig{VerifyWrites: true})
+		if err != nil {
+			t.Fatalf("Failed to create SysMounts: %s", err)
+		}
+		err = sh.PutResource("sys/mounts/secret", strings.NewReader(`{"type": "kv", "config": {"max_lease_ttl": "2h"}}`))
+		if err == nil || !strings.Contains(err.Error(), "did not keep") {
+			t.Errorf("%s: expected an error as the change was not kept, got %v", name, err)
+		}
+	}
+}
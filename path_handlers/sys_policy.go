@@ -1,12 +1,12 @@
 package path_handlers
 
 import (
-	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/vault"
-	"os"
+	"io"
+	"io/fs"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -16,7 +16,9 @@ import (
 	SysPolicy handles the creation/enabling of auth methods and policies, described in the
 	configuration under sys
 
-	Unlike SysAuthHandler, it supports templating
+	Unlike SysAuthHandler, it supports templating. The ACL policies may be in either sys/policy
+	(the legacy layout) or sys/policies/acl, but are always applied through sys/policies/acl,
+	unless Vault is too old to have it.
 */
 
 // fixed policies that should not be deleted from vault under any circumstances
@@ -27,8 +29,11 @@ var fixedPolicies = map[string]bool{
 
 type SysPolicy struct {
 	BaseHandler
+	prefix               string // of the resource paths of the documents, e.g. "sys/policy/"
 	livePolicyList       []string
 	configuredPolicyList []string
+	// the live text of the policies read, to roll back to
+	livePolicies map[string]string
 }
 
 type policy struct {
@@ -37,9 +42,27 @@ type policy struct {
 	SourceFile string // only for logging
 }
 
+// Handle the ACL policies in sys/policy
 func NewSysPolicyHandler(client vault.Vault, config PathHandlerConfig) (*SysPolicy, error) {
+	return newPolicyHandler(client, config, "sys/policy/")
+}
+
+// Handle the ACL policies in sys/policies/acl
+func NewACLPolicyHandler(client vault.Vault, config PathHandlerConfig) (*SysPolicy, error) {
+	return newPolicyHandler(client, config, "sys/policies/acl/")
+}
+
+func newPolicyHandler(client vault.Vault, config PathHandlerConfig, prefix string) (*SysPolicy, error) {
+	logger := handlerLogger("SysPolicy", config)
+
 	// Build a map of currently active auth methods, so walkFile() can reference it
-	livePolicyList, err := client.ListPolicies()
+	var livePolicyList []string
+	err := timed(config.Summary, logger, strings.TrimSuffix(prefix, "/"), "list", func() error {
+		return withRetries(config.Retries, logger, func() (err error) {
+			livePolicyList, err = client.ListPolicies()
+			return err
+		})
+	})
 	if err != nil {
 		return &SysPolicy{}, fmt.Errorf("error listing policies: %s", err)
 	}
@@ -49,16 +72,16 @@ func NewSysPolicyHandler(client vault.Vault, config PathHandlerConfig) (*SysPoli
 			name:   "SysPolicy",
 			client: client,
 			config: config,
-			log: log.WithFields(log.Fields{
-				"handler": "SysPolicy",
-			}),
+			order:  config.Order,
+			log:    logger,
 		},
+		prefix:               prefix,
 		livePolicyList:       livePolicyList,
 		configuredPolicyList: []string{},
 	}, nil
 }
 
-func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
+func (sh *SysPolicy) walkFile(path string, f fs.DirEntry, err error) error {
 	if f == nil {
 		sh.log.Infof("%q does not exist, skipping handler. Error was %q", path, err.Error())
 		return nil
@@ -66,6 +89,9 @@ func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return fmt.Errorf("error finding %s: %s", path, err)
 	}
+	if sh.ignored(path, f) {
+		return skipIgnored(f)
+	}
 	// not doing anything with dirs
 	if f.IsDir() {
 		return nil
@@ -76,7 +102,7 @@ func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
 		return fmt.Errorf("could not generate template parameters: %s", err)
 	}
 
-	content, err := document.Read(path)
+	content, err := sh.readFile(path)
 	if err != nil {
 		return fmt.Errorf("error reading %s: %s", path, err)
 	}
@@ -91,21 +117,32 @@ func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
 		return fmt.Errorf("failed to render document %q: %s", path, err)
 	}
 
-	apiPath, err := apiPath(sh.config.DocumentPath, path)
+	apiPath, err := sh.resourcePath(path)
 	if err != nil {
 		return err
 	}
-	if !strings.HasPrefix(apiPath, "sys/policy") {
-		return fmt.Errorf("found file without sys/policy prefix: %s", apiPath)
+	if !strings.HasPrefix(apiPath, sh.prefix) {
+		return fmt.Errorf("found file without %s prefix: %s", strings.TrimSuffix(sh.prefix, "/"), apiPath)
 	}
 	for _, td := range templatedDocs {
 		policy := policy{
 			Name:       td.Name,
 			SourceFile: f.Name(),
 		}
-		err = json.Unmarshal([]byte(td.Content), &policy)
+		annotations, err := sh.decodeReader(path, strings.NewReader(td.Content), &policy)
+		if err != nil {
+			return err
+		}
+		enabled, err := sh.enabled(sh.prefix+policy.Name, annotations)
 		if err != nil {
-			return fmt.Errorf("failed to parse json from %s: %s", path, err)
+			return err
+		}
+		if !enabled {
+			if !annotations.PruneWhenDisabled {
+				// still declared, so not deleted
+				sh.configuredPolicyList = append(sh.configuredPolicyList, policy.Name)
+			}
+			continue
 		}
 
 		err = sh.EnsurePolicy(policy)
@@ -117,8 +154,29 @@ func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
 	return nil
 }
 
+// Apply a single policy, e.g. resourcePath "sys/policy/read_secrets"
+func (sh *SysPolicy) PutResource(resourcePath string, r io.Reader) error {
+	resourcePath = normalizePath(resourcePath)
+	if !strings.HasPrefix(resourcePath, sh.prefix) {
+		return fmt.Errorf("resource path %s does not have %s prefix", resourcePath, strings.TrimSuffix(sh.prefix, "/"))
+	}
+
+	p := policy{
+		Name:       strings.TrimPrefix(resourcePath, sh.prefix),
+		SourceFile: resourcePath,
+	}
+	annotations, err := sh.decodeReader(resourcePath, r, &p)
+	if err != nil {
+		return err
+	}
+	if enabled, err := sh.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+	return sh.EnsurePolicy(p)
+}
+
 func (sh *SysPolicy) PutPoliciesFromDir(path string) error {
-	err := filepath.Walk(path, sh.walkFile)
+	err := sh.walk(path, sh.walkFile)
 	if err != nil {
 		return err
 	}
@@ -126,30 +184,52 @@ func (sh *SysPolicy) PutPoliciesFromDir(path string) error {
 	return err
 }
 
-func (sh *SysPolicy) EnsurePolicy(policy policy) error {
+func (sh *SysPolicy) EnsurePolicy(policy policy) (err error) {
 	logger := sh.log.WithFields(log.Fields{
 		"name":       policy.Name,
 		"sourceFile": policy.SourceFile,
 	})
 
 	sh.configuredPolicyList = append(sh.configuredPolicyList, policy.Name)
+	if sh.ignoredResource(sh.prefix+policy.Name) || sh.appliedEarlier(sh.prefix+policy.Name) {
+		return nil
+	}
+	defer func() { sh.recordApplied(sh.prefix+policy.Name, err) }()
 	applied, err := sh.isPolicyApplied(policy)
 	if err != nil {
 		return err
 	}
 	if applied {
 		logger.Debugf("Policy already applied")
+		sh.unchanged(sh.prefix + policy.Name)
 		return nil
 	}
 	logger.Info("Applying policy")
-	return sh.client.PutPolicy(policy.Name, policy.Policy)
+	var live interface{} // nil if it's a new policy
+	if previous, ok := sh.livePolicies[policy.Name]; ok {
+		live = previous
+	}
+	sh.diff(sh.prefix+policy.Name, policy.Policy, live)
+	err = sh.timed(sh.prefix+policy.Name, "write", func() error {
+		return sh.client.PutPolicy(policy.Name, policy.Policy)
+	})
+	if previous, ok := sh.livePolicies[policy.Name]; ok {
+		sh.onRollback(func() error {
+			return sh.client.PutPolicy(policy.Name, previous)
+		})
+	} else if !sh.policyExists(policy) {
+		sh.onRollback(func() error {
+			return sh.client.DeletePolicy(policy.Name)
+		})
+	}
+	return sh.result(sh.prefix+policy.Name, "write", err)
 }
 
 func (sh *SysPolicy) RemoveUndeclaredPolicies() (deleted []string, err error) {
 	// only real reason to track the deleted policies is for testing as logs inform user
 	for _, liveName := range sh.livePolicyList {
-		if fixedPolicies[liveName] {
-			// never want to delete default or root
+		if fixedPolicies[liveName] || sh.ignoredResource(sh.prefix+liveName) {
+			// never want to delete default or root, nor those managed elsewhere
 			continue
 		}
 
@@ -165,8 +245,18 @@ func (sh *SysPolicy) RemoveUndeclaredPolicies() (deleted []string, err error) {
 		if !found {
 			// not declared, delete
 			sh.log.WithFields(log.Fields{"policy": liveName}).Infof("Deleting policy")
-			sh.client.DeletePolicy(liveName)
-			deleted = append(deleted, liveName)
+			if sh.config.Rollback != nil {
+				sh.keepForRollback(liveName)
+			}
+			err := sh.timed(sh.prefix+liveName, "delete", func() error {
+				return sh.client.DeletePolicy(liveName)
+			})
+			if err := sh.result(sh.prefix+liveName, "delete", err); err != nil {
+				return deleted, err
+			}
+			if err == nil {
+				deleted = append(deleted, liveName)
+			}
 		}
 	}
 	return deleted, nil
@@ -190,11 +280,20 @@ func (sh *SysPolicy) isPolicyApplied(policy policy) (bool, error) {
 		return false, nil
 	}
 
-	remotePolicy, err := sh.client.GetPolicy(policy.Name)
+	var remotePolicy string
+	err := sh.timed(sh.prefix+policy.Name, "read", func() (err error) {
+		remotePolicy, err = sh.client.GetPolicy(policy.Name)
+		return err
+	})
 	if err != nil {
 		return false, nil
 	}
 
+	if sh.livePolicies == nil {
+		sh.livePolicies = map[string]string{}
+	}
+	sh.livePolicies[policy.Name] = remotePolicy
+
 	// TODO Need a proper HCL parser here, testing strings is error prone
 	if reflect.DeepEqual(policy.Policy, remotePolicy) {
 		return true, nil
@@ -204,6 +303,22 @@ func (sh *SysPolicy) isPolicyApplied(policy policy) (bool, error) {
 	}
 }
 
+// Read the policy about to be deleted, so it can be put back if the run is rolled back
+func (sh *SysPolicy) keepForRollback(name string) {
+	var previous string
+	err := sh.timed(sh.prefix+name, "read", func() (err error) {
+		previous, err = sh.client.GetPolicy(name)
+		return err
+	})
+	if err != nil {
+		sh.log.WithFields(log.Fields{"policy": name}).Warnf("Could not read policy to roll back to: %s", err)
+		return
+	}
+	sh.onRollback(func() error {
+		return sh.client.PutPolicy(name, previous)
+	})
+}
+
 func (sh *SysPolicy) Order() int {
 	return sh.order
 }
@@ -3,6 +3,10 @@ package path_handlers
 import (
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/vault"
+	"github.com/starlingbank/vaultsmith/vault/vaulttest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -116,3 +120,47 @@ func TestSysPolicyHandler_RemoveUndeclaredPolicies(t *testing.T) {
 			deleted, expected)
 	}
 }
+
+// Policies in sys/policies/acl are applied through the ACL policy endpoint of the real client
+func TestACLPolicy_RealClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	aclDir := filepath.Join(dir, "sys", "policies", "acl")
+	os.MkdirAll(aclDir, 0755)
+	err = ioutil.WriteFile(filepath.Join(aclDir, "read_secrets.json"),
+		[]byte(`{"policy": "path \"secret/*\" { capabilities = [\"read\"] }"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.Policies["old"] = `path "old/*" { capabilities = ["read"] }`
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	sph, err := NewACLPolicyHandler(client, PathHandlerConfig{DocumentPath: dir})
+	if err != nil {
+		t.Fatalf("Failed to create ACL policy handler: %s", err)
+	}
+	err = sph.PutPoliciesFromDir(aclDir)
+	if err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+
+	var requests []string
+	for _, r := range server.Requests() {
+		requests = append(requests, r.Method+" "+r.Path)
+	}
+	expected := []string{"PUT sys/policies/acl/read_secrets", "DELETE sys/policies/acl/old"}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %+v, got %+v", expected, requests)
+	}
+	if rules := server.Policies["read_secrets"]; rules != `path "secret/*" { capabilities = ["read"] }` {
+		t.Errorf("Expected read_secrets to be written, got %q", rules)
+	}
+}
@@ -0,0 +1,361 @@
+package path_handlers
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+/*
+	Transit manages the keys of the transit secrets engine, declared in transit/keys/<name>.json, e.g.
+		{"type": "aes256-gcm96", "exportable": false, "min_decryption_version": 1, "auto_rotate_period": "720h"}
+
+	A key is created with its type, exportable, allow_plaintext_backup, derived and
+	convergent_encryption, and its config (min_decryption_version, min_encryption_version,
+	exportable, allow_plaintext_backup and auto_rotate_period) is written when it differs from
+	Vault. Keys are never rotated. The type, derived and convergent_encryption can't be changed
+	once a key is created, nor can exportable or allow_plaintext_backup be turned off, so these are
+	errors rather than changes.
+
+	Deleting a key destroys everything encrypted with it, so undeclared keys are only deleted when
+	AllowDeleteTransitKeys is set; otherwise they are skipped with a warning.
+*/
+type Transit struct {
+	BaseHandler
+	configured map[string]bool
+}
+
+// As the fields of the transit keys API
+type transitKey struct {
+	Type                 string      `json:"type"`
+	Exportable           bool        `json:"exportable"`
+	AllowPlaintextBackup bool        `json:"allow_plaintext_backup"`
+	Derived              bool        `json:"derived"`
+	ConvergentEncryption bool        `json:"convergent_encryption"`
+	MinDecryptionVersion *int        `json:"min_decryption_version"`
+	MinEncryptionVersion *int        `json:"min_encryption_version"`
+	AutoRotatePeriod     interface{} `json:"auto_rotate_period"` // a duration, or seconds
+}
+
+const transitKeysPath = "transit/keys"
+
+// Vault's type for a key created without one
+const defaultTransitKeyType = "aes256-gcm96"
+
+func NewTransitHandler(client vault.Vault, config PathHandlerConfig) (*Transit, error) {
+	return &Transit{
+		BaseHandler: BaseHandler{
+			name:   "Transit",
+			client: client,
+			config: config,
+			order:  config.Order,
+			log:    handlerLogger("Transit", config),
+		},
+		configured: map[string]bool{},
+	}, nil
+}
+
+func (th *Transit) walkFile(path string, f fs.DirEntry, err error) error {
+	if f == nil {
+		th.log.WithFields(log.Fields{"path": path, "error": err}).Debug("Path does not exist, skipping")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	if th.ignored(path, f) {
+		return skipIgnored(f)
+	}
+	if f.IsDir() {
+		return nil
+	}
+
+	resourcePath, err := th.resourcePath(path)
+	if err != nil {
+		return err
+	}
+	name, err := transitKeyName(resourcePath)
+	if err != nil {
+		return err
+	}
+
+	var key transitKey
+	annotations, err := th.decodeFile(path, &key)
+	if err != nil {
+		return err
+	}
+	enabled, err := th.enabled(resourcePath, annotations)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if !annotations.PruneWhenDisabled {
+			// still declared, so not deleted
+			th.configured[name] = true
+		}
+		return nil
+	}
+	return th.ensureKey(name, key)
+}
+
+// Apply a single key, e.g. resourcePath "transit/keys/payments"
+func (th *Transit) PutResource(resourcePath string, r io.Reader) error {
+	resourcePath = normalizePath(resourcePath)
+	name, err := transitKeyName(resourcePath)
+	if err != nil {
+		return err
+	}
+
+	var key transitKey
+	annotations, err := th.decodeReader(resourcePath, r, &key)
+	if err != nil {
+		return err
+	}
+	if enabled, err := th.enabled(resourcePath, annotations); err != nil || !enabled {
+		return err
+	}
+	return th.ensureKey(name, key)
+}
+
+func (th *Transit) PutPoliciesFromDir(path string) error {
+	err := th.walk(path, th.walkFile)
+	if err != nil {
+		return err
+	}
+	return th.deleteUndeclared()
+}
+
+// The key name of a resource path
+func transitKeyName(resourcePath string) (string, error) {
+	name := strings.TrimPrefix(resourcePath, transitKeysPath+"/")
+	if !strings.HasPrefix(resourcePath, transitKeysPath+"/") || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("transit key %s must be at %s/<name>", resourcePath, transitKeysPath)
+	}
+	return name, nil
+}
+
+// Ensure the key exists with the configured settings
+func (th *Transit) ensureKey(name string, key transitKey) (err error) {
+	resource := transitKeysPath + "/" + name
+	logger := th.log.WithFields(log.Fields{"key": name})
+	th.configured[name] = true
+	if th.ignoredResource(resource) || th.appliedEarlier(resource) {
+		return nil
+	}
+	defer func() { th.recordApplied(resource, err) }()
+	if key.Type == "" {
+		key.Type = defaultTransitKeyType
+	}
+
+	var live *vaultApi.Secret
+	err = th.timed(resource, "read", func() (err error) {
+		live, err = th.client.Read(resource)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not read transit key %s: %s", name, err)
+	}
+
+	if live == nil || live.Data == nil {
+		config, err := configData(key, nil)
+		if err != nil {
+			return fmt.Errorf("invalid transit key %s: %s", name, err)
+		}
+		logger.WithFields(log.Fields{"type": key.Type}).Info("Creating transit key")
+		err = th.timed(resource, "create", func() error {
+			_, err := th.client.Write(resource, createData(key))
+			return err
+		})
+		if err != nil {
+			return th.result(resource, "create", fmt.Errorf("could not create transit key %s: %s", name, err))
+		}
+		deleteKey := func() error {
+			return th.deleteKey(name)
+		}
+		if len(config) == 0 {
+			th.onRollback(deleteKey)
+			return th.result(resource, "create", nil)
+		}
+		th.config.Summary.Add(resource, "create", nil)
+		th.config.Rollback.record(resource, "create", deleteKey)
+		// the versions can only be set once the key exists
+		return th.writeConfig(name, config)
+	}
+
+	if err := checkImmutable(name, key, live.Data); err != nil {
+		return th.result(resource, "configure", err)
+	}
+	config, err := configData(key, live.Data)
+	if err != nil {
+		return fmt.Errorf("invalid transit key %s: %s", name, err)
+	}
+	if len(config) == 0 {
+		logger.Debug("Transit key already configured")
+		th.unchanged(resource)
+		return nil
+	}
+	restore := map[string]interface{}{}
+	for k := range config {
+		if v, ok := live.Data[k]; ok {
+			restore[k] = v
+		}
+	}
+	th.onRollback(func() error {
+		_, err := th.client.Write(resource+"/config", restore)
+		return err
+	})
+	return th.writeConfig(name, config)
+}
+
+func (th *Transit) writeConfig(name string, config map[string]interface{}) error {
+	resource := transitKeysPath + "/" + name
+	th.log.WithFields(log.Fields{"key": name, "config": config}).Info("Configuring transit key")
+	err := th.timed(resource, "configure", func() error {
+		_, err := th.client.Write(resource+"/config", config)
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("could not configure transit key %s: %s", name, err)
+	}
+	return th.result(resource, "configure", err)
+}
+
+// The fields a key is created with
+func createData(key transitKey) map[string]interface{} {
+	data := map[string]interface{}{"type": key.Type}
+	if key.Exportable {
+		data["exportable"] = true
+	}
+	if key.AllowPlaintextBackup {
+		data["allow_plaintext_backup"] = true
+	}
+	if key.Derived {
+		data["derived"] = true
+	}
+	if key.ConvergentEncryption {
+		data["convergent_encryption"] = true
+	}
+	return data
+}
+
+// The config fields of key which differ from live, or all of those set if live is nil (the key has
+// just been created). exportable and allow_plaintext_backup are set when creating.
+func configData(key transitKey, live map[string]interface{}) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	if live != nil {
+		if key.Exportable && live["exportable"] != true {
+			config["exportable"] = true
+		}
+		if key.AllowPlaintextBackup && live["allow_plaintext_backup"] != true {
+			config["allow_plaintext_backup"] = true
+		}
+	}
+	if v := key.MinDecryptionVersion; v != nil && (live == nil || !isNumberEquivalent(*v, live["min_decryption_version"])) {
+		config["min_decryption_version"] = *v
+	}
+	if v := key.MinEncryptionVersion; v != nil && (live == nil || !isNumberEquivalent(*v, live["min_encryption_version"])) {
+		config["min_encryption_version"] = *v
+	}
+	if key.AutoRotatePeriod != nil {
+		configured, err := convertToDuration(key.AutoRotatePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("auto_rotate_period: %s", err)
+		}
+		// not returned by Vaults without auto-rotation, which is the same as it being off
+		var livePeriod interface{} = 0
+		if v, ok := live["auto_rotate_period"]; ok {
+			livePeriod = v
+		}
+		if applied, err := convertToDuration(livePeriod); live == nil || err != nil || applied != configured {
+			config["auto_rotate_period"] = key.AutoRotatePeriod
+		}
+	}
+	return config, nil
+}
+
+// Vault can't change these once the key exists, so a difference is an error
+func checkImmutable(name string, key transitKey, live map[string]interface{}) error {
+	if live["type"] != key.Type {
+		return fmt.Errorf("transit key %s is of type %v but is configured as %q; the type of a key "+
+			"can't be changed", name, live["type"], key.Type)
+	}
+	if (live["derived"] == true) != key.Derived {
+		return fmt.Errorf("transit key %s has derived set to %v but is configured with %t; this "+
+			"can't be changed once the key is created", name, live["derived"] == true, key.Derived)
+	}
+	if (live["convergent_encryption"] == true) != key.ConvergentEncryption {
+		return fmt.Errorf("transit key %s has convergent_encryption set to %v but is configured "+
+			"with %t; this can't be changed once the key is created", name,
+			live["convergent_encryption"] == true, key.ConvergentEncryption)
+	}
+	if live["exportable"] == true && !key.Exportable {
+		return fmt.Errorf("transit key %s is exportable, which can't be turned off", name)
+	}
+	if live["allow_plaintext_backup"] == true && !key.AllowPlaintextBackup {
+		return fmt.Errorf("transit key %s allows plaintext backups, which can't be turned off", name)
+	}
+	return nil
+}
+
+// Delete the keys in Vault which aren't declared, if allowed
+func (th *Transit) deleteUndeclared() error {
+	var secret *vaultApi.Secret
+	err := th.timed(transitKeysPath, "list", func() (err error) {
+		secret, err = th.client.List(transitKeysPath)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing transit keys: %s", err)
+	}
+	if secret == nil {
+		return nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	var names []string
+	for _, k := range keys {
+		if name, ok := k.(string); ok && !th.configured[name] && !th.ignoredResource(transitKeysPath+"/"+name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resource := transitKeysPath + "/" + name
+		logger := th.log.WithFields(log.Fields{"key": name})
+		if !th.config.AllowDeleteTransitKeys {
+			logger.Warn("Not deleting undeclared transit key, as deleting it destroys everything " +
+				"encrypted with it")
+			th.config.Summary.Skip(resource, "delete", "transit keys are only deleted with "+
+				"--allow-delete-transit-keys")
+			continue
+		}
+		logger.Warn("Deleting undeclared transit key. Everything encrypted with it can no longer " +
+			"be decrypted!")
+		err := th.timed(resource, "delete", func() error {
+			return th.deleteKey(name)
+		})
+		if err != nil {
+			err = fmt.Errorf("could not delete transit key %s: %s", name, err)
+		}
+		if err := th.result(resource, "delete", err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Vault only deletes a key once it is configured to allow this
+func (th *Transit) deleteKey(name string) error {
+	resource := transitKeysPath + "/" + name
+	_, err := th.client.Write(resource+"/config", map[string]interface{}{"deletion_allowed": true})
+	if err != nil {
+		return err
+	}
+	_, err = th.client.Delete(resource)
+	return err
+}
@@ -0,0 +1,170 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func applyTransit(t *testing.T, client *vault.MockClient, config PathHandlerConfig) error {
+	th, err := NewTransitHandler(client, config)
+	if err != nil {
+		t.Fatalf("Failed to create Transit handler: %s", err)
+	}
+	return th.PutPoliciesFromDir(filepath.Join(config.DocumentPath, "transit", "keys"))
+}
+
+// The writes made, as "<path> <data>"
+func transitWrites(client *vault.MockClient) []string {
+	var writes []string
+	for _, c := range client.CallsTo("Write") {
+		data, _ := json.Marshal(c.Args[1])
+		writes = append(writes, c.Args[0].(string)+" "+string(data))
+	}
+	return writes
+}
+
+func TestTransit_CreatesKey(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"transit/keys/payments.json": `{"type": "rsa-4096", "exportable": true, "min_decryption_version": 1, "auto_rotate_period": "720h"}`,
+		"transit/keys/default.json":  `{}`,
+	})
+
+	client := &vault.MockClient{}
+	if err := applyTransit(t, client, PathHandlerConfig{DocumentPath: docPath}); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	expected := []string{
+		`transit/keys/default {"type":"aes256-gcm96"}`,
+		`transit/keys/payments {"exportable":true,"type":"rsa-4096"}`,
+		`transit/keys/payments/config {"auto_rotate_period":"720h","min_decryption_version":1}`,
+	}
+	if got := transitWrites(client); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected writes %+v, got %+v", expected, got)
+	}
+}
+
+func TestTransit_UpdatesConfig(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"transit/keys/payments.json": `{"allow_plaintext_backup": true, "min_decryption_version": 3, "auto_rotate_period": "24h"}`,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"transit/keys/payments": {Data: map[string]interface{}{
+				"type":                   "aes256-gcm96",
+				"allow_plaintext_backup": false,
+				"min_decryption_version": json.Number("1"),
+				"auto_rotate_period":     json.Number("86400"),
+				"latest_version":         json.Number("3"),
+			}},
+		},
+	}
+	if err := applyTransit(t, client, PathHandlerConfig{DocumentPath: docPath}); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	// the auto-rotate period is already applied, as seconds
+	expected := []string{`transit/keys/payments/config {"allow_plaintext_backup":true,"min_decryption_version":3}`}
+	if got := transitWrites(client); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected writes %+v, got %+v", expected, got)
+	}
+	// the key is never rotated
+	for _, c := range client.CallLog {
+		if len(c.Args) > 0 && strings.HasSuffix(c.Args[0].(string), "/rotate") {
+			t.Errorf("Expected the key not to be rotated, got %+v", c)
+		}
+	}
+}
+
+func TestTransit_NoChange(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"transit/keys/payments.json": `{"min_decryption_version": 1, "auto_rotate_period": 0}`,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"transit/keys/payments": {Data: map[string]interface{}{
+				"type":                   "aes256-gcm96",
+				"min_decryption_version": json.Number("1"),
+			}},
+		},
+	}
+	if err := applyTransit(t, client, PathHandlerConfig{DocumentPath: docPath}); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if writes := transitWrites(client); len(writes) != 0 {
+		t.Errorf("Expected no writes, got %+v", writes)
+	}
+}
+
+// What Vault can't change once a key is created is an error, not a change
+func TestTransit_Immutable(t *testing.T) {
+	for doc, live := range map[string]map[string]interface{}{
+		`{"type": "rsa-4096"}`:     {"type": "aes256-gcm96"},
+		`{"derived": true}`:        {"type": "aes256-gcm96", "derived": false},
+		`{"exportable": false}`:    {"type": "aes256-gcm96", "exportable": true},
+		`{"type": "aes256-gcm96"}`: {"type": "aes256-gcm96", "allow_plaintext_backup": true},
+	} {
+		client := &vault.MockClient{
+			ReturnSecrets: map[string]*vaultApi.Secret{"transit/keys/payments": {Data: live}},
+		}
+		th, err := NewTransitHandler(client, PathHandlerConfig{})
+		if err != nil {
+			t.Fatalf("Failed to create Transit handler: %s", err)
+		}
+		if err := th.PutResource("transit/keys/payments", strings.NewReader(doc)); err == nil {
+			t.Errorf("Expected an error for %s with %+v in Vault", doc, live)
+		}
+		if writes := transitWrites(client); len(writes) != 0 {
+			t.Errorf("Expected no writes for %s, got %+v", doc, writes)
+		}
+	}
+}
+
+// Undeclared keys are only deleted when explicitly allowed
+func TestTransit_DeletionGuard(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"transit/keys/payments.json": `{}`})
+	newClient := func() *vault.MockClient {
+		return &vault.MockClient{
+			ReturnSecrets: map[string]*vaultApi.Secret{
+				"transit/keys/payments": {Data: map[string]interface{}{"type": "aes256-gcm96"}},
+			},
+			ReturnListSecret: &vaultApi.Secret{
+				Data: map[string]interface{}{"keys": []interface{}{"orphan", "payments"}},
+			},
+		}
+	}
+
+	client := newClient()
+	summary := &Summary{}
+	if err := applyTransit(t, client, PathHandlerConfig{DocumentPath: docPath, Summary: summary}); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if deletes := client.CallsTo("Delete"); len(deletes) != 0 {
+		t.Errorf("Expected no deletes without AllowDeleteTransitKeys, got %+v", deletes)
+	}
+	if writes := transitWrites(client); len(writes) != 0 {
+		t.Errorf("Expected no writes without AllowDeleteTransitKeys, got %+v", writes)
+	}
+	if len(summary.Rows) != 1 || summary.Rows[0].Resource != "transit/keys/orphan" || summary.Rows[0].Status != StatusSkipped {
+		t.Errorf("Expected the deletion of the orphan to be skipped in the summary, got %+v", summary.Rows)
+	}
+
+	client = newClient()
+	if err := applyTransit(t, client, PathHandlerConfig{DocumentPath: docPath, AllowDeleteTransitKeys: true}); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	expected := []string{`transit/keys/orphan/config {"deletion_allowed":true}`}
+	if got := transitWrites(client); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected writes %+v, got %+v", expected, got)
+	}
+	deletes := client.CallsTo("Delete")
+	if len(deletes) != 1 || deletes[0].Args[0] != "transit/keys/orphan" {
+		t.Errorf("Expected only transit/keys/orphan to be deleted, got %+v", deletes)
+	}
+}
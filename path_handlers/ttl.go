@@ -0,0 +1,39 @@
+package path_handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse a TTL in any of the forms Vault accepts: a bare number of seconds ("0", "3600"), a Go
+// duration ("24h", "1m10s"), or a number of days ("7d"). time.ParseDuration alone rejects the bare
+// seconds which Vault itself returns, and days.
+func parseTTL(ttl string) (time.Duration, error) {
+	s := strings.TrimSpace(ttl)
+	if s == "" {
+		return 0, fmt.Errorf("empty TTL")
+	}
+
+	var dur time.Duration
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		dur = time.Duration(secs) * time.Second
+	} else if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a valid TTL", ttl)
+		}
+		dur = time.Duration(days) * 24 * time.Hour
+	} else {
+		dur, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a valid TTL", ttl)
+		}
+	}
+
+	if dur < 0 {
+		return 0, fmt.Errorf("%q is not a valid TTL, as it is negative", ttl)
+	}
+	return dur, nil
+}
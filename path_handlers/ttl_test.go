@@ -0,0 +1,51 @@
+package path_handlers
+
+import (
+	vaultApi "github.com/hashicorp/vault/api"
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		ttl      string
+		expected time.Duration
+	}{
+		{"0", 0},
+		{"3600", time.Hour},
+		{"24h", 24 * time.Hour},
+		{"720h", 720 * time.Hour},
+		{"1m10s", 70 * time.Second},
+		{"7d", 7 * 24 * time.Hour},
+		{" 60 ", time.Minute},
+	}
+	for _, test := range tests {
+		dur, err := parseTTL(test.ttl)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.ttl, err)
+			continue
+		}
+		if dur != test.expected {
+			t.Errorf("Expected %q to be %s, got %s", test.ttl, test.expected, dur)
+		}
+	}
+}
+
+func TestParseTTL_Invalid(t *testing.T) {
+	for _, ttl := range []string{"", "forever", "1x", "-60", "-1h", "1.5d", "h"} {
+		if dur, err := parseTTL(ttl); err == nil {
+			t.Errorf("Expected an error parsing %q, got %s", ttl, dur)
+		}
+	}
+}
+
+// Bare seconds, as Vault returns them, should be usable in documents
+func TestConvertAuthConfig_BareSeconds(t *testing.T) {
+	out, err := ConvertAuthConfig(vaultApi.AuthConfigInput{DefaultLeaseTTL: "3600", MaxLeaseTTL: "0"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if out.DefaultLeaseTTL != 3600 || out.MaxLeaseTTL != 0 {
+		t.Errorf("Expected 3600 and 0, got %d and %d", out.DefaultLeaseTTL, out.MaxLeaseTTL)
+	}
+}
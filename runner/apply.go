@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The outcome of an Apply
+type Result struct {
+	DocumentPath string                 `json:"document_path"`   // resolved path of the documents that were applied
+	Summary      *path_handlers.Summary `json:"summary"`         // every change made or attempted
+	Drift        []Drift                `json:"drift,omitempty"` // changed outside vaultsmith since the last run
+	// the documents found, by target, before anything was applied
+	Inventory []internal.InventoryEntry `json:"inventory,omitempty"`
+}
+
+// Apply the documents described by config to Vault using client. This is everything the vaultsmith
+// command does after parsing its flags, so it can be embedded in other tools.
+func Apply(ctx context.Context, c vault.Vault, config config.VaultsmithConfig) (Result, error) {
+	started := time.Now()
+	result, err := apply(ctx, c, config)
+	reportErr := writeReport(config, started, result, err)
+	changelogErr := appendChangelog(config, started, result, err)
+	hookErr := runPostApplyHooks(config, result, err)
+	for _, finishErr := range []error{reportErr, changelogErr, hookErr} {
+		if finishErr == nil {
+			continue
+		}
+		if err != nil {
+			// the apply failure is what matters
+			log.Error(finishErr)
+			continue
+		}
+		err = finishErr
+	}
+	return result, err
+}
+
+func apply(ctx context.Context, c vault.Vault, config config.VaultsmithConfig) (result Result, err error) {
+	result.Summary = &path_handlers.Summary{Dry: config.Dry}
+	if config.PlanOutPath != "" && !config.Dry {
+		return result, fmt.Errorf("a plan can only be saved by a dry run")
+	}
+	err = authenticate(c, config)
+	if err != nil {
+		return result, err
+	}
+
+	if config.LockPath != "" && !config.Dry {
+		lock, err := acquireLock(c, config.LockPath, config.LockTTL, config.LockWait, nil)
+		if err != nil {
+			return result, err
+		}
+		defer lock.release()
+	}
+
+	if config.StatePath != "" {
+		result.Drift, err = checkDrift(c, config.StatePath, config.IgnoreResources)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	workDir, err := document.NewWorkDir()
+	if err != nil {
+		return result, err
+	}
+
+	docSet, err := document.GetSet(workDir, config)
+	if err != nil {
+		os.Remove(workDir)
+		return result, err
+	}
+	if config.NoCleanUp {
+		// kept even if fetching fails, as that's often what needs looking at
+		defer log.WithField("path", workDir).Info("Keeping the work directory")
+	} else {
+		defer os.Remove(workDir)
+		defer docSet.CleanUp()
+	}
+	err = docSet.Get()
+	if err != nil {
+		return result, err
+	}
+
+	docPath, err := docSet.Path()
+	if err != nil {
+		return result, err
+	}
+	result.DocumentPath = docPath
+
+	// Determine if we have a template file
+	config.TemplateFile = whichFileExists(
+		config.TemplateFile,
+		filepath.Join(docPath, "_vaultsmith.json"),
+	)
+
+	cw, err := internal.NewConfigWalker(c, config, docPath, result.Summary)
+	if err != nil {
+		return result, err
+	}
+	result.Inventory, err = internal.Inventory(docPath, cw.Ignore)
+	if err != nil {
+		return result, fmt.Errorf("could not count the documents in %s: %s", docPath, err)
+	}
+	internal.LogInventory(result.Inventory)
+	var planned *path_handlers.Summary
+	if config.PlanPath != "" {
+		planned, err = checkPlan(ctx, c, config, docPath, config.PlanPath)
+	} else if !config.Dry && (config.ConfirmInput != nil || config.MaxChanges > 0) {
+		planned, err = planRun(ctx, c, config, docPath)
+	}
+	if err != nil {
+		return result, err
+	}
+	if planned != nil {
+		err = checkMaxChanges(planned, config.MaxChanges)
+		// a saved plan was approved, so isn't confirmed again
+		if err == nil && config.PlanPath == "" && config.ConfirmInput != nil {
+			err = confirmDestructive(planned, config.ConfirmInput)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	if config.Since != "" {
+		err = applyChanged(ctx, cw, docPath, config.Since)
+	} else {
+		err = cw.Run(ctx)
+	}
+	if err != nil && config.Atomic && ctx.Err() == nil {
+		log.WithField("error", err).Warn("Change failed, rolling back the run")
+		if rollbackErr := cw.RollBack(result.Summary); rollbackErr != nil {
+			err = fmt.Errorf("%s; %s", err, rollbackErr)
+		} else {
+			err = fmt.Errorf("%s; the changes made before it were rolled back", err)
+		}
+	}
+	// kept only if the run stopped part way, for the next to resume from; once rolled back, the
+	// changes it records are undone
+	complete := err == nil && len(result.Summary.Failed()) == 0
+	rolledBack := err != nil && config.Atomic && ctx.Err() == nil
+	if checkpointErr := cw.CloseCheckpoint(complete || rolledBack); checkpointErr != nil {
+		if err != nil {
+			log.Error(checkpointErr)
+		} else {
+			err = checkpointErr
+		}
+	}
+	if config.StatePath != "" && !config.Dry {
+		// recorded even if the run failed, as it is what is now in Vault
+		if stateErr := saveState(c, config.StatePath, config.IgnoreResources); stateErr != nil {
+			if err != nil {
+				log.Error(stateErr)
+			} else {
+				err = stateErr
+			}
+		}
+	}
+	if config.PlanOutPath != "" && err == nil {
+		err = savePlan(c, config.PlanOutPath, docPath, result.Summary, config.IgnoreResources)
+	}
+	for _, t := range result.Summary.Slowest(5) {
+		log.WithFields(log.Fields{
+			"resource":  t.Resource,
+			"operation": t.Operation,
+			"duration":  t.Duration,
+		}).Debug("Slowest Vault operations")
+	}
+	if err != nil {
+		return result, err
+	}
+	if failed := result.Summary.Failed(); len(failed) > 0 {
+		return result, fmt.Errorf("%d of %d changes failed", len(failed), len(result.Summary.Rows))
+	}
+	return result, nil
+}
+
+// Apply the single resource read from r to config.ResourcePath, without walking a document
+// directory
+func ApplyResource(ctx context.Context, c vault.Vault, config config.VaultsmithConfig, r io.Reader) error {
+	err := authenticate(c, config)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return internal.ApplyResource(c, config, config.ResourcePath, r)
+}
+
+func authenticate(c vault.Vault, config config.VaultsmithConfig) error {
+	err := c.Authenticate(config.VaultRole)
+	if err != nil {
+		return fmt.Errorf("failed authenticating with Vault: %s", err)
+	}
+	return nil
+}
+
+func whichFileExists(filePath ...string) (file string) {
+	for _, f := range filePath {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"file": f}).Debug("file exists")
+			return f
+		} else {
+			log.WithFields(log.Fields{"file": f}).Debug("file does not exist")
+		}
+	}
+	return ""
+}
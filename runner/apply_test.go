@@ -0,0 +1,433 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"github.com/starlingbank/vaultsmith/vault/vaulttest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// calculate path to test fixtures (example/)
+func examplePath() string {
+	wd, _ := os.Getwd()
+	pathArray := strings.Split(wd, string(os.PathSeparator))
+	pathArray = pathArray[:len(pathArray)-1] // trims "runner"
+	path := append(pathArray, "example")
+	return strings.Join(path, string(os.PathSeparator))
+}
+
+func TestApply_Example(t *testing.T) {
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+	}
+
+	result, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if result.DocumentPath != examplePath() {
+		t.Errorf("Expected document path %q, got %q", examplePath(), result.DocumentPath)
+	}
+
+	// every handler should have been run against the example documents
+	enabled := map[string]bool{}
+	for _, c := range client.CallsTo("EnableAuth") {
+		enabled[c.Args[0].(string)] = true
+	}
+	for _, p := range []string{"approle/", "aws/"} {
+		if !enabled[p] {
+			t.Errorf("Expected auth mount %s to be enabled, got %+v", p, enabled)
+		}
+	}
+	if calls := client.CallsTo("PutPolicy"); len(calls) == 0 {
+		t.Error("Expected policies to be written")
+	}
+	written := map[string]bool{}
+	for _, c := range client.CallsTo("Write") {
+		written[c.Args[0].(string)] = true
+	}
+	if !written[filepath.Join("auth", "aws", "config", "client")] {
+		t.Errorf("Expected auth/aws/config/client to be written, got %+v", written)
+	}
+}
+
+// Documents may be written in yaml, going by their extension
+func TestApply_Yaml(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"secret/app/a.yaml": "value: a\n"})
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+	}
+
+	if _, err := Apply(context.Background(), client, conf); err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 || writes[0].Args[0] != "secret/app/a" {
+		t.Fatalf("Expected secret/app/a to be written, got %+v", writes)
+	}
+	if data := writes[0].Args[1].(map[string]interface{}); data["value"] != "a" {
+		t.Errorf("Expected the yaml to be written as decoded, got %+v", data)
+	}
+}
+
+func TestApply_Cancelled(t *testing.T) {
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Apply(ctx, client, conf)
+	if err != context.Canceled {
+		t.Errorf("Expected %s, got %v", context.Canceled, err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected nothing to be applied after cancellation, got %+v", calls)
+	}
+}
+
+// A dry run reads what is live to list exactly what would change, without writing anything
+func TestApply_DryRunPlan(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AuthMounts["approle/"] = &vaultApi.AuthMount{Type: "approle"}
+	server.AuthMounts["userpass/"] = &vaultApi.AuthMount{Type: "userpass"}
+	server.AuthMounts["ldap/"] = &vaultApi.AuthMount{Type: "ldap"}
+	client, err := vault.NewVaultClientWithOptions(vault.ClientOptions{
+		Address:  server.URL,
+		Token:    "root",
+		ReadOnly: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json":  `{"type": "approle", "config": {"default_lease_ttl": "1h"}}`,
+		"sys/auth/userpass.json": `{"type": "userpass"}`,
+		"sys/auth/github.json":   `{"type": "github"}`,
+	})
+
+	result, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		Dry:          true,
+	})
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("Expected no writes in a dry run, got %+v", requests)
+	}
+
+	plan := map[string]string{}
+	for _, r := range result.Summary.Rows {
+		if r.Action != path_handlers.ActionUnchanged && r.Status != path_handlers.StatusPlanned {
+			t.Errorf("Expected %s to be planned, got status %q", r.Resource, r.Status)
+		}
+		plan[r.Resource] = r.Action
+	}
+	expected := map[string]string{
+		"sys/auth/approle/":  "tune",
+		"sys/auth/userpass/": path_handlers.ActionUnchanged,
+		"sys/auth/github/":   "enable",
+		"sys/auth/ldap/":     "disable",
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Expected plan %+v, got %+v", expected, plan)
+	}
+}
+
+// The downloaded or extracted documents are kept with NoCleanUp, and removed without it
+func TestApply_NoCleanUp(t *testing.T) {
+	for _, noCleanUp := range []bool{true, false} {
+		client := &vault.MockClient{}
+		client.On("Authenticate", "root")
+		conf := config.VaultsmithConfig{
+			DocumentPath: filepath.Join(examplePath(), "example.tar.gz"),
+			VaultRole:    "root",
+			NoCleanUp:    noCleanUp,
+		}
+		result, err := Apply(context.Background(), client, conf)
+		if err != nil {
+			t.Fatalf("Error calling Apply: %s", err)
+		}
+
+		// the work directory is the parent of "example.tar.gz-extract"
+		extractDir := result.DocumentPath
+		for filepath.Base(extractDir) != "example.tar.gz-extract" && extractDir != "/" {
+			extractDir = filepath.Dir(extractDir)
+		}
+		if extractDir == "/" {
+			t.Fatalf("Expected the documents to be extracted, got %s", result.DocumentPath)
+		}
+		workDir := filepath.Dir(extractDir)
+		for _, dir := range []string{workDir, extractDir} {
+			_, err := os.Stat(dir)
+			if noCleanUp && err != nil {
+				t.Errorf("Expected %s to be kept: %s", dir, err)
+			} else if !noCleanUp && !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be removed, got %v", dir, err)
+			}
+		}
+		if noCleanUp {
+			os.RemoveAll(workDir)
+		}
+	}
+}
+
+// When the third change fails, the two made before it are undone, the most recent first
+func TestApply_AtomicRollsBack(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json":  `{"type": "approle"}`,
+		"sys/auth/github.json":   `{"type": "github", "description": "changed"}`,
+		"sys/auth/userpass.json": `{"type": "userpass"}`,
+	})
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"github/": {Type: "github", Description: "live",
+				Config: vaultApi.AuthConfigOutput{DefaultLeaseTTL: 3600}},
+		},
+		WriteErrors: map[string]error{"userpass/": errors.New("permission denied")},
+	}
+	client.On("Authenticate", "root")
+	result, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		Atomic:       true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected the failure and rollback to be returned, got %v", err)
+	}
+
+	var calls []string
+	for _, c := range client.CallLog {
+		if c.Method != "ListAuth" {
+			calls = append(calls, c.Method+" "+c.Args[0].(string))
+		}
+	}
+	expected := []string{
+		"EnableAuth approle/",
+		"TuneMount auth/github/",
+		"EnableAuth userpass/",
+		"TuneMount auth/github/",
+		"DisableAuth approle/",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %+v, got %+v", expected, calls)
+	}
+	restored := client.CallsTo("TuneMount")[1].Args[1].(vaultApi.MountConfigInput)
+	if restored.Description == nil || *restored.Description != "live" || restored.DefaultLeaseTTL != "3600s" {
+		t.Errorf("Expected github to be tuned back to its live config, got %+v", restored)
+	}
+
+	var rolledBack []string
+	for _, r := range result.Summary.Rows {
+		if r.Action == "rollback" && r.Status == path_handlers.StatusOK {
+			rolledBack = append(rolledBack, r.Resource)
+		}
+	}
+	if expected := []string{"sys/auth/github/", "sys/auth/approle/"}; !reflect.DeepEqual(rolledBack, expected) {
+		t.Errorf("Expected %+v to be rolled back in the summary, got %+v", expected, rolledBack)
+	}
+}
+
+// Generic documents written before the failure are put back as they were, or deleted if new
+func TestApply_AtomicRollsBackGeneric(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"secret/app/a.json": `{"value": "a"}`,
+		"secret/app/b.json": `{"value": "b"}`,
+		"secret/app/c.json": `{"value": "c"}`,
+	})
+
+	client := &vault.MockClient{
+		ReturnSecrets: map[string]*vaultApi.Secret{
+			"secret/app/b": {Data: map[string]interface{}{"value": "live"}},
+		},
+		WriteErrors: map[string]error{"secret/app/c": errors.New("permission denied")},
+	}
+	client.On("Authenticate", "root")
+	_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		Atomic:       true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected the failure and rollback to be returned, got %v", err)
+	}
+
+	var calls []string
+	for _, c := range client.CallLog {
+		if c.Method == "Write" || c.Method == "Delete" {
+			calls = append(calls, c.Method+" "+c.Args[0].(string))
+		}
+	}
+	expected := []string{
+		"Write secret/app/a",
+		"Write secret/app/b",
+		"Write secret/app/c",
+		"Write secret/app/b",
+		"Delete secret/app/a",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %+v, got %+v", expected, calls)
+	}
+	restored := client.CallsTo("Write")[3].Args[1].(map[string]interface{})
+	if restored["value"] != "live" {
+		t.Errorf("Expected secret/app/b to be written back with its live data, got %+v", restored)
+	}
+}
+
+// A run which fails part way leaves a checkpoint, so the next skips the documents it applied
+func TestApply_CheckpointPath(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"secret/app/a.json": `{"value": "a"}`,
+		"secret/app/b.json": `{"value": "b"}`,
+		"secret/app/c.json": `{"value": "c"}`,
+	})
+	checkpointPath := filepath.Join(t.TempDir(), "vaultsmith.checkpoint")
+	conf := config.VaultsmithConfig{
+		DocumentPath:    docPath,
+		VaultRole:       "root",
+		ContinueOnError: true,
+		CheckpointPath:  checkpointPath,
+	}
+
+	client := &vault.MockClient{WriteErrors: map[string]error{"secret/app/b": errors.New("permission denied")}}
+	client.On("Authenticate", "root")
+	if _, err := Apply(context.Background(), client, conf); err == nil {
+		t.Fatalf("Expected the failed write to be returned")
+	}
+	if _, err := os.Stat(checkpointPath); err != nil {
+		t.Fatalf("Expected the checkpoint to be kept after a failed run: %s", err)
+	}
+
+	client = &vault.MockClient{}
+	client.On("Authenticate", "root")
+	result, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	var read []string
+	for _, c := range client.CallsTo("Read") {
+		read = append(read, c.Args[0].(string))
+	}
+	if expected := []string{"secret/app/b"}; !reflect.DeepEqual(read, expected) {
+		t.Errorf("Expected only %+v to be read again, got %+v", expected, read)
+	}
+	var skipped []string
+	for _, r := range result.Summary.Rows {
+		if r.Status == path_handlers.StatusSkipped {
+			skipped = append(skipped, r.Resource)
+		}
+	}
+	if expected := []string{"secret/app/a", "secret/app/c"}; !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("Expected %+v to be skipped in the summary, got %+v", expected, skipped)
+	}
+	if deleted := client.CallsTo("Delete"); len(deleted) != 0 {
+		t.Errorf("Expected the skipped documents not to be pruned, got %+v", deleted)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkpoint to be removed once a run finished, got %v", err)
+	}
+}
+
+// The checkpoint is used by each handler, not only for generic documents
+func TestApply_CheckpointPath_AuthMethods(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json":  `{"type": "approle"}`,
+		"sys/auth/userpass.json": `{"type": "userpass"}`,
+	})
+	checkpointPath := filepath.Join(t.TempDir(), "vaultsmith.checkpoint")
+	conf := config.VaultsmithConfig{
+		DocumentPath:    docPath,
+		VaultRole:       "root",
+		ContinueOnError: true,
+		CheckpointPath:  checkpointPath,
+	}
+
+	client := &vault.MockClient{WriteErrors: map[string]error{"userpass/": errors.New("permission denied")}}
+	client.On("Authenticate", "root")
+	if _, err := Apply(context.Background(), client, conf); err == nil {
+		t.Fatalf("Expected the failed write to be returned")
+	}
+
+	client = &vault.MockClient{}
+	client.On("Authenticate", "root")
+	if _, err := Apply(context.Background(), client, conf); err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	var enabled []string
+	for _, c := range client.CallsTo("EnableAuth") {
+		enabled = append(enabled, c.Args[0].(string))
+	}
+	if expected := []string{"userpass/"}; !reflect.DeepEqual(enabled, expected) {
+		t.Errorf("Expected only %+v to be enabled again, got %+v", expected, enabled)
+	}
+	if disabled := client.CallsTo("DisableAuth"); len(disabled) != 0 {
+		t.Errorf("Expected the skipped auth method not to be disabled, got %+v", disabled)
+	}
+}
+
+// An undeclared auth mount matching --ignore is neither disabled, in the summary, nor reported as drift
+func TestApply_IgnoreResources(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/approle.json": `{"type": "approle"}`})
+	conf := config.VaultsmithConfig{
+		DocumentPath:    docPath,
+		VaultRole:       "root",
+		StatePath:       filepath.Join(t.TempDir(), "vaultsmith.state"),
+		IgnoreResources: []string{"sys/auth/legacy-*"},
+	}
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"approle/":      {Type: "approle"},
+			"legacy-corp/":  {Type: "userpass"},
+			"unmanaged-ci/": {Type: "userpass"},
+		},
+	}
+	client.On("Authenticate", "root")
+	if _, err := Apply(context.Background(), client, conf); err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	var disabled []string
+	for _, c := range client.CallsTo("DisableAuth") {
+		disabled = append(disabled, c.Args[0].(string))
+	}
+	if expected := []string{"unmanaged-ci/"}; !reflect.DeepEqual(disabled, expected) {
+		t.Errorf("Expected only %+v to be disabled, got %+v", expected, disabled)
+	}
+
+	// changed outside vaultsmith, and it is still left alone
+	client.ReturnAuthMounts["legacy-corp/"].Description = "changed"
+	result, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if len(result.Drift) != 0 {
+		t.Errorf("Expected no drift to be reported, got %+v", result.Drift)
+	}
+	for _, r := range result.Summary.Rows {
+		if strings.Contains(r.Resource, "legacy-corp") {
+			t.Errorf("Expected the ignored mount not to be in the summary, got %+v", r)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Format the changelog entry of a run: a line with when it started, its run ID and outcome, then
+// one per change made or attempted, e.g.
+//
+//	2026-10-14T09:30:00Z run 5f2c9a1e succeeded, 1 change
+//	  enable  sys/auth/approle/  ok
+func changelogEntry(config config.VaultsmithConfig, started time.Time, result Result, applyErr error) []byte {
+	var changes []path_handlers.SummaryRow
+	if result.Summary != nil {
+		for _, row := range result.Summary.Rows {
+			if row.Action != path_handlers.ActionUnchanged {
+				changes = append(changes, row)
+			}
+		}
+	}
+	outcome := "succeeded"
+	if applyErr != nil {
+		outcome = "failed"
+	}
+	plural := "s"
+	if len(changes) == 1 {
+		plural = ""
+	}
+
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "%s run %s %s, %d change%s\n", started.UTC().Format(time.RFC3339),
+		config.RunID, outcome, len(changes), plural)
+	w := tabwriter.NewWriter(&entry, 0, 0, 2, ' ', 0)
+	for _, row := range changes {
+		if row.Error != "" {
+			fmt.Fprintf(w, "  %s\t%s\t%s: %s\n", row.Action, row.Resource, row.Status, row.Error)
+		} else {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", row.Action, row.Resource, row.Status)
+		}
+	}
+	w.Flush()
+	if applyErr != nil {
+		fmt.Fprintf(&entry, "  error: %s\n", applyErr)
+	}
+	entry.WriteString("\n")
+	return entry.Bytes()
+}
+
+// Append the changelog entry of the run to config.ChangelogPath, if set. Dry runs change nothing,
+// so aren't recorded.
+func appendChangelog(config config.VaultsmithConfig, started time.Time, result Result, applyErr error) error {
+	if config.ChangelogPath == "" || config.Dry {
+		return nil
+	}
+	f, err := os.OpenFile(config.ChangelogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open changelog %s: %s", config.ChangelogPath, err)
+	}
+	_, err = f.Write(changelogEntry(config, started, result, applyErr))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write changelog %s: %s", config.ChangelogPath, err)
+	}
+	log.WithField("path", config.ChangelogPath).Debug("Appended to changelog")
+	return nil
+}
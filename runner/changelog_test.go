@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApply_ChangelogPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	changelogPath := filepath.Join(dir, "changelog.txt")
+
+	for _, runID := range []string{"run-1", "run-2"} {
+		client := &vault.MockClient{}
+		client.On("Authenticate", "root")
+		_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+			DocumentPath:  examplePath(),
+			VaultRole:     "root",
+			RunID:         runID,
+			ChangelogPath: changelogPath,
+		})
+		if err != nil {
+			t.Fatalf("Error calling Apply: %s", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(changelogPath)
+	if err != nil {
+		t.Fatalf("Could not read the changelog: %s", err)
+	}
+	entries := strings.Split(strings.TrimSpace(string(content)), "\n\n")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d:\n%s", len(entries), content)
+	}
+	for i, runID := range []string{"run-1", "run-2"} {
+		lines := strings.Split(entries[i], "\n")
+		if !strings.Contains(lines[0], "run "+runID+" succeeded") {
+			t.Errorf("Expected entry %d to be for %s, got %q", i, runID, lines[0])
+		}
+		if len(lines) < 2 || !strings.Contains(entries[i], "enable") {
+			t.Errorf("Expected entry %d to list the changes made, got:\n%s", i, entries[i])
+		}
+	}
+}
+
+func TestApply_ChangelogPathDry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	changelogPath := filepath.Join(dir, "changelog.txt")
+
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	_, err = Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath:  examplePath(),
+		VaultRole:     "root",
+		Dry:           true,
+		ChangelogPath: changelogPath,
+	})
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if _, err := os.Stat(changelogPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no changelog for a dry run, got %v", err)
+	}
+}
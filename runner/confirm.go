@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"io"
+	"os"
+	"strings"
+)
+
+// Returned when the destructive changes of a run aren't confirmed
+var ErrNotConfirmed = errors.New("destructive changes not confirmed, nothing was changed")
+
+// Actions which remove something from Vault
+var destructiveActions = map[string]bool{
+	"delete":     true,
+	"deregister": true,
+	"disable":    true,
+	"recreate":   true,
+}
+
+// where the confirmation prompt is written
+var promptOutput io.Writer = os.Stderr
+
+// If the planned run would remove anything, list what and ask for confirmation on input
+func confirmDestructive(plan *path_handlers.Summary, input io.Reader) error {
+	var destructive []path_handlers.SummaryRow
+	for _, r := range plan.Rows {
+		if destructiveActions[r.Action] && r.Status == path_handlers.StatusPlanned {
+			destructive = append(destructive, r)
+		}
+	}
+	if len(destructive) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(promptOutput, "This run will remove the following from Vault:")
+	for _, r := range destructive {
+		fmt.Fprintf(promptOutput, "  %s %s\n", r.Action, r.Resource)
+	}
+	fmt.Fprint(promptOutput, "Continue? [y/N] ")
+	answer, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("could not read confirmation: %s", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return ErrNotConfirmed
+	}
+}
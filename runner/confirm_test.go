@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"strings"
+	"testing"
+)
+
+// apply a document directory declaring only approle to a Vault which also has github enabled,
+// answering the confirmation prompt with answer
+func applyConfirmed(t *testing.T, answer string) (*vault.MockClient, string, error) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/approle.json": `{"type": "approle"}`})
+
+	var prompt bytes.Buffer
+	defer func(w io.Writer) { promptOutput = w }(promptOutput)
+	promptOutput = &prompt
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"approle/": {Type: "approle"},
+			"github/":  {Type: "github"},
+		},
+	}
+	client.On("Authenticate", "root")
+	_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		ConfirmInput: strings.NewReader(answer),
+	})
+	return client, prompt.String(), err
+}
+
+func TestApply_ConfirmDeclined(t *testing.T) {
+	client, prompt, err := applyConfirmed(t, "n\n")
+	if err != ErrNotConfirmed {
+		t.Errorf("Expected %q, got %v", ErrNotConfirmed, err)
+	}
+	if !strings.Contains(prompt, "disable sys/auth/github/") {
+		t.Errorf("Expected github to be listed in the prompt, got %q", prompt)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no DisableAuth calls, got %+v", calls)
+	}
+}
+
+func TestApply_ConfirmAccepted(t *testing.T) {
+	client, _, err := applyConfirmed(t, "y\n")
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	calls := client.CallsTo("DisableAuth")
+	if len(calls) != 1 || calls[0].Args[0] != "github/" {
+		t.Errorf("Expected github/ to be disabled, got %+v", calls)
+	}
+}
+
+// Nothing to remove, so nothing is asked
+func TestApply_ConfirmNotNeeded(t *testing.T) {
+	var prompt bytes.Buffer
+	defer func(w io.Writer) { promptOutput = w }(promptOutput)
+	promptOutput = &prompt
+
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		ConfirmInput: strings.NewReader(""),
+	})
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if prompt.Len() != 0 {
+		t.Errorf("Expected no prompt, got %q", prompt.String())
+	}
+}
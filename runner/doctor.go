@@ -0,0 +1,202 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A check made by Doctor
+type Check struct {
+	Name    string
+	Err     error  // nil if it passed
+	Skipped bool   // not made, as one it depends on failed
+	Hint    string // how to fix it, if it failed
+}
+
+// The most denied paths listed by the capabilities check
+const maxDeniedPaths = 5
+
+// Check everything a run needs, which a new user would otherwise find out from a failed run: that
+// Vault is reachable and unsealed, that we can log in with a token which won't expire part way
+// through (as Preflight does), that the documents can be fetched and parsed, and that the token may
+// write each of them. Every check is made, except those depending on one which failed. Nothing is
+// written to Vault.
+func Doctor(c vault.Vault, config config.VaultsmithConfig) []Check {
+	c = vault.NewReadOnlyClient(c)
+	var checks []Check
+	cleanUp := func() {}
+	add := func(name string, hint string, depends bool, check func() error) bool {
+		if !depends {
+			checks = append(checks, Check{Name: name, Skipped: true})
+			return false
+		}
+		err := check()
+		if err == nil {
+			hint = ""
+		}
+		checks = append(checks, Check{Name: name, Err: err, Hint: hint})
+		return err == nil
+	}
+
+	health, err := c.Health()
+	checks = append(checks, Check{Name: "Vault is reachable", Err: err, Hint: reachHint(err)})
+	unsealed := add("Vault is initialized and unsealed", "Initialize Vault with `vault operator init`, "+
+		"or unseal it with `vault operator unseal`", err == nil, func() error {
+		return problems(checkHealth(health))
+	})
+	loggedIn := add("Logged in to Vault", "Set VAULT_TOKEN, or check --role, or --approle-role-id "+
+		"and its wrapping token, and --auth-path if the auth method isn't at its default path",
+		unsealed, func() error {
+			return authenticate(c, config)
+		})
+	add("The token is valid for long enough", "Log in again for a new token, or lower "+
+		"--min-token-ttl", loggedIn, func() error {
+		return problems(checkToken(c, config.MinTokenTTL))
+	})
+
+	workDir, err := document.NewWorkDir()
+	if err != nil {
+		return append(checks, Check{Name: "The documents can be fetched", Err: err})
+	}
+	defer os.Remove(workDir)
+	var docPath string
+	fetched := add("The documents can be fetched", "Check --document-path exists, and for a "+
+		"download that --http-auth-token, --http-ca-cert or the Cloud Storage credentials are "+
+		"right", true, func() error {
+		docSet, err := document.GetSet(workDir, config)
+		if err != nil {
+			return err
+		}
+		// removed once the other checks are done
+		cleanUp = docSet.CleanUp
+		if err := docSet.Get(); err != nil {
+			return err
+		}
+		docPath, err = docSet.Path()
+		return err
+	})
+	defer func() { cleanUp() }()
+
+	parsed := add("The documents are valid", "Fix the documents named, or pass "+
+		"--allow-unknown-fields if Vault accepts fields vaultsmith doesn't know", fetched, func() error {
+		config.TemplateFile = whichFileExists(config.TemplateFile, filepath.Join(docPath, "_vaultsmith.json"))
+		return internal.CheckDocuments(config, docPath)
+	})
+	add("The token may write every document", "Grant the token's policies create and update on "+
+		"the paths named, and sudo on those which need it", parsed && loggedIn, func() error {
+		return checkCapabilities(c, docPath)
+	})
+	return checks
+}
+
+// What to do when Vault can't be reached, given how it failed
+func reachHint(err error) string {
+	if err == nil {
+		return ""
+	}
+	if strings.Contains(err.Error(), "x509") || strings.Contains(err.Error(), "certificate") {
+		return "Set VAULT_CACERT to the CA which signed Vault's certificate"
+	}
+	return "Check VAULT_ADDR is the address of Vault, and that it is listening"
+}
+
+// An error of every problem found, or nil if there were none
+func problems(failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(failed, "; "))
+}
+
+// Check the token may write the resource of every document under docPath. Documents whose path is
+// templated are left out, as which resource they are is only known once rendered.
+func checkCapabilities(c vault.Vault, docPath string) error {
+	fsys := os.DirFS(docPath)
+	ignore, err := document.LoadIgnoreFS(fsys)
+	if err != nil {
+		return err
+	}
+	var denied []string
+	err = fs.WalkDir(fsys, ".", func(name string, f fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		if ignore.Match(filepath.FromSlash(name), f.IsDir()) || strings.HasPrefix(f.Name(), "_") {
+			if f.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if f.IsDir() || path.Dir(name) == "." || strings.Contains(name, "{{") {
+			return nil
+		}
+		resource, sudo := internal.ResourceAPIPath(strings.TrimSuffix(name, path.Ext(name)))
+		capabilities, err := c.Capabilities(resource)
+		if err != nil {
+			return fmt.Errorf("could not read the capabilities of the token on %s: %s", resource, err)
+		}
+		if !mayWrite(capabilities, sudo) {
+			if sudo {
+				resource += " (with sudo)"
+			}
+			denied = append(denied, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(denied) > maxDeniedPaths {
+		denied = append(denied[:maxDeniedPaths], fmt.Sprintf("and %d more", len(denied)-maxDeniedPaths))
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("the token may not write %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// Whether capabilities allow writing, and sudo if it is needed
+func mayWrite(capabilities []string, needSudo bool) bool {
+	var write, sudo bool
+	for _, c := range capabilities {
+		switch c {
+		case "root":
+			return true
+		case "create", "update":
+			write = true
+		case "sudo":
+			sudo = true
+		}
+	}
+	return write && (sudo || !needSudo)
+}
+
+// Write a line for each check, with the hint of each which failed. Returns whether all passed.
+func RenderChecks(w io.Writer, checks []Check) bool {
+	passed := true
+	for _, c := range checks {
+		switch {
+		case c.Skipped:
+			fmt.Fprintf(w, "SKIP  %s\n", c.Name)
+		case c.Err != nil:
+			passed = false
+			fmt.Fprintf(w, "FAIL  %s: %s\n", c.Name, c.Err)
+			if c.Hint != "" {
+				fmt.Fprintf(w, "      %s\n", c.Hint)
+			}
+		default:
+			fmt.Fprintf(w, "PASS  %s\n", c.Name)
+		}
+	}
+	return passed
+}
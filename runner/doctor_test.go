@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"bytes"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The report of the doctor, and whether every check passed
+func doctorReport(client vault.Vault, docPath string) (string, bool) {
+	var report bytes.Buffer
+	passed := RenderChecks(&report, Doctor(client, config.VaultsmithConfig{VaultRole: "root", DocumentPath: docPath}))
+	return report.String(), passed
+}
+
+func TestDoctor(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/userpass.json": `{"type": "userpass"}`})
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+
+	report, passed := doctorReport(client, docPath)
+	if !passed || strings.Contains(report, "FAIL") || strings.Contains(report, "SKIP") {
+		t.Errorf("Expected every check to pass, got:\n%s", report)
+	}
+	for _, c := range client.CallLog {
+		if c.Method == "EnableAuth" {
+			t.Errorf("Expected nothing to be applied, got %+v", c)
+		}
+	}
+}
+
+// Nothing depending on an unsealed Vault is checked, but the documents still are
+func TestDoctor_Sealed(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/userpass.json": `{"type": "userpass"}`})
+	client := &vault.MockClient{ReturnHealth: &vaultApi.HealthResponse{Initialized: true, Sealed: true}}
+	client.On("Authenticate", "root")
+
+	report, passed := doctorReport(client, docPath)
+	if passed {
+		t.Errorf("Expected the checks to fail")
+	}
+	expected := "PASS  Vault is reachable\n" +
+		"FAIL  Vault is initialized and unsealed: Vault is sealed\n" +
+		"      Initialize Vault with `vault operator init`, or unseal it with `vault operator unseal`\n" +
+		"SKIP  Logged in to Vault\n" +
+		"SKIP  The token is valid for long enough\n" +
+		"PASS  The documents can be fetched\n" +
+		"PASS  The documents are valid\n" +
+		"SKIP  The token may write every document\n"
+	if report != expected {
+		t.Errorf("Expected the report:\n%s\ngot:\n%s", expected, report)
+	}
+}
+
+func TestDoctor_InvalidDocument(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/userpass.json": `{"type": "userpass", "tpye": "x"}`})
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+
+	report, _ := doctorReport(client, docPath)
+	for _, expected := range []string{
+		"FAIL  The documents are valid: invalid documents found: " + filepath.Join(docPath, "sys", "auth", "userpass.json"),
+		"      Fix the documents named, or pass --allow-unknown-fields",
+		"SKIP  The token may write every document",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected %q in the report, got:\n%s", expected, report)
+		}
+	}
+}
+
+func TestDoctor_MissingCapabilities(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/approle.json":  `{"type": "approle"}`,
+		"sys/auth/userpass.json": `{"type": "userpass"}`,
+	})
+	client := &vault.MockClient{ReturnCapabilities: map[string][]string{
+		"sys/auth/approle":  {"read", "list"},
+		"sys/auth/userpass": {"create", "read", "update", "sudo"},
+	}}
+	client.On("Authenticate", "root")
+
+	report, passed := doctorReport(client, docPath)
+	if passed {
+		t.Errorf("Expected the checks to fail")
+	}
+	expected := "FAIL  The token may write every document: the token may not write sys/auth/approle (with sudo)\n" +
+		"      Grant the token's policies create and update on the paths named, and sudo on those which need it\n"
+	if !strings.HasSuffix(report, expected) {
+		t.Errorf("Expected the report to end with:\n%s\ngot:\n%s", expected, report)
+	}
+}
+
+// Documents are checked at the path their handler writes, with sudo where Vault needs it
+func TestDoctor_CapabilitiesAPIPath(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{
+		"sys/auth/userpass.json": `{"type": "userpass"}`,
+		"sys/policy/admin.json":  `{"policy": "path \"secret/*\" {capabilities = [\"read\"]}"}`,
+	})
+	client := &vault.MockClient{ReturnCapabilities: map[string][]string{
+		"sys/auth/userpass":      {"create", "update"},
+		"sys/policies/acl/admin": {"create", "update"},
+	}}
+	client.On("Authenticate", "root")
+
+	report, _ := doctorReport(client, docPath)
+	expected := "FAIL  The token may write every document: the token may not write sys/auth/userpass (with sudo)\n"
+	if !strings.Contains(report, expected) {
+		t.Errorf("Expected %q in the report, got:\n%s", expected, report)
+	}
+	for _, c := range client.CallsTo("Capabilities") {
+		if c.Args[0] == "sys/policy/admin" {
+			t.Errorf("Expected the policy to be checked at sys/policies/acl, got %+v", c)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Mounts which Vault creates itself and can't be mounted by us, so aren't exported
+var systemMounts = map[string]bool{
+	"cubbyhole/": true,
+	"identity/":  true,
+	"sys/":       true,
+}
+
+// Write the auth methods, secrets engine mounts and policies in Vault to dir, in the layout
+// vaultsmith expects, so an existing Vault can be brought under its management. Applying the
+// exported documents back to the same Vault makes no changes. dir must be empty or not exist.
+func Export(c vault.Vault, config config.VaultsmithConfig, dir string) error {
+	err := authenticate(c, config)
+	if err != nil {
+		return err
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("export directory %s is not empty", dir)
+	}
+
+	docs, err := liveDocuments(c)
+	if err != nil {
+		return err
+	}
+	resourcePaths := make([]string, 0, len(docs))
+	for resourcePath := range docs {
+		resourcePaths = append(resourcePaths, resourcePath)
+	}
+	sort.Strings(resourcePaths)
+	for _, resourcePath := range resourcePaths {
+		err = writeDocument(dir, resourcePath, docs[resourcePath])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The documents for the auth methods, secrets engine mounts and policies in Vault, keyed by their
+// resource path (e.g. "sys/auth/approle/"). Those which Vault manages itself are left out.
+func liveDocuments(c vault.Vault) (map[string]map[string]interface{}, error) {
+	docs := map[string]map[string]interface{}{}
+	auths, err := c.ListAuth()
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth methods: %s", err)
+	}
+	for path, auth := range auths {
+		if auth.Type == "token" {
+			// always enabled, and never disabled by vaultsmith
+			continue
+		}
+		docs["sys/auth/"+path] = authDocument(auth)
+	}
+
+	mounts, err := c.ListMounts()
+	if err != nil {
+		return nil, fmt.Errorf("error listing mounts: %s", err)
+	}
+	for path, mount := range mounts {
+		if systemMounts[path] {
+			continue
+		}
+		docs["sys/mounts/"+path] = mountDocument(mount)
+	}
+
+	policies, err := c.ListPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("error listing policies: %s", err)
+	}
+	for _, name := range policies {
+		if name == "root" {
+			// can't be read or changed
+			continue
+		}
+		rules, err := c.GetPolicy(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading policy %s: %s", name, err)
+		}
+		docs["sys/policy/"+name] = map[string]interface{}{"policy": rules}
+	}
+	return docs, nil
+}
+
+// The document enabling auth as it is currently configured
+func authDocument(auth *vaultApi.AuthMount) map[string]interface{} {
+	doc := commonMountFields(auth.Type, auth.Description, auth.Local, auth.SealWrap, auth.Options)
+	config := map[string]interface{}{
+		"default_lease_ttl": fmt.Sprintf("%ds", auth.Config.DefaultLeaseTTL),
+		"max_lease_ttl":     fmt.Sprintf("%ds", auth.Config.MaxLeaseTTL),
+	}
+	setIfNotEmpty(config, "plugin_name", auth.Config.PluginName)
+	setIfNotEmpty(config, "listing_visibility", auth.Config.ListingVisibility)
+	setIfNotEmpty(config, "audit_non_hmac_request_keys", auth.Config.AuditNonHMACRequestKeys)
+	setIfNotEmpty(config, "audit_non_hmac_response_keys", auth.Config.AuditNonHMACResponseKeys)
+	setIfNotEmpty(config, "passthrough_request_headers", auth.Config.PassthroughRequestHeaders)
+	doc["config"] = config
+	return doc
+}
+
+// The document mounting the secrets engine as it is currently configured
+func mountDocument(mount *vaultApi.MountOutput) map[string]interface{} {
+	doc := commonMountFields(mount.Type, mount.Description, mount.Local, mount.SealWrap, mount.Options)
+	config := map[string]interface{}{
+		"default_lease_ttl": fmt.Sprintf("%ds", mount.Config.DefaultLeaseTTL),
+		"max_lease_ttl":     fmt.Sprintf("%ds", mount.Config.MaxLeaseTTL),
+	}
+	if mount.Config.ForceNoCache {
+		config["force_no_cache"] = true
+	}
+	setIfNotEmpty(config, "plugin_name", mount.Config.PluginName)
+	setIfNotEmpty(config, "listing_visibility", mount.Config.ListingVisibility)
+	setIfNotEmpty(config, "audit_non_hmac_request_keys", mount.Config.AuditNonHMACRequestKeys)
+	setIfNotEmpty(config, "audit_non_hmac_response_keys", mount.Config.AuditNonHMACResponseKeys)
+	setIfNotEmpty(config, "passthrough_request_headers", mount.Config.PassthroughRequestHeaders)
+	doc["config"] = config
+	return doc
+}
+
+func commonMountFields(mountType string, description string, local bool, sealWrap bool, options map[string]string) map[string]interface{} {
+	doc := map[string]interface{}{"type": mountType}
+	setIfNotEmpty(doc, "description", description)
+	if local {
+		doc["local"] = true
+	}
+	if sealWrap {
+		doc["seal_wrap"] = true
+	}
+	if len(options) > 0 {
+		doc["options"] = options
+	}
+	return doc
+}
+
+// Set key in m to value, unless it is an empty string or list
+func setIfNotEmpty(m map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return
+		}
+	case []string:
+		if len(v) == 0 {
+			return
+		}
+	}
+	m[key] = value
+}
+
+// Write doc as the json document for resourcePath (e.g. "sys/auth/approle/") under dir, with any
+// secret fields redacted
+func writeDocument(dir string, resourcePath string, doc map[string]interface{}) error {
+	file := filepath.Join(dir, filepath.FromSlash(strings.Trim(resourcePath, "/"))+".json")
+	content, err := json.MarshalIndent(vault.Redact(doc), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode %s: %s", resourcePath, err)
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory for %s: %s", file, err)
+	}
+	log.WithFields(log.Fields{"file": file}).Info("Exporting")
+	err = ioutil.WriteFile(file, append(content, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write %s: %s", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package runner
+
+import (
+	"context"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A mock Vault with some existing configuration
+func configuredVault() *vault.MockClient {
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"token/": {Type: "token"},
+			"approle/": {Type: "approle", Description: "Services", Config: vaultApi.AuthConfigOutput{
+				DefaultLeaseTTL:           3600,
+				PassthroughRequestHeaders: []string{"X-Request-Id"},
+			}},
+			"team/ldap/": {Type: "ldap", Local: true},
+		},
+		ReturnMounts: map[string]*vaultApi.MountOutput{
+			"sys/":       {Type: "system"},
+			"cubbyhole/": {Type: "cubbyhole"},
+			"secret/":    {Type: "kv", Options: map[string]string{"version": "2"}},
+			"legacy/":    {Type: "kv"},
+		},
+		ReturnPolicies: map[string]string{
+			"root":         "",
+			"default":      `path "sys/capabilities-self" { capabilities = ["update"] }`,
+			"read_secrets": `path "secret/*" { capabilities = ["read"] }`,
+		},
+	}
+	client.On("Authenticate", "root")
+	return client
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = Export(configuredVault(), config.VaultsmithConfig{VaultRole: "root"}, dir)
+	if err != nil {
+		t.Fatalf("Error calling Export: %s", err)
+	}
+
+	for _, f := range []string{
+		"sys/auth/approle.json",
+		"sys/auth/team/ldap.json",
+		"sys/mounts/secret.json",
+		"sys/mounts/legacy.json",
+		"sys/policy/default.json",
+		"sys/policy/read_secrets.json",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("Expected %s to be exported: %s", f, err)
+		}
+	}
+	for _, f := range []string{"sys/auth/token.json", "sys/mounts/sys.json", "sys/mounts/cubbyhole.json", "sys/policy/root.json"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to be exported", f)
+		}
+	}
+}
+
+// Applying an export to the Vault it came from should change nothing
+func TestExport_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = Export(configuredVault(), config.VaultsmithConfig{VaultRole: "root"}, dir)
+	if err != nil {
+		t.Fatalf("Error calling Export: %s", err)
+	}
+
+	client := configuredVault()
+	summary := &path_handlers.Summary{}
+	cw, err := internal.NewConfigWalker(client, config.VaultsmithConfig{}, dir, summary)
+	if err != nil {
+		t.Fatalf("Failed to create ConfigWalker for the export: %s", err)
+	}
+	err = cw.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error applying the export: %s", err)
+	}
+
+	for _, method := range []string{"EnableAuth", "DisableAuth", "TuneMount", "Mount", "PutPolicy", "DeletePolicy", "Write", "Delete"} {
+		if calls := client.CallsTo(method); len(calls) != 0 {
+			t.Errorf("Expected no %s calls applying the export, got %+v", method, calls)
+		}
+	}
+	if len(summary.Rows) != 0 {
+		t.Errorf("Expected no changes, got %+v", summary.Rows)
+	}
+}
+
+func TestExport_NotEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("docs"), 0644)
+
+	err = Export(configuredVault(), config.VaultsmithConfig{VaultRole: "root"}, dir)
+	if err == nil {
+		t.Error("Expected an error exporting to a directory which isn't empty")
+	}
+}
+
+// Secrets which Vault does return, such as in the options of a mount, aren't written out
+func TestExport_Redacted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	doc := mountDocument(&vaultApi.MountOutput{Type: "kv", Options: map[string]string{"token": "hunter2"}})
+	if err := writeDocument(dir, "sys/mounts/secret/", doc); err != nil {
+		t.Fatalf("Error calling writeDocument: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "sys", "mounts", "secret.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "hunter2") || !strings.Contains(string(content), vault.RedactedValue) {
+		t.Errorf("Expected the token to be redacted, got:\n%s", content)
+	}
+}
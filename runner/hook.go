@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"time"
+)
+
+// How long the post-apply webhook has to respond, and the post-apply command to finish
+var hookTimeout = 30 * time.Second
+
+// What is sent to the post-apply hook: the Result, and whether the apply succeeded
+type hookPayload struct {
+	Result
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Send the result of an apply to the configured webhook and command, so downstream automation can
+// be triggered. Unless config.PostApplyAlways is set, nothing is run if the apply failed.
+func runPostApplyHooks(config config.VaultsmithConfig, result Result, applyErr error) error {
+	if config.PostApplyURL == "" && config.PostApplyCommand == "" {
+		return nil
+	}
+	if applyErr != nil && !config.PostApplyAlways {
+		log.Debug("Apply failed, not running post-apply hooks")
+		return nil
+	}
+
+	payload := hookPayload{Result: result, Success: applyErr == nil}
+	if applyErr != nil {
+		payload.Error = applyErr.Error()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not encode result for post-apply hook: %s", err)
+	}
+
+	if config.PostApplyURL != "" {
+		err = postResult(config.PostApplyURL, body)
+		if err != nil {
+			return err
+		}
+	}
+	if config.PostApplyCommand != "" {
+		err = runHookCommand(config.PostApplyCommand, body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// POST the JSON body to hookURL, failing on anything other than a 2xx response
+func postResult(hookURL string, body []byte) error {
+	host := redactURL(hookURL)
+	logger := log.WithFields(log.Fields{"url": host})
+	logger.Info("Posting result to post-apply webhook")
+
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(hookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			// which repeats the whole url
+			err = urlErr.Err
+		}
+		return fmt.Errorf("post-apply webhook %s failed: %s", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post-apply webhook %s returned %s", host, resp.Status)
+	}
+	return nil
+}
+
+// Only the scheme and host of the webhook url are logged, as its path or query may hold a token,
+// e.g. for a Slack webhook
+func redactURL(hookURL string) string {
+	u, err := url.Parse(hookURL)
+	if err != nil || u.Host == "" {
+		return "<invalid url>"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Run command with the shell, giving it the JSON body on stdin
+func runHookCommand(command string, body []byte) error {
+	logger := log.WithFields(log.Fields{"command": command})
+	logger.Info("Running post-apply command")
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(body)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		logger.Infof("Post-apply command output: %s", output)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("post-apply command %q did not finish within %s", command, hookTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("post-apply command %q failed: %s", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApply_PostApplyURL(t *testing.T) {
+	var posted map[string]interface{}
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Errorf("Could not decode posted body: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		PostApplyURL: server.URL,
+	}
+
+	_, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("Expected application/json, got %q", contentType)
+	}
+	if posted["success"] != true {
+		t.Errorf("Expected success to be true, got %v", posted["success"])
+	}
+	if posted["document_path"] != examplePath() {
+		t.Errorf("Expected document_path %q, got %v", examplePath(), posted["document_path"])
+	}
+	summary, ok := posted["summary"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected a summary object, got %v", posted["summary"])
+	}
+	rows, ok := summary["rows"].([]interface{})
+	if !ok || len(rows) == 0 {
+		t.Fatalf("Expected the summary rows to be posted, got %v", summary["rows"])
+	}
+	row := rows[0].(map[string]interface{})
+	for _, k := range []string{"resource", "action", "status"} {
+		if _, ok := row[k]; !ok {
+			t.Errorf("Expected summary row to have %q, got %v", k, row)
+		}
+	}
+}
+
+// The hook should only run after a failed apply if asked to
+func TestRunPostApplyHooks_Failure(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	conf := config.VaultsmithConfig{PostApplyURL: server.URL}
+	applyErr := fmt.Errorf("1 of 2 changes failed")
+	if err := runPostApplyHooks(conf, Result{}, applyErr); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no POST after a failure, got %d", calls)
+	}
+
+	conf.PostApplyAlways = true
+	if err := runPostApplyHooks(conf, Result{}, applyErr); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 POST with PostApplyAlways, got %d", calls)
+	}
+}
+
+func TestRunPostApplyHooks_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := runPostApplyHooks(config.VaultsmithConfig{PostApplyURL: server.URL}, Result{}, nil)
+	if err == nil {
+		t.Error("Expected an error when the webhook returns 500")
+	}
+}
+
+func TestRunPostApplyHooks_Command(t *testing.T) {
+	conf := config.VaultsmithConfig{PostApplyCommand: `grep -q '"success":true'`}
+	if err := runPostApplyHooks(conf, Result{}, nil); err != nil {
+		t.Errorf("Expected the result on stdin, got %s", err)
+	}
+
+	conf.PostApplyCommand = "exit 1"
+	if err := runPostApplyHooks(conf, Result{}, nil); err == nil {
+		t.Error("Expected an error when the command fails")
+	}
+}
+
+// The webhook's path and query may hold a token, so only its host is logged or returned
+func TestRunPostApplyHooks_RedactsURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	log.SetOutput(&logged)
+
+	conf := config.VaultsmithConfig{PostApplyURL: server.URL + "/services/T000/s3cr3t?token=s3cr3t"}
+	err := runPostApplyHooks(conf, Result{}, nil)
+	if err == nil {
+		t.Fatal("Expected an error when the webhook returns 500")
+	}
+	for _, s := range []string{err.Error(), logged.String()} {
+		if strings.Contains(s, "s3cr3t") {
+			t.Errorf("Expected the webhook's path and query to be left out, got %s", s)
+		}
+		if !strings.Contains(s, server.URL) {
+			t.Errorf("Expected the webhook's host, got %s", s)
+		}
+	}
+}
+
+func TestRunPostApplyHooks_CommandTimeout(t *testing.T) {
+	defer func(d time.Duration) { hookTimeout = d }(hookTimeout)
+	hookTimeout = 100 * time.Millisecond
+
+	conf := config.VaultsmithConfig{PostApplyCommand: "exec sleep 5"}
+	err := runPostApplyHooks(conf, Result{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "did not finish within 100ms") {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+}
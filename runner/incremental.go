@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/internal"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Return the files under dir (relative to it) which differ from the git ref, including removed
+// ones and those git doesn't track yet, but not those it ignores. A moved file is listed at both
+// its old and new path, so the directory it left is pruned. Replaced in tests.
+var gitChangedFiles = func(dir string, ref string) ([]string, error) {
+	changed, err := gitFiles(dir, "diff", "--name-only", "--no-renames", "--relative", ref, "--", ".")
+	if err == nil {
+		var untracked []string
+		untracked, err = gitFiles(dir, "ls-files", "--others", "--exclude-standard", "--", ".")
+		changed = append(changed, untracked...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list files changed since %s (document-path must be in a "+
+			"git checkout): %s", ref, err)
+	}
+	return changed, nil
+}
+
+// Run git in dir, returning the files (relative to dir) it lists, one per line
+func gitFiles(dir string, args ...string) ([]string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			files = append(files, filepath.FromSlash(line))
+		}
+	}
+	return files, nil
+}
+
+// Apply only the directories containing files changed since the git ref. Each is applied by its
+// handler as a whole, including removing anything undeclared, so a deleted file is removed from
+// Vault as in a full run.
+func applyChanged(ctx context.Context, cw internal.ConfigWalker, docPath string, ref string) error {
+	changed, err := gitChangedFiles(docPath, ref)
+	if err != nil {
+		return err
+	}
+	scopes := changedScopes(docPath, changed)
+	logger := log.WithFields(log.Fields{"since": ref, "changed": len(changed)})
+	if len(scopes) == 0 {
+		logger.Info("No documents changed")
+		return nil
+	}
+	if scopes[0] == "." {
+		logger.Info("Template or top level files changed, applying everything")
+		return cw.Run(ctx)
+	}
+
+	logger.Infof("Applying %d changed directories", len(scopes))
+	for _, s := range scopes {
+		err := cw.RunSubtree(ctx, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The sorted directories to apply for the changed files, leaving out any within another. A removed
+// directory is covered by the closest one still present, which prunes it; if that's the document
+// root, it's left alone, as a full run would.
+func changedScopes(docPath string, changed []string) []string {
+	scopes := map[string]bool{}
+	for _, p := range changed {
+		s := watchScope(p)
+		if s == "." {
+			return []string{"."}
+		}
+		for s != "." {
+			if _, err := os.Stat(filepath.Join(docPath, s)); err == nil {
+				break
+			}
+			s = filepath.Dir(s)
+		}
+		if s != "." {
+			scopes[s] = true
+		}
+	}
+
+	var sorted []string
+	for s := range scopes {
+		if !hasParentScope(scopes, s) {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func hasParentScope(scopes map[string]bool, s string) bool {
+	for p := filepath.Dir(s); p != "."; p = filepath.Dir(p) {
+		if scopes[p] {
+			return true
+		}
+	}
+	return false
+}
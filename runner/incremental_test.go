@@ -0,0 +1,199 @@
+package runner
+
+import (
+	"context"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Only the handler owning the changed document is run
+func TestApply_Since(t *testing.T) {
+	defer func(f func(string, string) ([]string, error)) { gitChangedFiles = f }(gitChangedFiles)
+	gitChangedFiles = func(dir string, ref string) ([]string, error) {
+		return []string{filepath.Join("sys", "policy", "read_secrets.json")}, nil
+	}
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		Since:        "HEAD~1",
+	}
+
+	_, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if calls := client.CallsTo("PutPolicy"); len(calls) == 0 {
+		t.Error("Expected policies to be written")
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no auth mounts to be enabled, got %d EnableAuth calls", len(calls))
+	}
+}
+
+func TestApply_SinceNoChanges(t *testing.T) {
+	defer func(f func(string, string) ([]string, error)) { gitChangedFiles = f }(gitChangedFiles)
+	gitChangedFiles = func(dir string, ref string) ([]string, error) {
+		return nil, nil
+	}
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		Since:        "HEAD~1",
+	}
+
+	_, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	for _, method := range []string{"PutPolicy", "EnableAuth", "Write", "Delete"} {
+		if calls := client.CallsTo(method); len(calls) != 0 {
+			t.Errorf("Expected no %s calls, got %d", method, len(calls))
+		}
+	}
+}
+
+func TestChangedScopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-since")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"auth/aws/role", "sys/policy"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		changed  []string
+		expected []string
+	}{
+		{
+			changed:  []string{"sys/policy/a.json", "sys/policy/b.json"},
+			expected: []string{"sys/policy"},
+		},
+		{
+			// auth/aws/role is within auth/aws, which is applied as a whole
+			changed:  []string{"auth/aws/role/r.json", "auth/aws/config.json", "sys/policy/a.json"},
+			expected: []string{"auth/aws", "sys/policy"},
+		},
+		{
+			// auth/aws/client no longer exists, so auth/aws is applied to remove it
+			changed:  []string{"auth/aws/client/c.json"},
+			expected: []string{"auth/aws"},
+		},
+		{
+			// nothing under secret remains, and the document root is never pruned
+			changed:  []string{"secret/x/y.json"},
+			expected: nil,
+		},
+		{
+			changed:  []string{"sys/policy/a.json", "_vaultsmith.json"},
+			expected: []string{"."},
+		},
+	}
+	for _, test := range tests {
+		var changed []string
+		for _, c := range test.changed {
+			changed = append(changed, filepath.FromSlash(c))
+		}
+		var expected []string
+		for _, e := range test.expected {
+			expected = append(expected, filepath.FromSlash(e))
+		}
+		if scopes := changedScopes(dir, changed); !reflect.DeepEqual(scopes, expected) {
+			t.Errorf("Expected scopes %+v for %+v, got %+v", expected, test.changed, scopes)
+		}
+	}
+}
+
+// Files git doesn't track yet are changed, but those it ignores aren't
+func TestGitChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v: %s: %s", args, err, out)
+		}
+	}
+	write := func(name string, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "--quiet")
+	write("docs/sys/policy/read.json", `{}`)
+	write("docs/sys/policy/write.json", `{}`)
+	write(".gitignore", "*.swp\n")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "policies")
+	write("docs/sys/policy/read.json", `{"policy": ""}`)
+	write("docs/sys/auth/approle.json", `{"type": "approle"}`)
+	write("docs/sys/auth/.approle.json.swp", "")
+	write("other.json", `{}`)
+
+	changed, err := gitChangedFiles(filepath.Join(dir, "docs"), "HEAD")
+	if err != nil {
+		t.Fatalf("Error listing changed files: %s", err)
+	}
+	sort.Strings(changed)
+	expected := []string{filepath.Join("sys", "auth", "approle.json"), filepath.Join("sys", "policy", "read.json")}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected changed files %+v, got %+v", expected, changed)
+	}
+}
+
+// A document moved between directories changes both
+func TestGitChangedFiles_Moved(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v: %s: %s", args, err, out)
+		}
+	}
+	for _, d := range []string{"auth/aws/role", "auth/approle/role"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(d)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ioutil.WriteFile(filepath.Join(dir, "auth", "aws", "role", "ci.json"), []byte(`{"policies": ["ci"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git("init", "--quiet")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "roles")
+	git("mv", "auth/aws/role/ci.json", "auth/approle/role/ci.json")
+	git("commit", "--quiet", "-m", "move")
+
+	changed, err := gitChangedFiles(dir, "HEAD~1")
+	if err != nil {
+		t.Fatalf("Error listing changed files: %s", err)
+	}
+	sort.Strings(changed)
+	expected := []string{filepath.Join("auth", "approle", "role", "ci.json"), filepath.Join("auth", "aws", "role", "ci.json")}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected changed files %+v, got %+v", expected, changed)
+	}
+	if scopes := changedScopes(dir, changed); !reflect.DeepEqual(scopes, []string{filepath.Join("auth", "approle", "role"), filepath.Join("auth", "aws", "role")}) {
+		t.Errorf("Expected the roles of both auth methods to be applied, got %+v", scopes)
+	}
+}
@@ -0,0 +1,32 @@
+package runner
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+)
+
+// Refuse a planned run which would make more than max changes, so a bad set of documents can't
+// remove everything at once. There is no limit if max is 0.
+func checkMaxChanges(plan *path_handlers.Summary, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	var planned []path_handlers.SummaryRow
+	for _, r := range plan.Rows {
+		if r.Status == path_handlers.StatusPlanned {
+			planned = append(planned, r)
+		}
+	}
+	if len(planned) <= max {
+		return nil
+	}
+	for _, r := range planned {
+		log.WithFields(log.Fields{
+			"resource": r.Resource,
+			"action":   r.Action,
+		}).Warn("Planned change")
+	}
+	return fmt.Errorf("the run would make %d changes, more than the maximum of %d, so nothing was "+
+		"changed; check them with a dry run, and raise the maximum to apply them", len(planned), max)
+}
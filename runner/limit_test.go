@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"context"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"strings"
+	"testing"
+)
+
+// apply a document directory declaring only approle to a Vault which also has three other auth
+// methods enabled, so the plan is to disable them
+func applyMaxChanges(t *testing.T, maxChanges int) (*vault.MockClient, error) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/approle.json": `{"type": "approle"}`})
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"approle/":  {Type: "approle"},
+			"github/":   {Type: "github"},
+			"ldap/":     {Type: "ldap"},
+			"userpass/": {Type: "userpass"},
+		},
+	}
+	client.On("Authenticate", "root")
+	_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		MaxChanges:   maxChanges,
+	})
+	return client, err
+}
+
+func TestApply_MaxChangesExceeded(t *testing.T) {
+	client, err := applyMaxChanges(t, 2)
+	if err == nil || !strings.Contains(err.Error(), "would make 3 changes, more than the maximum of 2") {
+		t.Errorf("Expected the run to be refused, got %v", err)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no DisableAuth calls, got %+v", calls)
+	}
+}
+
+func TestApply_MaxChangesWithin(t *testing.T) {
+	client, err := applyMaxChanges(t, 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 3 {
+		t.Errorf("Expected 3 DisableAuth calls, got %+v", calls)
+	}
+}
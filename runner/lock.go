@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"time"
+)
+
+// How often a waiting run checks whether the lock has been released
+var lockPollInterval = 5 * time.Second
+
+// An apply lock, held in a KV version 2 path so concurrent runs against the same Vault don't race
+// each other. It is taken with a check-and-set write, so only one run can create it, and expires
+// after a TTL in case a run dies without releasing it. While held, it is renewed every third of
+// the TTL, so a run taking longer than the TTL still holds it.
+type applyLock struct {
+	client   vault.Vault
+	path     string // e.g. secret/data/vaultsmith/lock
+	owner    string
+	ttl      time.Duration
+	clock    Clock
+	stop     chan struct{}
+	finished chan struct{}
+}
+
+// Take the lock at path for ttl, waiting up to wait for another run to release it
+func acquireLock(client vault.Vault, path string, ttl time.Duration, wait time.Duration, clock Clock) (*applyLock, error) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	host, _ := os.Hostname()
+	l := &applyLock{
+		client: client,
+		path:   path,
+		owner:  fmt.Sprintf("%s-%d-%d", host, os.Getpid(), clock.Now().UnixNano()),
+		ttl:    ttl,
+		clock:  clock,
+	}
+	logger := log.WithFields(log.Fields{"path": path})
+
+	deadline := clock.Now().Add(wait)
+	for {
+		holder, err := l.tryAcquire()
+		if err != nil {
+			return nil, err
+		}
+		if holder == "" {
+			logger.Debugf("Acquired apply lock")
+			l.stop = make(chan struct{})
+			l.finished = make(chan struct{})
+			go l.keepAlive()
+			return l, nil
+		}
+		if !clock.Now().Before(deadline) {
+			return nil, fmt.Errorf("apply lock %s is held by %s; another vaultsmith run is in "+
+				"progress", path, holder)
+		}
+		logger.Infof("Apply lock is held by %s, waiting", holder)
+		<-clock.After(lockPollInterval)
+	}
+}
+
+// Read the lock, returning its owner and when it expires, if it is held, and its current version
+func (l *applyLock) read() (owner string, expiry time.Time, version interface{}, err error) {
+	secret, err := l.client.Read(l.path)
+	if err != nil {
+		return "", time.Time{}, nil, fmt.Errorf("could not read apply lock %s: %s", l.path, err)
+	}
+
+	// KV v2 returns the lock under "data", and its version under "metadata". A released lock is a
+	// deleted version, so has metadata but no data.
+	version = 0
+	if secret != nil && secret.Data != nil {
+		if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+			version = metadata["version"]
+		}
+		if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+			owner, _ = data["owner"].(string)
+			expires, _ := data["expires"].(string)
+			expiry, _ = time.Parse(time.RFC3339, expires)
+		}
+	}
+	return owner, expiry, version, nil
+}
+
+// Write the lock as ours for another ttl, if it is still at version
+func (l *applyLock) write(version interface{}) error {
+	_, err := l.client.Write(l.path, map[string]interface{}{
+		"options": map[string]interface{}{"cas": version},
+		"data": map[string]interface{}{
+			"owner":   l.owner,
+			"expires": l.clock.Now().Add(l.ttl).UTC().Format(time.RFC3339),
+		},
+	})
+	return err
+}
+
+// Try to take the lock, returning the current holder if someone else has it
+func (l *applyLock) tryAcquire() (holder string, err error) {
+	owner, expiry, version, err := l.read()
+	if err != nil {
+		return "", err
+	}
+	if owner != "" {
+		if l.clock.Now().Before(expiry) {
+			return owner, nil
+		}
+		log.WithFields(log.Fields{"path": l.path, "owner": owner}).Warn(
+			"Taking over expired apply lock")
+	}
+
+	if err := l.write(version); err != nil {
+		// most likely another run took it between our read and write
+		return "", fmt.Errorf("could not acquire apply lock %s: %s", l.path, err)
+	}
+	return "", nil
+}
+
+// Extend the lock until it is released
+func (l *applyLock) keepAlive() {
+	defer close(l.finished)
+	if l.ttl <= 0 {
+		<-l.stop
+		return
+	}
+	for {
+		select {
+		case <-l.clock.After(l.ttl / 3):
+		case <-l.stop:
+			return
+		}
+		if err := l.renew(); err != nil {
+			log.WithFields(log.Fields{"path": l.path}).Errorf("Could not renew apply lock: %s", err)
+		}
+	}
+}
+
+// Extend the lock by another ttl, as long as it is still ours
+func (l *applyLock) renew() error {
+	owner, _, version, err := l.read()
+	if err != nil {
+		return err
+	}
+	if owner != l.owner {
+		return fmt.Errorf("apply lock %s is now held by %q", l.path, owner)
+	}
+	if err := l.write(version); err != nil {
+		return fmt.Errorf("could not write apply lock %s: %s", l.path, err)
+	}
+	log.WithFields(log.Fields{"path": l.path}).Debugf("Renewed apply lock")
+	return nil
+}
+
+// Release the lock, unless another run has taken it over since. Errors are only logged, as the
+// lock will expire anyway.
+func (l *applyLock) release() {
+	close(l.stop)
+	<-l.finished
+
+	logger := log.WithFields(log.Fields{"path": l.path})
+	owner, _, _, err := l.read()
+	if err != nil {
+		logger.Errorf("Could not release apply lock: %s", err)
+		return
+	}
+	if owner != l.owner {
+		logger.Warnf("Apply lock is now held by %q, leaving it", owner)
+		return
+	}
+	_, err = l.client.Delete(l.path)
+	if err != nil {
+		logger.Errorf("Could not release apply lock: %s", err)
+		return
+	}
+	logger.Debugf("Released apply lock")
+}
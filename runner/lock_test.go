@@ -0,0 +1,151 @@
+package runner
+
+import (
+	"context"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A lock held by another run makes this one abort before anything is applied
+func TestApply_LockHeld(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"owner":   "other-run",
+					"expires": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+				},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		},
+	}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		LockPath:     "secret/data/vaultsmith/lock",
+		LockTTL:      time.Minute,
+	}
+
+	_, err := Apply(context.Background(), client, conf)
+	if err == nil || !strings.Contains(err.Error(), "held by other-run") {
+		t.Errorf("Expected a held lock error, got %v", err)
+	}
+	if calls := client.CallsTo("Write"); len(calls) != 0 {
+		t.Errorf("Expected no writes, got %+v", calls)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected nothing to be applied, got %+v", calls)
+	}
+}
+
+func TestAcquireLock_Free(t *testing.T) {
+	client := &vault.MockClient{}
+	lock, err := acquireLock(client, "secret/data/lock", time.Minute, 0, nil)
+	if err != nil {
+		t.Fatalf("Error acquiring lock: %s", err)
+	}
+	writes := client.CallsTo("Write")
+	if len(writes) != 1 {
+		t.Fatalf("Expected 1 Write, got %+v", writes)
+	}
+	data := writes[0].Args[1].(map[string]interface{})
+	if cas := data["options"].(map[string]interface{})["cas"]; cas != 0 {
+		t.Errorf("Expected a check-and-set write with cas 0, got %v", cas)
+	}
+
+	client.ReturnSecret = lockSecret(lock.owner, time.Now().Add(time.Minute), 1)
+	lock.release()
+	if deletes := client.CallsTo("Delete"); len(deletes) != 1 || deletes[0].Args[0] != "secret/data/lock" {
+		t.Errorf("Expected the lock to be deleted, got %+v", deletes)
+	}
+}
+
+// A lock which expired and was taken over by another run is left to that run
+func TestApplyLock_ReleaseTakenOver(t *testing.T) {
+	client := &vault.MockClient{}
+	lock, err := acquireLock(client, "secret/data/lock", time.Minute, 0, nil)
+	if err != nil {
+		t.Fatalf("Error acquiring lock: %s", err)
+	}
+	client.ReturnSecret = lockSecret("other-run", time.Now().Add(time.Minute), 2)
+	lock.release()
+	if deletes := client.CallsTo("Delete"); len(deletes) != 0 {
+		t.Errorf("Expected the other run's lock to be left, got %+v", deletes)
+	}
+}
+
+// The lock is renewed every third of its TTL while held
+func TestApplyLock_Renew(t *testing.T) {
+	clock := &fakeClock{
+		now:     time.Now(),
+		ticks:   make(chan time.Time),
+		waiting: make(chan time.Duration, 1),
+	}
+	client := &vault.MockClient{}
+	lock, err := acquireLock(client, "secret/data/lock", time.Minute, 0, clock)
+	if err != nil {
+		t.Fatalf("Error acquiring lock: %s", err)
+	}
+	defer lock.release()
+	if d := <-clock.waiting; d != 20*time.Second {
+		t.Errorf("Expected to renew after 20s, got %s", d)
+	}
+
+	client.ReturnSecret = lockSecret(lock.owner, clock.now.Add(time.Minute), 1)
+	clock.now = clock.now.Add(20 * time.Second)
+	clock.ticks <- clock.now
+	<-clock.waiting
+	writes := client.CallsTo("Write")
+	if len(writes) != 2 {
+		t.Fatalf("Expected the lock to be written again, got %+v", writes)
+	}
+	data := writes[1].Args[1].(map[string]interface{})
+	if cas := data["options"].(map[string]interface{})["cas"]; cas != 1 {
+		t.Errorf("Expected cas 1, got %v", cas)
+	}
+	expires := data["data"].(map[string]interface{})["expires"]
+	if want := clock.now.Add(time.Minute).UTC().Format(time.RFC3339); expires != want {
+		t.Errorf("Expected the lock to expire at %s, got %v", want, expires)
+	}
+}
+
+// A lock as KV v2 returns it
+func lockSecret(owner string, expires time.Time, version int) *vaultApi.Secret {
+	return &vaultApi.Secret{
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"owner":   owner,
+				"expires": expires.UTC().Format(time.RFC3339),
+			},
+			"metadata": map[string]interface{}{"version": version},
+		},
+	}
+}
+
+// An expired lock is taken over at its current version
+func TestAcquireLock_Expired(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"owner":   "dead-run",
+					"expires": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+				},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		},
+	}
+	_, err := acquireLock(client, "secret/data/lock", time.Minute, 0, nil)
+	if err != nil {
+		t.Fatalf("Error acquiring lock: %s", err)
+	}
+	data := client.CallsTo("Write")[0].Args[1].(map[string]interface{})
+	if cas := data["options"].(map[string]interface{})["cas"]; cas != 3 {
+		t.Errorf("Expected cas 3, got %v", cas)
+	}
+}
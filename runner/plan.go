@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// A saved plan, made by a dry run with config.PlanOutPath. It is applied by a later run with
+// config.PlanPath, but only if neither the documents nor Vault have changed since it was made.
+type plan struct {
+	Created   time.Time                  `json:"created"`
+	Documents string                     `json:"documents"` // hash of the document files
+	Live      map[string]string          `json:"live"`      // as the state file, when planned
+	Changes   []path_handlers.SummaryRow `json:"changes"`
+}
+
+// Plan the run against a read-only c, returning the summary of what it would do
+func planRun(ctx context.Context, c vault.Vault, config config.VaultsmithConfig, docPath string) (*path_handlers.Summary, error) {
+	config.Dry = true
+	summary := &path_handlers.Summary{Dry: true}
+	cw, err := internal.NewConfigWalker(vault.NewReadOnlyClient(c), config, docPath, summary)
+	if err != nil {
+		return nil, err
+	}
+	if config.Since != "" {
+		err = applyChanged(ctx, cw, docPath, config.Since)
+	} else {
+		err = cw.Run(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not plan the run: %s", err)
+	}
+	return summary, nil
+}
+
+// Write the plan made by a dry run to path
+func savePlan(c vault.Vault, path string, docPath string, summary *path_handlers.Summary, ignore []string) error {
+	documents, err := hashDocuments(docPath)
+	if err != nil {
+		return err
+	}
+	live, err := liveState(c, ignore)
+	if err != nil {
+		return err
+	}
+	err = writeJSONFile(path, plan{
+		Created:   time.Now().UTC(),
+		Documents: documents,
+		Live:      live,
+		Changes:   summary.Rows,
+	})
+	if err != nil {
+		return fmt.Errorf("could not write plan %s: %s", path, err)
+	}
+	log.WithFields(log.Fields{"plan": path, "changes": len(summary.Rows)}).Info("Saved the plan")
+	return nil
+}
+
+// Check that applying the documents at docPath would make exactly the changes in the plan at
+// path, and that nothing in Vault has changed since it was made, returning the plan
+func checkPlan(ctx context.Context, c vault.Vault, config config.VaultsmithConfig, docPath string, path string) (*path_handlers.Summary, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read plan %s: %s", path, err)
+	}
+	var saved plan
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return nil, fmt.Errorf("could not parse plan %s: %s", path, err)
+	}
+
+	documents, err := hashDocuments(docPath)
+	if err != nil {
+		return nil, err
+	}
+	if documents != saved.Documents {
+		return nil, fmt.Errorf("the documents have changed since the plan %s was made; make a new plan", path)
+	}
+
+	live, err := liveState(c, config.IgnoreResources)
+	if err != nil {
+		return nil, err
+	}
+	if drift := detectDrift(saved.Live, live); len(drift) > 0 {
+		for _, d := range drift {
+			log.WithFields(log.Fields{
+				"resource": d.Resource,
+				"change":   d.Change,
+				"planned":  saved.Created.Format(time.RFC3339),
+			}).Warn("Resource changed in Vault since the plan was made")
+		}
+		return nil, fmt.Errorf("%d resources have changed in Vault since the plan %s was made; make a "+
+			"new plan", len(drift), path)
+	}
+
+	summary, err := planRun(ctx, c, config, docPath)
+	if err != nil {
+		return nil, err
+	}
+	if !sameRows(summary.Rows, saved.Changes) {
+		return nil, fmt.Errorf("the changes to make differ from the plan %s, as Vault has changed since "+
+			"it was made; make a new plan", path)
+	}
+	return summary, nil
+}
+
+func sameRows(a []path_handlers.SummaryRow, b []path_handlers.SummaryRow) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash the documents under docPath
+func hashDocuments(docPath string) (string, error) {
+	documents, err := document.HashFS(os.DirFS(docPath))
+	if err != nil {
+		return "", fmt.Errorf("could not read the documents: %s", err)
+	}
+	return documents, nil
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal/testutil"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Make a plan declaring only approle against a Vault which also has github enabled, returning the
+// client, the document path and the path of the plan
+func makePlan(t *testing.T) (*vault.MockClient, string, string) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/approle.json": `{"type": "approle"}`})
+	// outside the documents, which would otherwise change with it
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+
+	client := &vault.MockClient{
+		ReturnAuthMounts: map[string]*vaultApi.AuthMount{
+			"github/": {Type: "github"},
+		},
+	}
+	client.On("Authenticate", "root")
+	_, err := Apply(context.Background(), vault.NewReadOnlyClient(client), config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		Dry:          true,
+		PlanOutPath:  planPath,
+	})
+	if err != nil {
+		t.Fatalf("Error making the plan: %s", err)
+	}
+	return client, docPath, planPath
+}
+
+func applyPlan(client *vault.MockClient, docPath string, planPath string) error {
+	_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		PlanPath:     planPath,
+		// the plan was approved, so this isn't asked
+		ConfirmInput: strings.NewReader("n\n"),
+	})
+	return err
+}
+
+func TestApply_Plan(t *testing.T) {
+	client, docPath, planPath := makePlan(t)
+
+	content, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		t.Fatalf("Expected the plan to be saved: %s", err)
+	}
+	var saved plan
+	if err := json.Unmarshal(content, &saved); err != nil {
+		t.Fatalf("Could not parse the plan: %s", err)
+	}
+	if len(saved.Changes) != 2 || saved.Changes[0].Resource != "sys/auth/approle/" ||
+		saved.Changes[1].Resource != "sys/auth/github/" {
+		t.Errorf("Expected approle to be enabled and github disabled, got %+v", saved.Changes)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Fatalf("Expected nothing to be changed while planning, got %+v", calls)
+	}
+
+	if err := applyPlan(client, docPath, planPath); err != nil {
+		t.Fatalf("Error applying the plan: %s", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 1 || calls[0].Args[0] != "approle/" {
+		t.Errorf("Expected approle to be enabled, got %+v", calls)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 1 || calls[0].Args[0] != "github/" {
+		t.Errorf("Expected github to be disabled, got %+v", calls)
+	}
+}
+
+func TestApply_PlanLiveChanged(t *testing.T) {
+	client, docPath, planPath := makePlan(t)
+
+	client.ReturnAuthMounts["ldap/"] = &vaultApi.AuthMount{Type: "ldap"}
+	err := applyPlan(client, docPath, planPath)
+	if err == nil || !strings.Contains(err.Error(), "changed in Vault since the plan") {
+		t.Errorf("Expected the plan to be refused, got %v", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+	if calls := client.CallsTo("DisableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no DisableAuth calls, got %+v", calls)
+	}
+}
+
+func TestApply_PlanDocumentsChanged(t *testing.T) {
+	client, docPath, planPath := makePlan(t)
+
+	err := ioutil.WriteFile(filepath.Join(docPath, "sys", "auth", "approle.json"),
+		[]byte(`{"type": "approle", "description": "changed"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applyPlan(client, docPath, planPath)
+	if err == nil || !strings.Contains(err.Error(), "documents have changed") {
+		t.Errorf("Expected the plan to be refused, got %v", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
+
+// A plan is only saved by a dry run, rather than after applying it
+func TestApply_PlanOutNotDry(t *testing.T) {
+	docPath := testutil.WriteDocuments(t, map[string]string{"sys/auth/approle.json": `{"type": "approle"}`})
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+
+	_, err := Apply(context.Background(), client, config.VaultsmithConfig{
+		DocumentPath: docPath,
+		VaultRole:    "root",
+		PlanOutPath:  planPath,
+	})
+	if err == nil || !strings.Contains(err.Error(), "dry run") {
+		t.Errorf("Expected an error for --plan-out without --dry, got %v", err)
+	}
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 0 {
+		t.Errorf("Expected no EnableAuth calls, got %+v", calls)
+	}
+}
@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"strings"
+	"time"
+)
+
+// Used when config.MinTokenTTL isn't set
+const DefaultMinTokenTTL = 5 * time.Minute
+
+// Check that Vault is reachable, initialized and unsealed, and that our token is valid for at least
+// config.MinTokenTTL, so a run doesn't fail part way through. Every failed check is reported.
+func Preflight(c vault.Vault, config config.VaultsmithConfig) error {
+	var failed []string
+	health, err := c.Health()
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("Vault is not reachable: %s", err))
+	} else {
+		failed = append(failed, checkHealth(health)...)
+	}
+
+	if err := authenticate(c, config); err != nil {
+		failed = append(failed, err.Error())
+	} else {
+		failed = append(failed, checkToken(c, config.MinTokenTTL)...)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("preflight checks failed: %s", strings.Join(failed, "; "))
+	}
+	log.Debug("Preflight checks passed")
+	return nil
+}
+
+func checkHealth(health *vaultApi.HealthResponse) (failed []string) {
+	if !health.Initialized {
+		failed = append(failed, "Vault is not initialized")
+	}
+	if health.Sealed {
+		failed = append(failed, "Vault is sealed")
+	}
+	return failed
+}
+
+func checkToken(c vault.Vault, minTTL time.Duration) []string {
+	if minTTL == 0 {
+		minTTL = DefaultMinTokenTTL
+	}
+	token, err := c.LookupSelf()
+	if err != nil {
+		return []string{fmt.Sprintf("the token is not valid: %s", err)}
+	}
+	if token == nil {
+		return []string{"the token is not valid: nothing returned looking it up"}
+	}
+	ttl, err := token.TokenTTL()
+	if err != nil {
+		return []string{fmt.Sprintf("could not read the token's TTL: %s", err)}
+	}
+	// a TTL of 0 is a token which never expires, such as a root token
+	if ttl != 0 && ttl < minTTL {
+		return []string{fmt.Sprintf("the token expires in %s, sooner than the %s required", ttl, minTTL)}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"encoding/json"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreflight(t *testing.T) {
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	err := Preflight(client, config.VaultsmithConfig{VaultRole: "root"})
+	if err != nil {
+		t.Errorf("Expected the preflight checks to pass, got %s", err)
+	}
+}
+
+// Every failed check is reported, including a token which is about to expire
+func TestPreflight_ExpiringToken(t *testing.T) {
+	client := &vault.MockClient{
+		ReturnHealth: &vaultApi.HealthResponse{Initialized: true, Sealed: true},
+		ReturnToken:  &vaultApi.Secret{Data: map[string]interface{}{"ttl": json.Number("60")}},
+	}
+	client.On("Authenticate", "root")
+	err := Preflight(client, config.VaultsmithConfig{VaultRole: "root", MinTokenTTL: 10 * time.Minute})
+	if err == nil {
+		t.Fatal("Expected the preflight checks to fail")
+	}
+	for _, expected := range []string{"Vault is sealed", "the token expires in 1m0s, sooner than the 10m0s required"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in the error, got %q", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "initialized") {
+		t.Errorf("Expected only the failed checks to be reported, got %q", err)
+	}
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"time"
+)
+
+// Clock abstracts time so the reconcile schedule can be tested
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type ReconcileOptions struct {
+	Interval time.Duration   // time between the end of one cycle and the start of the next
+	Trigger  <-chan struct{} // start a cycle straight away, e.g. on SIGHUP
+	OnCycle  func(Cycle)     // called after each cycle, e.g. to export metrics
+	Clock    Clock           // defaults to the real clock
+}
+
+// The outcome of one reconcile cycle
+type Cycle struct {
+	Number   int
+	Started  time.Time
+	Duration time.Duration
+	Result   Result
+	Err      error
+}
+
+// Apply config every opts.Interval until ctx is cancelled. The documents are fetched again on each
+// cycle. Cycles never overlap: the next is scheduled once the current one finishes, and triggers
+// received during a cycle start one more as soon as it is done. Cancelling ctx lets the current
+// cycle finish before returning.
+func Reconcile(ctx context.Context, c vault.Vault, config config.VaultsmithConfig, opts ReconcileOptions) error {
+	return reconcile(ctx, opts, func() (Result, error) {
+		// not ctx, so a shutdown doesn't leave a half applied cycle behind
+		return Apply(context.Background(), c, config)
+	})
+}
+
+func reconcile(ctx context.Context, opts ReconcileOptions, apply func() (Result, error)) error {
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	for n := 1; ; n++ {
+		cycle := Cycle{Number: n, Started: clock.Now()}
+		cycle.Result, cycle.Err = apply()
+		cycle.Duration = clock.Now().Sub(cycle.Started)
+		logCycle(cycle)
+		if opts.OnCycle != nil {
+			opts.OnCycle(cycle)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping reconcile loop")
+			return nil
+		case <-clock.After(opts.Interval):
+		case <-opts.Trigger:
+			log.Info("Reconcile triggered")
+		}
+	}
+}
+
+func logCycle(cycle Cycle) {
+	var changes, failed int
+	if cycle.Result.Summary != nil {
+		changes = len(cycle.Result.Summary.Rows)
+		failed = len(cycle.Result.Summary.Failed())
+	}
+	logger := log.WithFields(log.Fields{
+		"cycle":    cycle.Number,
+		"duration": cycle.Duration.String(),
+		"changes":  changes,
+		"failed":   failed,
+	})
+	if cycle.Err != nil {
+		logger.Errorf("Reconcile cycle failed: %s", cycle.Err)
+		return
+	}
+	logger.Info("Reconcile cycle finished")
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock only fires when the test sends on ticks
+type fakeClock struct {
+	now     time.Time
+	ticks   chan time.Time
+	waiting chan time.Duration // intervals the loop has asked to wait for
+}
+
+func (f *fakeClock) Now() time.Time { return f.now }
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.waiting <- d
+	return f.ticks
+}
+
+func TestReconcile_Schedule(t *testing.T) {
+	clock := &fakeClock{
+		now:     time.Now(),
+		ticks:   make(chan time.Time),
+		waiting: make(chan time.Duration, 1),
+	}
+	trigger := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	applied := 0
+	var cycles []Cycle
+	done := make(chan error)
+	go func() {
+		done <- reconcile(ctx, ReconcileOptions{
+			Interval: time.Minute,
+			Trigger:  trigger,
+			Clock:    clock,
+			OnCycle:  func(c Cycle) { cycles = append(cycles, c) },
+		}, func() (Result, error) {
+			applied++
+			if applied == 2 {
+				return Result{}, errors.New("boom")
+			}
+			return Result{}, nil
+		})
+	}()
+
+	// runs once straight away, then waits for the interval
+	if d := <-clock.waiting; d != time.Minute {
+		t.Errorf("Expected to wait %s, waited %s", time.Minute, d)
+	}
+	clock.ticks <- clock.now
+	<-clock.waiting
+	// a failed cycle doesn't stop the loop, and a trigger starts one without waiting
+	trigger <- struct{}{}
+	<-clock.waiting
+	// shutting down stops the loop once the current cycle is done
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from reconcile: %s", err)
+	}
+	if applied != 3 {
+		t.Errorf("Expected 3 applies, got %d", applied)
+	}
+	if len(cycles) != 3 || cycles[1].Err == nil || cycles[2].Number != 3 {
+		t.Errorf("Unexpected cycles: %+v", cycles)
+	}
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"time"
+)
+
+// The report of a run written to config.ReportPath, for tooling which has no metrics endpoint to
+// scrape, e.g. a batch job. The Result gives each resource's action and status, and how long each
+// operation against Vault took.
+type Report struct {
+	RunID    string        `json:"run_id"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Success  bool          `json:"success"`
+	Error    string        `json:"error,omitempty"`
+	// the number of summary rows by status, e.g. "ok", and by action, e.g. "enable"
+	Statuses map[string]int `json:"statuses"`
+	Actions  map[string]int `json:"actions"`
+	Result
+}
+
+// The duration is given in seconds, e.g. 1.83, rather than as a count of nanoseconds
+func (r Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return json.Marshal(struct {
+		report
+		Duration float64 `json:"duration"`
+	}{report(r), r.Duration.Seconds()})
+}
+
+func newReport(config config.VaultsmithConfig, started time.Time, result Result, applyErr error) Report {
+	report := Report{
+		RunID:    config.RunID,
+		Started:  started,
+		Duration: time.Since(started),
+		Success:  applyErr == nil,
+		Statuses: map[string]int{},
+		Actions:  map[string]int{},
+		Result:   result,
+	}
+	if applyErr != nil {
+		report.Error = applyErr.Error()
+	}
+	if result.Summary != nil {
+		for _, row := range result.Summary.Rows {
+			report.Statuses[row.Status]++
+			report.Actions[row.Action]++
+		}
+	}
+	return report
+}
+
+// Write the report of the run to config.ReportPath, if set, replacing the report of any earlier run
+func writeReport(config config.VaultsmithConfig, started time.Time, result Result, applyErr error) error {
+	if config.ReportPath == "" {
+		return nil
+	}
+	err := writeJSONFile(config.ReportPath, newReport(config, started, result, applyErr))
+	if err != nil {
+		return fmt.Errorf("could not write report %s: %s", config.ReportPath, err)
+	}
+	log.WithField("path", config.ReportPath).Debug("Wrote report")
+	return nil
+}
@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readReport(t *testing.T, path string) map[string]interface{} {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read the report: %s", err)
+	}
+	var report map[string]interface{}
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatalf("Could not decode the report: %s", err)
+	}
+	return report
+}
+
+func TestApply_ReportPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reportPath := filepath.Join(dir, "report.json")
+
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		RunID:        "run-1",
+		ReportPath:   reportPath,
+	}
+	result, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+
+	report := readReport(t, reportPath)
+	if report["run_id"] != "run-1" || report["success"] != true || report["document_path"] != examplePath() {
+		t.Errorf("Expected the run id, success and document path in the report, got %v", report)
+	}
+	for _, k := range []string{"started", "duration", "summary"} {
+		if _, ok := report[k]; !ok {
+			t.Errorf("Expected the report to have %q, got %v", k, report)
+		}
+	}
+	// in seconds, so a run this short is well under one
+	if d, ok := report["duration"].(float64); !ok || d <= 0 || d >= 1 {
+		t.Errorf("Expected the duration in seconds, got %v", report["duration"])
+	}
+	statuses, _ := report["statuses"].(map[string]interface{})
+	if statuses["ok"] != float64(len(result.Summary.Rows)) {
+		t.Errorf("Expected all %d changes to be counted as ok, got %v", len(result.Summary.Rows), statuses)
+	}
+	actions, _ := report["actions"].(map[string]interface{})
+	if actions["enable"] == nil {
+		t.Errorf("Expected the enable actions to be counted, got %v", actions)
+	}
+	rows := report["summary"].(map[string]interface{})["rows"].([]interface{})
+	if len(rows) != len(result.Summary.Rows) {
+		t.Errorf("Expected %d summary rows in the report, got %d", len(result.Summary.Rows), len(rows))
+	}
+}
+
+// The report is written when the run fails too
+func TestApply_ReportPathFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reportPath := filepath.Join(dir, "report.json")
+
+	client := &vault.MockClient{}
+	client.On("Authenticate", "InvalidRole")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "InvalidRole",
+		ReportPath:   reportPath,
+	}
+	_, err = Apply(context.Background(), client, conf)
+	if err == nil {
+		t.Fatalf("Expected Apply to fail")
+	}
+
+	report := readReport(t, reportPath)
+	if report["success"] != false || report["error"] != err.Error() {
+		t.Errorf("Expected the report to give the error %q, got %v", err, report)
+	}
+}
@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// What was observed in Vault at the end of a run, so the next run can tell what was changed out of
+// band since. It is only a record; the documents and Vault itself are always what is applied.
+type state struct {
+	Updated   time.Time         `json:"updated"`
+	Resources map[string]string `json:"resources"` // resource path -> hash of its live configuration
+}
+
+// A resource whose live configuration differs from that recorded at the end of the last run
+type Drift struct {
+	Resource string `json:"resource"`
+	Change   string `json:"change"` // "changed", "added" or "removed"
+}
+
+// Read the state file at path. A missing file is an empty state, as for the first run.
+func readState(path string) (state, error) {
+	var s state
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("could not read state file %s: %s", path, err)
+	}
+	err = json.Unmarshal(content, &s)
+	if err != nil {
+		return s, fmt.Errorf("could not parse state file %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Write s to path, replacing it only once fully written
+func writeState(path string, s state) error {
+	err := writeJSONFile(path, s)
+	if err != nil {
+		return fmt.Errorf("could not write state file %s: %s", path, err)
+	}
+	return nil
+}
+
+// Write v to path as indented json, replacing the file only once fully written
+func writeJSONFile(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(content, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return err
+}
+
+// Hash the live configuration of each auth method, mount and policy in Vault, except those matching
+// ignore, which are managed elsewhere
+func liveState(c vault.Vault, ignore []string) (map[string]string, error) {
+	docs, err := liveDocuments(c)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(docs))
+	for resourcePath, doc := range docs {
+		if path_handlers.IgnoredResource(ignore, resourcePath) {
+			continue
+		}
+		// maps are encoded with sorted keys, so equal configuration always has the same hash
+		content, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s: %s", resourcePath, err)
+		}
+		sum := sha256.Sum256(content)
+		hashes[resourcePath] = hex.EncodeToString(sum[:])
+	}
+	return hashes, nil
+}
+
+// The resources which differ between the last recorded and live hashes, sorted by resource
+func detectDrift(last map[string]string, live map[string]string) []Drift {
+	var drift []Drift
+	for resource, hash := range live {
+		if lastHash, ok := last[resource]; !ok {
+			drift = append(drift, Drift{Resource: resource, Change: "added"})
+		} else if lastHash != hash {
+			drift = append(drift, Drift{Resource: resource, Change: "changed"})
+		}
+	}
+	for resource := range last {
+		if _, ok := live[resource]; !ok {
+			drift = append(drift, Drift{Resource: resource, Change: "removed"})
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Resource < drift[j].Resource })
+	return drift
+}
+
+// Compare Vault with the state recorded at path by the last run, logging anything changed since
+func checkDrift(c vault.Vault, path string, ignore []string) ([]Drift, error) {
+	last, err := readState(path)
+	if err != nil {
+		return nil, err
+	}
+	if last.Resources == nil {
+		log.WithFields(log.Fields{"state": path}).Debug("No previous state, not checking for drift")
+		return nil, nil
+	}
+	live, err := liveState(c, ignore)
+	if err != nil {
+		return nil, err
+	}
+	drift := detectDrift(last.Resources, live)
+	for _, d := range drift {
+		log.WithFields(log.Fields{
+			"resource":   d.Resource,
+			"change":     d.Change,
+			"last state": last.Updated.Format(time.RFC3339),
+		}).Warn("Resource changed outside vaultsmith since the last run")
+	}
+	return drift, nil
+}
+
+// Record the live state of Vault at path for the next run
+func saveState(c vault.Vault, path string, ignore []string) error {
+	live, err := liveState(c, ignore)
+	if err != nil {
+		return err
+	}
+	return writeState(path, state{Updated: time.Now().UTC(), Resources: live})
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"github.com/starlingbank/vaultsmith/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestState_WriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := readState(path)
+	if err != nil {
+		t.Fatalf("Expected no error reading a missing state file, got %s", err)
+	}
+	if s.Resources != nil {
+		t.Errorf("Expected an empty state, got %+v", s)
+	}
+
+	written := state{
+		Updated:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Resources: map[string]string{"sys/auth/approle/": "abc", "sys/policy/default": "def"},
+	}
+	err = writeState(path, written)
+	if err != nil {
+		t.Fatalf("Error writing state: %s", err)
+	}
+	s, err = readState(path)
+	if err != nil {
+		t.Fatalf("Error reading state: %s", err)
+	}
+	if !reflect.DeepEqual(s, written) {
+		t.Errorf("Expected %+v, got %+v", written, s)
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	last := map[string]string{"same": "1", "changed": "2", "removed": "3"}
+	live := map[string]string{"same": "1", "changed": "4", "added": "5"}
+	expected := []Drift{
+		{Resource: "added", Change: "added"},
+		{Resource: "changed", Change: "changed"},
+		{Resource: "removed", Change: "removed"},
+	}
+	if drift := detectDrift(last, live); !reflect.DeepEqual(drift, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, drift)
+	}
+}
+
+// Changes made to Vault between runs are reported by the next
+func TestApply_StateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		StatePath:    filepath.Join(dir, "state.json"),
+	}
+
+	client := configuredVault()
+	result, err := Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if len(result.Drift) != 0 {
+		t.Errorf("Expected no drift without a previous state, got %+v", result.Drift)
+	}
+	if _, err := os.Stat(conf.StatePath); err != nil {
+		t.Fatalf("Expected state file to be written: %s", err)
+	}
+
+	// nothing changed in between
+	result, err = Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if len(result.Drift) != 0 {
+		t.Errorf("Expected no drift, got %+v", result.Drift)
+	}
+
+	client.ReturnPolicies["read_secrets"] = `path "secret/*" { capabilities = ["read", "list"] }`
+	delete(client.ReturnMounts, "legacy/")
+	result, err = Apply(context.Background(), client, conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	expected := []Drift{
+		{Resource: "sys/mounts/legacy/", Change: "removed"},
+		{Resource: "sys/policy/read_secrets", Change: "changed"},
+	}
+	if !reflect.DeepEqual(result.Drift, expected) {
+		t.Errorf("Expected drift %+v, got %+v", expected, result.Drift)
+	}
+}
+
+// A dry run reports drift but doesn't record anything
+func TestApply_StateFileDry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultsmith-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+		StatePath:    filepath.Join(dir, "state.json"),
+		Dry:          true,
+	}
+
+	_, err = Apply(context.Background(), configuredVault(), conf)
+	if err != nil {
+		t.Fatalf("Error calling Apply: %s", err)
+	}
+	if _, err := os.Stat(conf.StatePath); !os.IsNotExist(err) {
+		t.Errorf("Expected no state file after a dry run, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/vault"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Apply the documents in config.DocumentPath, then keep re-applying the subtree containing each
+// path received on events (relative to the document path) until ctx is cancelled or events is
+// closed. Changes arriving within debounce of each other are applied together. The same client is
+// used throughout, so it is only authenticated once.
+func Watch(ctx context.Context, c vault.Vault, config config.VaultsmithConfig, events <-chan string, debounce time.Duration) error {
+	docPath := config.DocumentPath
+	if f, err := os.Stat(docPath); err != nil || !f.IsDir() {
+		return fmt.Errorf("--watch requires document-path to be a local directory")
+	}
+	config.TemplateFile = whichFileExists(
+		config.TemplateFile,
+		filepath.Join(docPath, "_vaultsmith.json"),
+	)
+	// every change is applied as it is made, so there is no unfinished run to resume
+	config.CheckpointPath = ""
+
+	err := authenticate(c, config)
+	if err != nil {
+		return err
+	}
+	cw, err := internal.NewConfigWalker(c, config, docPath, nil)
+	if err != nil {
+		return err
+	}
+	err = cw.Run(ctx)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"path": docPath}).Info("Watching for changes")
+
+	pending := map[string]bool{}
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p, ok := <-events:
+			if !ok {
+				// apply anything outstanding before giving up
+				return reapply(ctx, c, config, docPath, pending)
+			}
+			pending[watchScope(p)] = true
+			timer = time.After(debounce)
+		case <-timer:
+			timer = nil
+			err := reapply(ctx, c, config, docPath, pending)
+			if err != nil {
+				// keep watching, the next change may well fix it
+				log.Errorf("Error applying changes: %s", err)
+			}
+			pending = map[string]bool{}
+		}
+	}
+}
+
+// Apply each of the given scopes. Handlers are recreated so they see the current state of Vault.
+func reapply(ctx context.Context, c vault.Vault, config config.VaultsmithConfig, docPath string, scopes map[string]bool) error {
+	if len(scopes) == 0 {
+		return nil
+	}
+	cw, err := internal.NewConfigWalker(c, config, docPath, nil)
+	if err != nil {
+		return err
+	}
+	if scopes["."] {
+		return cw.Run(ctx)
+	}
+
+	var sorted []string
+	for s := range scopes {
+		sorted = append(sorted, s)
+	}
+	sort.Strings(sorted)
+	for _, s := range sorted {
+		err := cw.RunSubtree(ctx, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The directory to re-apply for a changed path. The template file affects every document, so a
+// change to anything at the top level (or starting with an underscore) means a full apply.
+func watchScope(changed string) string {
+	dir := filepath.Dir(filepath.Clean(changed))
+	for _, s := range strings.Split(filepath.ToSlash(changed), "/") {
+		if strings.HasPrefix(s, "_") {
+			return "."
+		}
+	}
+	return dir
+}
+
+// Watch dir and every directory under it, sending the path (relative to dir) of any file or
+// directory which is added, removed or modified. The channel is closed once ctx is cancelled.
+func WatchDir(ctx context.Context, dir string) (<-chan string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("could not watch %s: %s", dir, err)
+	}
+	if _, err := watchTree(watcher, dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("could not watch %s: %s", dir, err)
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		defer watcher.Close()
+		for {
+			var changed []string
+			select {
+			case <-ctx.Done():
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithField("path", dir).Warnf("Error watching documents: %s", err)
+				continue
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				changed = []string{event.Name}
+				if event.Op&fsnotify.Create != 0 {
+					// a new directory is watched too, and anything made in it before it was
+					added, err := watchTree(watcher, event.Name)
+					if err != nil {
+						log.WithField("path", event.Name).Warnf("Could not watch new directory: %s", err)
+					}
+					changed = append(changed, added...)
+				}
+			}
+			for _, p := range changed {
+				rel, err := filepath.Rel(dir, p)
+				if err != nil {
+					continue
+				}
+				select {
+				case events <- rel:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Add a watch on path, if it is a directory, and on every directory under it, returning the files
+// under it
+func watchTree(watcher *fsnotify.Watcher, path string) (files []string, err error) {
+	err = filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed again already
+				return nil
+			}
+			return err
+		}
+		if !f.IsDir() {
+			if p != path {
+				files = append(files, p)
+			}
+			return nil
+		}
+		return watcher.Add(p)
+	})
+	return files, err
+}
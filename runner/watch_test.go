@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A change under sys/policy re-applies only the policy handler, after the initial full apply
+func TestWatch_ChangeTriggersScopedApply(t *testing.T) {
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+	}
+
+	events := make(chan string, 2)
+	events <- "sys/policy/read_secrets.json"
+	events <- "sys/policy/write_secrets.json"
+	close(events)
+
+	err := Watch(context.Background(), client, conf, events, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error calling Watch: %s", err)
+	}
+
+	// policies in example/sys/policy once templated; both changes are applied together, so only once
+	initial := 9
+	if calls := client.CallsTo("PutPolicy"); len(calls) != 2*initial {
+		t.Errorf("Expected %d PutPolicy calls, got %d", 2*initial, len(calls))
+	}
+	// auth mounts are only applied by the initial run
+	if calls := client.CallsTo("EnableAuth"); len(calls) != 2 {
+		t.Errorf("Expected 2 EnableAuth calls, got %d", len(calls))
+	}
+	client.AssertNumberOfCalls(t, "Authenticate", 1)
+}
+
+func TestWatch_Cancelled(t *testing.T) {
+	client := &vault.MockClient{}
+	client.On("Authenticate", "root")
+	conf := config.VaultsmithConfig{
+		DocumentPath: examplePath(),
+		VaultRole:    "root",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, client, conf, events, time.Millisecond)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil && err != context.Canceled {
+			t.Errorf("Unexpected error from Watch: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after cancellation")
+	}
+}
+
+func TestWatchScope(t *testing.T) {
+	tests := map[string]string{
+		"sys/policy/read_secrets.json": "sys/policy",
+		"auth/aws/role/example.json":   "auth/aws/role",
+		"_vaultsmith.json":             ".",
+		"auth/_templates/foo.json":     ".",
+	}
+	for changed, expected := range tests {
+		if s := watchScope(changed); s != expected {
+			t.Errorf("Expected scope %q for %q, got %q", expected, changed, s)
+		}
+	}
+}
+
+// A file made in a new directory is sent relative to the watched one
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := WatchDir(ctx, dir)
+	if err != nil {
+		t.Fatalf("Error watching %s: %s", dir, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "sys", "policy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join("sys", "policy", "read.json")
+	if err := ioutil.WriteFile(filepath.Join(dir, policy), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-events:
+			if p == policy {
+				cancel()
+				for range events {
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected a change to %s", policy)
+		}
+	}
+}
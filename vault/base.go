@@ -1,11 +1,17 @@
 package vault
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"crypto/rand"
 	"crypto/tls"
 	vaultApi "github.com/hashicorp/vault/api"
 	credAws "github.com/hashicorp/vault/builtin/credential/aws"
@@ -32,8 +38,15 @@ type readMethods interface {
 	GetPolicy(name string) (string, error)
 	List(path string) (*vaultApi.Secret, error)
 	ListAuth() (map[string]*vaultApi.AuthMount, error)
+	ListMounts() (map[string]*vaultApi.MountOutput, error)
 	ListPolicies() ([]string, error)
+	GetPlugin(pluginType string, name string) (*vaultApi.GetPluginResponse, error)
+	ListPlugins(pluginType string) ([]string, error)
 	Read(path string) (*vaultApi.Secret, error)
+	Health() (*vaultApi.HealthResponse, error)
+	LookupSelf() (*vaultApi.Secret, error) // the token the client is using
+	// what the token may do on path, e.g. ["create", "update"]
+	Capabilities(path string) ([]string, error)
 }
 
 type writeMethods interface {
@@ -41,7 +54,15 @@ type writeMethods interface {
 	DeletePolicy(name string) error
 	DisableAuth(string) error
 	EnableAuth(path string, options *vaultApi.EnableAuthOptions) error
+	Mount(path string, input *vaultApi.MountInput) error
 	PutPolicy(string, string) error
+	RegisterPlugin(pluginType string, input *vaultApi.RegisterPluginInput) error
+	DeregisterPlugin(pluginType string, name string) error
+	// reload the plugins mounted at these paths, e.g. "custom/" or "auth/custom/", so they run the
+	// binary now registered
+	ReloadPlugin(mounts []string) error
+	TuneMount(path string, config vaultApi.MountConfigInput) error
+	Unmount(path string) error
 	Write(path string, data map[string]interface{}) (*vaultApi.Secret, error)
 }
 
@@ -51,16 +72,105 @@ type BaseClient struct {
 	client  *vaultApi.Client
 	handler *credAws.CLIHandler
 	logger  *log.Entry
+	// for an AppRole login in place of AWS; see ClientOptions
+	appRoleID     string
+	wrappingToken string
+	// mount path of the auth method to log in with; see ClientOptions
+	authPath string
+	policies *policyEndpoint
 }
 
+// Where ACL policies are managed since Vault 0.9
+const aclPolicyPath = "sys/policies/acl/"
+
+// Which endpoint ACL policies are managed through. Vault before 0.9 has only sys/policy, so answers
+// 404 for sys/policies/acl, whereas a later one always lists at least the default policy there.
+// This is found out from the first request for a policy, and holds for the rest of the run.
+type policyEndpoint struct {
+	client *vaultApi.Client
+	logger *log.Entry
+	once   sync.Once
+	legacy bool
+}
+
+// Whether Vault only has the legacy sys/policy endpoint
+func (p *policyEndpoint) isLegacy() bool {
+	if p == nil {
+		return false
+	}
+	p.once.Do(func() {
+		// listed as Logical().List does, which doesn't say whether the response was a 404
+		r := p.client.NewRequest("GET", "/v1/"+strings.TrimSuffix(aclPolicyPath, "/"))
+		r.Params.Set("list", "true")
+		resp, err := p.client.RawRequest(r)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+			p.logger.Info("Vault has no sys/policies/acl, managing ACL policies through sys/policy")
+			p.legacy = true
+		}
+	})
+	return p.legacy
+}
+
+// Where plugins are registered, by type (auth, secret or database), since Vault 1.0
+const pluginCatalogPath = "sys/plugins/catalog/"
+
+// Where the plugin backends of mounts are reloaded
+const pluginReloadPath = "sys/plugins/reload/backend"
+
+// Defaults for the connection settings in ClientOptions
+const (
+	DefaultTimeout      = 60 * time.Second
+	DefaultMaxIdleConns = 16
+	DefaultKeepAlive    = 30 * time.Second
+)
+
+// Options for a Vault client. Anything not set is taken from the environment, as with the vault CLI.
+type ClientOptions struct {
+	ReadOnly bool   // only read methods call Vault; writes are logged and dropped
+	Address  string // overrides VAULT_ADDR
+	Token    string // overrides VAULT_TOKEN
+	// how long each request may take, overriding VAULT_CLIENT_TIMEOUT; DefaultTimeout if neither is set
+	Timeout time.Duration
+	// idle connections kept open to Vault for reuse; DefaultMaxIdleConns if 0
+	MaxIdleConns int
+	// interval between TCP keep-alives on open connections; DefaultKeepAlive if 0
+	KeepAlive time.Duration
+	// added to every request, e.g. for a gateway in front of Vault
+	Headers http.Header
+	// sent as RunIDHeader with every request, so Vault's audit log can be filtered by run
+	RunID string
+	// if set, Authenticate logs in with this AppRole role_id rather than with AWS, using the
+	// secret_id unwrapped from AppRoleWrappingToken
+	AppRoleID            string
+	AppRoleWrappingToken string
+	// the path the auth method Authenticate logs in with is mounted at, e.g. "ci-approle" for
+	// auth/ci-approle/login; approle or aws if empty
+	AuthPath string
+	// fail a write when Vault returns warnings for it, rather than only logging them
+	WarningsAsErrors bool
+	// if set, the token is read from this Vault Agent sink file before every request, overriding
+	// Token, so one rotated by the agent is picked up
+	TokenSink string
+}
+
+// The header carrying ClientOptions.RunID. Vault only records it in the audit log once it is
+// listed in sys/config/auditing/request-headers.
+const RunIDHeader = "X-Vaultsmith-Run-Id"
+
+// Where AppRole logins are made, unless ClientOptions.AuthPath is set
+const defaultAppRolePath = "approle"
+
 func NewVaultClient(readonly bool) (c Vault, err error) {
+	return NewVaultClientWithOptions(ClientOptions{ReadOnly: readonly})
+}
+
+func NewVaultClientWithOptions(opts ClientOptions) (c Vault, err error) {
 	config := vaultApi.Config{
 		HttpClient: &http.Client{
-			Transport: &http.Transport{
-				// lack of TLSClientConfig can cause SIGSEGV on config.ReadEnvironment() below
-				// when VAULT_SKIP_VERIFY is true
-				TLSClientConfig: &tls.Config{},
-			},
+			Transport: newTransport(opts),
 		},
 	}
 
@@ -68,33 +178,113 @@ func NewVaultClient(readonly bool) (c Vault, err error) {
 	if err != nil {
 		return c, err
 	}
+	if opts.Address != "" {
+		config.Address = opts.Address
+	}
+	if opts.Timeout != 0 {
+		config.Timeout = opts.Timeout
+	} else if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	config.HttpClient.Timeout = config.Timeout
 
 	vaultApiClient, err := vaultApi.NewClient(&config)
 	if err != nil {
 		return c, err
 	}
-	logger := log.WithFields(log.Fields{"readonly": readonly})
+	if opts.Token != "" {
+		vaultApiClient.SetToken(opts.Token)
+	}
+	transport := config.HttpClient.Transport
+	if opts.TokenSink != "" {
+		// set, so that Authenticate doesn't log in; the token sent is the one in the sink then
+		token, err := readTokenSink(opts.TokenSink)
+		if err != nil {
+			return c, err
+		}
+		vaultApiClient.SetToken(token)
+		transport = &tokenSinkTransport{next: transport, path: opts.TokenSink}
+	}
+	headers := http.Header{}
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
+	if opts.RunID != "" {
+		headers.Set(RunIDHeader, opts.RunID)
+	}
+	if len(headers) > 0 {
+		vaultApiClient.SetHeaders(headers)
+	}
+	logger := log.WithFields(log.Fields{"readonly": opts.ReadOnly})
+	// Wrapped only now, as ReadEnvironment and NewClient expect an *http.Transport
+	warnings := &warningTransport{next: transport, logger: logger}
+	config.HttpClient.Transport = &indexTransport{next: warnings}
 
+	policies := &policyEndpoint{client: vaultApiClient, logger: logger}
 	var writer writeMethods
-	if readonly {
+	if opts.ReadOnly {
 		writer = &dryClient{
 			logger: logger,
 		}
 	} else {
 		writer = &writeClient{
-			logger: logger,
-			client: vaultApiClient,
+			logger:           logger,
+			client:           vaultApiClient,
+			warnings:         warnings,
+			warningsAsErrors: opts.WarningsAsErrors,
+			policies:         policies,
 		}
 	}
 	return &BaseClient{
-		writeMethods: writer,
-		client:       vaultApiClient,
-		handler:      &credAws.CLIHandler{},
-		logger:       logger,
+		writeMethods:  writer,
+		client:        vaultApiClient,
+		handler:       &credAws.CLIHandler{},
+		logger:        logger,
+		appRoleID:     opts.AppRoleID,
+		wrappingToken: opts.AppRoleWrappingToken,
+		authPath:      strings.Trim(opts.AuthPath, "/"),
+		policies:      policies,
 	}, nil
 
 }
 
+// A random (version 4) UUID identifying a run, for ClientOptions.RunID
+func NewRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate a run ID: %s", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// The transport for connections to Vault, pooled as given by opts
+func newTransport(opts ClientOptions) *http.Transport {
+	maxIdle := opts.MaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = DefaultMaxIdleConns
+	}
+	keepAlive := opts.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		MaxIdleConns:        maxIdle,
+		MaxIdleConnsPerHost: maxIdle,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		// lack of TLSClientConfig can cause SIGSEGV on config.ReadEnvironment() when
+		// VAULT_SKIP_VERIFY is true
+		TLSClientConfig: &tls.Config{},
+	}
+}
+
 func (c *BaseClient) Authenticate(role string) error {
 	if c.client.Token() != "" {
 		// Already authenticated. Supposedly.
@@ -102,13 +292,23 @@ func (c *BaseClient) Authenticate(role string) error {
 		return nil
 	}
 
-	secret, err := c.handler.Auth(c.client, map[string]string{"role": role})
+	var secret *vaultApi.Secret
+	var err error
+	if c.appRoleID != "" {
+		secret, err = c.appRoleLogin()
+	} else {
+		login := map[string]string{"role": role}
+		if c.authPath != "" {
+			login["mount"] = c.authPath
+		}
+		secret, err = c.handler.Auth(c.client, login)
+	}
 	if err != nil {
 		c.logger.Errorf("Auth error: %s", err)
 		return err
 	}
 
-	if secret == nil {
+	if secret == nil || secret.Auth == nil {
 		return errors.New("no secret returned from Vault")
 	}
 
@@ -122,23 +322,136 @@ func (c *BaseClient) Authenticate(role string) error {
 	return nil
 }
 
-// Only read methods should be in the base client
+// Log in with the AppRole role_id, unwrapping the secret_id first so that it is never seen
+// outside this function. Nothing it returns or logs includes the secret_id.
+func (c *BaseClient) appRoleLogin() (*vaultApi.Secret, error) {
+	if c.wrappingToken == "" {
+		return nil, errors.New("an AppRole login needs a wrapping token for the secret_id")
+	}
+	c.logger.WithField("role_id", c.appRoleID).Debug("Unwrapping AppRole secret_id")
+	wrapped, err := c.client.Logical().Unwrap(c.wrappingToken)
+	// Unwrap uses the wrapping token as the client token, which the login must not send
+	c.client.ClearToken()
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap the secret_id: %s", err)
+	}
+	if wrapped == nil || wrapped.Data == nil {
+		return nil, errors.New("nothing returned unwrapping the secret_id")
+	}
+	secretID, ok := wrapped.Data["secret_id"].(string)
+	if !ok || secretID == "" {
+		return nil, errors.New("no secret_id in the unwrapped response")
+	}
+
+	mount := c.authPath
+	if mount == "" {
+		mount = defaultAppRolePath
+	}
+	c.logger.WithFields(log.Fields{"role_id": c.appRoleID, "mount": mount}).Debug("Logging in with AppRole")
+	secret, err := c.client.Logical().Write("auth/"+mount+"/login", map[string]interface{}{
+		"role_id":   c.appRoleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("AppRole login failed: %s", err)
+	}
+	return secret, nil
+}
+
+// Only read methods should be in the base client. A request Vault refuses returns a *ResponseError.
 func (c *BaseClient) Read(path string) (*vaultApi.Secret, error) {
-	return c.client.Logical().Read(path)
+	secret, err := c.client.Logical().Read(path)
+	return secret, responseError(err)
 }
 
 func (c *BaseClient) List(path string) (*vaultApi.Secret, error) {
-	return c.client.Logical().List(path)
+	secret, err := c.client.Logical().List(path)
+	return secret, responseError(err)
 }
 
 func (c *BaseClient) ListAuth() (map[string]*vaultApi.AuthMount, error) {
-	return c.client.Sys().ListAuth()
+	auths, err := c.client.Sys().ListAuth()
+	return auths, responseError(err)
+}
+
+func (c *BaseClient) ListMounts() (map[string]*vaultApi.MountOutput, error) {
+	mounts, err := c.client.Sys().ListMounts()
+	return mounts, responseError(err)
+}
+
+func (c *BaseClient) Health() (*vaultApi.HealthResponse, error) {
+	health, err := c.client.Sys().Health()
+	return health, responseError(err)
+}
+
+func (c *BaseClient) LookupSelf() (*vaultApi.Secret, error) {
+	secret, err := c.client.Auth().Token().LookupSelf()
+	return secret, responseError(err)
 }
 
+func (c *BaseClient) Capabilities(path string) ([]string, error) {
+	capabilities, err := c.client.Sys().CapabilitiesSelf(path)
+	return capabilities, responseError(err)
+}
+
+// ACL policies are read from sys/policies/acl, rather than the legacy sys/policy used by the api
+// package, unless Vault is too old to have it. A missing policy is returned as empty.
 func (c *BaseClient) GetPolicy(name string) (string, error) {
-	return c.client.Sys().GetPolicy(name)
+	if c.policies.isLegacy() {
+		policy, err := c.client.Sys().GetPolicy(name)
+		return policy, responseError(err)
+	}
+	secret, err := c.client.Logical().Read(aclPolicyPath + name)
+	if err != nil || secret == nil {
+		return "", responseError(err)
+	}
+	policy, _ := secret.Data["policy"].(string)
+	return policy, nil
 }
 
 func (c *BaseClient) ListPolicies() ([]string, error) {
-	return c.client.Sys().ListPolicies()
+	if c.policies.isLegacy() {
+		names, err := c.client.Sys().ListPolicies()
+		return names, responseError(err)
+	}
+	return c.listKeys(aclPolicyPath)
+}
+
+// Plugins are read from the typed catalog, which the api package doesn't support. A plugin which
+// isn't registered is returned as nil.
+func (c *BaseClient) GetPlugin(pluginType string, name string) (*vaultApi.GetPluginResponse, error) {
+	secret, err := c.client.Logical().Read(pluginCatalogPath + pluginType + "/" + name)
+	if err != nil || secret == nil {
+		return nil, responseError(err)
+	}
+	var plugin vaultApi.GetPluginResponse
+	content, err := json.Marshal(secret.Data)
+	if err == nil {
+		err = json.Unmarshal(content, &plugin)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode plugin %s/%s: %s", pluginType, name, err)
+	}
+	return &plugin, nil
+}
+
+// List the plugins of pluginType in the catalog, including those built in to Vault
+func (c *BaseClient) ListPlugins(pluginType string) ([]string, error) {
+	return c.listKeys(pluginCatalogPath + pluginType)
+}
+
+// List path, returning its keys
+func (c *BaseClient) listKeys(path string) ([]string, error) {
+	secret, err := c.client.Logical().List(path)
+	if err != nil || secret == nil {
+		return nil, responseError(err)
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if name, ok := k.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
 }
@@ -1,9 +1,19 @@
 package vault
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAuthenticate(t *testing.T) {
@@ -15,3 +25,322 @@ func TestAuthenticate(t *testing.T) {
 	}
 	log.Println(client)
 }
+
+// A request taking longer than the configured timeout fails rather than waiting for Vault
+func TestNewVaultClientWithOptions_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := NewVaultClientWithOptions(ClientOptions{
+		Address: server.URL,
+		Token:   "root",
+		Timeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	start := time.Now()
+	_, err = client.Read("secret/foo")
+	if err == nil {
+		t.Fatal("Expected the read to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the read to fail after 50ms, took %s", elapsed)
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	transport := newTransport(ClientOptions{})
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConns {
+		t.Errorf("Expected %d idle connections by default, got %d", DefaultMaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+
+	transport = newTransport(ClientOptions{MaxIdleConns: 4})
+	if transport.MaxIdleConns != 4 || transport.MaxIdleConnsPerHost != 4 {
+		t.Errorf("Expected 4 idle connections, got %d (%d per host)", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+}
+
+func TestNewVaultClientWithOptions_Headers(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := NewVaultClientWithOptions(ClientOptions{
+		Address: server.URL,
+		Token:   "root",
+		Headers: http.Header{"X-Gateway-Token": []string{"letmein"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	_, err = client.Write("secret/foo", map[string]interface{}{"a": "b"})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if h := received.Get("X-Gateway-Token"); h != "letmein" {
+		t.Errorf("Expected X-Gateway-Token header %q, got %q", "letmein", h)
+	}
+	if h := received.Get("X-Vault-Token"); h != "root" {
+		t.Errorf("Expected the token to still be sent, got %q", h)
+	}
+}
+
+// Reads go through to the wrapped client, but writes never reach it
+func TestNewReadOnlyClient(t *testing.T) {
+	mock := &MockClient{}
+	c := NewReadOnlyClient(mock)
+	c.Read("secret/foo")
+	c.Write("secret/foo", map[string]interface{}{"foo": "bar"})
+	c.DisableAuth("github/")
+
+	if len(mock.CallLog) != 1 || mock.CallLog[0].Method != "Read" {
+		t.Errorf("Expected only the Read to reach the client, got %+v", mock.CallLog)
+	}
+}
+
+// The secret_id is unwrapped and used to log in, without being logged or kept as the client token
+func TestAuthenticate_AppRoleWrappedSecretID(t *testing.T) {
+	const secretID = "d7c5c8a2-secret"
+	var loginToken string
+	var loginData map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/unwrap":
+			if r.Header.Get("X-Vault-Token") != "wrapping-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, `{"data": {"secret_id": %q, "secret_id_accessor": "abc"}}`, secretID)
+		case "/v1/auth/approle/login":
+			loginToken = r.Header.Get("X-Vault-Token")
+			json.NewDecoder(r.Body).Decode(&loginData)
+			fmt.Fprint(w, `{"auth": {"client_token": "approle-token"}}`)
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != "approle-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"data": {"id": "approle-token"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var logged bytes.Buffer
+	defer func(out io.Writer, level log.Level) {
+		log.SetOutput(out)
+		log.SetLevel(level)
+	}(log.StandardLogger().Out, log.GetLevel())
+	log.SetOutput(&logged)
+	log.SetLevel(log.DebugLevel)
+
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Unsetenv("VAULT_TOKEN")
+	client, err := NewVaultClientWithOptions(ClientOptions{
+		Address:              server.URL,
+		AppRoleID:            "vaultsmith",
+		AppRoleWrappingToken: "wrapping-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	if err := client.Authenticate("root"); err != nil {
+		t.Fatalf("Error authenticating: %s", err)
+	}
+
+	if loginData["role_id"] != "vaultsmith" || loginData["secret_id"] != secretID {
+		t.Errorf("Expected a login with the unwrapped secret_id, got %+v", loginData)
+	}
+	if loginToken != "" {
+		t.Errorf("Expected the wrapping token not to be sent with the login, got %q", loginToken)
+	}
+	if token := client.(*BaseClient).client.Token(); token != "approle-token" {
+		t.Errorf("Expected the AppRole token to be used, got %q", token)
+	}
+	if strings.Contains(logged.String(), secretID) {
+		t.Errorf("Expected the secret_id never to be logged, got:\n%s", logged.String())
+	}
+}
+
+// A login server recording the paths logged in at
+func loginServer(logins *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/sys/wrapping/unwrap":
+			fmt.Fprint(w, `{"data": {"secret_id": "secret"}}`)
+		case strings.HasSuffix(r.URL.Path, "/login"):
+			*logins = append(*logins, r.URL.Path)
+			fmt.Fprint(w, `{"auth": {"client_token": "login-token"}}`)
+		case r.URL.Path == "/v1/auth/token/lookup-self":
+			fmt.Fprint(w, `{"data": {"id": "login-token"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// The login is made at the auth mount given by AuthPath
+func TestAuthenticate_AuthPath(t *testing.T) {
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Unsetenv("VAULT_TOKEN")
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Setenv(env, "test")
+	}
+
+	for _, tc := range []struct {
+		opts     ClientOptions
+		expected string
+	}{
+		{ClientOptions{AppRoleID: "vaultsmith", AppRoleWrappingToken: "wrapping-token"}, "/v1/auth/approle/login"},
+		{ClientOptions{AppRoleID: "vaultsmith", AppRoleWrappingToken: "wrapping-token", AuthPath: "ci-approle"},
+			"/v1/auth/ci-approle/login"},
+		{ClientOptions{}, "/v1/auth/aws/login"},
+		{ClientOptions{AuthPath: "/aws-prod/"}, "/v1/auth/aws-prod/login"},
+	} {
+		var logins []string
+		server := loginServer(&logins)
+		tc.opts.Address = server.URL
+		client, err := NewVaultClientWithOptions(tc.opts)
+		if err != nil {
+			t.Fatalf("Failed to create client: %s", err)
+		}
+		if err := client.Authenticate("root"); err != nil {
+			t.Errorf("Error authenticating with %+v: %s", tc.opts, err)
+		}
+		if len(logins) != 1 || logins[0] != tc.expected {
+			t.Errorf("Expected a login at %s, got %+v", tc.expected, logins)
+		}
+		server.Close()
+	}
+}
+
+func TestAuthenticate_AppRoleNoWrappingToken(t *testing.T) {
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Unsetenv("VAULT_TOKEN")
+	client, err := NewVaultClientWithOptions(ClientOptions{
+		Address:   "http://127.0.0.1:1",
+		AppRoleID: "vaultsmith",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	if err := client.Authenticate("root"); err == nil {
+		t.Error("Expected an error without a wrapping token")
+	}
+}
+
+// Every request made by a client carries the same run ID
+func TestNewVaultClientWithOptions_RunID(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(RunIDHeader))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	runID, err := NewRunID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewVaultClientWithOptions(ClientOptions{
+		Address: server.URL,
+		Token:   "root",
+		Headers: http.Header{"X-Gateway-Token": []string{"letmein"}},
+		RunID:   runID,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	client.Read("secret/foo")
+	client.Write("secret/foo", map[string]interface{}{"a": "b"})
+	client.List("secret")
+	client.Delete("secret/foo")
+
+	if len(received) != 4 {
+		t.Fatalf("Expected 4 requests, got %d", len(received))
+	}
+	for i, id := range received {
+		if id != runID {
+			t.Errorf("Expected request %d to have run ID %q, got %q", i, runID, id)
+		}
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	a, err := NewRunID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewRunID()
+	if a == b {
+		t.Errorf("Expected a different ID for each run, got %s twice", a)
+	}
+	if len(a) != 36 || a[14] != '4' {
+		t.Errorf("Expected a version 4 UUID, got %s", a)
+	}
+}
+
+// A Vault before 0.9 has no sys/policies/acl, so ACL policies are managed through sys/policy
+func TestPolicies_Legacy(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/sys/policy":
+			w.Write([]byte(`{"policies": ["default", "read"]}`))
+		case "/v1/sys/policy/read":
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"name": "read", "rules": "path \"secret/*\" {}"}`))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewVaultClientWithOptions(ClientOptions{Address: server.URL, Token: "root"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	names, err := client.ListPolicies()
+	if err != nil || !reflect.DeepEqual(names, []string{"default", "read"}) {
+		t.Errorf("Expected the policies listed from sys/policy, got %+v (err: %v)", names, err)
+	}
+	policy, err := client.GetPolicy("read")
+	if err != nil || policy != `path "secret/*" {}` {
+		t.Errorf("Expected the policy read from sys/policy, got %q (err: %v)", policy, err)
+	}
+	if err := client.PutPolicy("read", `path "secret/*" {}`); err != nil {
+		t.Errorf("Expected the policy to be written to sys/policy, got %s", err)
+	}
+	if err := client.DeletePolicy("read"); err != nil {
+		t.Errorf("Expected the policy to be deleted from sys/policy, got %s", err)
+	}
+
+	expected := []string{
+		"GET /v1/sys/policies/acl", // found out once, from the first request
+		"GET /v1/sys/policy",
+		"GET /v1/sys/policy/read",
+		"PUT /v1/sys/policy/read",
+		"DELETE /v1/sys/policy/read",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %+v, got %+v", expected, requests)
+	}
+}
@@ -0,0 +1,59 @@
+package vault
+
+import (
+	"net/http"
+	"sync"
+)
+
+// With performance replication, a standby may serve a read before it has caught up with a write we
+// just made, and we would then report drift that isn't there. Vault returns the replication state
+// after a write in this header; sending it back on later requests makes the standby wait for (or
+// forward to the active node) that state.
+const vaultIndexHeader = "X-Vault-Index"
+const vaultInconsistentHeader = "X-Vault-Inconsistent"
+
+// indexTransport remembers the X-Vault-Index of the last write and sends it with every request
+type indexTransport struct {
+	next  http.RoundTripper
+	mu    sync.Mutex
+	index string
+}
+
+func (t *indexTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if index := t.lastIndex(); index != "" {
+		// RoundTrippers must not modify the request they are given
+		req = cloneRequest(req)
+		req.Header.Set(vaultIndexHeader, index)
+		req.Header.Set(vaultInconsistentHeader, "forward-active-node")
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		if index := resp.Header.Get(vaultIndexHeader); index != "" {
+			t.mu.Lock()
+			t.index = index
+			t.mu.Unlock()
+		}
+	}
+	return resp, nil
+}
+
+func (t *indexTransport) lastIndex() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.index
+}
+
+// shallow copy of req with its own headers
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return r
+}
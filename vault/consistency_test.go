@@ -0,0 +1,54 @@
+package vault
+
+import (
+	"net/http"
+	"testing"
+)
+
+// mockTransport records requests and returns a response with the given headers
+type mockTransport struct {
+	requests []*http.Request
+	headers  http.Header
+}
+
+func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.requests = append(m.requests, req)
+	return &http.Response{StatusCode: 200, Header: m.headers, Request: req}, nil
+}
+
+func TestIndexTransport_PropagatesIndexFromWrites(t *testing.T) {
+	mock := &mockTransport{headers: http.Header{}}
+	transport := &indexTransport{next: mock}
+
+	get := func() *http.Request {
+		req, _ := http.NewRequest("GET", "http://vault/v1/secret/foo", nil)
+		return req
+	}
+
+	// nothing written yet, so nothing to send
+	transport.RoundTrip(get())
+	if h := mock.requests[0].Header.Get(vaultIndexHeader); h != "" {
+		t.Errorf("Expected no %s header before any write, got %q", vaultIndexHeader, h)
+	}
+
+	// reads never update the index
+	mock.headers.Set(vaultIndexHeader, "from-read")
+	transport.RoundTrip(get())
+
+	mock.headers.Set(vaultIndexHeader, "from-write")
+	put, _ := http.NewRequest("PUT", "http://vault/v1/secret/foo", nil)
+	transport.RoundTrip(put)
+
+	req := get()
+	transport.RoundTrip(req)
+	sent := mock.requests[len(mock.requests)-1]
+	if h := sent.Header.Get(vaultIndexHeader); h != "from-write" {
+		t.Errorf("Expected %s header %q on read after write, got %q", vaultIndexHeader, "from-write", h)
+	}
+	if h := sent.Header.Get(vaultInconsistentHeader); h != "forward-active-node" {
+		t.Errorf("Expected %s header %q, got %q", vaultInconsistentHeader, "forward-active-node", h)
+	}
+	if h := req.Header.Get(vaultIndexHeader); h != "" {
+		t.Errorf("Original request should not be modified, got %s header %q", vaultIndexHeader, h)
+	}
+}
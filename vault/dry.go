@@ -9,6 +9,26 @@ type dryClient struct {
 	logger *log.Entry
 }
 
+// A client reading through to another, whose writes are logged and dropped
+type readOnlyClient struct {
+	readMethods
+	writeMethods
+	client Vault
+}
+
+// Wrap c so only its read methods call Vault, as if it had been created with ReadOnly set
+func NewReadOnlyClient(c Vault) Vault {
+	return &readOnlyClient{
+		readMethods:  c,
+		writeMethods: &dryClient{logger: log.WithFields(log.Fields{"readonly": true})},
+		client:       c,
+	}
+}
+
+func (c *readOnlyClient) Authenticate(role string) error {
+	return c.client.Authenticate(role)
+}
+
 // Override any methods that write, so we can only perform reads
 func (c *dryClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
 	c.logger.WithFields(log.Fields{
@@ -27,6 +47,32 @@ func (c *dryClient) DisableAuth(path string) error {
 	return nil
 }
 
+func (c *dryClient) Mount(path string, input *vaultApi.MountInput) error {
+	c.logger.WithFields(log.Fields{
+		"action": "Mount",
+		"input":  input,
+		"path":   path,
+	}).Debug("No Vault API call made")
+	return nil
+}
+
+func (c *dryClient) TuneMount(path string, config vaultApi.MountConfigInput) error {
+	c.logger.WithFields(log.Fields{
+		"action": "TuneMount",
+		"config": config,
+		"path":   path,
+	}).Debug("No Vault API call made")
+	return nil
+}
+
+func (c *dryClient) Unmount(path string) error {
+	c.logger.WithFields(log.Fields{
+		"action": "Unmount",
+		"path":   path,
+	}).Debug("No Vault API call made")
+	return nil
+}
+
 func (c *dryClient) PutPolicy(name string, data string) error {
 	c.logger.WithFields(log.Fields{
 		"action": "PutPolicy",
@@ -44,11 +90,37 @@ func (c *dryClient) DeletePolicy(name string) error {
 	return nil
 }
 
+func (c *dryClient) RegisterPlugin(pluginType string, input *vaultApi.RegisterPluginInput) error {
+	c.logger.WithFields(log.Fields{
+		"action": "RegisterPlugin",
+		"type":   pluginType,
+		"input":  input,
+	}).Debug("No Vault API call made")
+	return nil
+}
+
+func (c *dryClient) DeregisterPlugin(pluginType string, name string) error {
+	c.logger.WithFields(log.Fields{
+		"action": "DeregisterPlugin",
+		"type":   pluginType,
+		"name":   name,
+	}).Debug("No Vault API call made")
+	return nil
+}
+
+func (c *dryClient) ReloadPlugin(mounts []string) error {
+	c.logger.WithFields(log.Fields{
+		"action": "ReloadPlugin",
+		"mounts": mounts,
+	}).Debug("No Vault API call made")
+	return nil
+}
+
 func (c *dryClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
 	c.logger.WithFields(log.Fields{
 		"action": "Write",
 		"path":   path,
-		"data":   data,
+		"data":   Redact(data),
 	}).Debug("No Vault API call made")
 	return &vaultApi.Secret{}, nil
 }
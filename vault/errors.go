@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ResponseError is returned by the client when Vault refuses a request, so callers can tell e.g.
+// permission denied (403) from a bad request (400) or a sealed or standby Vault (503) with
+// errors.As. Its message is the api package's, so it reads as before.
+type ResponseError struct {
+	StatusCode int
+	Errors     []string // Vault's error messages, or the body of the response if it wasn't JSON
+	err        error
+}
+
+func (e *ResponseError) Error() string {
+	if e.err == nil {
+		// made directly, e.g. by a test, rather than from the api package's error
+		return fmt.Sprintf("Code: %d. Errors: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+	}
+	return e.err.Error()
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.err
+}
+
+// How the api package describes a response Vault refused, followed by the messages in it
+var responseErrorPattern = regexp.MustCompile(`(?s)\nCode: (\d+)\. (Errors|Raw Message):\n\n(.*)$`)
+
+// err from the api package as a *ResponseError, if Vault refused the request; otherwise err, e.g.
+// if Vault couldn't be reached
+func responseError(err error) error {
+	var re *ResponseError
+	if err == nil || errors.As(err, &re) {
+		return err
+	}
+	m := responseErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	code, _ := strconv.Atoi(m[1])
+	re = &ResponseError{StatusCode: code, err: err}
+	if m[2] == "Errors" {
+		re.Errors = splitErrors(m[3])
+	} else if raw := strings.TrimSpace(m[3]); raw != "" {
+		re.Errors = []string{raw}
+	}
+	return re
+}
+
+// Split the errors the api package lists as "* one* two". A message may hold a list of its own,
+// indented, as Vault's multierror lists are, so only a "* " without space before starts another.
+func splitErrors(listed string) []string {
+	var messages []string
+	start := -1
+	for i := 0; i+1 < len(listed); i++ {
+		if listed[i] != '*' || listed[i+1] != ' ' || (i > 0 && (listed[i-1] == ' ' || listed[i-1] == '\t')) {
+			continue
+		}
+		if start >= 0 {
+			messages = append(messages, strings.TrimSpace(listed[start:i]))
+		}
+		start = i + 2
+		i++
+	}
+	if start >= 0 {
+		messages = append(messages, strings.TrimSpace(listed[start:]))
+	}
+	return messages
+}
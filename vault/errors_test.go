@@ -0,0 +1,80 @@
+package vault
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/secret/forbidden":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["1 error occurred:\n\t* permission denied\n\n"]}`))
+		case "/v1/secret/invalid":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": ["missing field: name", "unknown field: nmae"]}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Vault is sealed"))
+		}
+	}))
+	defer server.Close()
+	client, err := NewVaultClientWithOptions(ClientOptions{Address: server.URL, Token: "root"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	_, err = client.Read("secret/forbidden")
+	var re *ResponseError
+	if !errors.As(err, &re) {
+		t.Fatalf("Expected a *ResponseError, got %T: %v", err, err)
+	}
+	if re.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected code 403, got %d", re.StatusCode)
+	}
+	if expected := []string{"1 error occurred:\n\t* permission denied"}; !reflect.DeepEqual(re.Errors, expected) {
+		t.Errorf("Expected errors %q, got %q", expected, re.Errors)
+	}
+	// still reads as the api package's error
+	if !strings.Contains(err.Error(), "Code: 403") {
+		t.Errorf("Expected the code in the message, got %q", err.Error())
+	}
+
+	_, err = client.Write("secret/invalid", map[string]interface{}{"nmae": "x"})
+	if !errors.As(err, &re) || re.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a *ResponseError with code 400, got %v", err)
+	}
+	if expected := []string{"missing field: name", "unknown field: nmae"}; !reflect.DeepEqual(re.Errors, expected) {
+		t.Errorf("Expected errors %q, got %q", expected, re.Errors)
+	}
+
+	err = client.DisableAuth("sealed")
+	if !errors.As(err, &re) || re.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a *ResponseError with code 503, got %v", err)
+	}
+	if expected := []string{"Vault is sealed"}; !reflect.DeepEqual(re.Errors, expected) {
+		t.Errorf("Expected the raw body as the error, got %q", re.Errors)
+	}
+}
+
+// Failing to reach Vault at all isn't a response
+func TestResponseError_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client, err := NewVaultClientWithOptions(ClientOptions{Address: server.URL, Token: "root"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	_, err = client.Read("secret/foo")
+	var re *ResponseError
+	if err == nil || errors.As(err, &re) {
+		t.Errorf("Expected an error which isn't a *ResponseError, got %#v", err)
+	}
+}
@@ -4,13 +4,68 @@ import (
 	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/mock"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// MockClient can be shared by handlers applying in parallel: calls are recorded under a mutex, and
+// the Return fields are only read once set up
 type MockClient struct {
 	mock.Mock
-	ReturnString string
-	ReturnError  error
-	ReturnSecret *vaultApi.Secret
+	ReturnString     string
+	ReturnError      error
+	ReturnSecret     *vaultApi.Secret
+	ReturnListSecret *vaultApi.Secret            // returned by List instead of ReturnSecret, if set
+	ReturnSecrets    map[string]*vaultApi.Secret // returned by Read for specific paths
+	ReturnAuthMounts map[string]*vaultApi.AuthMount
+	ReturnMounts     map[string]*vaultApi.MountOutput
+	ReturnPolicies   map[string]string // policy name -> rules, for ListPolicies and GetPolicy
+	CallLog          []MockCall        // calls made against the client, excluding Authenticate
+	WriteErrors      map[string]error  // errors returned by write methods for specific paths or names
+
+	// "<type>/<name>" -> registration, for ListPlugins and GetPlugin
+	ReturnPlugins map[string]*vaultApi.GetPluginResponse
+	// returned by Health and LookupSelf; an initialized, unsealed Vault and a token which never
+	// expires if not set
+	ReturnHealth *vaultApi.HealthResponse
+	ReturnToken  *vaultApi.Secret
+	// path -> capabilities, for Capabilities; root if not set
+	ReturnCapabilities map[string][]string
+
+	mu sync.Mutex // guards CallLog
+}
+
+// A record of a method called on the MockClient, so tests can assert what was sent to Vault
+type MockCall struct {
+	Method string
+	Args   []interface{}
+}
+
+func (m *MockClient) record(method string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.CallLog = append(m.CallLog, MockCall{Method: method, Args: args})
+}
+
+// The error a write method should return for path
+func (m *MockClient) writeError(path string) error {
+	if err, ok := m.WriteErrors[path]; ok {
+		return err
+	}
+	return m.ReturnError
+}
+
+// Return all recorded calls to the given method, in the order they were made
+func (m *MockClient) CallsTo(method string) (calls []MockCall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.CallLog {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
 }
 
 func (m *MockClient) Authenticate(role string) error {
@@ -23,48 +78,160 @@ func (m *MockClient) Authenticate(role string) error {
 	return m.ReturnError
 }
 
-func (m *MockClient) DisableAuth(string) error {
-	return m.ReturnError
+func (m *MockClient) DisableAuth(path string) error {
+	m.record("DisableAuth", path)
+	return m.writeError(path)
 }
 
 func (m *MockClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
-	return m.ReturnError
+	m.record("EnableAuth", path, options)
+	return m.writeError(path)
+}
+
+func (m *MockClient) Mount(path string, input *vaultApi.MountInput) error {
+	m.record("Mount", path, input)
+	return m.writeError(path)
+}
+
+func (m *MockClient) TuneMount(path string, config vaultApi.MountConfigInput) error {
+	m.record("TuneMount", path, config)
+	return m.writeError(path)
+}
+
+func (m *MockClient) Unmount(path string) error {
+	m.record("Unmount", path)
+	return m.writeError(path)
 }
 
 func (m *MockClient) ListAuth() (map[string]*vaultApi.AuthMount, error) {
+	m.record("ListAuth")
 	rv := make(map[string]*vaultApi.AuthMount)
+	for k, v := range m.ReturnAuthMounts {
+		rv[k] = v
+	}
 	return rv, m.ReturnError
 }
 
+func (m *MockClient) ListMounts() (map[string]*vaultApi.MountOutput, error) {
+	m.record("ListMounts")
+	rv := make(map[string]*vaultApi.MountOutput)
+	for k, v := range m.ReturnMounts {
+		rv[k] = v
+	}
+	return rv, m.ReturnError
+}
+
+func (m *MockClient) Health() (*vaultApi.HealthResponse, error) {
+	m.record("Health")
+	if m.ReturnHealth != nil {
+		return m.ReturnHealth, m.ReturnError
+	}
+	return &vaultApi.HealthResponse{Initialized: true}, m.ReturnError
+}
+
+func (m *MockClient) LookupSelf() (*vaultApi.Secret, error) {
+	m.record("LookupSelf")
+	if m.ReturnToken != nil {
+		return m.ReturnToken, m.ReturnError
+	}
+	return &vaultApi.Secret{Data: map[string]interface{}{"ttl": 0}}, m.ReturnError
+}
+
+func (m *MockClient) Capabilities(path string) ([]string, error) {
+	m.record("Capabilities", path)
+	if c, ok := m.ReturnCapabilities[path]; ok {
+		return c, m.ReturnError
+	}
+	return []string{"root"}, m.ReturnError
+}
+
 func (m *MockClient) ListPolicies() ([]string, error) {
+	m.record("ListPolicies")
 	rv := make([]string, 0)
+	for name := range m.ReturnPolicies {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
 	return rv, m.ReturnError
 }
 
 func (m *MockClient) GetPolicy(name string) (string, error) {
+	m.record("GetPolicy", name)
+	if policy, ok := m.ReturnPolicies[name]; ok {
+		return policy, m.ReturnError
+	}
 	return m.ReturnString, m.ReturnError
 }
 
 func (m *MockClient) PutPolicy(name string, data string) error {
-	return m.ReturnError
+	m.record("PutPolicy", name, data)
+	return m.writeError(name)
 }
 
 func (m *MockClient) DeletePolicy(name string) error {
+	m.record("DeletePolicy", name)
+	return m.writeError(name)
+}
+
+func (m *MockClient) ListPlugins(pluginType string) ([]string, error) {
+	m.record("ListPlugins", pluginType)
+	rv := make([]string, 0)
+	for key := range m.ReturnPlugins {
+		if strings.HasPrefix(key, pluginType+"/") {
+			rv = append(rv, strings.TrimPrefix(key, pluginType+"/"))
+		}
+	}
+	sort.Strings(rv)
+	return rv, m.ReturnError
+}
+
+func (m *MockClient) GetPlugin(pluginType string, name string) (*vaultApi.GetPluginResponse, error) {
+	m.record("GetPlugin", pluginType, name)
+	return m.ReturnPlugins[pluginType+"/"+name], m.ReturnError
+}
+
+func (m *MockClient) RegisterPlugin(pluginType string, input *vaultApi.RegisterPluginInput) error {
+	m.record("RegisterPlugin", pluginType, input)
+	return m.writeError(pluginType + "/" + input.Name)
+}
+
+func (m *MockClient) DeregisterPlugin(pluginType string, name string) error {
+	m.record("DeregisterPlugin", pluginType, name)
+	return m.writeError(pluginType + "/" + name)
+}
+
+func (m *MockClient) ReloadPlugin(mounts []string) error {
+	m.record("ReloadPlugin", mounts)
+	for _, mount := range mounts {
+		if err, ok := m.WriteErrors[mount]; ok {
+			return err
+		}
+	}
 	return m.ReturnError
 }
 
 func (m *MockClient) Read(path string) (*vaultApi.Secret, error) {
+	m.record("Read", path)
+	if secret, ok := m.ReturnSecrets[path]; ok {
+		return secret, m.ReturnError
+	}
 	return m.ReturnSecret, m.ReturnError
 }
 
 func (m *MockClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
-	return m.ReturnSecret, m.ReturnError
+	m.record("Write", path, data)
+	return m.ReturnSecret, m.writeError(path)
 }
 
 func (m *MockClient) List(path string) (*vaultApi.Secret, error) {
+	m.record("List", path)
+	if m.ReturnListSecret != nil {
+		return m.ReturnListSecret, m.ReturnError
+	}
 	return m.ReturnSecret, m.ReturnError
 }
 
 func (m *MockClient) Delete(path string) (*vaultApi.Secret, error) {
-	return m.ReturnSecret, m.ReturnError
+	m.record("Delete", path)
+	return m.ReturnSecret, m.writeError(path)
 }
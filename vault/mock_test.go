@@ -0,0 +1,38 @@
+package vault
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	"sync"
+	"testing"
+)
+
+// Run with -race: handlers applying in parallel share one MockClient
+func TestMockClient_Concurrent(t *testing.T) {
+	client := &MockClient{
+		ReturnSecrets:  map[string]*vaultApi.Secret{"secret/foo": {Data: map[string]interface{}{"a": "b"}}},
+		ReturnPolicies: map[string]string{"admin": `path "*" {}`},
+	}
+	client.On("Authenticate", "root")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client.Authenticate("root")
+			client.Read("secret/foo")
+			client.Write(fmt.Sprintf("secret/%d", i), map[string]interface{}{"i": i})
+			client.ListPolicies()
+			client.CallsTo("Write")
+		}(i)
+	}
+	wg.Wait()
+
+	if writes := client.CallsTo("Write"); len(writes) != 50 {
+		t.Errorf("Expected 50 Write calls, got %d", len(writes))
+	}
+	if calls := len(client.CallLog); calls != 150 {
+		t.Errorf("Expected 150 calls to be recorded, got %d", calls)
+	}
+}
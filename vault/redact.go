@@ -0,0 +1,81 @@
+package vault
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// What the value of a redacted field is replaced with
+const RedactedValue = "****"
+
+// Fields whose values are never logged or shown in a diff, as path.Match patterns matched against
+// the field name regardless of case. Lists such as token_policies and audit_non_hmac_request_keys
+// don't match.
+var DefaultRedactFields = []string{
+	"password", "*_password", "bindpass",
+	"secret", "*_secret", "secret_id", "*_secret_id",
+	"token", "*_token",
+	"*_key", "private_key",
+	"credentials", "settings_file_base64",
+}
+
+// the patterns in use; only changed before anything is applied
+var redactFields = DefaultRedactFields
+
+// Redact the fields matching patterns as well as the defaults
+func AddRedactFields(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid field pattern %q: %s", p, err)
+		}
+	}
+	redactFields = append(append([]string{}, DefaultRedactFields...), patterns...)
+	return nil
+}
+
+// Whether the value of field is redacted, e.g. "bindpass"
+func IsRedacted(field string) bool {
+	field = strings.ToLower(field)
+	for _, p := range redactFields {
+		if matched, _ := path.Match(strings.ToLower(p), field); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// A copy of v, as decoded from json, with the value of every redacted field replaced by
+// RedactedValue, however deeply nested. Anything else is returned as it is.
+func Redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, value := range t {
+			if value != nil && IsRedacted(k) {
+				out[k] = RedactedValue
+			} else {
+				out[k] = Redact(value)
+			}
+		}
+		return out
+	case map[string]string:
+		// such as the options of a mount
+		out := make(map[string]string, len(t))
+		for k, value := range t {
+			if IsRedacted(k) {
+				value = RedactedValue
+			}
+			out[k] = value
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, value := range t {
+			out[i] = Redact(value)
+		}
+		return out
+	default:
+		return v
+	}
+}
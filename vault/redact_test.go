@@ -0,0 +1,83 @@
+package vault
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsRedacted(t *testing.T) {
+	for field, expected := range map[string]bool{
+		"password":                    true,
+		"Bind_Password":               true,
+		"bindpass":                    true,
+		"secret_id":                   true,
+		"oidc_client_secret":          true,
+		"api_token":                   true,
+		"secret_key":                  true,
+		"token_policies":              false,
+		"token_ttl":                   false,
+		"audit_non_hmac_request_keys": false,
+		"username":                    false,
+	} {
+		if IsRedacted(field) != expected {
+			t.Errorf("Expected IsRedacted(%q) to be %t", field, expected)
+		}
+	}
+}
+
+func TestAddRedactFields(t *testing.T) {
+	defer func(fields []string) { redactFields = fields }(redactFields)
+	if err := AddRedactFields([]string{"*_pin"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !IsRedacted("duo_pin") || !IsRedacted("password") {
+		t.Errorf("Expected both the pattern and the defaults to be redacted, got %+v", redactFields)
+	}
+	if err := AddRedactFields([]string{"[pin"}); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	data := map[string]interface{}{
+		"username": "deploy",
+		"password": "hunter2",
+		"config":   map[string]interface{}{"bindpass": "hunter2", "url": "ldap://x"},
+		"clients":  []interface{}{map[string]interface{}{"client_secret": "hunter2"}},
+		"options":  map[string]string{"version": "2", "token": "hunter2"},
+	}
+	expected := map[string]interface{}{
+		"username": "deploy",
+		"password": RedactedValue,
+		"config":   map[string]interface{}{"bindpass": RedactedValue, "url": "ldap://x"},
+		"clients":  []interface{}{map[string]interface{}{"client_secret": RedactedValue}},
+		"options":  map[string]string{"version": "2", "token": RedactedValue},
+	}
+	if redacted := Redact(data); !reflect.DeepEqual(redacted, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, redacted)
+	}
+	if data["password"] != "hunter2" {
+		t.Errorf("Expected the data not to be changed, got %+v", data)
+	}
+}
+
+// What a dry run logs as the data it would write is redacted
+func TestDryClient_WriteRedacted(t *testing.T) {
+	var logged bytes.Buffer
+	defer func(out io.Writer) { log.SetOutput(out) }(log.StandardLogger().Out)
+	defer func(level log.Level) { log.SetLevel(level) }(log.GetLevel())
+	log.SetOutput(&logged)
+	log.SetLevel(log.DebugLevel)
+
+	c := NewReadOnlyClient(&MockClient{})
+	if _, err := c.Write("auth/userpass/users/deploy", map[string]interface{}{"password": "hunter2"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Contains(logged.String(), "hunter2") || !strings.Contains(logged.String(), RedactedValue) {
+		t.Errorf("Expected the password to be redacted, got:\n%s", logged.String())
+	}
+}
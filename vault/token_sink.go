@@ -0,0 +1,46 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Vault Agent's auto-auth writes its token to a sink file, replacing it whenever the token is
+// renewed or rotated. tokenSinkTransport reads the file before each request, so the token sent is
+// always the latest rather than the one read at the start of the run.
+type tokenSinkTransport struct {
+	next http.RoundTripper
+	path string
+}
+
+func (t *tokenSinkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(vaultTokenHeader) == "" {
+		// e.g. an unwrap, which must not send the client token
+		return t.next.RoundTrip(req)
+	}
+	token, err := readTokenSink(t.path)
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrippers must not modify the request they are given
+	req = cloneRequest(req)
+	req.Header.Set(vaultTokenHeader, token)
+	return t.next.RoundTrip(req)
+}
+
+const vaultTokenHeader = "X-Vault-Token"
+
+// The token in the sink file at path. Only plain sinks are supported, not wrapped or encrypted ones.
+func readTokenSink(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read token sink: %s", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("token sink %s is empty", path)
+	}
+	return token, nil
+}
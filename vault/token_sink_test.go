@@ -0,0 +1,71 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// A token rotated by Vault Agent is used from the next request on
+func TestTokenSink(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("X-Vault-Token"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data": {}}`)
+	}))
+	defer server.Close()
+
+	sink, err := ioutil.TempFile("", "vaultsmith-sink-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(sink.Name())
+	sink.Close()
+	if err := ioutil.WriteFile(sink.Name(), []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewVaultClientWithOptions(ClientOptions{
+		Address:   server.URL,
+		Token:     "root",
+		TokenSink: sink.Name(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	if err := client.Authenticate(""); err != nil {
+		t.Fatalf("Expected the token from the sink to be used, got %s", err)
+	}
+	if _, err := client.Read("secret/foo"); err != nil {
+		t.Fatalf("Error reading: %s", err)
+	}
+	if err := ioutil.WriteFile(sink.Name(), []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write("secret/foo", map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+
+	if expected := []string{"first", "second"}; !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected the tokens %+v to be sent, got %+v", expected, tokens)
+	}
+}
+
+func TestTokenSink_Empty(t *testing.T) {
+	sink, err := ioutil.TempFile("", "vaultsmith-sink-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(sink.Name())
+	sink.Close()
+
+	_, err = NewVaultClientWithOptions(ClientOptions{TokenSink: sink.Name()})
+	if err == nil {
+		t.Errorf("Expected an error for an empty token sink")
+	}
+}
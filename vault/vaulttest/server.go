@@ -0,0 +1,222 @@
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Server emulates the parts of the Vault HTTP API used by vaultsmith, so tests can run against
+	the real client (see Client) and assert on the requests it makes, which vault.MockClient can't
+	show. It is kept out of the vault package so that it isn't built into vaultsmith.
+
+	Only the sys/auth endpoints are implemented: listing, enabling and disabling auth methods, and
+	tuning them through sys/mounts/auth/<path>/tune, along with ACL policies under sys/policies/acl.
+	AuthMounts and Policies hold the state they act on. Any other request is recorded and answered
+	with a 404.
+*/
+type Server struct {
+	*httptest.Server
+	AuthMounts map[string]*vaultApi.AuthMount // keyed by path with a trailing slash, as Vault lists them
+	Policies   map[string]string              // ACL policy name -> rules
+	mu         sync.Mutex
+	requests   []Request
+}
+
+// A request received by the Server
+type Request struct {
+	Method string
+	Path   string                 // without the leading /v1/, e.g. "sys/auth/approle"
+	Body   map[string]interface{} // the decoded JSON body, if any
+}
+
+func NewServer() *Server {
+	s := &Server{
+		AuthMounts: map[string]*vaultApi.AuthMount{
+			"token/": {Type: "token", Description: "token based credentials"},
+		},
+		Policies: map[string]string{"default": "", "root": ""},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// A client using the real Vault API against this server, already authenticated
+func (s *Server) Client() (vault.Vault, error) {
+	return vault.NewVaultClientWithOptions(vault.ClientOptions{Address: s.URL, Token: "root"})
+}
+
+// Return the requests received, excluding reads, in the order they were made
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []Request
+	for _, r := range s.requests {
+		if r.Method != http.MethodGet {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := Request{Method: r.Method, Path: strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")}
+	if content, _ := ioutil.ReadAll(r.Body); len(content) > 0 {
+		if err := json.Unmarshal(content, &req.Body); err != nil {
+			writeErrors(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %s", err))
+			return
+		}
+	}
+	s.requests = append(s.requests, req)
+
+	switch {
+	case req.Method == http.MethodGet && req.Path == "sys/auth":
+		json.NewEncoder(w).Encode(s.AuthMounts)
+	case req.Method == http.MethodPost && strings.HasPrefix(req.Path, "sys/auth/"):
+		s.enableAuth(w, strings.TrimPrefix(req.Path, "sys/auth/")+"/", req.Body)
+	case req.Method == http.MethodDelete && strings.HasPrefix(req.Path, "sys/auth/"):
+		delete(s.AuthMounts, strings.TrimPrefix(req.Path, "sys/auth/")+"/")
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPost && strings.HasPrefix(req.Path, "sys/mounts/auth/") &&
+		strings.HasSuffix(req.Path, "/tune"):
+		path := strings.TrimSuffix(strings.TrimPrefix(req.Path, "sys/mounts/auth/"), "tune")
+		s.tuneAuth(w, path, req.Body)
+	case req.Path == "sys/policies/acl" && r.URL.Query().Get("list") == "true":
+		var names []string
+		for name := range s.Policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		writeData(w, map[string]interface{}{"keys": names})
+	case strings.HasPrefix(req.Path, "sys/policies/acl/"):
+		s.policy(w, strings.TrimPrefix(req.Path, "sys/policies/acl/"), req)
+	default:
+		writeErrors(w, http.StatusNotFound, fmt.Sprintf("no handler for route %q", req.Path))
+	}
+}
+
+func (s *Server) enableAuth(w http.ResponseWriter, path string, body map[string]interface{}) {
+	if _, ok := s.AuthMounts[path]; ok {
+		writeErrors(w, http.StatusBadRequest, fmt.Sprintf("path is already in use at %s", path))
+		return
+	}
+	var options vaultApi.EnableAuthOptions
+	content, _ := json.Marshal(body)
+	if err := json.Unmarshal(content, &options); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mount := &vaultApi.AuthMount{
+		Type:        options.Type,
+		Description: options.Description,
+		Local:       options.Local,
+		SealWrap:    options.SealWrap,
+		Options:     options.Options,
+	}
+	if err := tuneConfig(&mount.Config, body["config"]); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.AuthMounts[path] = mount
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) tuneAuth(w http.ResponseWriter, path string, body map[string]interface{}) {
+	mount, ok := s.AuthMounts[path]
+	if !ok {
+		writeErrors(w, http.StatusBadRequest, fmt.Sprintf("no mount at auth/%s", path))
+		return
+	}
+	if err := tuneConfig(&mount.Config, body); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if description, ok := body["description"].(string); ok {
+		mount.Description = description
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) policy(w http.ResponseWriter, name string, req Request) {
+	switch req.Method {
+	case http.MethodGet:
+		rules, ok := s.Policies[name]
+		if !ok {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		writeData(w, map[string]interface{}{"name": name, "policy": rules})
+	case http.MethodPut, http.MethodPost:
+		rules, ok := req.Body["policy"].(string)
+		if !ok {
+			writeErrors(w, http.StatusBadRequest, "'policy' parameter not supplied or empty")
+			return
+		}
+		s.Policies[name] = rules
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		delete(s.Policies, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+// Apply the TTLs, listing visibility and passthrough headers in the request config to the mount's
+// config
+func tuneConfig(config *vaultApi.AuthConfigOutput, input interface{}) error {
+	fields, _ := input.(map[string]interface{})
+	for key, target := range map[string]*int{
+		"default_lease_ttl": &config.DefaultLeaseTTL,
+		"max_lease_ttl":     &config.MaxLeaseTTL,
+	} {
+		value, ok := fields[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		secs, err := strconv.Atoi(value)
+		if err != nil {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			secs = int(d.Seconds())
+		}
+		*target = secs
+	}
+	if value, ok := fields["listing_visibility"].(string); ok {
+		config.ListingVisibility = value
+	}
+	if values, ok := fields["passthrough_request_headers"].([]interface{}); ok {
+		config.PassthroughRequestHeaders = nil
+		for _, v := range values {
+			config.PassthroughRequestHeaders = append(config.PassthroughRequestHeaders, fmt.Sprint(v))
+		}
+	}
+	return nil
+}
+
+// Write a response in the form of a secret with the given data
+func writeData(w http.ResponseWriter, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": errors})
+}
@@ -0,0 +1,64 @@
+package vaulttest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServer_ListAuth(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	mounts, err := client.ListAuth()
+	if err != nil {
+		t.Fatalf("Error listing auth: %s", err)
+	}
+	if mount, ok := mounts["token/"]; !ok || mount.Type != "token" {
+		t.Errorf("Expected token/ mount to be listed, got %+v", mounts)
+	}
+}
+
+// Requests for anything not emulated fail, rather than appearing to succeed
+func TestServer_UnknownRoute(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	_, err = client.Write("secret/foo", map[string]interface{}{"a": "b"})
+	if err == nil || !strings.Contains(err.Error(), "no handler for route") {
+		t.Errorf("Expected an error for an unknown route, got %v", err)
+	}
+	if requests := server.Requests(); len(requests) != 1 || requests[0].Path != "secret/foo" {
+		t.Errorf("Expected the request to be recorded, got %+v", requests)
+	}
+}
+
+// A Vault with sys/policies/acl has its ACL policies managed there
+func TestServer_Policies(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	if err := client.PutPolicy("read", `path "secret/*" {}`); err != nil {
+		t.Fatalf("Error writing policy: %s", err)
+	}
+	policy, err := client.GetPolicy("read")
+	if err != nil || policy != `path "secret/*" {}` {
+		t.Errorf("Expected the policy to be read back, got %q (err: %v)", policy, err)
+	}
+	for _, r := range server.Requests() {
+		if r.Path != "sys/policies/acl" && r.Path != "sys/policies/acl/read" {
+			t.Errorf("Expected only requests to sys/policies/acl, got %s %s", r.Method, r.Path)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Vault may succeed but return warnings, e.g. when a TTL is capped at the maximum. The api package
+// doesn't return them from most calls, so they're read from the responses here.
+type warningTransport struct {
+	next     http.RoundTripper
+	logger   *log.Entry
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (t *warningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.Body == nil || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var parsed struct {
+		Warnings []string `json:"warnings"`
+	}
+	if json.Unmarshal(body, &parsed) != nil || len(parsed.Warnings) == 0 {
+		return resp, nil
+	}
+	t.logger.WithFields(log.Fields{
+		"method":   req.Method,
+		"path":     req.URL.Path,
+		"warnings": parsed.Warnings,
+	}).Warn("Vault returned warnings")
+	t.mu.Lock()
+	t.warnings = append(t.warnings, parsed.Warnings...)
+	t.mu.Unlock()
+	return resp, nil
+}
+
+// Return the warnings received since the last call
+func (t *warningTransport) take() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	warnings := t.warnings
+	t.warnings = nil
+	return warnings
+}
+
+// Call fn, failing if Vault returned any warnings for it and ClientOptions.WarningsAsErrors is set.
+// A request Vault refuses returns a *ResponseError.
+func (c *writeClient) checkWarnings(fn func() error) error {
+	if c.warnings == nil {
+		return responseError(fn())
+	}
+	// anything returned earlier, e.g. when logging in, has already been logged
+	c.warnings.take()
+	err := responseError(fn())
+	warnings := c.warnings.take()
+	if err == nil && c.warningsAsErrors && len(warnings) > 0 {
+		return fmt.Errorf("Vault returned warnings: %s", strings.Join(warnings, "; "))
+	}
+	return err
+}
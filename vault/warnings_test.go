@@ -0,0 +1,65 @@
+package vault
+
+import (
+	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A Vault which succeeds, with a warning for every write
+func warningServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"data": {"ttl": "1h"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"warnings": ["TTL of \"48h\" exceeded the effective max_ttl of \"24h\"; TTL value is capped accordingly"]}`)
+	}))
+}
+
+func TestWarnings(t *testing.T) {
+	server := warningServer()
+	defer server.Close()
+
+	for _, strict := range []bool{false, true} {
+		client, err := NewVaultClientWithOptions(ClientOptions{
+			Address:          server.URL,
+			Token:            "root",
+			WarningsAsErrors: strict,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client: %s", err)
+		}
+
+		_, writeErr := client.Write("auth/approle/role/foo", map[string]interface{}{"token_ttl": "48h"})
+		tuneErr := client.TuneMount("secret", vaultApi.MountConfigInput{DefaultLeaseTTL: "48h"})
+		for name, err := range map[string]error{"Write": writeErr, "TuneMount": tuneErr} {
+			if !strict && err != nil {
+				t.Errorf("%s: expected warnings only to be logged, got %s", name, err)
+			}
+			if strict && (err == nil || !strings.Contains(err.Error(), "exceeded the effective max_ttl")) {
+				t.Errorf("%s: expected the warning as an error, got %v", name, err)
+			}
+		}
+
+		// the body is still read by the api package
+		secret, err := client.Read("auth/approle/role/foo")
+		if err != nil || secret == nil || secret.Data["ttl"] != "1h" {
+			t.Errorf("Expected the read to succeed, got %+v, %v", secret, err)
+		}
+	}
+}
+
+// Warnings returned before a write, e.g. when logging in, aren't blamed on it
+func TestWarnings_Earlier(t *testing.T) {
+	transport := &warningTransport{}
+	c := &writeClient{warnings: transport, warningsAsErrors: true}
+	transport.warnings = []string{"from the login"}
+	if err := c.checkWarnings(func() error { return nil }); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+}
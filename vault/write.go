@@ -1,6 +1,8 @@
 package vault
 
 import (
+	"encoding/json"
+	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 )
@@ -8,6 +10,10 @@ import (
 type writeClient struct {
 	logger *log.Entry
 	client *vaultApi.Client
+	// the warnings Vault returns; a write fails with them if warningsAsErrors
+	warnings         *warningTransport
+	warningsAsErrors bool
+	policies         *policyEndpoint
 }
 
 // Used by sysAuthHandler
@@ -17,7 +23,9 @@ func (c *writeClient) EnableAuth(path string, options *vaultApi.EnableAuthOption
 		"options": options,
 		"path":    path,
 	}).Debug()
-	return c.client.Sys().EnableAuthWithOptions(path, options)
+	return c.checkWarnings(func() error {
+		return c.client.Sys().EnableAuthWithOptions(path, options)
+	})
 }
 
 func (c *writeClient) DisableAuth(path string) error {
@@ -25,7 +33,76 @@ func (c *writeClient) DisableAuth(path string) error {
 		"action": "DisableAuth",
 		"path":   path,
 	}).Debug("Calling Vault API")
-	return c.client.Sys().DisableAuth(path)
+	return c.checkWarnings(func() error {
+		return c.client.Sys().DisableAuth(path)
+	})
+}
+
+// Used by sysMountsHandler
+func (c *writeClient) Mount(path string, input *vaultApi.MountInput) error {
+	c.logger.WithFields(log.Fields{
+		"action": "Mount",
+		"input":  input,
+		"path":   path,
+	}).Debug("Calling Vault API")
+	return c.checkWarnings(func() error {
+		return c.client.Sys().Mount(path, input)
+	})
+}
+
+// Used by sysAuthHandler and sysMountsHandler to tune existing mounts (path is prefixed with
+// "auth/" for auth mounts)
+func (c *writeClient) TuneMount(path string, config vaultApi.MountConfigInput) error {
+	c.logger.WithFields(log.Fields{
+		"action": "TuneMount",
+		"config": config,
+		"path":   path,
+	}).Debug("Calling Vault API")
+	return c.checkWarnings(func() error {
+		if config.PassthroughRequestHeaders == nil || len(config.PassthroughRequestHeaders) > 0 {
+			return c.client.Sys().TuneMount(path, config)
+		}
+		// an empty list clears the headers, but the api leaves it out of the request, so this is
+		// sent as the request TuneMount would make with the list added back
+		body, err := tuneBody(config)
+		if err != nil {
+			return err
+		}
+		body["passthrough_request_headers"] = []string{}
+		r := c.client.NewRequest("POST", "/v1/sys/mounts/"+path+"/tune")
+		if err := r.SetJSONBody(body); err != nil {
+			return err
+		}
+		resp, err := c.client.RawRequest(r)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	})
+}
+
+// The body of the tune request for config, as TuneMount sends it
+func tuneBody(config vaultApi.MountConfigInput) (map[string]interface{}, error) {
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode tune config: %s", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(content, &body); err != nil {
+		return nil, fmt.Errorf("could not encode tune config: %s", err)
+	}
+	return body, nil
+}
+
+// Used to roll back a mount made by sysMountsHandler
+func (c *writeClient) Unmount(path string) error {
+	c.logger.WithFields(log.Fields{
+		"action": "Unmount",
+		"path":   path,
+	}).Debug("Calling Vault API")
+	return c.checkWarnings(func() error {
+		return c.client.Sys().Unmount(path)
+	})
 }
 
 // Used by sysPolicyHandler
@@ -35,7 +112,13 @@ func (c *writeClient) PutPolicy(name string, data string) error {
 		"name":   name,
 		"data":   data,
 	}).Debug("Calling Vault API")
-	return c.client.Sys().PutPolicy(name, data)
+	return c.checkWarnings(func() error {
+		if c.policies.isLegacy() {
+			return c.client.Sys().PutPolicy(name, data)
+		}
+		_, err := c.client.Logical().Write(aclPolicyPath+name, map[string]interface{}{"policy": data})
+		return err
+	})
 }
 
 func (c *writeClient) DeletePolicy(name string) error {
@@ -43,7 +126,53 @@ func (c *writeClient) DeletePolicy(name string) error {
 		"action": "DeletePolicy",
 		"name":   name,
 	}).Debug("Calling Vault API")
-	return c.client.Sys().DeletePolicy(name)
+	return c.checkWarnings(func() error {
+		if c.policies.isLegacy() {
+			return c.client.Sys().DeletePolicy(name)
+		}
+		_, err := c.client.Logical().Delete(aclPolicyPath + name)
+		return err
+	})
+}
+
+// Used by pluginCatalogHandler
+func (c *writeClient) RegisterPlugin(pluginType string, input *vaultApi.RegisterPluginInput) error {
+	c.logger.WithFields(log.Fields{
+		"action": "RegisterPlugin",
+		"type":   pluginType,
+		"input":  input,
+	}).Debug("Calling Vault API")
+	return c.checkWarnings(func() error {
+		_, err := c.client.Logical().Write(pluginCatalogPath+pluginType+"/"+input.Name, map[string]interface{}{
+			"sha256":  input.SHA256,
+			"command": input.Command,
+			"args":    input.Args,
+		})
+		return err
+	})
+}
+
+func (c *writeClient) DeregisterPlugin(pluginType string, name string) error {
+	c.logger.WithFields(log.Fields{
+		"action": "DeregisterPlugin",
+		"type":   pluginType,
+		"name":   name,
+	}).Debug("Calling Vault API")
+	return c.checkWarnings(func() error {
+		_, err := c.client.Logical().Delete(pluginCatalogPath + pluginType + "/" + name)
+		return err
+	})
+}
+
+func (c *writeClient) ReloadPlugin(mounts []string) error {
+	c.logger.WithFields(log.Fields{
+		"action": "ReloadPlugin",
+		"mounts": mounts,
+	}).Debug("Calling Vault API")
+	return c.checkWarnings(func() error {
+		_, err := c.client.Logical().Write(pluginReloadPath, map[string]interface{}{"mounts": mounts})
+		return err
+	})
 }
 
 // Used by genericHandler
@@ -51,9 +180,14 @@ func (c *writeClient) Write(path string, data map[string]interface{}) (*vaultApi
 	c.logger.WithFields(log.Fields{
 		"action": "Write",
 		"path":   path,
-		"data":   data,
+		"data":   Redact(data),
 	}).Debug("Calling Vault API")
-	return c.client.Logical().Write(path, data)
+	var secret *vaultApi.Secret
+	err := c.checkWarnings(func() (err error) {
+		secret, err = c.client.Logical().Write(path, data)
+		return err
+	})
+	return secret, err
 }
 
 func (c *writeClient) Delete(path string) (*vaultApi.Secret, error) {
@@ -61,5 +195,10 @@ func (c *writeClient) Delete(path string) (*vaultApi.Secret, error) {
 		"action": "Delete",
 		"path":   path,
 	}).Debug("Calling Vault API")
-	return c.client.Logical().Delete(path)
+	var secret *vaultApi.Secret
+	err := c.checkWarnings(func() (err error) {
+		secret, err = c.client.Logical().Delete(path)
+		return err
+	})
+	return secret, err
 }
@@ -1,41 +1,157 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/starlingbank/vaultsmith/config"
-	"github.com/starlingbank/vaultsmith/document"
-	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/runner"
 	"github.com/starlingbank/vaultsmith/vault"
-	"io/ioutil"
-	"path/filepath"
+	"io"
 )
 
 var flags = flag.NewFlagSet("Vaultsmith", flag.ExitOnError)
-var documentPath string
+var documentPaths []string
+var source string
 var dry bool
 var templateFile string
 var vaultRole string
+var appRoleID string
+var vaultTokenSink string
+var appRoleWrappingTokenEnv string
+var authPath string
 var logLevel string
+var logFile string
+var logMaxSize int64
+var logMaxBackups int
+var logCompress bool
+var output string
+var noColor bool
 var templateParams []string
 var httpAuthToken string
 var tarDir string
 var noCleanUp bool
+var maxFileSize int64
+var allowRecreate bool
+var allowDeleteTransitKeys bool
+var resourcePath string
+var watch bool
+var continueOnError bool
+var reconcileInterval time.Duration
+var targets []string
+var handlerOrder []string
+var verifyWrites bool
+var lockPath string
+var lockTTL time.Duration
+var lockWait time.Duration
+var retries int
+var allowUnknownFields bool
+var postApplyURL string
+var postApplyCommand string
+var postApplyAlways bool
+var reportPath string
+var subtreeConfig string
+var subtreeStagger time.Duration
+var mergeTuning bool
+var maxChanges int
+var changelogPath string
+var checkpointPath string
+var stubMissingPolicies bool
+var reloadPlugins bool
+var ignoreResources []string
+var redactFields []string
+var exportPath string
+var since string
+var statePath string
+var vaultTimeout time.Duration
+var vaultMaxIdleConns int
+var vaultKeepAlive time.Duration
+var vaultHeaders []string
+var yes bool
+var disableAuthTypes []string
+var ownedAuthPaths []string
+var minTokenTTL time.Duration
+var skipPreflight bool
+var allowEmpty bool
+var explain bool
+var diff bool
+var runID string
+var shutdownGrace time.Duration
+var runTimeout time.Duration
+var planOutPath string
+var planPath string
+var streamTarball bool
+var warningsAsErrors bool
+var atomic bool
+var httpCACert string
+var httpInsecureSkipVerify bool
+var maxDownloadRate int64
+
+// how long --watch waits for changes to settle before applying them
+const watchDebounce = 500 * time.Millisecond
+
+// where the summary of changes is written at the end of a run
+var summaryOutput io.Writer = os.Stderr
+
+// how vaultsmith exits when a shutdown takes too long. Replaced in tests.
+var exit = os.Exit
+
+// where to read a single resource from when document-path is "-"
+var stdin io.Reader = os.Stdin
+
+// whether vaultsmith is being run by hand, so can ask for confirmation. Replaced in tests.
+var interactive = func() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
 func init() {
-	flags.StringVar(
+	flags.StringArrayVar(
 		// TODO: remove default value of "./example", could do bad things in production
-		&documentPath, "document-path", "",
+		&documentPaths, "document-path", nil,
 		"The root directory of the configuration. Can be a local directory, local gz "+
-			"tarball or http url to a gz tarball.",
+			"tarball, all-in-one .json file, http or file:// url to a gz tarball, "+
+			"gs://bucket/object or s3://bucket/key for one in Google Cloud Storage or S3, or "+
+			"a git+ssh:// or git+https:// git repository. Use \"-\" to read a single resource from "+
+			"stdin (see --resource-path). If given more than once, each is overlaid on those "+
+			"before it, replacing files at the same path. A comma is part of the path, as in a "+
+			"signed url, rather than separating two.",
+	)
+	flags.StringVar(
+		&source, "source", "", "The url of the documents, whose scheme selects where they are "+
+			"fetched from: file://, http(s)://, gs://, s3://, or git+ssh:// or git+https:// with "+
+			"the branch or tag as a #fragment. The same as a single --document-path, so can't be "+
+			"given with it.",
 	)
 	flags.StringVar(
 		&vaultRole, "role", "root", "The Vault role to authenticate as",
 	)
+	flags.StringVar(
+		&appRoleID, "approle-role-id", "", "Log in with this AppRole role_id rather than with "+
+			"AWS. The secret_id is unwrapped from the response-wrapping token in "+
+			"approle-wrapping-token-env, so is never passed to vaultsmith itself.",
+	)
+	flags.StringVar(
+		&appRoleWrappingTokenEnv, "approle-wrapping-token-env", "VAULTSMITH_WRAPPING_TOKEN",
+		"Environment variable holding the wrapping token for the AppRole secret_id.",
+	)
+	flags.StringVar(
+		&authPath, "auth-path", "", "The path the auth method vaultsmith logs in with is mounted "+
+			"at, if not the default of approle (with --approle-role-id) or aws, e.g. ci-approle to "+
+			"log in at auth/ci-approle/login.",
+	)
+	flags.StringVar(
+		&vaultTokenSink, "vault-token-sink", "", "Read the Vault token from this Vault Agent "+
+			"sink file before every request, so a token rotated by the agent is picked up. "+
+			"Overrides VAULT_TOKEN.",
+	)
 	flags.StringVar(
 		&templateFile, "template-file", "", "JSON file containing template "+
 			"mappings. If not specified, vaultsmith will look for \"_vaultsmith.json\" in the "+
@@ -48,6 +164,33 @@ func init() {
 		&logLevel, "log-level", "info", fmt.Sprintf("Log level, valid "+
 			"values are %+v", log.AllLevels),
 	)
+	flags.StringVar(
+		&output, "output", "pretty", "How log lines are written: pretty (coloured when writing to "+
+			"a terminal), plain (never coloured) or json (one JSON object per line, including the "+
+			"summary of changes).",
+	)
+	flags.StringVar(
+		&logFile, "log-file", "", "Write the log to this file rather than stderr. It is rotated as "+
+			"given by log-max-size, log-max-backups and log-compress.",
+	)
+	flags.Int64Var(
+		&logMaxSize, "log-max-size", 100, "Size in megabytes the log-file is rotated at. Not "+
+			"rotated if 0.",
+	)
+	flags.IntVar(
+		&logMaxBackups, "log-max-backups", 5, "How many rotated log files are kept, as "+
+			"<log-file>.1 (the newest) and so on. All are kept if 0.",
+	)
+	flags.BoolVar(
+		&logCompress, "log-compress", false, "Gzip rotated log files.",
+	)
+	flags.BoolVar(
+		&yes, "yes", false, "Don't ask for confirmation before disabling or deleting anything. "+
+			"Confirmation is only asked for when stdin is a terminal.",
+	)
+	flags.BoolVar(
+		&noColor, "no-color", false, "Don't colour the log lines; the same as --output plain",
+	)
 	flags.StringSliceVar(
 		&templateParams, "template-params", []string{}, "Template parameters. "+
 			"Applies globally, but values in template-file take precedence. E.G.: service=foo,account=bar",
@@ -56,6 +199,24 @@ func init() {
 		&httpAuthToken, "http-auth-token", "", "Auth token to pass as "+
 			"'Authorization' header. Useful for passing user tokens to private github repos.",
 	)
+	flags.StringVar(
+		&httpCACert, "http-ca-cert", "", "PEM file of the CA to verify an https document-path "+
+			"with, e.g. an internal artifact server. Vault is still verified as set by VAULT_CACERT.",
+	)
+	flags.BoolVar(
+		&httpInsecureSkipVerify, "http-insecure-skip-verify", false, "Don't verify the TLS "+
+			"certificate of an https document-path. Vault is still verified.",
+	)
+	flags.IntVar(
+		&maxChanges, "max-changes", 0, "Plan the run first, and refuse to apply it if it would "+
+			"make more than this many changes (enables, disables, writes, deletes...), e.g. to stop "+
+			"a bad document-path removing every mount. Unlimited if 0.",
+	)
+	flags.Int64Var(
+		&maxDownloadRate, "max-download-rate", 0, "Download an http(s) or gs:// document-path at "+
+			"no more than this many bytes a second, e.g. so as not to saturate a shared CI network. "+
+			"Unlimited if 0.",
+	)
 	flags.StringVar(
 		&tarDir, "tar-dir", "", "Directory within the tarball to use as the "+
 			"document-path. If not specified, and there is only one directory within the archive, "+
@@ -63,7 +224,242 @@ func init() {
 			"archive will be used.",
 	)
 	flags.BoolVar(
-		&noCleanUp, "no-cleanup", false, "Don't clean up temp directory on exit",
+		&noCleanUp, "keep-work-dir", false, "Keep the temporary directory documents are "+
+			"downloaded and extracted to, and log where it is, e.g. to debug a failed run.",
+	)
+	flags.BoolVar(&noCleanUp, "no-cleanup", false, "Same as keep-work-dir")
+	flags.MarkDeprecated("no-cleanup", "use --keep-work-dir instead")
+	flags.Int64Var(
+		&maxFileSize, "max-file-size", 10*1024*1024, "Maximum size in bytes of a single "+
+			"document. Larger files abort the run. Set to 0 to disable the limit.",
+	)
+	flags.BoolVar(
+		&mergeTuning, "merge-tuning", false, "Tune auth methods and mounts by reading their live "+
+			"config and changing only the fields set in their document, leaving the rest as "+
+			"they are, e.g. when another process manages some of a mount's tuning.",
+	)
+	flags.DurationVar(
+		&minTokenTTL, "min-token-ttl", runner.DefaultMinTokenTTL, "Fail before applying anything "+
+			"if the Vault token expires sooner than this.",
+	)
+	flags.StringVar(
+		&resourcePath, "resource-path", "", "The path of the resource read from stdin when "+
+			"document-path is \"-\", e.g. sys/auth/approle",
+	)
+	flags.StringSliceVar(
+		&disableAuthTypes, "disable-auth-types", []string{}, "Only disable undeclared auth "+
+			"mounts of these types, e.g. userpass,approle. Others are left enabled with a warning. "+
+			"All types may be disabled if not given.",
+	)
+	flags.StringSliceVar(
+		&ownedAuthPaths, "owned-auth-paths", []string{}, "Only disable undeclared auth mounts "+
+			"whose path is, or is under, one of these, e.g. approle,team-a (which owns "+
+			"team-a/approle but not team-ab). Others are left alone, for another configuration "+
+			"to manage.",
+	)
+	flags.StringSliceVar(
+		&ignoreResources, "ignore", []string{}, "Never change, remove or report drift in "+
+			"resources whose path matches one of these globs, e.g. sys/auth/legacy-*,sys/policy/ops-*, "+
+			"or is under one which does, even if they are declared. For resources managed outside "+
+			"vaultsmith.",
+	)
+	flags.BoolVar(
+		&allowEmpty, "allow-empty", false, "Apply a document-path with no documents, or only "+
+			"empty directories. Everything vaultsmith manages is removed from Vault.",
+	)
+	flags.BoolVar(
+		&allowDeleteTransitKeys, "allow-delete-transit-keys", false, "Delete transit keys which "+
+			"aren't declared under transit/keys. EVERYTHING ENCRYPTED WITH THEM CAN NO LONGER BE "+
+			"DECRYPTED. By default they are skipped.",
+	)
+	flags.BoolVar(
+		&allowRecreate, "allow-recreate", false, "Allow auth mounts whose type, local or seal_wrap "+
+			"setting has changed to be disabled and enabled again. ALL DATA AND LEASES UNDER THE MOUNT WILL BE LOST.",
+	)
+	flags.BoolVar(
+		&allowUnknownFields, "allow-unknown-fields", false, "Don't fail on fields in documents "+
+			"which aren't part of the resource, such as annotations. By default these are errors, "+
+			"as they are usually misspelled keys. Known fields are still checked.",
+	)
+	flags.BoolVar(
+		&continueOnError, "continue-on-error", false, "Carry on applying the remaining "+
+			"documents when a change fails. Failures are listed in the summary, and the exit code "+
+			"is still non-zero.",
+	)
+	flags.BoolVar(
+		&atomic, "atomic", false, "If a change fails, undo those already made in the run: disable "+
+			"the auth methods and mounts it enabled, and put back the tuning, policies and "+
+			"documents it changed. Best effort; changes which can't be undone are listed in the summary.",
+	)
+	flags.StringVar(
+		&lockPath, "lock-path", "", "KV version 2 path used as a lock so that only one "+
+			"vaultsmith run can apply at a time, e.g. secret/data/vaultsmith/lock. Not used in dry runs.",
+	)
+	flags.DurationVar(
+		&lockTTL, "lock-ttl", 15*time.Minute, "How long the lock is valid for, in case a run "+
+			"dies without releasing it",
+	)
+	flags.DurationVar(
+		&lockWait, "lock-wait", 0, "How long to wait for another run to release the lock. "+
+			"By default, fail straight away.",
+	)
+	flags.IntVar(
+		&retries, "retries", 3, "How many times to retry reading the live configuration from "+
+			"Vault when it fails, e.g. the enabled auth methods",
+	)
+	flags.BoolVar(
+		&explain, "explain", false, "Log every field which differs from Vault, with the "+
+			"configured and live values, to show why a resource is changed. Best with --dry.",
+	)
+	flags.StringSliceVar(
+		&redactFields, "redact-fields", []string{}, "Also redact the values of fields matching "+
+			"these patterns (e.g. *_pin) in logs, --explain and --diff, as well as passwords, "+
+			"secrets, tokens, keys and credentials. Patterns are matched against field names "+
+			"regardless of case, with * for any characters.",
+	)
+	flags.BoolVar(
+		&diff, "diff", false, "After the summary, show each resource which is changed as a "+
+			"unified diff of its JSON in Vault and in the documents. Best with --dry.",
+	)
+	flags.StringVar(
+		&exportPath, "export", "", "Instead of applying anything, write the auth methods, mounts "+
+			"and policies currently in Vault to this directory, in the layout used by document-path",
+	)
+	flags.StringVar(
+		&planPath, "plan", "", "Apply the plan saved by --plan-out. Nothing is changed unless the "+
+			"documents and Vault are as they were when it was made, so the changes are exactly those "+
+			"planned. Destructive changes aren't confirmed again.",
+	)
+	flags.StringVar(
+		&planOutPath, "plan-out", "", "Save the plan of a dry run to this file, to apply later with "+
+			"--plan. Must be given with --dry.",
+	)
+	flags.StringVar(
+		&postApplyURL, "post-apply-url", "", "URL to POST the result of the run to as JSON, "+
+			"once the documents have been applied",
+	)
+	flags.StringVar(
+		&postApplyCommand, "post-apply-command", "", "Shell command to run once the documents "+
+			"have been applied, with the JSON result of the run on stdin",
+	)
+	flags.BoolVar(
+		&postApplyAlways, "post-apply-always", false, "Run the post-apply webhook and command "+
+			"even if the run failed. By default they only run on success.",
+	)
+	flags.BoolVar(
+		&reloadPlugins, "reload-plugins", false, "When a plugin in sys/plugins/catalog is "+
+			"registered again, e.g. with a new sha256, reload the auth methods and mounts using it "+
+			"so they run the new binary. Database plugins aren't reloaded.",
+	)
+	flags.StringVar(
+		&reportPath, "report-file", "", "Write a JSON report of the run to this file once it "+
+			"finishes, whether it succeeded or not: the changes made, counts of each action and "+
+			"status, and how long it and each Vault operation took",
+	)
+	flags.StringVar(
+		&changelogPath, "changelog-file", "", "Append an entry to this file after each run, with "+
+			"when it ran, its run ID and the changes it made, as a readable history of what "+
+			"vaultsmith changed. Dry runs aren't recorded.",
+	)
+	flags.StringVar(
+		&checkpointPath, "checkpoint-file", "", "Record each resource applied in this file as "+
+			"the run goes. If the run is interrupted or fails, the next run of the same documents "+
+			"skips those already applied rather than reading them again. It is removed once a run "+
+			"finishes.",
+	)
+	flags.DurationVar(
+		&reconcileInterval, "reconcile-interval", 0, "Keep running, fetching and applying "+
+			"the documents this often (e.g. 5m). SIGHUP starts a run straight away. If not set, "+
+			"documents are applied once.",
+	)
+	flags.StringSliceVar(
+		&targets, "target", []string{}, "Only apply these handlers, leaving everything else "+
+			"(including removal of undeclared resources) untouched. Valid values are auth, config, "+
+			"generic, jwt, ldap, mfa, mounts, plugins, policy, quotas and transit. E.G.: --target policy",
+	)
+	flags.StringSliceVar(
+		&handlerOrder, "handler-order", []string{}, "Run a handler in a different order, as "+
+			"target=order with the names of --target, e.g. --handler-order mounts=8 to mount "+
+			"secrets engines before enabling auth methods (auth is 10). Lower runs earlier, except "+
+			"0, which runs last with jwt, ldap and the generic handler.",
+	)
+	flags.StringVar(
+		&since, "since", "", "Only apply the directories containing files changed since this git "+
+			"ref (e.g. origin/master). document-path must be in a git checkout.",
+	)
+	flags.StringVar(
+		&runID, "run-id", "", "ID sent to Vault with every request, as the "+vault.RunIDHeader+
+			" header, e.g. the CI job. A random UUID if not given.",
+	)
+	flags.DurationVar(
+		&shutdownGrace, "shutdown-grace", 30*time.Second, "On SIGINT or SIGTERM, how long to "+
+			"wait for the resource being applied to finish before exiting. No further resource is "+
+			"started.",
+	)
+	flags.DurationVar(
+		&runTimeout, "timeout", 0, "Stop the run if it hasn't finished within this long, e.g. "+
+			"10m, exiting with code 124. No further resource is started, and the one being applied "+
+			"has --shutdown-grace to finish. Unlimited if 0.",
+	)
+	flags.BoolVar(
+		&skipPreflight, "skip-preflight", false, "Don't check that Vault is initialized, unsealed "+
+			"and reachable, and that the token is valid, before applying anything.",
+	)
+	flags.StringVar(
+		&statePath, "state-file", "", "Record the live configuration of auth methods, mounts and "+
+			"policies in this file after each run, and warn at the start of the next about anything "+
+			"changed outside vaultsmith since.",
+	)
+	flags.BoolVar(
+		&streamTarball, "stream-tarball", false, "Extract an http(s) or gs document-path as it is "+
+			"downloaded, without saving the archive to disk first. Halves the disk space needed for "+
+			"a large tarball.",
+	)
+	flags.BoolVar(
+		&stubMissingPolicies, "stub-missing-policies", false, "Before writing a document which "+
+			"grants policies (policies or token_policies), create an empty policy for each one not "+
+			"yet in Vault, e.g. when the handler order applies roles first. A policy declared under "+
+			"sys/policy replaces its stub when it is applied.",
+	)
+	flags.StringVar(
+		&subtreeConfig, "subtree-config", "", "JSON file mapping document subtrees (e.g. "+
+			"secret/dr) to the address of the Vault they are applied to, and the environment "+
+			"variable holding its token. Everything else is applied to VAULT_ADDR.",
+	)
+	flags.DurationVar(
+		&subtreeStagger, "subtree-stagger", 0, "Wait a random time between half this and this "+
+			"(e.g. 30s) before applying each subtree in subtree-config to its Vault, so they "+
+			"aren't all hit at once. By default they are applied straight after each other.",
+	)
+	flags.StringArrayVar(
+		&vaultHeaders, "vault-header", []string{}, "Header to send with every request to Vault, "+
+			"as Name=value, e.g. for a gateway in front of it. May be given more than once. Not "+
+			"sent to the Vaults in subtree-config.",
+	)
+	flags.DurationVar(
+		&vaultKeepAlive, "vault-keep-alive", vault.DefaultKeepAlive, "Interval between TCP "+
+			"keep-alives on connections to Vault.",
+	)
+	flags.IntVar(
+		&vaultMaxIdleConns, "vault-max-idle-conns", vault.DefaultMaxIdleConns, "How many idle "+
+			"connections to Vault are kept open for reuse.",
+	)
+	flags.DurationVar(
+		&vaultTimeout, "vault-timeout", 0, "How long each request to Vault may take. Defaults to "+
+			"VAULT_CLIENT_TIMEOUT if set, otherwise 60s.",
+	)
+	flags.BoolVar(
+		&verifyWrites, "verify-writes", false, "Read each auth method and secrets mount back from "+
+			"Vault after enabling or tuning it, failing if it doesn't match its document. This "+
+			"catches changes Vault accepts but doesn't keep, at the cost of a list per change.",
+	)
+	flags.BoolVar(
+		&watch, "watch", false, "Keep running and re-apply documents as they change. "+
+			"document-path must be a local directory.",
+	)
+	flags.BoolVar(
+		&warningsAsErrors, "warnings-as-errors", false, "Fail a change when Vault returns "+
+			"warnings for it, e.g. that a TTL was capped at the maximum. Warnings are always logged.",
 	)
 
 	flags.Usage = func() {
@@ -75,12 +471,17 @@ func init() {
 			"• Vault authentication is handled by environment variables (the same " +
 			"ones as the Vault client, as vaultsmith uses the same code). So ensure VAULT_ADDR " +
 			"and VAULT_TOKEN are set.\n" +
+			"• Every flag can also be set with an environment variable named VAULTSMITH_ and the " +
+			"flag in upper case, with underscores, e.g. VAULTSMITH_DOCUMENT_PATH. A flag given on " +
+			"the command line takes precedence.\n" +
 			"• Files that start with an underscore (e.g. _vaultsmith.json) are not published to " +
 			"vault.\n" +
 			"• If template-file is not specified, it is not mandatory for _vaultsmith.json to be " +
 			"present.\n" +
 			"• Specifying a parameter with --template-params allows only a single value. If you " +
-			"need multiple values, please use a template-file." +
+			"need multiple values, please use a template-file.\n" +
+			"• Run `vaultsmith doctor` with the same flags to check Vault, logging in and the " +
+			"documents, with a hint for each check which fails. Nothing is changed." +
 			"\n\n")
 	}
 
@@ -97,6 +498,36 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = flagsFromEnv(flags, os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Prefix of the environment variables which set flags
+const envPrefix = "VAULTSMITH_"
+
+// The environment variable setting the flag name, e.g. VAULTSMITH_DOCUMENT_PATH for document-path
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Set each flag which wasn't given on the command line from its environment variable, if set, so
+// that flags take precedence over the environment, and it over the defaults
+func flagsFromEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Changed || f.Deprecated != "" {
+			return
+		}
+		name := flagEnvName(f.Name)
+		if value, ok := lookupEnv(name); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %s", value, name, setErr)
+			}
+		}
+	})
+	return err
 }
 
 func main() {
@@ -105,13 +536,72 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if logFile != "" {
+		f, err := openLogFile(logFile, logMaxSize*1024*1024, logMaxBackups, logCompress)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.SetOutput(f)
+	}
 	log.SetLevel(ll)
+	if noColor && output == "pretty" {
+		output = "plain"
+	}
+	formatter, err := logFormatter(output)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.SetFormatter(formatter)
 
+	if flags.NArg() > 0 && flags.Arg(0) != "doctor" {
+		log.Fatalf("Unknown command %q, the only command is doctor", flags.Arg(0))
+	}
+	if planOutPath != "" {
+		if planPath != "" {
+			log.Fatalln("--plan-out makes a plan, so can't be given with --plan")
+		}
+		if !dry {
+			log.Fatalln("--plan-out only saves the plan of a dry run, so must be given with --dry")
+		}
+	}
+	if atomic && (continueOnError || watch) {
+		log.Fatalln("--atomic can't be given with --continue-on-error or --watch")
+	}
+	if runTimeout > 0 && (watch || reconcileInterval > 0) {
+		log.Fatalln("--timeout limits a single run, so can't be given with --watch or --reconcile-interval")
+	}
+	if vaultTokenSink != "" && appRoleID != "" {
+		log.Fatalln("--vault-token-sink gives the token, so can't be given with --approle-role-id")
+	}
+	if err := vault.AddRedactFields(redactFields); err != nil {
+		log.Fatalf("Invalid --redact-fields: %s", err)
+	}
 	if dry {
 		log.Info("Dry mode enabled, no changes will be made")
 	}
-	if documentPath == "" {
-		log.Fatalln("Please specify --document-path")
+	if source != "" {
+		if len(documentPaths) > 0 {
+			log.Fatalln("--source gives the documents, so can't be given with --document-path")
+		}
+		documentPaths = []string{source}
+	}
+	if len(documentPaths) == 0 && exportPath == "" {
+		log.Fatalln("Please specify --document-path or --source")
+	}
+	var documentPath string
+	var overlayPaths []string
+	if len(documentPaths) > 0 {
+		documentPath, overlayPaths = documentPaths[0], documentPaths[1:]
+	}
+	if len(overlayPaths) > 0 {
+		if watch || since != "" {
+			log.Fatalln("Only one --document-path can be given with --watch or --since")
+		}
+		for _, p := range documentPaths {
+			if p == "-" {
+				log.Fatalln("A document can't be read from stdin with more than one --document-path")
+			}
+		}
 	}
 	// Only check if specified, otherwise no template file is OK
 	if templateFile != "" {
@@ -121,78 +611,357 @@ func main() {
 	}
 
 	conf := config.VaultsmithConfig{
-		DocumentPath:   documentPath,
-		VaultRole:      vaultRole,
-		TemplateFile:   templateFile,
-		Dry:            dry,
-		TemplateParams: templateParams,
-		HttpAuthToken:  httpAuthToken,
-		TarDir:         tarDir,
+		DocumentPath:       documentPath,
+		OverlayPaths:       overlayPaths,
+		VaultRole:          vaultRole,
+		TemplateFile:       templateFile,
+		Dry:                dry,
+		TemplateParams:     templateParams,
+		HttpAuthToken:      httpAuthToken,
+		TarDir:             tarDir,
+		MaxFileSize:        maxFileSize,
+		AllowRecreate:      allowRecreate,
+		ResourcePath:       resourcePath,
+		NoCleanUp:          noCleanUp,
+		Watch:              watch,
+		ContinueOnError:    continueOnError,
+		ReconcileInterval:  reconcileInterval,
+		Targets:            targets,
+		LockPath:           lockPath,
+		LockTTL:            lockTTL,
+		LockWait:           lockWait,
+		Retries:            retries,
+		AllowUnknownFields: allowUnknownFields,
+		PostApplyURL:       postApplyURL,
+		PostApplyCommand:   postApplyCommand,
+		PostApplyAlways:    postApplyAlways,
+		ExportPath:         exportPath,
+		Since:              since,
+		StatePath:          statePath,
+		VaultTimeout:       vaultTimeout,
+		VaultMaxIdleConns:  vaultMaxIdleConns,
+		VaultKeepAlive:     vaultKeepAlive,
+		DisableAuthTypes:   disableAuthTypes,
+		MinTokenTTL:        minTokenTTL,
+		AllowEmpty:         allowEmpty,
+		Explain:            explain,
+		RunID:              runID,
+		PlanOutPath:        planOutPath,
+		PlanPath:           planPath,
+		StreamTarball:      streamTarball,
+		WarningsAsErrors:   warningsAsErrors,
+		Atomic:             atomic,
+		HttpCACert:         httpCACert,
+		// only for the documents, not VAULT_SKIP_VERIFY
+		HttpInsecureSkipVerify: httpInsecureSkipVerify,
+		OwnedAuthPaths:         ownedAuthPaths,
+		VaultTokenSink:         vaultTokenSink,
+		Diff:                   diff,
+		VerifyWrites:           verifyWrites,
+		AllowDeleteTransitKeys: allowDeleteTransitKeys,
+		RunTimeout:             runTimeout,
+		MaxDownloadRate:        maxDownloadRate,
+		AuthPath:               authPath,
+		ReportPath:             reportPath,
+		SubtreeStagger:         subtreeStagger,
+		MergeTuning:            mergeTuning,
+		MaxChanges:             maxChanges,
+		ChangelogPath:          changelogPath,
+		CheckpointPath:         checkpointPath,
+		StubMissingPolicies:    stubMissingPolicies,
+		ReloadPlugins:          reloadPlugins,
+		IgnoreResources:        ignoreResources,
+	}
+	if conf.RunID == "" {
+		conf.RunID, err = vault.NewRunID()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.WithField("run_id", conf.RunID).Info("Starting run")
+	conf.ConfirmInput = confirmInput(conf)
+	if appRoleID != "" {
+		conf.AppRoleID = appRoleID
+		conf.AppRoleWrappingToken = os.Getenv(appRoleWrappingTokenEnv)
+	}
+	conf.VaultHeaders, err = config.ParseHeaders(vaultHeaders)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.HandlerOrder, err = config.ParseHandlerOrder(handlerOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if subtreeConfig != "" {
+		conf.Subtrees, err = config.LoadSubtrees(subtreeConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	var client vault.Vault
-	client, err = vault.NewVaultClient(conf.Dry)
+	client, err = vault.NewVaultClientWithOptions(vault.ClientOptions{
+		ReadOnly:     conf.Dry,
+		Timeout:      conf.VaultTimeout,
+		MaxIdleConns: conf.VaultMaxIdleConns,
+		KeepAlive:    conf.VaultKeepAlive,
+		Headers:      conf.VaultHeaders,
+		// the wrapped secret_id can only be unwrapped once, so subtrees still use their own tokens
+		AppRoleID:            conf.AppRoleID,
+		AppRoleWrappingToken: conf.AppRoleWrappingToken,
+		AuthPath:             conf.AuthPath,
+		RunID:                conf.RunID,
+		WarningsAsErrors:     conf.WarningsAsErrors,
+		TokenSink:            conf.VaultTokenSink,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if flags.Arg(0) == "doctor" {
+		// which includes the preflight checks
+		if !runner.RenderChecks(summaryOutput, runner.Doctor(client, conf)) {
+			exit(1)
+		}
+		return
+	}
+	if !skipPreflight {
+		err = runner.Preflight(client, conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	err = Run(client, conf)
+	if _, ok := err.(timeoutError); ok {
+		log.Errorf("Error: %s", err)
+		exit(timeoutExitCode)
+	}
 	if err != nil {
 		log.Fatalf("Error: %s", err)
 	}
 	log.Debugf("Success")
 }
 
-func whichFileExists(filePath ...string) (file string) {
-	for _, f := range filePath {
-		if _, err := os.Stat(f); !os.IsNotExist(err) {
-			log.WithFields(log.Fields{"file": f}).Debug("file exists")
-			return f
+// Run vaultsmith against c with the given config. This is a thin wrapper around the runner package.
+func Run(c vault.Vault, config config.VaultsmithConfig) error {
+	if config.ExportPath != "" {
+		return runner.Export(c, config, config.ExportPath)
+	}
+	if config.ReconcileInterval > 0 {
+		return reconcile(c, config)
+	}
+	if config.Watch {
+		ctx := context.Background()
+		events, err := runner.WatchDir(ctx, config.DocumentPath)
+		if err != nil {
+			return err
+		}
+		return runner.Watch(ctx, c, config, events, watchDebounce)
+	}
+	if config.DocumentPath == "-" {
+		return runner.ApplyResource(context.Background(), c, config, stdin)
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	if config.RunTimeout > 0 {
+		var cancel func()
+		ctx, cancel = withTimeout(ctx, config.RunTimeout)
+		defer cancel()
+	}
+	result, err := runner.Apply(ctx, c, config)
+	if result.Summary != nil && len(result.Summary.Rows) > 0 {
+		if output == "json" {
+			// as log lines, so all output can be parsed the same way
+			for _, row := range result.Summary.Rows {
+				log.WithFields(log.Fields{
+					"resource": row.Resource,
+					"action":   row.Action,
+					"status":   row.Status,
+					"error":    row.Error,
+				}).Info("Summary")
+			}
+			for _, d := range result.Summary.Diffs {
+				log.WithFields(log.Fields{"resource": d.Resource, "diff": d.Diff}).Info("Diff")
+			}
 		} else {
-			log.WithFields(log.Fields{"file": f}).Debug("file does not exist")
+			// printed last, so it isn't lost among the log lines
+			fmt.Fprintln(summaryOutput)
+			if config.Dry {
+				fmt.Fprintln(summaryOutput, "Dry run, nothing was changed. The plan is:")
+			}
+			result.Summary.Render(summaryOutput)
+			if len(result.Summary.Diffs) > 0 {
+				fmt.Fprintln(summaryOutput)
+				result.Summary.RenderDiffs(summaryOutput)
+			}
 		}
 	}
-	return ""
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return timeoutError{config.RunTimeout}
+	}
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("interrupted before all resources were applied")
+	}
+	return err
 }
 
-func Run(c vault.Vault, config config.VaultsmithConfig) error {
-	err := c.Authenticate(config.VaultRole)
-	if err != nil {
-		return fmt.Errorf("failed authenticating with Vault: %s", err)
+// Returned when the run didn't finish within --timeout
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s before all resources were applied", e.timeout)
+}
+
+// The exit code when the run times out, as for timeout(1)
+const timeoutExitCode = 124
+
+// A context which is done after timeout, so the run stops once the resource being applied is
+// finished. If that takes longer than shutdownGrace, vaultsmith exits with timeoutExitCode, much as
+// interruptible does. Call cancel once the run is done; it returns once the timeout is no longer watched.
+func withTimeout(parent context.Context, timeout time.Duration) (ctx context.Context, cancel func()) {
+	ctx, cancelCtx := context.WithTimeout(parent, timeout)
+	grace := shutdownGrace
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				return
+			}
+			log.Warnf("Timed out after %s, stopping once the current resource is applied", timeout)
+		case <-done:
+			return
+		}
+		select {
+		case <-time.After(grace):
+			log.Errorf("The current resource was not applied within %s of timing out, exiting", grace)
+		case <-done:
+			return
+		}
+		exit(timeoutExitCode)
+	}()
+	return ctx, func() {
+		close(done)
+		<-finished
+		cancelCtx()
 	}
+}
 
-	workDir, err := ioutil.TempDir(os.TempDir(), "vaultsmith-")
-	if err != nil {
-		return fmt.Errorf("could not create temp directory: %s", err)
+// Where confirmation of destructive changes is read from, or nil if it isn't asked for. Only a
+// single run by hand is confirmed, as the long running modes re-apply unattended.
+func confirmInput(conf config.VaultsmithConfig) io.Reader {
+	if yes || !interactive() || conf.Dry || conf.Watch || conf.ReconcileInterval > 0 ||
+		conf.ExportPath != "" || conf.DocumentPath == "-" {
+		return nil
 	}
-	defer os.Remove(workDir)
+	return stdin
+}
 
-	docSet, err := document.GetSet(workDir, config)
-	if err != nil {
-		return err
+// The log formatter for an --output mode
+func logFormatter(mode string) (log.Formatter, error) {
+	switch mode {
+	case "pretty":
+		return componentFormatter{&log.TextFormatter{FullTimestamp: true}}, nil
+	case "plain":
+		return componentFormatter{&log.TextFormatter{FullTimestamp: true, DisableColors: true}}, nil
+	case "json":
+		// the component stays a field, which is as easy to filter on
+		return &log.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output %q, valid values are pretty, plain and json", mode)
 	}
-	err = docSet.Get()
-	if err != nil {
-		return err
+}
+
+// Puts the component field of a log line, e.g. of the sys/auth handler, in front of its message as
+// "[sys/auth] ", so the lines of each handler can be picked out with grep
+type componentFormatter struct {
+	log.Formatter
+}
+
+func (f componentFormatter) Format(entry *log.Entry) ([]byte, error) {
+	component, ok := entry.Data["component"]
+	if !ok {
+		return f.Formatter.Format(entry)
 	}
-	if !noCleanUp {
-		defer docSet.CleanUp()
+	prefixed := *entry
+	prefixed.Data = make(log.Fields, len(entry.Data)-1)
+	for k, v := range entry.Data {
+		if k != "component" {
+			prefixed.Data[k] = v
+		}
 	}
+	prefixed.Message = fmt.Sprintf("[%s] %s", component, entry.Message)
+	return f.Formatter.Format(&prefixed)
+}
 
-	docPath, err := docSet.Path()
-	if err != nil {
-		return err
+// A context cancelled on SIGINT or SIGTERM, so the run stops once the resource being applied is
+// finished and the summary shows what was. If that takes longer than shutdownGrace, or another
+// signal is received, vaultsmith exits straight away. Call stop once the run is done.
+func interruptible() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	grace := shutdownGrace
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case s := <-signals:
+			log.Warnf("Received %s, stopping once the current resource is applied", s)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case s := <-signals:
+			log.Errorf("Received %s again, exiting", s)
+		case <-time.After(grace):
+			log.Errorf("The current resource was not applied within %s, exiting", grace)
+		case <-done:
+			return
+		}
+		exit(1)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		<-finished
+		cancel()
 	}
+}
 
-	// Determine if we have a template file
-	config.TemplateFile = whichFileExists(
-		templateFile,
-		filepath.Join(docPath, "_vaultsmith.json"),
-	)
+// Run the reconcile loop until interrupted, starting a cycle early on SIGHUP
+func reconcile(c vault.Vault, config config.VaultsmithConfig) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	cw, err := internal.NewConfigWalker(c, config, docPath)
-	if err != nil {
-		return err
-	}
-	return cw.Run()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	trigger := make(chan struct{}, 1)
+	go func() {
+		for s := range signals {
+			if s != syscall.SIGHUP {
+				log.Infof("Received %s, stopping after the current run", s)
+				cancel()
+				return
+			}
+			select {
+			case trigger <- struct{}{}:
+			default: // a run is already pending
+			}
+		}
+	}()
+
+	return runner.Reconcile(ctx, c, config, runner.ReconcileOptions{
+		Interval: config.ReconcileInterval,
+		Trigger:  trigger,
+	})
 }
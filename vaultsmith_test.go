@@ -1,12 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
 	"github.com/starlingbank/vaultsmith/config"
 	"github.com/starlingbank/vaultsmith/vault"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestRunWhenVaultNotListening(t *testing.T) {
@@ -49,3 +61,316 @@ func TestRunWhenRoleIsInvalid(t *testing.T) {
 		t.Errorf("bad reason message '%s'", err.Error())
 	}
 }
+
+func TestRunReadsSingleResourceFromStdin(t *testing.T) {
+	stdin = bytes.NewBufferString(`{"type": "userpass"}`)
+	defer func() { stdin = os.Stdin }()
+
+	conf := config.VaultsmithConfig{
+		VaultRole:    "ValidRole",
+		DocumentPath: "-",
+		ResourcePath: "sys/auth/userpass",
+	}
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", conf.VaultRole)
+
+	err := Run(mockClient, conf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	calls := mockClient.CallsTo("EnableAuth")
+	if len(calls) != 1 || calls[0].Args[0] != "userpass/" {
+		t.Errorf("Expected a single EnableAuth call for userpass/, got %+v", calls)
+	}
+}
+
+// apply a document directory enabling userpass with the given --output, returning the log lines
+func applyWithOutput(t *testing.T, mode string) []string {
+	docPath, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(docPath)
+	os.MkdirAll(filepath.Join(docPath, "sys", "auth"), 0755)
+	err = ioutil.WriteFile(filepath.Join(docPath, "sys", "auth", "userpass.json"), []byte(`{"type": "userpass"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formatter, err := logFormatter(mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	log.SetFormatter(formatter)
+	log.SetOutput(&logs)
+	defer log.SetFormatter(&log.TextFormatter{})
+	defer log.SetOutput(os.Stderr)
+	defer func(o string) { output = o }(output)
+	output = mode
+	defer func(w io.Writer) { summaryOutput = w }(summaryOutput)
+	summaryOutput = &logs
+
+	conf := config.VaultsmithConfig{VaultRole: "ValidRole", DocumentPath: docPath}
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", conf.VaultRole)
+	if err := Run(mockClient, conf); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	return strings.Split(strings.TrimSpace(logs.String()), "\n")
+}
+
+func TestRunJsonOutput(t *testing.T) {
+	var summarised bool
+	for _, line := range applyWithOutput(t, "json") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Expected a JSON object per line, got %q: %s", line, err)
+		}
+		if event["msg"] == "Summary" && event["resource"] == "sys/auth/userpass/" {
+			summarised = true
+		}
+	}
+	if !summarised {
+		t.Errorf("Expected the summary as JSON lines")
+	}
+}
+
+func TestRunPrettyOutput(t *testing.T) {
+	lines := applyWithOutput(t, "pretty")
+	text := strings.Join(lines, "\n")
+	if err := json.Unmarshal([]byte(lines[0]), &map[string]interface{}{}); err == nil {
+		t.Errorf("Expected text rather than JSON, got %q", lines[0])
+	}
+	if !strings.Contains(text, "level=info") || !strings.Contains(text, "userpass") {
+		t.Errorf("Expected the apply to be logged, got:\n%s", text)
+	}
+	if !strings.Contains(text, "RESOURCE") {
+		t.Errorf("Expected a summary table, got:\n%s", text)
+	}
+}
+
+// Each handler's lines start with what it applies, and the component isn't repeated as a field
+func TestRunPlainOutput_Component(t *testing.T) {
+	var prefixed bool
+	for _, line := range applyWithOutput(t, "plain") {
+		if strings.Contains(line, "component=") {
+			t.Errorf("Expected the component only as a prefix, got %q", line)
+		}
+		if strings.Contains(line, `msg="[sys/auth] `) && strings.Contains(line, "handler=SysAuth") {
+			prefixed = true
+		}
+	}
+	if !prefixed {
+		t.Errorf("Expected the lines of the SysAuth handler to start with [sys/auth]")
+	}
+}
+
+func TestRunJsonOutput_Component(t *testing.T) {
+	var found bool
+	for _, line := range applyWithOutput(t, "json") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Expected a JSON object per line, got %q: %s", line, err)
+		}
+		if event["handler"] == "SysAuth" {
+			found = true
+			if event["component"] != "sys/auth" || strings.HasPrefix(event["msg"].(string), "[") {
+				t.Errorf("Expected the component as a field only, got %+v", event)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected lines from the SysAuth handler")
+	}
+}
+
+func TestLogFormatter_Unknown(t *testing.T) {
+	if _, err := logFormatter("xml"); err == nil {
+		t.Error("Expected an error for an unknown output")
+	}
+}
+
+func TestConfirmInput(t *testing.T) {
+	defer func(f func() bool) { interactive = f }(interactive)
+	interactive = func() bool { return true }
+	defer func(y bool) { yes = y }(yes)
+
+	conf := config.VaultsmithConfig{DocumentPath: "example"}
+	yes = false
+	if confirmInput(conf) != stdin {
+		t.Error("Expected confirmation to be read from stdin")
+	}
+	yes = true
+	if r := confirmInput(conf); r != nil {
+		t.Errorf("Expected no confirmation with --yes, got %v", r)
+	}
+	yes = false
+	conf.Watch = true
+	if r := confirmInput(conf); r != nil {
+		t.Errorf("Expected no confirmation with --watch, got %v", r)
+	}
+
+	interactive = func() bool { return false }
+	if r := confirmInput(config.VaultsmithConfig{DocumentPath: "example"}); r != nil {
+		t.Errorf("Expected no confirmation when not run by hand, got %v", r)
+	}
+}
+
+func TestInterruptible(t *testing.T) {
+	defer func(d time.Duration) { shutdownGrace = d }(shutdownGrace)
+	shutdownGrace = 10 * time.Millisecond
+	exited := make(chan int, 1)
+	defer func(f func(int)) { exit = f }(exit)
+	exit = func(code int) { exited <- code }
+
+	ctx, stop := interruptible()
+	defer stop()
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected SIGTERM to cancel the run")
+	}
+	// the run doesn't finish, so vaultsmith exits once the grace period is over
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("Expected exit code 1, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected to exit after the grace period")
+	}
+}
+
+// A client which takes a while to enable each auth method
+type slowClient struct {
+	*vault.MockClient
+	delay time.Duration
+}
+
+func (c slowClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
+	time.Sleep(c.delay)
+	return c.MockClient.EnableAuth(path, options)
+}
+
+func TestRunTimeout(t *testing.T) {
+	docPath, err := ioutil.TempDir("", "vaultsmith-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(docPath)
+	os.MkdirAll(filepath.Join(docPath, "sys", "auth"), 0755)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		err := ioutil.WriteFile(filepath.Join(docPath, "sys", "auth", name+".json"), []byte(`{"type": "userpass"}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var summary bytes.Buffer
+	defer func(w io.Writer) { summaryOutput = w }(summaryOutput)
+	summaryOutput = &summary
+
+	conf := config.VaultsmithConfig{VaultRole: "ValidRole", DocumentPath: docPath, RunTimeout: 150 * time.Millisecond}
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", conf.VaultRole)
+	err = Run(slowClient{mockClient, 100 * time.Millisecond}, conf)
+	if _, ok := err.(timeoutError); !ok {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	// the auth method being enabled at the deadline is finished, but no more are started
+	if calls := mockClient.CallsTo("EnableAuth"); len(calls) != 2 {
+		t.Errorf("Expected 2 EnableAuth calls before the deadline, got %+v", calls)
+	}
+	if !strings.Contains(summary.String(), "sys/auth/a") || !strings.Contains(summary.String(), "sys/auth/b") {
+		t.Errorf("Expected the summary to report the auth methods enabled, got %q", summary.String())
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	defer func(d time.Duration) { shutdownGrace = d }(shutdownGrace)
+	shutdownGrace = 10 * time.Millisecond
+	exited := make(chan int, 1)
+	defer func(f func(int)) { exit = f }(exit)
+	exit = func(code int) { exited <- code }
+
+	ctx, cancel := withTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the deadline to cancel the run")
+	}
+	// the run doesn't finish, so vaultsmith exits once the grace period is over
+	select {
+	case code := <-exited:
+		if code != timeoutExitCode {
+			t.Errorf("Expected exit code %d, got %d", timeoutExitCode, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected to exit after the grace period")
+	}
+}
+
+func TestFlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var documentPaths, headers []string
+	var authToken, tarDir string
+	var timeout time.Duration
+	var dry bool
+	fs.StringArrayVar(&documentPaths, "document-path", nil, "")
+	fs.StringVar(&authToken, "http-auth-token", "", "")
+	fs.StringVar(&tarDir, "tar-dir", "", "")
+	fs.DurationVar(&timeout, "vault-timeout", 0, "")
+	fs.StringArrayVar(&headers, "vault-header", []string{}, "")
+	fs.BoolVar(&dry, "dry", false, "")
+	if err := fs.Parse([]string{"--tar-dir", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"VAULTSMITH_DOCUMENT_PATH":   "https://example.com/docs.tar.gz?sig=a,b",
+		"VAULTSMITH_HTTP_AUTH_TOKEN": "hunter2",
+		"VAULTSMITH_TAR_DIR":         "from-env",
+		"VAULTSMITH_VAULT_TIMEOUT":   "10s",
+		"VAULTSMITH_VAULT_HEADER":    "X-Team=platform",
+	}
+	err := flagsFromEnv(fs, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if expected := []string{"https://example.com/docs.tar.gz?sig=a,b"}; !reflect.DeepEqual(documentPaths, expected) {
+		t.Errorf("Expected document paths %+v, got %+v", expected, documentPaths)
+	}
+	if authToken != "hunter2" {
+		t.Errorf("Expected the auth token from the environment, got %q", authToken)
+	}
+	if tarDir != "from-flag" {
+		t.Errorf("Expected the flag to take precedence, got %q", tarDir)
+	}
+	if timeout != 10*time.Second {
+		t.Errorf("Expected a vault timeout of 10s, got %s", timeout)
+	}
+	if expected := []string{"X-Team=platform"}; !reflect.DeepEqual(headers, expected) {
+		t.Errorf("Expected headers %+v, got %+v", expected, headers)
+	}
+	if dry {
+		t.Errorf("Expected dry to keep its default")
+	}
+}
+
+func TestFlagsFromEnv_Invalid(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var timeout time.Duration
+	fs.DurationVar(&timeout, "vault-timeout", 0, "")
+	err := flagsFromEnv(fs, func(name string) (string, bool) {
+		return "soon", name == "VAULTSMITH_VAULT_TIMEOUT"
+	})
+	if err == nil || !strings.Contains(err.Error(), "VAULTSMITH_VAULT_TIMEOUT") {
+		t.Errorf("Expected an error naming the variable, got %v", err)
+	}
+}